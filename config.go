@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loadConfigFile sets the flags from the options of a YAML config file. The
+// options are keyed by the flag names (e.g. default-schedule) so the file
+// and the command line share the same vocabulary. Flags set on the command
+// line take precedence over the file, and errors point to the line of the
+// offending option.
+func loadConfigFile(path string, flags *flag.FlagSet) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	if len(document.Content) == 0 {
+		return nil
+	}
+	options := document.Content[0]
+	if options.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s:%d: expected a mapping of option names to values", path, options.Line)
+	}
+
+	explicit := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	for i := 0; i+1 < len(options.Content); i += 2 {
+		key, value := options.Content[i], options.Content[i+1]
+		if key.Value == "config" || flags.Lookup(key.Value) == nil {
+			return fmt.Errorf("%s:%d: unknown option '%s'", path, key.Line, key.Value)
+		}
+		if value.Kind != yaml.ScalarNode {
+			return fmt.Errorf("%s:%d: invalid value of option '%s', expected a single value", path, value.Line, key.Value)
+		}
+		if explicit[key.Value] {
+			continue
+		}
+		if err := flags.Set(key.Value, value.Value); err != nil {
+			return fmt.Errorf("%s:%d: invalid value '%s' of option '%s': %s", path, value.Line, value.Value, key.Value, err)
+		}
+	}
+	return nil
+}
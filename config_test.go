@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testFlags returns a flag set with options of every kind, parsed from the
+// command line arguments
+func testFlags(t *testing.T, args []string) (*flag.FlagSet, *string, *time.Duration, *int, *bool) {
+	t.Helper()
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	schedule := flags.String("default-schedule", "", "")
+	timeout := flags.Duration("api-timeout", 10*time.Second, "")
+	burst := flags.Int("kube-burst", 10, "")
+	skip := flags.Bool("skip-rbac-check", false, "")
+	flags.String("config", "", "")
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return flags, schedule, timeout, burst, skip
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	const sample = `# Options of the scheduler
+default-schedule: "20:00-08:00"
+api-timeout: 30s
+kube-burst: 50
+skip-rbac-check: true
+`
+	tests := []struct {
+		name     string
+		content  string
+		args     []string
+		schedule string
+		timeout  time.Duration
+		burst    int
+		skip     bool
+	}{
+		{"sample file", sample, nil, "20:00-08:00", 30 * time.Second, 50, true},
+		{"flags override the file", sample, []string{"--default-schedule=22:00-06:00", "--kube-burst=20"}, "22:00-06:00", 30 * time.Second, 20, true},
+		{"flag set to its default overrides the file", sample, []string{"--skip-rbac-check=false"}, "20:00-08:00", 30 * time.Second, 50, false},
+		{"partial file", "api-timeout: 1m\n", nil, "", time.Minute, 10, false},
+		{"empty file", "", nil, "", 10 * time.Second, 10, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(test.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			flags, schedule, timeout, burst, skip := testFlags(t, test.args)
+
+			if err := loadConfigFile(path, flags); err != nil {
+				t.Fatal(err)
+			}
+			if *schedule != test.schedule || *timeout != test.timeout || *burst != test.burst || *skip != test.skip {
+				t.Errorf("expected %s, %s, %d, %t, got %s, %s, %d, %t", test.schedule, test.timeout, test.burst, test.skip, *schedule, *timeout, *burst, *skip)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"unknown option", "api-timeout: 30s\nunknown-option: 1\n", "config.yaml:2: unknown option 'unknown-option'"},
+		{"config option", "config: other.yaml\n", "config.yaml:1: unknown option 'config'"},
+		{"invalid value", "api-timeout: 30s\nkube-burst: many\n", "config.yaml:2: invalid value 'many' of option 'kube-burst'"},
+		{"list value", "default-schedule:\n  - 20:00-08:00\n", "config.yaml:2: invalid value of option 'default-schedule', expected a single value"},
+		{"not a mapping", "- api-timeout\n", "config.yaml:1: expected a mapping of option names to values"},
+		{"invalid YAML", "api-timeout: [30s\n", "config.yaml: yaml:"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(test.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			flags, _, _, _, _ := testFlags(t, nil)
+
+			err := loadConfigFile(path, flags)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected the error '%s', got '%v'", test.err, err)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileMissing(t *testing.T) {
+	flags, _, _, _, _ := testFlags(t, nil)
+	if err := loadConfigFile(filepath.Join(t.TempDir(), "missing.yaml"), flags); err == nil {
+		t.Errorf("expected an error")
+	}
+}
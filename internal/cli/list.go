@@ -0,0 +1,69 @@
+// Package cli holds the subcommands of the application that run once and
+// exit, instead of starting the long running controller and HTTP service.
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/dimitris4000/concept02/internal/controller"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// List writes a table of all the deployments enabled for scheduling and their
+// parsed schedules to w. Deployments with a schedule that can not be parsed
+// are listed with the parse error in place of the schedule.
+func List(ctx context.Context, clientset kubernetes.Interface, config controller.ControllerConfig, w io.Writer) error {
+	deployments, err := clientset.AppsV1().Deployments("").List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "NAMESPACE\tNAME\tSCHEDULE\tTIMEZONE")
+	for _, deployment := range deployments.Items {
+		annotations := deployment.GetAnnotations()
+		if !controller.IsEnabled(config, annotations) {
+			continue
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", deployment.Namespace, deployment.Name, describeSchedule(config, annotations, deployment.GetLabels()), describeTimezone(config, annotations, deployment.GetLabels()))
+	}
+
+	return table.Flush()
+}
+
+// describeSchedule returns the parsed schedule of the annotations in a human
+// readable form. ConfigMap references are not resolved, they are shown as
+// they are.
+func describeSchedule(config controller.ControllerConfig, annotations, labels map[string]string) string {
+	if ref, exists := annotations[config.Annotation(controller.SCHEDULE_REF_ANNOTATION)]; exists {
+		return fmt.Sprintf("ref:%s", ref)
+	}
+	if _, exists := annotations[config.Annotation(controller.SCHEDULE_ANNOTATION)]; !exists && config.DefaultSchedule != "" {
+		annotations = map[string]string{config.Annotation(controller.SCHEDULE_ANNOTATION): config.DefaultSchedule}
+	}
+
+	schedule, err := controller.ParseScheduleAnnotation(config, annotations, labels)
+	if err != nil {
+		return fmt.Sprintf("error: %s", err)
+	}
+	return schedule.String()
+}
+
+// describeTimezone returns the time zone the schedule of the annotations is
+// evaluated in.
+func describeTimezone(config controller.ControllerConfig, annotations, labels map[string]string) string {
+	if schedule, err := controller.ParseScheduleAnnotation(config, annotations, labels); err == nil && schedule.Location != nil {
+		return schedule.Location.String()
+	}
+	if name, exists := annotations[config.Annotation(controller.TIMEZONE_ANNOTATION)]; exists {
+		return name
+	}
+	if config.DefaultTimezone != "" {
+		return config.DefaultTimezone
+	}
+	return "UTC"
+}
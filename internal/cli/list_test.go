@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/dimitris4000/concept02/internal/controller"
+	apps_v1 "k8s.io/api/apps/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newTestDeployment creates a deployment in the namespace with the
+// annotations and replicas
+func newTestDeployment(namespace, name string, replicas int32, annotations map[string]string) *apps_v1.Deployment {
+	return &apps_v1.Deployment{
+		ObjectMeta: meta_v1.ObjectMeta{Namespace: namespace, Name: name, Annotations: annotations},
+		Spec:       apps_v1.DeploymentSpec{Replicas: &replicas},
+	}
+}
+
+func TestList(t *testing.T) {
+	tests := []struct {
+		name            string
+		annotations     map[string]string
+		defaultSchedule string
+		columns         []string
+	}{
+		{"schedule", map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00"}, "", []string{"default", "foo", "20:00-08:00", "UTC"}},
+		{"days and time zone", map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "weekdays 20:00-08:00", "scheduler.timezone": "Europe/Athens"}, "", []string{"default", "foo", "MTuWThF 20:00-08:00", "Europe/Athens"}},
+		{"default schedule", map[string]string{"scheduler.enabled": "true"}, "22:00-06:00", []string{"default", "foo", "22:00-06:00", "UTC"}},
+		{"schedule reference", map[string]string{"scheduler.enabled": "true", "scheduler.schedule-ref": "schedules/nightly"}, "", []string{"default", "foo", "ref:schedules/nightly", "UTC"}},
+		{"invalid schedule", map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00"}, "", []string{"default", "foo", "error: invalid scheduler.off-schedule annotation: invalid schedule '20:00', expected format '[days] HH:MM-HH:MM'", "UTC"}},
+		{"not enabled", map[string]string{"scheduler.off-schedule": "20:00-08:00"}, "", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(newTestDeployment("default", "foo", 1, test.annotations))
+			config := controller.NewDefaultControllerConfig()
+			config.DefaultSchedule = test.defaultSchedule
+			var out bytes.Buffer
+
+			if err := List(context.Background(), clientset, config, &out); err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			if header := strings.Fields(lines[0]); strings.Join(header, " ") != "NAMESPACE NAME SCHEDULE TIMEZONE" {
+				t.Errorf("expected the header NAMESPACE NAME SCHEDULE TIMEZONE, got %s", lines[0])
+			}
+			if test.columns == nil {
+				if len(lines) != 1 {
+					t.Errorf("expected no deployments listed, got %s", out.String())
+				}
+				return
+			}
+			if len(lines) != 2 {
+				t.Fatalf("expected a single deployment listed, got %s", out.String())
+			}
+			// The columns are separated by at least two spaces
+			columns := regexp.MustCompile(` {2,}`).Split(strings.TrimSpace(lines[1]), -1)
+			if strings.Join(columns, "|") != strings.Join(test.columns, "|") {
+				t.Errorf("expected the columns %q, got %q", test.columns, columns)
+			}
+		})
+	}
+}
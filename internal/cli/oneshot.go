@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/dimitris4000/concept02/internal/controller"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Environment variables configuring the target of the oneshot command
+const (
+	ENV_TARGET_NAMESPACE = "TARGET_NAMESPACE"
+	ENV_TARGET_NAME      = "TARGET_NAME"
+	ENV_OFF_SCHEDULE     = "OFF_SCHEDULE"
+	ENV_TIMEZONE         = "TIMEZONE"
+)
+
+// OneshotTarget is the deployment scaled by the oneshot command together
+// with its schedule.
+type OneshotTarget struct {
+	Namespace string
+	Name      string
+	Schedule  controller.Schedule
+}
+
+// OneshotTargetFromEnv reads the target of the oneshot command from the
+// environment. The TIMEZONE variable is optional and falls back to the
+// configured default time zone.
+func OneshotTargetFromEnv(config controller.ControllerConfig) (OneshotTarget, error) {
+	target := OneshotTarget{
+		Namespace: os.Getenv(ENV_TARGET_NAMESPACE),
+		Name:      os.Getenv(ENV_TARGET_NAME),
+	}
+	if target.Namespace == "" || target.Name == "" {
+		return OneshotTarget{}, fmt.Errorf("%s and %s environment variables are required", ENV_TARGET_NAMESPACE, ENV_TARGET_NAME)
+	}
+
+	timeRange, err := controller.ParseSchedule(os.Getenv(ENV_OFF_SCHEDULE))
+	if err != nil {
+		return OneshotTarget{}, fmt.Errorf("invalid %s environment variable: %s", ENV_OFF_SCHEDULE, err)
+	}
+	timezone := config.DefaultTimezone
+	if name, exists := os.LookupEnv(ENV_TIMEZONE); exists {
+		timezone = name
+	}
+	location, err := controller.LoadLocation(timezone)
+	if err != nil {
+		return OneshotTarget{}, fmt.Errorf("invalid time zone: %s", err)
+	}
+	target.Schedule = controller.Schedule{Range: timeRange, Location: location}
+
+	return target, nil
+}
+
+// Oneshot evaluates the schedule of the target once at now and scales the
+// target deployment accordingly. It is meant to be run periodically, e.g.
+// by a CronJob, instead of running the controller.
+func Oneshot(ctx context.Context, clientset kubernetes.Interface, config controller.ControllerConfig, target OneshotTarget, now time.Time, w io.Writer) error {
+	state := controller.ENABLED
+	if target.Schedule.InRange(now) {
+		state = controller.DISABLED
+		fmt.Fprintf(w, "Schedule '%s' is in range at %s, scaling down deployment '%s.%s'\n", target.Schedule.Range, now.In(target.Schedule.Location).Format(time.RFC3339), target.Namespace, target.Name)
+	} else {
+		fmt.Fprintf(w, "Schedule '%s' is out of range at %s, scaling up deployment '%s.%s'\n", target.Schedule.Range, now.In(target.Schedule.Location).Format(time.RFC3339), target.Namespace, target.Name)
+	}
+
+	ctx = controller.WithAuditSource(ctx, controller.AUDIT_ACTOR_SCHEDULE, "oneshot")
+	return controller.ToggleDeployment(ctx, clientset, config, target.Namespace, target.Name, state)
+}
@@ -0,0 +1,115 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dimitris4000/concept02/internal/controller"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestOneshotTargetFromEnv(t *testing.T) {
+	tests := []struct {
+		name            string
+		env             map[string]string
+		defaultTimezone string
+		location        string
+		err             string
+	}{
+		{"target", map[string]string{"TARGET_NAMESPACE": "default", "TARGET_NAME": "foo", "OFF_SCHEDULE": "20:00-08:00"}, "", "UTC", ""},
+		{"time zone", map[string]string{"TARGET_NAMESPACE": "default", "TARGET_NAME": "foo", "OFF_SCHEDULE": "20:00-08:00", "TIMEZONE": "Europe/Athens"}, "UTC", "Europe/Athens", ""},
+		{"default time zone", map[string]string{"TARGET_NAMESPACE": "default", "TARGET_NAME": "foo", "OFF_SCHEDULE": "20:00-08:00"}, "Europe/Athens", "Europe/Athens", ""},
+		{"missing namespace", map[string]string{"TARGET_NAME": "foo", "OFF_SCHEDULE": "20:00-08:00"}, "", "", "TARGET_NAMESPACE and TARGET_NAME environment variables are required"},
+		{"missing name", map[string]string{"TARGET_NAMESPACE": "default", "OFF_SCHEDULE": "20:00-08:00"}, "", "", "TARGET_NAMESPACE and TARGET_NAME environment variables are required"},
+		{"missing schedule", map[string]string{"TARGET_NAMESPACE": "default", "TARGET_NAME": "foo"}, "", "", "invalid OFF_SCHEDULE environment variable"},
+		{"invalid schedule", map[string]string{"TARGET_NAMESPACE": "default", "TARGET_NAME": "foo", "OFF_SCHEDULE": "20:00"}, "", "", "invalid OFF_SCHEDULE environment variable"},
+		{"invalid time zone", map[string]string{"TARGET_NAMESPACE": "default", "TARGET_NAME": "foo", "OFF_SCHEDULE": "20:00-08:00", "TIMEZONE": "Europe/Nowhere"}, "", "", "invalid time zone"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, name := range []string{ENV_TARGET_NAMESPACE, ENV_TARGET_NAME, ENV_OFF_SCHEDULE, ENV_TIMEZONE} {
+				// Setenv restores the variable when the test ends
+				t.Setenv(name, test.env[name])
+				if _, exists := test.env[name]; !exists {
+					os.Unsetenv(name)
+				}
+			}
+			config := controller.NewDefaultControllerConfig()
+			config.DefaultTimezone = test.defaultTimezone
+
+			target, err := OneshotTargetFromEnv(config)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("expected the error '%s', got '%v'", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if target.Namespace != "default" || target.Name != "foo" {
+				t.Errorf("expected the target default/foo, got %s/%s", target.Namespace, target.Name)
+			}
+			if target.Schedule.Location.String() != test.location {
+				t.Errorf("expected the time zone %s, got %s", test.location, target.Schedule.Location)
+			}
+		})
+	}
+}
+
+func TestOneshot(t *testing.T) {
+	monday := func(hour int) time.Time { return time.Date(2024, time.June, 3, hour, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name        string
+		schedule    string
+		timezone    string
+		replicas    int32
+		annotations map[string]string
+		now         time.Time
+		expected    int32
+		output      string
+	}{
+		{"in range", "20:00-08:00", "UTC", 3, nil, monday(22), 0, "Schedule '20:00-08:00' is in range at 2024-06-03T22:00:00Z, scaling down deployment 'default.foo'"},
+		{"out of range", "20:00-08:00", "UTC", 0, map[string]string{"scheduler.replicas-memory": "3"}, monday(12), 3, "Schedule '20:00-08:00' is out of range at 2024-06-03T12:00:00Z, scaling up deployment 'default.foo'"},
+		{"already up", "20:00-08:00", "UTC", 3, nil, monday(12), 3, "is out of range"},
+		{"in range in the time zone", "20:00-08:00", "Europe/Athens", 3, nil, monday(18), 0, "is in range at 2024-06-03T21:00:00+03:00"},
+		{"out of range in the time zone", "20:00-08:00", "Europe/Athens", 3, nil, monday(6), 3, "is out of range at 2024-06-03T09:00:00+03:00"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			clientset := fake.NewSimpleClientset(newTestDeployment("default", "foo", test.replicas, test.annotations))
+			timeRange, err := controller.ParseSchedule(test.schedule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			location, err := controller.LoadLocation(test.timezone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			target := OneshotTarget{Namespace: "default", Name: "foo", Schedule: controller.Schedule{Range: timeRange, Location: location}}
+			var out bytes.Buffer
+
+			if err := Oneshot(ctx, clientset, controller.NewDefaultControllerConfig(), target, test.now, &out); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(out.String(), test.output) {
+				t.Errorf("expected the output '%s', got '%s'", test.output, out.String())
+			}
+			deployment, err := clientset.AppsV1().Deployments("default").Get(ctx, "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *deployment.Spec.Replicas != test.expected {
+				t.Errorf("expected %d replicas, got %d", test.expected, *deployment.Spec.Replicas)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dimitris4000/concept02/internal/controller"
+	apps_v1 "k8s.io/api/apps/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SIMULATE_DATE_FORMAT is the format of the date of the simulate command
+const SIMULATE_DATE_FORMAT = "2006-01-02"
+
+// SimulateTarget is the schedule simulated by the simulate command and the
+// date it is simulated on.
+type SimulateTarget struct {
+	Date     time.Time
+	Schedule controller.Schedule
+}
+
+// ParseSimulateArgs reads the target of the simulate command out of its
+// arguments, a date followed by annotations in the '<name>=<value>' form
+// (e.g. 'off-schedule=19:00-07:00 timezone=Europe/Athens'). The annotation
+// names are given without the annotation prefix.
+func ParseSimulateArgs(config controller.ControllerConfig, args []string) (SimulateTarget, error) {
+	if len(args) < 1 {
+		return SimulateTarget{}, fmt.Errorf("expected a date (e.g. 2024-06-01) followed by the annotations of the schedule (e.g. off-schedule=19:00-07:00)")
+	}
+	date, err := time.Parse(SIMULATE_DATE_FORMAT, args[0])
+	if err != nil {
+		return SimulateTarget{}, fmt.Errorf("invalid date '%s', expected the YYYY-MM-DD format", args[0])
+	}
+
+	deployment := &apps_v1.Deployment{ObjectMeta: meta_v1.ObjectMeta{Annotations: map[string]string{}}}
+	for _, arg := range args[1:] {
+		name, value, found := strings.Cut(arg, "=")
+		if !found || name == "" {
+			return SimulateTarget{}, fmt.Errorf("invalid annotation '%s', expected the '<name>=<value>' form", arg)
+		}
+		deployment.Annotations[config.Annotation(controller.DEFAULT_ANNOTATION_PREFIX+name)] = value
+	}
+	schedule, err := controller.ResolveStandaloneSchedule(config, deployment)
+	if err != nil {
+		return SimulateTarget{}, err
+	}
+
+	return SimulateTarget{Date: date, Schedule: schedule}, nil
+}
+
+// Simulate writes the timeline of the target's schedule on its date to w, as
+// a table with the state at midnight followed by every transition of the
+// day. The times are in the time zone of the schedule.
+func Simulate(target SimulateTarget, w io.Writer) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "TIME\tSTATE\tWINDOW")
+	for _, transition := range target.Schedule.Timeline(target.Date) {
+		fmt.Fprintf(table, "%s\t%s\t%s\n", transition.At.Format(time.RFC3339), transition.State, describeWindow(target.Schedule, transition.At))
+	}
+
+	return table.Flush()
+}
+
+// describeWindow returns the off-window of the schedule in range at t, or a
+// dash if none is in range. Off days are shown as such.
+func describeWindow(schedule controller.Schedule, t time.Time) string {
+	window, inRange := schedule.Match(t)
+	switch {
+	case !inRange:
+		return "-"
+	case window == controller.TimeRange{}:
+		return "off day"
+	default:
+		return window.String()
+	}
+}
@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/dimitris4000/concept02/internal/controller"
+)
+
+func TestParseSimulateArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		schedule string
+		timezone string
+		err      string
+	}{
+		{"schedule", []string{"2024-06-03", "off-schedule=20:00-08:00"}, "20:00-08:00", "UTC", ""},
+		{"time zone", []string{"2024-06-03", "off-schedule=20:00-08:00", "timezone=Europe/Athens"}, "20:00-08:00", "Europe/Athens", ""},
+		{"value with an equals sign", []string{"2024-06-03", `off-schedule={"windows":["20:00-08:00"],"timezone":"Europe/Athens"}`}, "20:00-08:00", "Europe/Athens", ""},
+		{"no arguments", nil, "", "", "expected a date"},
+		{"invalid date", []string{"06/03/2024", "off-schedule=20:00-08:00"}, "", "", "invalid date '06/03/2024'"},
+		{"invalid annotation", []string{"2024-06-03", "off-schedule"}, "", "", "invalid annotation 'off-schedule'"},
+		{"annotation without a name", []string{"2024-06-03", "=20:00-08:00"}, "", "", "invalid annotation '=20:00-08:00'"},
+		{"no schedule", []string{"2024-06-03"}, "", "", "scheduler.off-schedule"},
+		{"invalid schedule", []string{"2024-06-03", "off-schedule=20:00"}, "", "", "20:00"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target, err := ParseSimulateArgs(controller.NewDefaultControllerConfig(), test.args)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("expected the error '%s', got '%v'", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if target.Date.Format(SIMULATE_DATE_FORMAT) != test.args[0] {
+				t.Errorf("expected the date %s, got %s", test.args[0], target.Date.Format(SIMULATE_DATE_FORMAT))
+			}
+			if target.Schedule.Range.String() != test.schedule || target.Schedule.Location.String() != test.timezone {
+				t.Errorf("expected the schedule '%s' in %s, got '%s' in %s", test.schedule, test.timezone, target.Schedule.Range, target.Schedule.Location)
+			}
+		})
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		rows []string
+	}{
+		{"overnight window", []string{"2024-06-03", "off-schedule=20:00-08:00"}, []string{
+			"2024-06-03T00:00:00Z  down   20:00-08:00",
+			"2024-06-03T08:00:00Z  up     -",
+			"2024-06-03T20:00:00Z  down   20:00-08:00",
+		}},
+		{"time zone", []string{"2024-06-03", "off-schedule=12:00-13:00", "timezone=Europe/Athens"}, []string{
+			"2024-06-03T00:00:00+03:00  up     -",
+			"2024-06-03T12:00:00+03:00  down   12:00-13:00",
+			"2024-06-03T13:00:00+03:00  up     -",
+		}},
+		{"off day", []string{"2024-06-08", "off-schedule=12:00-13:00", "off-days=sat,sun"}, []string{
+			"2024-06-08T00:00:00Z  down   off day",
+		}},
+		{"exception", []string{"2024-06-03", "off-schedule=12:00-13:00", "schedule-exceptions=2024-06-03"}, []string{
+			"2024-06-03T00:00:00Z  up     -",
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target, err := ParseSimulateArgs(controller.NewDefaultControllerConfig(), test.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			if err := Simulate(target, &out); err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+			if !strings.HasPrefix(lines[0], "TIME") {
+				t.Errorf("expected a header, got '%s'", lines[0])
+			}
+			if rows := lines[1:]; strings.Join(rows, "\n") != strings.Join(test.rows, "\n") {
+				t.Errorf("expected the rows\n%s\ngot\n%s", strings.Join(test.rows, "\n"), strings.Join(rows, "\n"))
+			}
+		})
+	}
+}
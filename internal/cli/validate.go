@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/dimitris4000/concept02/internal/controller"
+	apps_v1 "k8s.io/api/apps/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Validate checks the schedule of all the deployments in the cluster that
+// are enabled for scheduling. Every invalid deployment is reported to w and
+// the number of invalid deployments is returned.
+func Validate(ctx context.Context, clientset kubernetes.Interface, config controller.ControllerConfig, w io.Writer) (int, error) {
+	deployments, err := clientset.AppsV1().Deployments("").List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return 0, err
+	}
+
+	invalid := 0
+	for _, deployment := range deployments.Items {
+		if err := validateDeployment(config, &deployment); err != nil {
+			fmt.Fprintf(w, "cluster: %s/%s: %s\n", deployment.Namespace, deployment.Name, err)
+			invalid++
+		}
+	}
+	return invalid, nil
+}
+
+// ValidateFiles is the same as Validate but checks the deployments of YAML
+// (or JSON) manifest files. A file can contain multiple documents and
+// documents of other kinds are ignored.
+func ValidateFiles(paths []string, config controller.ControllerConfig, w io.Writer) (int, error) {
+	invalid := 0
+	for _, path := range paths {
+		deployments, err := readDeployments(path)
+		if err != nil {
+			return invalid, fmt.Errorf("could not read %s: %s", path, err)
+		}
+		for _, deployment := range deployments {
+			if err := validateDeployment(config, deployment); err != nil {
+				fmt.Fprintf(w, "%s: %s/%s: %s\n", path, deployment.Namespace, deployment.Name, err)
+				invalid++
+			}
+		}
+	}
+	return invalid, nil
+}
+
+// readDeployments decodes all the deployments of a manifest file
+func readDeployments(path string) ([]*apps_v1.Deployment, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var deployments []*apps_v1.Deployment
+	decoder := yaml.NewYAMLOrJSONDecoder(file, 4096)
+	for {
+		var deployment apps_v1.Deployment
+		err := decoder.Decode(&deployment)
+		if errors.Is(err, io.EOF) {
+			return deployments, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if deployment.Kind == "Deployment" {
+			deployments = append(deployments, &deployment)
+		}
+	}
+}
+
+// validateDeployment checks the schedule of a deployment the same way the
+// controller does. Deployments that are not enabled are always valid and
+// schedules referenced through a ConfigMap are not checked.
+func validateDeployment(config controller.ControllerConfig, deployment *apps_v1.Deployment) error {
+	annotations := deployment.GetAnnotations()
+	if !controller.IsEnabled(config, annotations) {
+		return nil
+	}
+	if _, exists := annotations[config.Annotation(controller.SCHEDULE_REF_ANNOTATION)]; exists {
+		return nil
+	}
+	if _, exists := annotations[config.Annotation(controller.SCHEDULE_ANNOTATION)]; !exists && config.DefaultSchedule != "" {
+		return nil
+	}
+
+	_, err := controller.ParseScheduleAnnotation(config, annotations, deployment.GetLabels())
+	return err
+}
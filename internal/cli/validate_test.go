@@ -0,0 +1,124 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dimitris4000/concept02/internal/controller"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// testManifest returns a deployment manifest with the annotations
+func testManifest(name, annotations string) string {
+	return `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  namespace: default
+  name: ` + name + `
+  annotations:
+` + annotations
+}
+
+func TestValidateFiles(t *testing.T) {
+	valid := testManifest("valid", "    scheduler.enabled: \"true\"\n    scheduler.off-schedule: 20:00-08:00\n")
+	invalid := testManifest("invalid", "    scheduler.enabled: \"true\"\n    scheduler.off-schedule: \"20:00\"\n")
+	disabled := testManifest("disabled", "    scheduler.off-schedule: \"20:00\"\n")
+	missing := testManifest("missing", "    scheduler.enabled: \"true\"\n")
+	service := "apiVersion: v1\nkind: Service\nmetadata:\n  name: foo\n  annotations:\n    scheduler.enabled: \"true\"\n    scheduler.off-schedule: \"20:00\"\n"
+	tests := []struct {
+		name     string
+		manifest string
+		invalid  int
+		report   []string
+	}{
+		{"valid", valid, 0, nil},
+		{"invalid", invalid, 1, []string{"default/invalid: invalid scheduler.off-schedule annotation"}},
+		{"not enabled", disabled, 0, nil},
+		{"missing schedule", missing, 1, []string{"default/missing: "}},
+		{"other kinds", service, 0, nil},
+		{"mixed documents", valid + "---\n" + invalid + "---\n" + service + "---\n" + missing, 2, []string{"default/invalid: ", "default/missing: "}},
+		{"empty", "", 0, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "manifest.yaml")
+			if err := os.WriteFile(path, []byte(test.manifest), 0600); err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+
+			invalid, err := ValidateFiles([]string{path}, controller.NewDefaultControllerConfig(), &out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if invalid != test.invalid {
+				t.Errorf("expected %d invalid deployments, got %d: %s", test.invalid, invalid, out.String())
+			}
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			if out.Len() == 0 {
+				lines = nil
+			}
+			if len(lines) != len(test.report) {
+				t.Fatalf("expected %d reported deployments, got %s", len(test.report), out.String())
+			}
+			for i, report := range test.report {
+				if !strings.HasPrefix(lines[i], path+": "+report) {
+					t.Errorf("expected the report '%s: %s', got '%s'", path, report, lines[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateFilesErrors(t *testing.T) {
+	dir := t.TempDir()
+	malformed := filepath.Join(dir, "malformed.yaml")
+	if err := os.WriteFile(malformed, []byte("kind: [Deployment\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		path string
+	}{
+		{"missing file", filepath.Join(dir, "missing.yaml")},
+		{"malformed file", malformed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ValidateFiles([]string{test.path}, controller.NewDefaultControllerConfig(), &bytes.Buffer{})
+			if err == nil || !strings.HasPrefix(err.Error(), "could not read "+test.path) {
+				t.Errorf("expected an error reading %s, got '%v'", test.path, err)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		newTestDeployment("default", "valid", 1, map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00"}),
+		newTestDeployment("default", "invalid", 1, map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00"}),
+		newTestDeployment("default", "disabled", 1, map[string]string{"scheduler.off-schedule": "20:00"}),
+		newTestDeployment("other", "referenced", 1, map[string]string{"scheduler.enabled": "true", "scheduler.schedule-ref": "schedules/nightly"}),
+		newTestDeployment("other", "missing", 1, map[string]string{"scheduler.enabled": "true"}),
+	)
+	var out bytes.Buffer
+
+	invalid, err := Validate(context.Background(), clientset, controller.NewDefaultControllerConfig(), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invalid != 2 {
+		t.Errorf("expected 2 invalid deployments, got %d", invalid)
+	}
+	for _, report := range []string{"cluster: default/invalid: ", "cluster: other/missing: "} {
+		if !strings.Contains(out.String(), report) {
+			t.Errorf("expected the report '%s', got %s", report, out.String())
+		}
+	}
+}
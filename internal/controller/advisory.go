@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/dimitris4000/concept02/internal/logging"
+	apps_v1 "k8s.io/api/apps/v1"
+)
+
+// ENABLED_ADVISORY is the value of the enabled annotation that turns on the
+// advisory mode, where the controller never scales the deployment and only
+// records the state it would scale it to in the recommended-state
+// annotation.
+const ENABLED_ADVISORY = "advisory"
+
+// isAdvisory checks if the value of the enabled annotation turns on the
+// advisory mode
+func isAdvisory(value string) bool {
+	return strings.EqualFold(strings.TrimSpace(value), ENABLED_ADVISORY)
+}
+
+// recommend records the state a deployment in advisory mode would be scaled
+// to, writing the recommended-state annotation only when it changes. A
+// deployment switched to the advisory mode while scaled down is restored
+// once, like a paused one.
+func (c *Controller) recommend(ctx context.Context, deployment *apps_v1.Deployment, state DeploymentState) error {
+	deploymentName := fmt.Sprintf("%s/%s", deployment.Namespace, deployment.Name)
+	if _, remembered := scaledDownMemory(c.config, deployment.GetAnnotations()); remembered {
+		logging.FromContext(ctx).Info(fmt.Sprintf("Restoring deployment %s in advisory mode", deploymentName))
+		if _, err := c.toggle(WithAuditSource(ctx, AUDIT_ACTOR_SCHEDULE, "advisory"), deployment, ENABLED); err != nil {
+			return err
+		}
+	}
+
+	advisoryDecisions.WithLabelValues(state.String()).Inc()
+	recommendedAnnotation := c.config.Annotation(RECOMMENDED_STATE_ANNOTATION)
+	recommended := state.String()
+	if current, exists := deployment.GetAnnotations()[recommendedAnnotation]; exists && current == recommended {
+		return nil
+	}
+	logging.FromContext(ctx).Info(fmt.Sprintf("Recommending to scale %s deployment %s", recommended, deploymentName))
+	ctx, cancel := context.WithTimeout(ctx, c.config.APITimeout)
+	defer cancel()
+	return PatchDeploymentAnnotations(ctx, c.clientset, deployment.Namespace, deployment.Name, map[string]*string{recommendedAnnotation: &recommended})
+}
+
+// clearRecommendation removes the recommended-state annotation left over by
+// the advisory mode from a deployment that is scheduled for real
+func (c *Controller) clearRecommendation(ctx context.Context, deployment *apps_v1.Deployment) error {
+	recommendedAnnotation := c.config.Annotation(RECOMMENDED_STATE_ANNOTATION)
+	if _, exists := deployment.GetAnnotations()[recommendedAnnotation]; !exists {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.config.APITimeout)
+	defer cancel()
+	return PatchDeploymentAnnotations(ctx, c.clientset, deployment.Namespace, deployment.Name, map[string]*string{recommendedAnnotation: nil})
+}
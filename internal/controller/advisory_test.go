@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// deploymentActions counts the actions of a verb on deployments
+func deploymentActions(clientset *fake.Clientset, verb string) int {
+	actions := 0
+	for _, action := range clientset.Actions() {
+		if action.GetResource().Resource == "deployments" && action.GetVerb() == verb {
+			actions++
+		}
+	}
+	return actions
+}
+
+func TestReconcileAdvisoryMode(t *testing.T) {
+	discardLogs(t)
+	night := time.Date(2024, time.June, 3, 22, 0, 0, 0, time.UTC)
+	noon := time.Date(2024, time.June, 3, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		replicas    int32
+		now         time.Time
+		recommended string
+		expected    int32
+		updates     int
+		patches     int
+	}{
+		{"inside the window", map[string]string{"scheduler.enabled": "advisory"}, 2, night, "down", 2, 0, 1},
+		{"outside the window", map[string]string{"scheduler.enabled": "advisory"}, 2, noon, "up", 2, 0, 1},
+		{"mixed case", map[string]string{"scheduler.enabled": " Advisory "}, 2, night, "down", 2, 0, 1},
+		{"unchanged recommendation", map[string]string{"scheduler.enabled": "advisory", "scheduler.recommended-state": "down"}, 2, night, "down", 2, 0, 0},
+		{"changed recommendation", map[string]string{"scheduler.enabled": "advisory", "scheduler.recommended-state": "up"}, 2, night, "down", 2, 0, 1},
+		{"scaled down before", map[string]string{"scheduler.enabled": "advisory", "scheduler.replicas-memory": "3"}, 0, night, "down", 3, 1, 2},
+		{"switched to scheduling", map[string]string{"scheduler.enabled": "true", "scheduler.recommended-state": "down"}, 2, night, "", 0, 1, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			annotations := map[string]string{"scheduler.off-schedule": "20:00-08:00"}
+			for key, value := range test.annotations {
+				annotations[key] = value
+			}
+			c, clientset := newTestController(t, NewDefaultControllerConfig(), newTestDeployment("foo", test.replicas, annotations))
+			c.SetClock(&fakeClock{now: test.now})
+			series := `scheduler_advisory_decisions_total{state="` + test.recommended + `"}`
+			before := scrapeMetric(t, series)
+
+			if err := c.reconcile(context.Background(), "default/foo"); err != nil {
+				t.Fatal(err)
+			}
+			current, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *current.Spec.Replicas != test.expected {
+				t.Errorf("expected %d replicas, got %d", test.expected, *current.Spec.Replicas)
+			}
+			if recommended := current.Annotations["scheduler.recommended-state"]; recommended != test.recommended {
+				t.Errorf("expected the recommended state '%s', got '%s'", test.recommended, recommended)
+			}
+			if updates := deploymentActions(clientset, "update"); updates != test.updates {
+				t.Errorf("expected %d updates, got %d", test.updates, updates)
+			}
+			if patches := deploymentActions(clientset, "patch"); patches != test.patches {
+				t.Errorf("expected %d patches, got %d", test.patches, patches)
+			}
+			if test.recommended == "" {
+				return
+			}
+			if delta := scrapeMetric(t, series) - before; delta != 1 {
+				t.Errorf("expected one advisory decision, got %v", delta)
+			}
+		})
+	}
+}
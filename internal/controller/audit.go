@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dimitris4000/concept02/internal/logging"
+)
+
+// Actors of the audit log entries, what triggered a scale action
+const (
+	AUDIT_ACTOR_SCHEDULE = "schedule"
+	AUDIT_ACTOR_OVERRIDE = "override"
+	AUDIT_ACTOR_HTTP     = "http"
+	AUDIT_ACTOR_SHUTDOWN = "shutdown"
+	AUDIT_ACTOR_UNKNOWN  = "unknown"
+)
+
+// AUDIT_LOG_STDOUT is the ControllerConfig.AuditLog writing to stdout
+const AUDIT_LOG_STDOUT = "-"
+
+// AuditEntry is a line of the audit log. One is written for every change
+// of the replicas of an object made by the scheduler.
+type AuditEntry struct {
+	Time         time.Time `json:"time"`
+	Actor        string    `json:"actor"`
+	Reason       string    `json:"reason"`
+	Resource     string    `json:"resource"`
+	Namespace    string    `json:"namespace"`
+	Name         string    `json:"name"`
+	FromReplicas int32     `json:"fromReplicas"`
+	ToReplicas   int32     `json:"toReplicas"`
+}
+
+type auditSourceKey struct{}
+
+// auditSource is the actor and the reason carried by the context of a scale
+// action
+type auditSource struct {
+	actor  string
+	reason string
+}
+
+// WithAuditSource returns a copy of ctx carrying the actor and the reason
+// recorded in the audit log for the scale actions made with it
+func WithAuditSource(ctx context.Context, actor, reason string) context.Context {
+	return context.WithValue(ctx, auditSourceKey{}, auditSource{actor: actor, reason: reason})
+}
+
+// auditLog is an append-only audit log. Its lock keeps the lines of
+// concurrent writers apart.
+type auditLog struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+// auditLogs holds the opened audit logs by path, so every helper writing
+// to the same path shares the file and its lock
+var (
+	auditLogs      = map[string]*auditLog{}
+	auditLogsMutex sync.Mutex
+)
+
+// openAuditLog returns the audit log of the path, opening it for appending
+// on first use. Nil is returned for an empty path.
+func openAuditLog(path string) (*auditLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+	auditLogsMutex.Lock()
+	defer auditLogsMutex.Unlock()
+	if log, exists := auditLogs[path]; exists {
+		return log, nil
+	}
+
+	var writer io.Writer = os.Stdout
+	if path != AUDIT_LOG_STDOUT {
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		writer = file
+	}
+	log := &auditLog{writer: writer}
+	auditLogs[path] = log
+	return log, nil
+}
+
+// write appends the entry to the log as a JSON line
+func (l *auditLog) write(entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	_, err = l.writer.Write(append(line, '\n'))
+	return err
+}
+
+// audit records a change of the replicas of an object in the configured
+// audit log, with the actor and the reason carried by ctx. The change has
+// already happened, so failures are only logged.
+func audit(ctx context.Context, config ControllerConfig, resource, namespace, name string, fromReplicas, toReplicas int32) {
+	log, err := openAuditLog(config.AuditLog)
+	if log == nil && err == nil {
+		return
+	}
+	source, ok := ctx.Value(auditSourceKey{}).(auditSource)
+	if !ok {
+		source.actor = AUDIT_ACTOR_UNKNOWN
+	}
+	if err == nil {
+		err = log.write(AuditEntry{
+			Time:         time.Now().UTC(),
+			Actor:        source.actor,
+			Reason:       source.reason,
+			Resource:     resource,
+			Namespace:    namespace,
+			Name:         name,
+			FromReplicas: fromReplicas,
+			ToReplicas:   toReplicas,
+		})
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error(fmt.Sprintf("Failed to write the audit log of %s '%s.%s' scaled from %d to %d replicas: %s", resource, namespace, name, fromReplicas, toReplicas, err))
+	}
+}
@@ -0,0 +1,149 @@
+package controller
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readAuditLog reads the entries of the audit log at path
+func readAuditLog(t *testing.T, path string) []AuditEntry {
+	t.Helper()
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("invalid audit log line '%s': %s", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestReconcileWritesAuditLog(t *testing.T) {
+	discardLogs(t)
+	night := time.Date(2024, time.June, 3, 22, 0, 0, 0, time.UTC)
+	noon := time.Date(2024, time.June, 3, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		replicas    int32
+		now         time.Time
+		entries     []AuditEntry
+	}{
+		{"scale down", nil, 2, night, []AuditEntry{{Actor: AUDIT_ACTOR_SCHEDULE, Reason: "schedule", FromReplicas: 2, ToReplicas: 0}}},
+		{"scale up", map[string]string{"scheduler.replicas-memory": "3"}, 0, noon, []AuditEntry{{Actor: AUDIT_ACTOR_SCHEDULE, Reason: "schedule", FromReplicas: 0, ToReplicas: 3}}},
+		{"override", map[string]string{"scheduler.override": "down"}, 2, noon, []AuditEntry{{Actor: AUDIT_ACTOR_OVERRIDE, Reason: "override down", FromReplicas: 2, ToReplicas: 0}}},
+		{"no change", nil, 2, noon, nil},
+		{"paused while scaled down", map[string]string{"scheduler.enabled": "false", "scheduler.replicas-memory": "3"}, 0, night, []AuditEntry{{Actor: AUDIT_ACTOR_SCHEDULE, Reason: "disabled", FromReplicas: 0, ToReplicas: 3}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			annotations := map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00"}
+			for key, value := range test.annotations {
+				annotations[key] = value
+			}
+			config := NewDefaultControllerConfig()
+			config.AuditLog = filepath.Join(t.TempDir(), "audit.log")
+			c, _ := newTestController(t, config, newTestDeployment("foo", test.replicas, annotations))
+			c.SetClock(&fakeClock{now: test.now})
+
+			if err := c.reconcile(context.Background(), "default/foo"); err != nil {
+				t.Fatal(err)
+			}
+			entries := readAuditLog(t, config.AuditLog)
+			if len(entries) != len(test.entries) {
+				t.Fatalf("expected %d audit entries, got %+v", len(test.entries), entries)
+			}
+			for i, expected := range test.entries {
+				entry := entries[i]
+				if entry.Actor != expected.Actor || entry.Reason != expected.Reason {
+					t.Errorf("expected the actor %s for '%s', got %s for '%s'", expected.Actor, expected.Reason, entry.Actor, entry.Reason)
+				}
+				if entry.Resource != "deployments" || entry.Namespace != "default" || entry.Name != "foo" {
+					t.Errorf("expected the deployment default/foo, got %s %s/%s", entry.Resource, entry.Namespace, entry.Name)
+				}
+				if entry.FromReplicas != expected.FromReplicas || entry.ToReplicas != expected.ToReplicas {
+					t.Errorf("expected a scale from %d to %d replicas, got from %d to %d", expected.FromReplicas, expected.ToReplicas, entry.FromReplicas, entry.ToReplicas)
+				}
+				if entry.Time.IsZero() {
+					t.Errorf("expected the time of the scale action")
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreAllWritesAuditLog(t *testing.T) {
+	discardLogs(t)
+	config := NewDefaultControllerConfig()
+	config.AuditLog = filepath.Join(t.TempDir(), "audit.log")
+	c, _ := newTestController(t, config, newTestDeployment("foo", 0, map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00", "scheduler.replicas-memory": "3"}))
+
+	c.restoreAll()
+	entries := readAuditLog(t, config.AuditLog)
+	if len(entries) != 1 {
+		t.Fatalf("expected an audit entry, got %+v", entries)
+	}
+	if entries[0].Actor != AUDIT_ACTOR_SHUTDOWN || entries[0].ToReplicas != 3 {
+		t.Errorf("expected the restore to 3 replicas on shutdown, got %+v", entries[0])
+	}
+}
+
+func TestAudit(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		enabled bool
+		actor   string
+		reason  string
+	}{
+		{"with a source", WithAuditSource(context.Background(), AUDIT_ACTOR_HTTP, "POST /scaleDown"), true, AUDIT_ACTOR_HTTP, "POST /scaleDown"},
+		{"without a source", context.Background(), true, AUDIT_ACTOR_UNKNOWN, ""},
+		{"disabled", WithAuditSource(context.Background(), AUDIT_ACTOR_HTTP, "POST /scaleDown"), false, "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.log")
+			config := NewDefaultControllerConfig()
+			if test.enabled {
+				config.AuditLog = path
+			}
+
+			audit(test.ctx, config, "statefulsets.apps", "default", "foo", 2, 0)
+			audit(test.ctx, config, "statefulsets.apps", "default", "foo", 0, 2)
+			entries := readAuditLog(t, path)
+			if !test.enabled {
+				if entries != nil {
+					t.Errorf("expected no audit log, got %+v", entries)
+				}
+				return
+			}
+			// Entries are appended to the same log
+			if len(entries) != 2 {
+				t.Fatalf("expected 2 audit entries, got %+v", entries)
+			}
+			for _, entry := range entries {
+				if entry.Actor != test.actor || entry.Reason != test.reason || entry.Resource != "statefulsets.apps" {
+					t.Errorf("expected the actor %s for '%s', got %+v", test.actor, test.reason, entry)
+				}
+			}
+		})
+	}
+}
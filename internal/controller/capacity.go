@@ -0,0 +1,169 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/dimitris4000/concept02/internal/logging"
+	apps_v1 "k8s.io/api/apps/v1"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// isCapacityAware checks if the scale up of the deployment waits for the
+// cluster to have room for its pods
+func isCapacityAware(config ControllerConfig, deployment *apps_v1.Deployment) bool {
+	capacityAware, _ := ParseBoolAnnotation(deployment.GetAnnotations()[config.Annotation(CAPACITY_AWARE_ANNOTATION)])
+	return capacityAware
+}
+
+// capacityPending delays the scale up of capacity aware deployments while
+// the cluster has no room for the pods of the remembered replicas, to avoid
+// piling up pending pods during a capacity crunch. The following loops
+// retry the scale up.
+func (c *Controller) capacityPending(ctx context.Context, deployment *apps_v1.Deployment) (bool, error) {
+	if !isCapacityAware(c.config, deployment) {
+		return false, nil
+	}
+	value, scaledDown := scaledDownMemory(c.config, deployment.GetAnnotations())
+	if !scaledDown {
+		return false, nil
+	}
+	replicas := rememberedReplicas(c.config, value, fmt.Sprintf("deployment '%s.%s'", deployment.Namespace, deployment.Name)) - *deployment.Spec.Replicas
+	if replicas <= 0 {
+		return false, nil
+	}
+
+	fits, err := c.reserveCapacity(ctx, &deployment.Spec.Template.Spec, replicas)
+	if err != nil {
+		return false, err
+	}
+	if !fits {
+		logging.FromContext(ctx).Info(fmt.Sprintf("Delaying scale up of deployment '%s.%s', the cluster has no capacity for %d more replicas", deployment.Namespace, deployment.Name, replicas))
+	}
+	return !fits, nil
+}
+
+// clusterCapacity caches the free capacity of the cluster for a single
+// loop, so the capacity aware deployments do not list all the nodes and
+// pods of the cluster on every reconcile.
+type clusterCapacity struct {
+	mutex  sync.Mutex
+	loopID string
+	free   core_v1.ResourceList
+}
+
+// reserveCapacity checks if the cluster has room for the requests of the
+// provided number of pods. The free capacity is read once per loop, and the
+// requests of the pods that fit are subtracted from it, since the scale ups
+// of the same loop compete for the same capacity.
+func (c *Controller) reserveCapacity(ctx context.Context, podSpec *core_v1.PodSpec, replicas int32) (bool, error) {
+	c.capacity.mutex.Lock()
+	defer c.capacity.mutex.Unlock()
+
+	loopID, _ := c.loopID.Load().(string)
+	if c.capacity.free == nil || loopID == "" || c.capacity.loopID != loopID {
+		ctx, cancel := context.WithTimeout(ctx, c.config.APITimeout)
+		defer cancel()
+		free, err := freeCapacity(ctx, c.clientset)
+		if err != nil {
+			return false, err
+		}
+		c.capacity.free, c.capacity.loopID = free, loopID
+	}
+
+	required := podRequests(podSpec)
+	for name, quantity := range required {
+		total := quantity.DeepCopy()
+		total.Mul(int64(replicas))
+		required[name] = total
+	}
+	if !hasCapacity(c.capacity.free, required) {
+		return false, nil
+	}
+	for name, quantity := range required {
+		remaining := c.capacity.free[name]
+		remaining.Sub(quantity)
+		c.capacity.free[name] = remaining
+	}
+	return true, nil
+}
+
+// freeCapacity returns the allocatable resources of the schedulable nodes of
+// the cluster minus the requests of the pods running on them.
+func freeCapacity(ctx context.Context, clientset kubernetes.Interface) (core_v1.ResourceList, error) {
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	free := core_v1.ResourceList{}
+	schedulable := map[string]bool{}
+	for _, node := range nodes.Items {
+		if node.Spec.Unschedulable || !isNodeReady(&node) {
+			continue
+		}
+		schedulable[node.Name] = true
+		addResources(free, node.Status.Allocatable)
+	}
+
+	pods, err := clientset.CoreV1().Pods("").List(ctx, meta_v1.ListOptions{FieldSelector: "status.phase!=Succeeded,status.phase!=Failed"})
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range pods.Items {
+		if !schedulable[pod.Spec.NodeName] {
+			continue
+		}
+		for name, quantity := range podRequests(&pod.Spec) {
+			remaining := free[name]
+			remaining.Sub(quantity)
+			free[name] = remaining
+		}
+	}
+	return free, nil
+}
+
+// hasCapacity checks if the free CPU and memory cover the required ones.
+// The check is an estimate over the whole cluster, it does not take the
+// placement of the pods into account.
+func hasCapacity(free, required core_v1.ResourceList) bool {
+	for name, quantity := range required {
+		if name != core_v1.ResourceCPU && name != core_v1.ResourceMemory {
+			continue
+		}
+		if available := free[name]; available.Cmp(quantity) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// isNodeReady checks the Ready condition of the node
+func isNodeReady(node *core_v1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == core_v1.NodeReady {
+			return condition.Status == core_v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// podRequests sums the resource requests of the containers of a pod
+func podRequests(podSpec *core_v1.PodSpec) core_v1.ResourceList {
+	requests := core_v1.ResourceList{}
+	for _, container := range podSpec.Containers {
+		addResources(requests, container.Resources.Requests)
+	}
+	return requests
+}
+
+// addResources adds the quantities of resources to total
+func addResources(total, resources core_v1.ResourceList) {
+	for name, quantity := range resources {
+		sum := total[name]
+		sum.Add(quantity)
+		total[name] = sum
+	}
+}
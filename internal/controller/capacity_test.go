@@ -0,0 +1,192 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	apps_v1 "k8s.io/api/apps/v1"
+	core_v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8s_testing "k8s.io/client-go/testing"
+)
+
+// newTestNode creates a node with the allocatable CPU and memory
+func newTestNode(name, cpu, memory string, ready, unschedulable bool) *core_v1.Node {
+	status := core_v1.ConditionFalse
+	if ready {
+		status = core_v1.ConditionTrue
+	}
+	return &core_v1.Node{
+		ObjectMeta: meta_v1.ObjectMeta{Name: name},
+		Spec:       core_v1.NodeSpec{Unschedulable: unschedulable},
+		Status: core_v1.NodeStatus{
+			Allocatable: core_v1.ResourceList{core_v1.ResourceCPU: resource.MustParse(cpu), core_v1.ResourceMemory: resource.MustParse(memory)},
+			Conditions:  []core_v1.NodeCondition{{Type: core_v1.NodeReady, Status: status}},
+		},
+	}
+}
+
+// newTestPod creates a pod on the node, with a container requesting the
+// CPU and memory
+func newTestPod(name, node, cpu, memory string) *core_v1.Pod {
+	return &core_v1.Pod{
+		ObjectMeta: meta_v1.ObjectMeta{Namespace: "default", Name: name},
+		Spec:       core_v1.PodSpec{NodeName: node, Containers: []core_v1.Container{newTestContainer(cpu, memory)}},
+	}
+}
+
+// newTestContainer creates a container requesting the CPU and memory
+func newTestContainer(cpu, memory string) core_v1.Container {
+	return core_v1.Container{
+		Name: "app",
+		Resources: core_v1.ResourceRequirements{
+			Requests: core_v1.ResourceList{core_v1.ResourceCPU: resource.MustParse(cpu), core_v1.ResourceMemory: resource.MustParse(memory)},
+		},
+	}
+}
+
+// newTestCapacityAwareDeployment creates a capacity aware deployment scaled
+// down from the remembered replicas, whose pods request the CPU and memory
+func newTestCapacityAwareDeployment(name string, remembered, cpu, memory string) *apps_v1.Deployment {
+	deployment := newTestDeployment(name, 0, map[string]string{
+		"scheduler.enabled":         "true",
+		"scheduler.off-schedule":    "20:00-08:00",
+		"scheduler.capacity-aware":  "true",
+		"scheduler.replicas-memory": remembered,
+	})
+	deployment.Spec.Template.Spec.Containers = []core_v1.Container{newTestContainer(cpu, memory)}
+	return deployment
+}
+
+func TestFreeCapacity(t *testing.T) {
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		cpu     string
+		memory  string
+	}{
+		{"no nodes", nil, "0", "0"},
+		{"empty nodes", []runtime.Object{newTestNode("a", "2", "4Gi", true, false), newTestNode("b", "2", "4Gi", true, false)}, "4", "8Gi"},
+		{"pods", []runtime.Object{newTestNode("a", "2", "4Gi", true, false), newTestPod("foo", "a", "500m", "1Gi"), newTestPod("bar", "a", "250m", "512Mi")}, "1250m", "2560Mi"},
+		{"node not ready", []runtime.Object{newTestNode("a", "2", "4Gi", true, false), newTestNode("b", "2", "4Gi", false, false)}, "2", "4Gi"},
+		{"unschedulable node", []runtime.Object{newTestNode("a", "2", "4Gi", true, false), newTestNode("b", "2", "4Gi", true, true)}, "2", "4Gi"},
+		{"pods of a left out node", []runtime.Object{newTestNode("a", "2", "4Gi", true, false), newTestNode("b", "2", "4Gi", true, true), newTestPod("foo", "b", "1", "1Gi")}, "2", "4Gi"},
+		{"pending pods", []runtime.Object{newTestNode("a", "2", "4Gi", true, false), newTestPod("foo", "", "1", "1Gi")}, "2", "4Gi"},
+		{"overcommitted node", []runtime.Object{newTestNode("a", "1", "1Gi", true, false), newTestPod("foo", "a", "1500m", "1Gi")}, "-500m", "0"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, clientset := newTestController(t, NewDefaultControllerConfig())
+			for _, object := range test.objects {
+				if err := clientset.Tracker().Add(object); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			free, err := freeCapacity(context.Background(), clientset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cpu, memory := free[core_v1.ResourceCPU], free[core_v1.ResourceMemory]
+			if cpu.Cmp(resource.MustParse(test.cpu)) != 0 || memory.Cmp(resource.MustParse(test.memory)) != 0 {
+				t.Errorf("expected %s CPU and %s memory, got %s and %s", test.cpu, test.memory, cpu.String(), memory.String())
+			}
+		})
+	}
+}
+
+func TestReconcileCapacityAware(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name       string
+		deployment *apps_v1.Deployment
+		nodes      []runtime.Object
+		replicas   int32
+	}{
+		{"enough capacity", newTestCapacityAwareDeployment("foo", "3", "500m", "1Gi"), []runtime.Object{newTestNode("a", "2", "4Gi", true, false)}, 3},
+		{"exactly enough capacity", newTestCapacityAwareDeployment("foo", "4", "500m", "1Gi"), []runtime.Object{newTestNode("a", "2", "4Gi", true, false)}, 4},
+		{"not enough CPU", newTestCapacityAwareDeployment("foo", "5", "500m", "512Mi"), []runtime.Object{newTestNode("a", "2", "4Gi", true, false)}, 0},
+		{"not enough memory", newTestCapacityAwareDeployment("foo", "3", "100m", "2Gi"), []runtime.Object{newTestNode("a", "2", "4Gi", true, false)}, 0},
+		{"no nodes", newTestCapacityAwareDeployment("foo", "1", "100m", "128Mi"), nil, 0},
+		{"capacity of ready nodes only", newTestCapacityAwareDeployment("foo", "3", "1", "1Gi"), []runtime.Object{newTestNode("a", "2", "4Gi", true, false), newTestNode("b", "2", "4Gi", false, false)}, 0},
+		{"not capacity aware", func() *apps_v1.Deployment {
+			deployment := newTestCapacityAwareDeployment("foo", "5", "500m", "512Mi")
+			deployment.Annotations["scheduler.capacity-aware"] = "false"
+			return deployment
+		}(), []runtime.Object{newTestNode("a", "2", "4Gi", true, false)}, 5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, clientset := newTestController(t, NewDefaultControllerConfig(), test.deployment)
+			c.SetClock(&fakeClock{now: time.Date(2024, time.June, 4, 12, 0, 0, 0, time.UTC)})
+			for _, node := range test.nodes {
+				if err := clientset.Tracker().Add(node); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := c.reconcile(context.Background(), "default/foo"); err != nil {
+				t.Fatal(err)
+			}
+			current, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *current.Spec.Replicas != test.replicas {
+				t.Errorf("expected %d replicas, got %d", test.replicas, *current.Spec.Replicas)
+			}
+		})
+	}
+}
+
+func TestReserveCapacityPerLoop(t *testing.T) {
+	discardLogs(t)
+	// Both deployments fit in the cluster, but not together
+	foo := newTestCapacityAwareDeployment("foo", "2", "500m", "1Gi")
+	bar := newTestCapacityAwareDeployment("bar", "2", "500m", "1Gi")
+	c, clientset := newTestController(t, NewDefaultControllerConfig(), foo, bar)
+	c.SetClock(&fakeClock{now: time.Date(2024, time.June, 4, 12, 0, 0, 0, time.UTC)})
+	if err := clientset.Tracker().Add(newTestNode("a", "1500m", "4Gi", true, false)); err != nil {
+		t.Fatal(err)
+	}
+	nodeLists := 0
+	clientset.PrependReactor("list", "nodes", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+		nodeLists++
+		return false, nil, nil
+	})
+
+	steps := []struct {
+		name       string
+		loopID     string
+		deployment string
+		replicas   int32
+		nodeLists  int
+	}{
+		{"first scale up of the loop", "loop-1", "foo", 2, 1},
+		{"capacity taken in the same loop", "loop-1", "bar", 0, 1},
+		// The scaled up pods do not exist in the fake cluster, so the next
+		// loop sees the whole capacity again
+		{"next loop", "loop-2", "bar", 2, 2},
+	}
+	for _, step := range steps {
+		c.loopID.Store(step.loopID)
+		if err := c.reconcile(context.Background(), "default/"+step.deployment); err != nil {
+			t.Fatalf("%s: %s", step.name, err)
+		}
+		current, err := clientset.AppsV1().Deployments("default").Get(context.Background(), step.deployment, meta_v1.GetOptions{})
+		if err != nil {
+			t.Fatalf("%s: %s", step.name, err)
+		}
+		if *current.Spec.Replicas != step.replicas {
+			t.Errorf("%s: expected %d replicas, got %d", step.name, step.replicas, *current.Spec.Replicas)
+		}
+		if nodeLists != step.nodeLists {
+			t.Errorf("%s: expected %d node lists, got %d", step.name, step.nodeLists, nodeLists)
+		}
+	}
+}
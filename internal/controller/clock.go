@@ -0,0 +1,38 @@
+package controller
+
+import "time"
+
+// Clock provides the current time to the controller, so the schedule
+// decisions can be tested against any point in time.
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock is the Clock used by default, it returns time.Now()
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// now returns the time of the configured Clock, or of the real clock if
+// none is configured
+func (config ControllerConfig) now() time.Time {
+	if config.Clock == nil {
+		return time.Now()
+	}
+	return config.Clock.Now()
+}
+
+// SetClock replaces the clock the controller evaluates the schedules with.
+// It must be called before the controller is started.
+func (c *Controller) SetClock(clock Clock) {
+	c.clock = clock
+	c.config.Clock = clock
+}
+
+// Now returns the current time of the controller's clock, so the schedules
+// are described at the same time they are evaluated.
+func (c *Controller) Now() time.Time {
+	return c.clock.Now()
+}
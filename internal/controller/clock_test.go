@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// syncCache copies the deployments of the fake clientset to the informer's
+// cache, as the running informer would
+func syncCache(t *testing.T, c *Controller, clientset *fake.Clientset) {
+	t.Helper()
+	deployments, err := clientset.AppsV1().Deployments("").List(context.Background(), meta_v1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range deployments.Items {
+		if err := c.deploymentInformer.GetIndexer().Update(&deployments.Items[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReconcileFollowsTheClock(t *testing.T) {
+	discardLogs(t)
+	c, clientset := newTestController(t, NewDefaultControllerConfig(), newTestDeployment("foo", 3, map[string]string{
+		"scheduler.enabled":      "true",
+		"scheduler.off-schedule": "20:00-08:00",
+	}))
+	clock := &fakeClock{}
+	c.SetClock(clock)
+
+	tests := []struct {
+		name     string
+		now      time.Time
+		replicas int32
+	}{
+		{"before the window", time.Date(2024, time.June, 3, 19, 59, 59, 0, time.UTC), 3},
+		{"window start", time.Date(2024, time.June, 3, 20, 0, 0, 0, time.UTC), 0},
+		{"before the window end", time.Date(2024, time.June, 4, 7, 59, 59, 0, time.UTC), 0},
+		{"window end", time.Date(2024, time.June, 4, 8, 0, 0, 0, time.UTC), 3},
+	}
+	// The steps run in order, each one reconciling the outcome of the
+	// previous one
+	for _, test := range tests {
+		clock.Set(test.now)
+		if err := c.reconcile(context.Background(), "default/foo"); err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		syncCache(t, c, clientset)
+
+		deployment, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *deployment.Spec.Replicas != test.replicas {
+			t.Errorf("%s: expected %d replicas, got %d", test.name, test.replicas, *deployment.Spec.Replicas)
+		}
+	}
+}
+
+func TestControllerDefaultsToRealClock(t *testing.T) {
+	c, _ := newTestController(t, NewDefaultControllerConfig())
+	before := time.Now()
+	now := c.clock.Now()
+	if now.Before(before) || now.After(time.Now()) {
+		t.Errorf("expected the current time, got %s", now)
+	}
+}
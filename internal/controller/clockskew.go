@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+// clockSkewInterval is how often the clock of the controller is compared
+// with the clock of the API server
+const clockSkewInterval = 10 * time.Minute
+
+// checkClockSkew compares the clock of the controller with the clock of the
+// API server, read from the Date header of a request to its /version
+// endpoint. The skew is exported as the clock skew metric and a skew above
+// MaxClockSkew is logged as an error, since the schedules are evaluated with
+// the controller's clock.
+func (c *Controller) checkClockSkew(ctx context.Context) {
+	restClient, ok := c.clientset.Discovery().RESTClient().(*rest.RESTClient)
+	if !ok || restClient == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.config.APITimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, restClient.Get().AbsPath("/version").URL().String(), nil)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to check the clock skew: %s", err))
+		return
+	}
+	sent := c.clock.Now()
+	response, err := restClient.Client.Do(request)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to check the clock skew: %s", err))
+		return
+	}
+	response.Body.Close()
+	received := c.clock.Now()
+	serverTime, err := http.ParseTime(response.Header.Get("Date"))
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to check the clock skew, invalid Date header '%s' of the API server", response.Header.Get("Date")))
+		return
+	}
+
+	skew := clockSkew(sent, received, serverTime)
+	clockSkewSeconds.Set(skew.Seconds())
+	if c.config.MaxClockSkew > 0 && skew.Abs() > c.config.MaxClockSkew {
+		slog.Error(fmt.Sprintf("The clock of the controller is %s off the clock of the API server, above the maximum of %s. Deployments will be scaled at the wrong times until the clock of the node is fixed", skew, c.config.MaxClockSkew))
+	}
+}
+
+// clockSkew returns how far ahead the local clock is of a server that
+// responded with serverTime to a request sent at sent and answered at
+// received, both local times. The server time is assumed to be taken
+// halfway through the request. The Date header truncates the server time to
+// the second, which would make the local clock look up to a second ahead,
+// so the server time is taken in the middle of its second instead. What is
+// left of the error is within half a second, below the rounding of the skew
+// to the second.
+func clockSkew(sent, received, serverTime time.Time) time.Duration {
+	local := sent.Add(received.Sub(sent) / 2)
+	return local.Sub(serverTime.Add(time.Second / 2)).Round(time.Second)
+}
+
+// runClockSkewChecks checks the clock skew right away and then every
+// clockSkewInterval, until the context is done
+func (c *Controller) runClockSkewChecks(ctx context.Context) {
+	ticker := time.NewTicker(clockSkewInterval)
+	defer ticker.Stop()
+	for {
+		c.checkClockSkew(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package controller
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClockSkew(t *testing.T) {
+	at := func(minute, second, millisecond int) time.Time {
+		return time.Date(2024, time.June, 3, 12, minute, second, millisecond*int(time.Millisecond), time.UTC)
+	}
+	tests := []struct {
+		name       string
+		sent       time.Time
+		received   time.Time
+		serverTime time.Time
+		skew       time.Duration
+	}{
+		{"in sync late in the second", at(0, 0, 600), at(0, 0, 800), at(0, 0, 0), 0},
+		{"in sync early in the second", at(0, 0, 50), at(0, 0, 150), at(0, 0, 0), 0},
+		{"in sync with a slow request", at(0, 0, 200), at(0, 4, 200), at(0, 2, 0), 0},
+		{"ahead", at(0, 30, 600), at(0, 30, 800), at(0, 0, 0), 30 * time.Second},
+		{"behind", at(0, 30, 200), at(0, 30, 400), at(1, 0, 0), -30 * time.Second},
+		{"ahead by minutes", at(5, 0, 400), at(5, 0, 600), at(0, 0, 0), 5 * time.Minute},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if skew := clockSkew(test.sent, test.received, test.serverTime); skew != test.skew {
+				t.Errorf("expected a skew of %s, got %s", test.skew, skew)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// DEFAULT_ANNOTATION_PREFIX is the prefix that all the *_ANNOTATION constants
+// share. It can be replaced by ControllerConfig.AnnotationPrefix.
+const DEFAULT_ANNOTATION_PREFIX = "scheduler."
+
+// Strategies for writing replica changes to the k8s API
+const (
+	UPDATE_STRATEGY_UPDATE = "update"
+	UPDATE_STRATEGY_PATCH  = "patch"
+	UPDATE_STRATEGY_APPLY  = "apply"
+)
+
+// Values of the scale-down-mode annotation. The pause mode also pauses the
+// rollouts of the deployment while it is scaled down.
+const (
+	SCALE_DOWN_MODE_REPLICAS = "replicas"
+	SCALE_DOWN_MODE_PAUSE    = "pause"
+)
+
+// FIELD_MANAGER is the field manager used for server-side apply
+const FIELD_MANAGER = "concept02-scheduler"
+
+// ControllerConfig is holding all the configuration of the scheduler
+// controller and the helpers that act on deployments.
+type ControllerConfig struct {
+	AnnotationPrefix string
+	// DefaultSchedule applies to enabled deployments without a schedule
+	// annotation. Empty means no default schedule.
+	DefaultSchedule string
+	// DefaultTimezone is the time zone of deployments without a timezone
+	// annotation. Empty means UTC.
+	DefaultTimezone string
+	// APITimeout bounds the duration of the k8s API calls
+	APITimeout time.Duration
+	// KubeQPS and KubeBurst are the client side rate limits of the k8s API
+	// clients. The client-go defaults (5 QPS, 10 burst) throttle the
+	// controller when many deployments change state at the same time.
+	KubeQPS   float64
+	KubeBurst int
+	// ReconcileQPS and ReconcileBurst bound the overall rate of reconciles
+	ReconcileQPS   float64
+	ReconcileBurst int
+	// ToggleConcurrency is the number of deployments reconciled, and so
+	// toggled, in parallel
+	ToggleConcurrency int
+	// RetryBaseDelay and RetryMaxDelay configure the exponential backoff
+	// of deployments that failed to reconcile
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+	// UpdateStrategy is one of the UPDATE_STRATEGY_* constants
+	UpdateStrategy string
+	// FallbackReplicas is the replicas number restored when the replicas
+	// memory annotation can not be parsed
+	FallbackReplicas int
+	// MaxScaleDownPerLoop limits the deployments scaled down in a single
+	// resync interval. Failed or postponed scale downs do not count towards
+	// the limit. Zero means unlimited.
+	MaxScaleDownPerLoop int
+	// NotifyURL is the webhook notified when a deployment is scaled. Empty
+	// means no notifications.
+	NotifyURL string
+	// NotifyTimeout bounds every request to the webhook
+	NotifyTimeout time.Duration
+	// NotifyRetries and NotifyBackoff configure the retries of failed
+	// requests, the backoff doubles on every retry
+	NotifyRetries int
+	NotifyBackoff time.Duration
+	// StartupAttempts and StartupBackoff configure the retries of the
+	// connection to the k8s API on startup, the backoff doubles on every
+	// attempt
+	StartupAttempts int
+	StartupBackoff  time.Duration
+	// SkipRBACCheck disables the check of the controller's permissions on
+	// startup
+	SkipRBACCheck bool
+	// RestoreOnShutdown scales the deployments the controller has scaled
+	// down back up when it stops, bounded by RestoreTimeout
+	RestoreOnShutdown bool
+	RestoreTimeout    time.Duration
+	// ScheduleConfigMap is the '<namespace>/<name>' of a ConfigMap mapping
+	// '<namespace>.<deployment>' keys to schedules. The listed deployments
+	// are scheduled without annotations of their own. Empty means none.
+	ScheduleConfigMap string
+	// ReplicasConfigMap is the '<namespace>/<name>' of a ConfigMap keeping
+	// the remembered replicas of the scaled down deployments under
+	// '<namespace>.<deployment>' keys, instead of their replicas memory
+	// annotation. Empty means the annotation.
+	ReplicasConfigMap string
+	// IgnoreLabels is a comma separated list of 'key=value' labels, or bare
+	// 'key' labels matching any value, of deployments that are never
+	// scheduled, e.g. the workloads of other tools. A deployment with any of
+	// the labels is ignored like an excluded one. Empty means none.
+	IgnoreLabels string
+	// ReplicaStore replaces the built-in stores of the remembered replicas,
+	// e.g. with an external storage. Nil means the ReplicasConfigMap, or the
+	// replicas memory annotation if none is configured.
+	ReplicaStore ReplicaStore
+	// Clock replaces the clock the schedules are evaluated and the restores
+	// are stamped with, e.g. in tests. Nil means the real clock.
+	Clock Clock
+	// KeepReplicasMemory keeps the replicas memory of an object on restore,
+	// updated to the replicas it was restored to. The time of the restore is
+	// recorded in the last-restored-replicas annotation, which marks the
+	// kept memory as restored until the object is scaled down again.
+	KeepReplicasMemory bool
+	// AuditLog is the file every change of replicas is appended to as a JSON
+	// line, or AUDIT_LOG_STDOUT for stdout. Empty disables the audit log.
+	AuditLog string
+	// PerDeploymentMetrics exports metrics labeled with the namespace and
+	// name of every deployment, on top of the aggregate ones.
+	// MaxDeploymentSeries caps the number of deployments with such series.
+	// Zero means unlimited.
+	PerDeploymentMetrics bool
+	MaxDeploymentSeries  int
+	// PrometheusURL is the Prometheus server queried for the request rate
+	// of deployments in idle mode, using IdleQuery. Empty disables the
+	// idle mode.
+	PrometheusURL string
+	IdleQuery     string
+	// MaxWindowLength is the length above which the windows of a schedule
+	// are reported as suspicious, e.g. '08:00-07:00' instead of
+	// '07:00-08:00'. Zero disables the warnings.
+	MaxWindowLength time.Duration
+	// MaxClockSkew is the difference between the clocks of the controller
+	// and the k8s API server above which the controller logs errors. Zero
+	// disables the errors, the skew is exported as a metric either way.
+	MaxClockSkew time.Duration
+}
+
+// NewDefaultControllerConfig is used to create an initial ControllerConfig
+// instance with sane defaults
+func NewDefaultControllerConfig() ControllerConfig {
+	return ControllerConfig{
+		AnnotationPrefix:     DEFAULT_ANNOTATION_PREFIX,
+		APITimeout:           30 * time.Second,
+		KubeQPS:              20,
+		KubeBurst:            30,
+		ReconcileQPS:         10,
+		ReconcileBurst:       100,
+		ToggleConcurrency:    4,
+		RetryBaseDelay:       time.Second,
+		RetryMaxDelay:        5 * time.Minute,
+		UpdateStrategy:       UPDATE_STRATEGY_UPDATE,
+		FallbackReplicas:     1,
+		NotifyTimeout:        5 * time.Second,
+		NotifyRetries:        3,
+		NotifyBackoff:        time.Second,
+		StartupAttempts:      10,
+		StartupBackoff:       time.Second,
+		RestoreTimeout:       time.Minute,
+		PerDeploymentMetrics: true,
+		MaxDeploymentSeries:  1000,
+		IdleQuery:            DEFAULT_IDLE_QUERY,
+		MaxClockSkew:         30 * time.Second,
+	}
+}
+
+// Annotation returns the actual key of one of the *_ANNOTATION constants
+// after applying the configured annotation prefix to it.
+func (c ControllerConfig) Annotation(annotation string) string {
+	return c.AnnotationPrefix + strings.TrimPrefix(annotation, DEFAULT_ANNOTATION_PREFIX)
+}
+
+// boolAnnotationAliases are the values accepted by boolean annotations on
+// top of the ones of strconv.ParseBool
+var boolAnnotationAliases = map[string]bool{
+	"yes": true,
+	"on":  true,
+	"no":  false,
+	"off": false,
+}
+
+// ParseBoolAnnotation parses the value of a boolean annotation. Surrounding
+// whitespace is ignored and, besides the values of strconv.ParseBool,
+// yes/no and on/off are accepted in any case. ok is false for unrecognized
+// values.
+func ParseBoolAnnotation(value string) (result bool, ok bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if result, err := strconv.ParseBool(value); err == nil {
+		return result, true
+	}
+	result, ok = boolAnnotationAliases[value]
+	return result, ok
+}
+
+// IsEnabled checks if the annotations enable the scheduling of a deployment.
+// The exclude annotation takes precedence over the enabled one.
+func IsEnabled(config ControllerConfig, annotations map[string]string) bool {
+	enabled, _ := ParseBoolAnnotation(annotations[config.Annotation(ENABLED_ANNOTATION)])
+	return enabled && !isExcluded(config, annotations)
+}
+
+// isExcluded checks if the annotations opt the deployment out of any
+// scheduling
+func isExcluded(config ControllerConfig, annotations map[string]string) bool {
+	excluded, _ := ParseBoolAnnotation(annotations[config.Annotation(EXCLUDE_ANNOTATION)])
+	return excluded
+}
+
+// ignoredLabel is one of the labels of ControllerConfig.IgnoreLabels. Bare
+// keys match any value.
+type ignoredLabel struct {
+	key   string
+	value string
+	any   bool
+}
+
+// parseIgnoreLabels parses the labels of ControllerConfig.IgnoreLabels
+func parseIgnoreLabels(text string) ([]ignoredLabel, error) {
+	var ignored []ignoredLabel
+	for _, token := range strings.Split(text, ",") {
+		token = strings.TrimSpace(token)
+		if token == "" {
+			continue
+		}
+		key, value, found := strings.Cut(token, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid label '%s', expected 'key=value' or 'key': %s", token, strings.Join(errs, ", "))
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid label '%s', expected 'key=value' or 'key': %s", token, strings.Join(errs, ", "))
+		}
+		ignored = append(ignored, ignoredLabel{key: key, value: value, any: !found})
+	}
+	return ignored, nil
+}
+
+// ignoredLabelOf returns the first of the parsed IgnoreLabels the labels of
+// a deployment match, in the 'key=value' form. False is returned if none
+// matches.
+func ignoredLabelOf(ignored []ignoredLabel, labels map[string]string) (string, bool) {
+	for _, label := range ignored {
+		if value, exists := labels[label.key]; exists && (label.any || value == label.value) {
+			return label.key + "=" + value, true
+		}
+	}
+	return "", false
+}
@@ -2,12 +2,22 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
 	"strings"
+	"sync/atomic"
 	"time"
+	"unicode"
 
+	"github.com/dimitris4000/concept02/internal/logging"
+	"golang.org/x/time/rate"
 	apps_v1 "k8s.io/api/apps/v1"
+	core_v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -16,12 +26,43 @@ import (
 	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
 )
 
 const (
-	REPLICAS_MEMORY_ANNOTATION = "scheduler.replicas-memory"
-	SCHEDULE_ANNOTATION        = "scheduler.off-schedule"
-	ENABLED_ANNOTATION         = "scheduler.enabled"
+	REPLICAS_MEMORY_ANNOTATION     = "scheduler.replicas-memory"
+	SCHEDULE_ANNOTATION            = "scheduler.off-schedule"
+	ENABLED_ANNOTATION             = "scheduler.enabled"
+	GRACEFUL_SCALE_DOWN_ANNOTATION = "scheduler.graceful-scale-down"
+	SCHEDULE_REF_ANNOTATION        = "scheduler.schedule-ref"
+	ERROR_ANNOTATION               = "scheduler.error"
+	TIMEZONE_ANNOTATION            = "scheduler.timezone"
+	EXCEPTIONS_ANNOTATION          = "scheduler.schedule-exceptions"
+	OVERRIDE_ANNOTATION            = "scheduler.override"
+	PRE_WARM_ANNOTATION            = "scheduler.pre-warm"
+	JITTER_ANNOTATION              = "scheduler.jitter"
+	REQUIRE_SIGNAL_ANNOTATION      = "scheduler.require-signal"
+	FORCE_ANNOTATION               = "scheduler.force"
+	SCALE_DOWN_MODE_ANNOTATION     = "scheduler.scale-down-mode"
+	OFF_REPLICAS_ANNOTATION        = "scheduler.off-replicas"
+	MIN_REPLICAS_ANNOTATION        = "scheduler.min-replicas"
+	ON_REPLICAS_ANNOTATION         = "scheduler.on-replicas"
+	RESTING_REPLICAS_ANNOTATION    = "scheduler.resting-replicas"
+	NAMESPACE_SCHEDULE_ANNOTATION  = "scheduler.default-off-schedule"
+	EXCLUDE_ANNOTATION             = "scheduler.exclude"
+	SCALE_DOWN_DELAY_ANNOTATION    = "scheduler.scale-down-delay"
+	OFF_SINCE_ANNOTATION           = "scheduler.off-since"
+	TARGET_ANNOTATION              = "scheduler.target"
+	CAPACITY_AWARE_ANNOTATION      = "scheduler.capacity-aware"
+	PAUSE_UNTIL_ANNOTATION         = "scheduler.pause-until"
+	DRAIN_CHECK_URL_ANNOTATION     = "scheduler.drain-check-url"
+	IDLE_TIMEOUT_ANNOTATION        = "scheduler.idle-timeout"
+	IDLE_SINCE_ANNOTATION          = "scheduler.idle-since"
+	OFF_DAYS_ANNOTATION            = "scheduler.off-days"
+	RECOMMENDED_STATE_ANNOTATION   = "scheduler.recommended-state"
+	LAST_RESTORED_ANNOTATION       = "scheduler.last-restored-replicas"
+	GRACE_AFTER_CREATE_ANNOTATION  = "scheduler.grace-after-create"
+	REPLICA_WINDOWS_ANNOTATION     = "scheduler.replica-windows"
 )
 
 // DeploymentState is used across the controller package to designate whether
@@ -33,43 +74,236 @@ const (
 	DISABLED DeploymentState = false
 )
 
+// String returns "up" for ENABLED and "down" for DISABLED
+func (s DeploymentState) String() string {
+	if s == ENABLED {
+		return "up"
+	}
+	return "down"
+}
+
+// ParseDeploymentState parses the "up" and "down" forms of a DeploymentState
+func ParseDeploymentState(text string) (DeploymentState, error) {
+	switch strings.ToLower(strings.TrimSpace(text)) {
+	case "up":
+		return ENABLED, nil
+	case "down":
+		return DISABLED, nil
+	}
+	return DISABLED, fmt.Errorf("invalid state '%s', expected up or down", text)
+}
+
 const postRestartBackoffPeriod = 7200
 
+// resyncInterval is how often all the deployments are reconciled
+const resyncInterval = 5 * time.Second
+
+// Layouts of the boundaries of a TimeRange
+const (
+	CLOCK_LAYOUT_MINUTES = "15:04"
+	CLOCK_LAYOUT_SECONDS = "15:04:05"
+)
+
 // TimeRange represents a time range taking only into account hour and
-// minute component of Time value.
+// minute component of Time value, or also the second component if Seconds
+// is set. The range can be limited to specific days of the week, in which
+// case a range crossing midnight belongs to the day it starts on.
 type TimeRange struct {
-	Start time.Time
-	End   time.Time
+	Start   time.Time
+	End     time.Time
+	Days    Weekdays
+	Seconds bool
+}
+
+// Layout returns the layout the boundaries of the range are compared with
+func (t TimeRange) Layout() string {
+	if t.Seconds {
+		return CLOCK_LAYOUT_SECONDS
+	}
+	return CLOCK_LAYOUT_MINUTES
 }
 
 // InRangeNow checks if the current time (i.e. time.Now()) is between the
 // Sart and End times configured in the TimeRange object. The function
 // ignores the Year, Month, Day and Second components of the time values.
+// The range includes the whole minute of Start and excludes the minute of
+// End, so '18:00-19:00' is entered at 18:00:00 and left at 19:00:00.
 // If the Start time is after the End time, the function will assume that
 // the range crosses to the midnight time an respond accordingly.
 func (t TimeRange) InRangeNow() bool {
-	now, _ := time.Parse("15:04", time.Now().Format("15:04"))
-	var result bool
+	return t.InRange(time.Now())
+}
+
+// InRange is the same as InRangeNow but checks the provided time instead
+// of the current one. The boundaries of the range are placed on the date of
+// now in now's location, following the daylight saving time rules of
+// zonedClock.
+func (t TimeRange) InRange(now time.Time) bool {
+	// Truncating now to the precision of the range makes the whole minute
+	// (or second) of Start part of the range
+	precision := time.Minute
+	if t.Seconds {
+		precision = time.Second
+	}
+	now = now.Truncate(precision)
+	year, month, day := now.Date()
+	start := zonedClock(year, month, day, clockOffset(t.Start), now.Location())
+	end := zonedClock(year, month, day, clockOffset(t.End), now.Location())
 	if t.End.Before(t.Start) {
-		result = now.After(t.Start) || now.Before(t.End)
-	} else {
-		result = now.After(t.Start) && now.Before(t.End)
+		if !now.Before(start) {
+			return t.Days.Contains(now.Weekday())
+		}
+		// After midnight the range belongs to the previous day
+		return now.Before(end) && t.Days.Contains(now.AddDate(0, 0, -1).Weekday())
 	}
-	return result
+	return !now.Before(start) && now.Before(end) && t.Days.Contains(now.Weekday())
+}
+
+// String returns the time range in the format accepted by ParseSchedule
+func (t TimeRange) String() string {
+	timeRange := fmt.Sprintf("%s-%s", t.Start.Format(t.Layout()), t.End.Format(t.Layout()))
+	if days := t.Days.String(); days != "" {
+		return days + " " + timeRange
+	}
+	return timeRange
 }
 
 // Controller holds the components of the schedule controller
 type Controller struct {
 	clientset          kubernetes.Interface
 	deploymentInformer cache.SharedIndexInformer
+	configMapInformer  cache.SharedIndexInformer
+	namespaceInformer  cache.SharedIndexInformer
+	queue              workqueue.RateLimitingInterface
+	limiter            *rate.Limiter
+	lastReconcileTime  atomic.Int64
+	reconcileLoops     atomic.Int64
+	scaleDowns         atomic.Int64
+	loopID             atomic.Value
+	capacity           clusterCapacity
+	replicas           ReplicaStore
+	ignoredLabels      []ignoredLabel
+	signals            *signalChecker
+	drains             *drainChecker
+	prometheus         *prometheusClient
+	notifier           *notifier
+	events             *eventBroadcaster
+	series             *deploymentSeries
+	clock              Clock
+	done               chan struct{}
+	reload             chan struct{}
+	config             ControllerConfig
 }
 
 // NewResourceController can be used to initialize a Controller object in an
 // easy way.
-func NewResourceController(client kubernetes.Interface, deploymentInformer cache.SharedIndexInformer) *Controller {
-	return &Controller{
+func NewResourceController(client kubernetes.Interface, deploymentInformer, configMapInformer, namespaceInformer cache.SharedIndexInformer, config ControllerConfig) *Controller {
+	// The bucket limiter bounds the overall rate of reconciles while the
+	// per-item limiter backs off deployments that keep failing.
+	limiter := rate.NewLimiter(rate.Limit(config.ReconcileQPS), config.ReconcileBurst)
+	c := &Controller{
 		clientset:          client,
 		deploymentInformer: deploymentInformer,
+		configMapInformer:  configMapInformer,
+		namespaceInformer:  namespaceInformer,
+		queue:              workqueue.NewNamedRateLimitingQueue(newQueueRateLimiter(config, limiter), "deployments"),
+		limiter:            limiter,
+		signals:            newSignalChecker(&http.Client{Timeout: config.APITimeout}),
+		drains:             newDrainChecker(&http.Client{Timeout: config.APITimeout}),
+		clock:              realClock{},
+		done:               make(chan struct{}),
+		reload:             make(chan struct{}, 1),
+		notifier:           newNotifier(config),
+		events:             newEventBroadcaster(),
+		series:             newDeploymentSeries(config),
+		replicas:           newReplicaStore(client, config),
+		config:             config,
+	}
+
+	if config.Clock != nil {
+		c.clock = config.Clock
+	}
+	if config.PrometheusURL != "" {
+		c.prometheus = newPrometheusClient(config.PrometheusURL, &http.Client{Timeout: config.APITimeout})
+	}
+
+	// Only changed deployments are queued by the informer. Schedule
+	// transitions are picked up by the periodic resync of loopIteration.
+	deploymentInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			c.warnLongWindows(nil, obj)
+			c.enqueue(obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			c.warnLongWindows(oldObj, newObj)
+			c.publishReplicaChange(oldObj, newObj)
+			c.enqueue(newObj)
+		},
+		DeleteFunc: c.onDeploymentDeleted,
+	})
+
+	return c
+}
+
+// newQueueRateLimiter returns the rate limiter of the controller's queue,
+// backing off the deployments that keep failing within the overall rate of
+// the limiter
+func newQueueRateLimiter(config ControllerConfig, limiter *rate.Limiter) workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(config.RetryBaseDelay, config.RetryMaxDelay),
+		&workqueue.BucketRateLimiter{Limiter: limiter},
+	)
+}
+
+// enqueue adds the key of a deployment to the controller's queue
+func (c *Controller) enqueue(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.queue.Add(key)
+}
+
+// deploymentFromObject returns the deployment of an informer event. The
+// deletions the informer missed while it was disconnected from the API
+// server are delivered as DeletedFinalStateUnknown tombstones, which wrap
+// the last known state of the deployment.
+func deploymentFromObject(obj interface{}) (*apps_v1.Deployment, bool) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	deployment, ok := obj.(*apps_v1.Deployment)
+	return deployment, ok
+}
+
+// onDeploymentDeleted drops the state the controller keeps for a deleted
+// deployment: its per-deployment metrics, its retry backoff and the cached
+// results of its signal and drain endpoints.
+func (c *Controller) onDeploymentDeleted(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.deleteDeploymentMetrics(namespace, name)
+	c.queue.Forget(key)
+
+	// The tombstone of a deletion may carry no deployment at all
+	deployment, ok := deploymentFromObject(obj)
+	if !ok {
+		return
+	}
+	if url := strings.TrimSpace(deployment.GetAnnotations()[c.config.Annotation(REQUIRE_SIGNAL_ANNOTATION)]); url != "" {
+		c.signals.Forget(url)
+	}
+	if url := strings.TrimSpace(deployment.GetAnnotations()[c.config.Annotation(DRAIN_CHECK_URL_ANNOTATION)]); url != "" {
+		c.drains.Forget(url)
 	}
 }
 
@@ -77,11 +311,15 @@ func NewResourceController(client kubernetes.Interface, deploymentInformer cache
 // This methods is supposed to be run as a goroutine. The loop will keep
 // running until the stopCh is closed.
 func (c *Controller) Run(stopCh <-chan struct{}) {
+	defer close(c.done)
 	defer utilruntime.HandleCrash()
+	defer c.queue.ShutDown()
 
 	slog.Info("Starting scheduler controller")
 
 	go c.deploymentInformer.Run(stopCh)
+	go c.configMapInformer.Run(stopCh)
+	go c.namespaceInformer.Run(stopCh)
 
 	// Waiting for client-go to load the cache
 	if !cache.WaitForCacheSync(stopCh, c.HasSynced) {
@@ -91,13 +329,46 @@ func (c *Controller) Run(stopCh <-chan struct{}) {
 
 	slog.Info("Scheduler controller synced and ready")
 
-	// Run the controller's logic every 5sec
-	wait.Until(c.loopIteration, 5*time.Second, stopCh)
+	// Closing stopCh cancels the context and with it any in-flight API call.
+	ctx := wait.ContextForChannel(stopCh)
+	c.startWorkers(ctx)
+	if c.notifier != nil {
+		go c.notifier.Run(ctx)
+	}
+	go c.runReloads(ctx)
+	go c.runClockSkewChecks(ctx)
+
+	c.resync(ctx)
+
+	if c.config.RestoreOnShutdown {
+		c.restoreAll()
+	}
+}
+
+// resync queues all the deployments right away and then every
+// resyncInterval, until the context is done. The first resync does not wait
+// for the interval, so deployments left in the wrong state while the
+// controller was down (e.g. still scaled down past their window) recover
+// promptly after a restart.
+func (c *Controller) resync(ctx context.Context) {
+	slog.Info(fmt.Sprintf("Reconciling all %d deployments on start", len(c.deploymentInformer.GetIndexer().ListKeys())))
+	c.loopIteration(ctx)
+
+	ticker := time.NewTicker(resyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.loopIteration(ctx)
+		}
+	}
 }
 
 // HasSynced is required for the cache.Controller interface.
 func (c *Controller) HasSynced() bool {
-	return c.deploymentInformer.HasSynced()
+	return c.deploymentInformer.HasSynced() && c.configMapInformer.HasSynced() && c.namespaceInformer.HasSynced()
 }
 
 // LastSyncResourceVersion is required for the cache.Controller interface.
@@ -106,104 +377,936 @@ func (c *Controller) LastSyncResourceVersion() string {
 }
 
 // loopIteration contains the logic of the controller that needs to be run in every
-// loop. It is supposed to be called from within the controllers loop only.
-func (c *Controller) loopIteration() {
-	// Check deployments with scheduler.enabled:"true" annotation
-	for _, deploymentName := range c.deploymentInformer.GetIndexer().ListKeys() {
-		deployment, exists, err := c.deploymentInformer.GetIndexer().GetByKey(deploymentName)
+// loop. It is supposed to be called from within the controllers loop only. It
+// queues every known deployment, since schedule transitions are not reflected
+// in any informer event.
+func (c *Controller) loopIteration(ctx context.Context) {
+	c.lastReconcileTime.Store(c.clock.Now().UnixNano())
+	c.reconcileLoops.Add(1)
+	reconcileTotal.Inc()
+	c.scaleDowns.Store(0)
+	c.loopID.Store(logging.NewCorrelationID())
+	keys := c.deploymentInformer.GetIndexer().ListKeys()
+	for _, deploymentName := range keys {
+		c.queue.Add(deploymentName)
+	}
+	reconcileDeployments.Set(float64(len(keys)))
+}
+
+// Reload requests an immediate resync of all the deployments, instead of
+// waiting for the next resync interval. Requests made while a resync is
+// pending are coalesced into it.
+func (c *Controller) Reload() {
+	select {
+	case c.reload <- struct{}{}:
+	default:
+	}
+}
+
+// runReloads runs the resyncs requested by Reload until the context is done
+func (c *Controller) runReloads(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.reload:
+			c.loopIteration(ctx)
+		}
+	}
+}
+
+// startWorkers starts the ToggleConcurrency workers reconciling the queued
+// deployments in parallel, until the context is done. A deployment is never
+// reconciled by two workers at once, the queue hands a key out again only
+// once it is done.
+func (c *Controller) startWorkers(ctx context.Context) {
+	for i := 0; i < c.config.ToggleConcurrency; i++ {
+		go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+	}
+}
+
+// runWorker processes items of the queue until the queue is shut down
+func (c *Controller) runWorker(ctx context.Context) {
+	for c.processNextItem(ctx) {
+	}
+}
+
+// processNextItem reconciles the next deployment of the queue. It returns
+// false once the queue is shut down.
+func (c *Controller) processNextItem(ctx context.Context) bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	// Stay within the overall reconcile rate
+	err := c.limiter.Wait(ctx)
+	if err != nil {
+		c.queue.AddRateLimited(key)
+		return true
+	}
+
+	// Tag the logs of the reconcile with the ID of the loop that queued it
+	loopID, _ := c.loopID.Load().(string)
+	if loopID == "" {
+		loopID = logging.NewCorrelationID()
+	}
+	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).With(logging.CORRELATION_ID_KEY, loopID, "deployment", key))
+
+	start := time.Now()
+	err = c.reconcile(ctx, key.(string))
+	reconcileDuration.Observe(time.Since(start).Seconds())
+	if apierrors.IsNotFound(err) {
+		// The deployment was deleted after it was queued, which is part of
+		// the normal churn and retrying would not help
+		logging.FromContext(ctx).Debug(fmt.Sprintf("Deployment %s was deleted during its reconcile: %s", key, err))
+		c.queue.Forget(key)
+		return true
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error(fmt.Sprintf("%s. Requeuing %s (retry %d)", err, key, c.queue.NumRequeues(key)+1))
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.queue.Forget(key)
+	return true
+}
+
+// reconcile brings a single deployment to the state its schedule dictates.
+// Deployments without a truthy scheduler.enabled annotation are ignored,
+// apart from the ones paused with a falsy one (e.g. "false") which are
+// restored.
+// Configuration errors are logged and not returned, since retrying would
+// not fix them.
+func (c *Controller) reconcile(ctx context.Context, deploymentName string) error {
+	deployment, exists, err := c.deploymentInformer.GetIndexer().GetByKey(deploymentName)
+	if err != nil {
+		return fmt.Errorf("Error while checking deployment %s: %s", deploymentName, err)
+	}
+	if !exists {
+		if namespace, name, err := cache.SplitMetaNamespaceKey(deploymentName); err == nil {
+			c.deleteDeploymentMetrics(namespace, name)
+		}
+		return nil
+	}
+
+	// Using the informer's object
+	object, ok := deployment.(*apps_v1.Deployment)
+	if !ok {
+		return nil
+	}
+	object = c.withRememberedReplicas(ctx, object)
+
+	// Excluded deployments are never touched, whatever else is set
+	annotations := object.GetAnnotations()
+	if isExcluded(c.config, annotations) {
+		c.deleteDeploymentMetrics(object.Namespace, object.Name)
+		c.skip(ctx, deploymentName, SKIP_REASON_EXCLUDED)
+		return nil
+	}
+	if label, ignored := ignoredLabelOf(c.ignoredLabels, object.GetLabels()); ignored {
+		c.deleteDeploymentMetrics(object.Namespace, object.Name)
+		logging.FromContext(ctx).Debug(fmt.Sprintf("Ignoring deployment %s with label %s", deploymentName, label))
+		c.skip(ctx, deploymentName, SKIP_REASON_IGNORED_LABEL)
+		return nil
+	}
+
+	// Check deployment's annotation
+	enabledAnnotation := c.config.Annotation(ENABLED_ANNOTATION)
+	value, exists := annotations[enabledAnnotation]
+	if _, mapped := c.lookupMappedSchedule(object.Namespace, object.Name); mapped && !exists {
+		// Deployments listed in the schedule ConfigMap are enabled by it
+		value, exists = "true", true
+	}
+	enabled, known := ParseBoolAnnotation(value)
+	advisory := isAdvisory(value)
+	if advisory {
+		enabled, known = true, true
+	}
+	if !enabled {
+		c.deleteDeploymentMetrics(object.Namespace, object.Name)
+	}
+	if !exists {
+		// Only deployments with other scheduler annotations are of interest,
+		// they were most likely meant to be enabled
+		if c.hasSchedulerAnnotations(object) {
+			c.skip(ctx, deploymentName, SKIP_REASON_NOT_ENABLED)
+		}
+		return nil
+	}
+	if !known {
+		logging.FromContext(ctx).Debug(fmt.Sprintf("Unrecognized %s annotation '%s' of deployment %s, treating it as disabled", enabledAnnotation, value, deploymentName))
+		c.skip(ctx, deploymentName, SKIP_REASON_NOT_ENABLED)
+		return nil
+	}
+	// A pause leaves the deployment alone in either direction
+	paused, err := c.pausedUntil(ctx, object)
+	if apierrors.IsNotFound(err) {
+		return err
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error(fmt.Sprintf("%s", err))
+		deploymentsSkipped.WithLabelValues(SKIP_REASON_PARSE_ERROR).Inc()
+		return nil
+	}
+	if paused {
+		c.skip(ctx, deploymentName, SKIP_REASON_PAUSED_UNTIL)
+		return nil
+	}
+	// Freshly created deployments are left alone in either direction too
+	young, err := c.inCreateGrace(object)
+	if err != nil {
+		logging.FromContext(ctx).Error(fmt.Sprintf("%s", err))
+		deploymentsSkipped.WithLabelValues(SKIP_REASON_PARSE_ERROR).Inc()
+		return nil
+	}
+	if young {
+		c.skip(ctx, deploymentName, SKIP_REASON_CREATE_GRACE)
+		return nil
+	}
+	if !enabled {
+		// Paused deployments that were scaled down by the controller are
+		// restored once to their remembered replicas and then left alone
+		if _, remembered := scaledDownMemory(c.config, annotations); remembered {
+			if c.invalidScaling(ctx, object) {
+				return nil
+			}
+			logging.FromContext(ctx).Info(fmt.Sprintf("Restoring paused deployment %s", deploymentName))
+			_, err := c.toggle(WithAuditSource(ctx, AUDIT_ACTOR_SCHEDULE, "disabled"), object, ENABLED)
+			return err
+		}
+		c.skip(ctx, deploymentName, SKIP_REASON_PAUSED)
+		return nil
+	}
+
+	// Deployments managed by other controllers are skipped, since their
+	// replicas would be reverted, unless the user opted in
+	if manager := managedBy(object); manager != "" && !isForced(c.config, object) {
+		logging.FromContext(ctx).Info(fmt.Sprintf("Skipping deployment %s managed by %s, set %s to 'true' to schedule it anyway", deploymentName, manager, c.config.Annotation(FORCE_ANNOTATION)))
+		deploymentsSkipped.WithLabelValues(SKIP_REASON_MANAGED).Inc()
+		return nil
+	}
+
+	// Check deployment
+	logging.FromContext(ctx).Info(fmt.Sprintf("Checking deployment %s", deploymentName))
+	if c.invalidScaling(ctx, object) {
+		return nil
+	}
+
+	var state DeploymentState
+	actor, reason := AUDIT_ACTOR_SCHEDULE, "schedule"
+	if c.isIdleMode(object) {
+		// Deployments in idle mode follow their traffic instead of a
+		// schedule
+		reason = "idle"
+		state, err = c.decideIdle(ctx, object)
+		if apierrors.IsNotFound(err) {
+			return err
+		}
+		if err != nil {
+			logging.FromContext(ctx).Error(fmt.Sprintf("%s", err))
+			return nil
+		}
+	} else {
+		state, err = c.Decide(object, c.clock.Now())
+		if errors.As(err, &noScheduleError{}) && hasReplicaWindows(c.config, object) {
+			// Replica windows alone keep the deployment up
+			state, err = ENABLED, nil
+		}
+		c.reportScheduleError(ctx, object, err)
+		if err != nil {
+			logging.FromContext(ctx).Error(fmt.Sprintf("%s", err))
+			if errors.As(err, &noScheduleError{}) {
+				deploymentsSkipped.WithLabelValues(SKIP_REASON_NO_SCHEDULE).Inc()
+			} else {
+				deploymentsSkipped.WithLabelValues(SKIP_REASON_PARSE_ERROR).Inc()
+			}
+			return nil
+		}
+		c.recordNextTransition(object)
+		if overrideState, overridden, _ := c.parseOverride(object); overridden {
+			actor, reason = AUDIT_ACTOR_OVERRIDE, fmt.Sprintf("override %s", overrideState)
+		}
+	}
+	if state == DISABLED && c.holdUp(ctx, object) {
+		state = ENABLED
+		reason = "signal"
+	}
+	ctx = WithAuditSource(ctx, actor, reason)
+
+	// Deployments in advisory mode are never scaled
+	if advisory {
+		return c.recommend(ctx, object, state)
+	}
+	if err := c.clearRecommendation(ctx, object); err != nil {
+		return err
+	}
+
+	pending, err := c.scaleDownPending(ctx, object, state)
+	if apierrors.IsNotFound(err) {
+		return err
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error(fmt.Sprintf("%s", err))
+		return nil
+	}
+	if pending {
+		return nil
+	}
+	if state == DISABLED && c.drainPending(ctx, object) {
+		return nil
+	}
+	if state == ENABLED {
+		pending, err := c.capacityPending(ctx, object)
 		if err != nil {
-			slog.Error(fmt.Sprintf("Error while checking deployment %s. Moving to the next one", deploymentName))
-			continue
+			logging.FromContext(ctx).Error(fmt.Sprintf("Failed to check the cluster capacity for deployment %s: %s", deploymentName, err))
+		}
+		if pending {
+			return nil
 		}
-		if !exists {
-			continue
+	}
+
+	// Guard against a bad schedule taking down everything at once. The
+	// skipped deployments are scaled down by the following loops. The
+	// scale downs of reconciles triggered by deployment changes count
+	// towards the limit of the current loop too. A slot is reserved before
+	// the toggle, so parallel workers can not exceed the limit, and given
+	// back if the deployment was not actually scaled down.
+	_, scaledDown := scaledDownMemory(c.config, annotations)
+	reserved := false
+	if state == DISABLED && *object.Spec.Replicas != 0 && !scaledDown && c.config.MaxScaleDownPerLoop > 0 {
+		if c.scaleDowns.Add(1) > int64(c.config.MaxScaleDownPerLoop) {
+			c.scaleDowns.Add(-1)
+			logging.FromContext(ctx).Warn(fmt.Sprintf("Skipping scale down of deployment %s, the limit of %d scale downs per loop is reached", deploymentName, c.config.MaxScaleDownPerLoop))
+			return nil
 		}
+		reserved = true
+	}
+	// Deployments that are up follow their replica windows, if any
+	var scaled bool
+	if state == ENABLED && !scaledDown && hasReplicaWindows(c.config, object) {
+		scaled, err = c.applyReplicaWindows(ctx, object)
+	} else {
+		scaled, err = c.toggle(ctx, object, state)
+	}
+	if reserved && (err != nil || !scaled) {
+		c.scaleDowns.Add(-1)
+	}
+	if err != nil {
+		return err
+	}
+
+	if scaled && c.notifier != nil {
+		c.notifier.Notify(Notification{Namespace: object.Namespace, Name: object.Name, State: state.String(), Time: c.clock.Now()})
+	}
+	return nil
+}
+
+// invalidScaling checks the annotations that configure how the deployment
+// is scaled. Invalid ones are reported like schedule errors, in the error
+// annotation, instead of failing every toggle of the deployment.
+func (c *Controller) invalidScaling(ctx context.Context, deployment *apps_v1.Deployment) bool {
+	err := validateScaling(c.config, deployment)
+	if err == nil {
+		return false
+	}
+	c.reportScheduleError(ctx, deployment, err)
+	logging.FromContext(ctx).Error(fmt.Sprintf("%s", err))
+	deploymentsSkipped.WithLabelValues(SKIP_REASON_PARSE_ERROR).Inc()
+	return true
+}
 
-		// Using the informer's object
-		switch object := deployment.(type) {
-		case *apps_v1.Deployment:
-			// Check deployment's annotation
-			annotations := object.GetAnnotations()
-			value, exists := annotations[ENABLED_ANNOTATION]
-			if !exists || strings.ToLower(value) != "true" {
-				continue
+// skip records a deployment that is not scheduled, due to its annotations.
+// It is only logged at debug level since it happens on every resync.
+func (c *Controller) skip(ctx context.Context, deploymentName, reason string) {
+	deploymentsSkipped.WithLabelValues(reason).Inc()
+	logging.FromContext(ctx).Debug(fmt.Sprintf("Skipping deployment %s: %s", deploymentName, reason))
+}
+
+// hasSchedulerAnnotations checks if the deployment has any annotation with
+// the configured annotation prefix
+func (c *Controller) hasSchedulerAnnotations(deployment *apps_v1.Deployment) bool {
+	for key := range deployment.GetAnnotations() {
+		if strings.HasPrefix(key, c.config.AnnotationPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Decide returns the state the deployment must be in at the provided time,
+// according to its override and schedule annotations. It has no side
+// effects, apart from reading referenced schedules from the ConfigMap cache,
+// so the external signal of the require-signal annotation is not taken into
+// account.
+func (c *Controller) Decide(deployment *apps_v1.Deployment, now time.Time) (DeploymentState, error) {
+	// An override pins the deployment regardless of the schedule
+	overrideState, overridden, err := c.parseOverride(deployment)
+	if err != nil {
+		return ENABLED, err
+	}
+	if overridden {
+		return overrideState, nil
+	}
+
+	schedule, err := c.resolveSchedule(deployment)
+	if err != nil {
+		return ENABLED, err
+	}
+	if schedule.InRange(now) {
+		return DISABLED, nil
+	}
+	return ENABLED, nil
+}
+
+// EffectiveSchedule returns the fully resolved schedule of a deployment, as
+// the controller evaluates it. The deployment is read from the informer's
+// cache and false is returned if it does not exist.
+func (c *Controller) EffectiveSchedule(namespace, name string) (Schedule, bool, error) {
+	obj, exists, err := c.deploymentInformer.GetIndexer().GetByKey(namespace + "/" + name)
+	if err != nil || !exists {
+		return Schedule{}, exists, err
+	}
+	deployment, ok := obj.(*apps_v1.Deployment)
+	if !ok {
+		return Schedule{}, false, fmt.Errorf("unexpected object in Deployment cache for '%s.%s'", namespace, name)
+	}
+
+	schedule, err := c.resolveSchedule(deployment)
+	return schedule, true, err
+}
+
+// holdUp checks the external signal of deployments with the require-signal
+// annotation. Such deployments are only scaled down while their signal
+// endpoint does not respond with 200. Deployments pinned by an override
+// ignore the signal.
+func (c *Controller) holdUp(ctx context.Context, deployment *apps_v1.Deployment) bool {
+	url := strings.TrimSpace(deployment.GetAnnotations()[c.config.Annotation(REQUIRE_SIGNAL_ANNOTATION)])
+	if url == "" {
+		return false
+	}
+	if _, overridden, _ := c.parseOverride(deployment); overridden {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.config.APITimeout)
+	defer cancel()
+	return c.signals.HoldUp(ctx, url)
+}
+
+// parseOverride reads the override annotation of the deployment. It returns
+// the pinned state and true if the deployment is pinned up or down, or false
+// if the schedule must be followed.
+func (c *Controller) parseOverride(deployment *apps_v1.Deployment) (DeploymentState, bool, error) {
+	overrideAnnotation := c.config.Annotation(OVERRIDE_ANNOTATION)
+	value := deployment.GetAnnotations()[overrideAnnotation]
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "up":
+		return ENABLED, true, nil
+	case "down":
+		return DISABLED, true, nil
+	case "", "none":
+		return ENABLED, false, nil
+	default:
+		return ENABLED, false, fmt.Errorf("invalid %s annotation '%s', expected one of up, down, none", overrideAnnotation, value)
+	}
+}
+
+// toggle calls ToggleDeployment for the deployment bounding the API calls
+// by the configured timeout. It reports whether the deployment was scaled.
+func (c *Controller) toggle(ctx context.Context, deployment *apps_v1.Deployment, targetState DeploymentState) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.config.APITimeout)
+	defer cancel()
+	return toggleDeployment(ctx, c.clientset, c.config, c.replicas, deployment.Namespace, deployment.Name, targetState)
+}
+
+// reportScheduleError keeps the error annotation of the deployment in sync
+// with the outcome of the schedule resolution so users can see it without
+// access to the controller logs. The deployment is only updated when the
+// annotation actually changes, to avoid needless writes on every loop.
+func (c *Controller) reportScheduleError(ctx context.Context, deployment *apps_v1.Deployment, scheduleErr error) {
+	errorAnnotation := c.config.Annotation(ERROR_ANNOTATION)
+	current, exists := deployment.GetAnnotations()[errorAnnotation]
+
+	var value *string
+	if scheduleErr != nil {
+		message := scheduleErr.Error()
+		if exists && current == message {
+			return
+		}
+		value = &message
+	} else if !exists {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.config.APITimeout)
+	defer cancel()
+	err := PatchDeploymentAnnotations(ctx, c.clientset, deployment.Namespace, deployment.Name, map[string]*string{errorAnnotation: value})
+	if err != nil && !apierrors.IsNotFound(err) {
+		logging.FromContext(ctx).Error(fmt.Sprintf("Failed to update %s annotation of deployment '%s.%s': %s", errorAnnotation, deployment.Namespace, deployment.Name, err))
+	}
+}
+
+// warnLongWindows logs a warning for a scheduled deployment with a window
+// longer than MaxWindowLength. On updates the warning is only repeated if
+// the annotations changed, not on every resync of the informer.
+func (c *Controller) warnLongWindows(oldObj, newObj interface{}) {
+	if c.config.MaxWindowLength <= 0 {
+		return
+	}
+	deployment, ok := newObj.(*apps_v1.Deployment)
+	if !ok || !c.isScheduled(deployment) {
+		return
+	}
+	if old, ok := oldObj.(*apps_v1.Deployment); ok && maps.Equal(old.GetAnnotations(), deployment.GetAnnotations()) {
+		return
+	}
+
+	// Invalid schedules are reported by the reconcile
+	schedule, err := c.resolveSchedule(deployment)
+	if err != nil {
+		return
+	}
+	if err := schedule.CheckWindowLength(c.config.MaxWindowLength); err != nil {
+		slog.Warn(fmt.Sprintf("Suspicious schedule of deployment '%s.%s': %s", deployment.Namespace, deployment.Name, err))
+	}
+}
+
+// resolveSchedule puts together the complete schedule of the deployment out
+// of its time range, time zone and exception dates.
+func (c *Controller) resolveSchedule(deployment *apps_v1.Deployment) (Schedule, error) {
+	schedule, err := c.resolveWindows(deployment)
+	if err != nil {
+		return Schedule{}, err
+	}
+	return completeSchedule(c.config, schedule, deployment)
+}
+
+// completeSchedule applies the annotations of the deployment that refine
+// its off-windows (time zone, jitter, pre-warm, exceptions and off days) to
+// the schedule.
+func completeSchedule(config ControllerConfig, schedule Schedule, deployment *apps_v1.Deployment) (Schedule, error) {
+	var err error
+	// A JSON schedule with a time zone can not have a timezone annotation too
+	timezoneAnnotation := config.Annotation(TIMEZONE_ANNOTATION)
+	if _, exists := deployment.GetAnnotations()[timezoneAnnotation]; exists && schedule.Location != nil {
+		return Schedule{}, fmt.Errorf("invalid %s annotation: the time zone is already set by the schedule", timezoneAnnotation)
+	}
+	if schedule.Location == nil {
+		schedule.Location, err = resolveLocation(config, deployment)
+		if err != nil {
+			return Schedule{}, err
+		}
+	}
+
+	// Jitter spreads the toggles of deployments sharing the same window
+	jitterAnnotation := config.Annotation(JITTER_ANNOTATION)
+	if jitterText, exists := deployment.GetAnnotations()[jitterAnnotation]; exists {
+		jitter, err := time.ParseDuration(jitterText)
+		if err != nil {
+			return Schedule{}, fmt.Errorf("invalid %s annotation: %s", jitterAnnotation, err)
+		}
+		for _, timeRange := range schedule.Ranges() {
+			if jitter < 0 || 2*jitter >= timeRange.Length() {
+				return Schedule{}, fmt.Errorf("invalid %s annotation: %s must be positive and shorter than half the off-window (%s)", jitterAnnotation, jitter, timeRange.Length())
 			}
+		}
+		schedule.Range = schedule.Range.WithJitter(jitter, deployment.Namespace+"/"+deployment.Name)
+		for i := range schedule.Windows {
+			schedule.Windows[i] = schedule.Windows[i].WithJitter(jitter, deployment.Namespace+"/"+deployment.Name)
+		}
+	}
 
-			// Check deployment
-			slog.Info(fmt.Sprintf("Checking deployment %s", deploymentName))
-			schedule, err := c.parseScheduleAnnotation(annotations)
-			if err != nil {
-				slog.Error(fmt.Sprintf("%s", err))
-				continue
+	// Pre-warm scales the deployment up before the off-window ends
+	preWarmAnnotation := config.Annotation(PRE_WARM_ANNOTATION)
+	if preWarmText, exists := deployment.GetAnnotations()[preWarmAnnotation]; exists {
+		preWarm, err := time.ParseDuration(preWarmText)
+		if err != nil {
+			return Schedule{}, fmt.Errorf("invalid %s annotation: %s", preWarmAnnotation, err)
+		}
+		for _, timeRange := range schedule.Ranges() {
+			if preWarm < 0 || preWarm >= timeRange.Length() {
+				return Schedule{}, fmt.Errorf("invalid %s annotation: %s must be positive and shorter than the off-window (%s)", preWarmAnnotation, preWarm, timeRange.Length())
 			}
-			if schedule.InRangeNow() {
-				err := ToggleDeployment(c.clientset, object.Namespace, object.Name, DISABLED)
-				if err != nil {
-					slog.Error(fmt.Sprintf("%s", err))
-					continue
-				}
-			} else {
-				err := ToggleDeployment(c.clientset, object.Namespace, object.Name, ENABLED)
-				if err != nil {
-					slog.Error(fmt.Sprintf("%s", err))
-					continue
-				}
+		}
+		schedule.Range.End = shiftClock(schedule.Range.End, -preWarm)
+		for i := range schedule.Windows {
+			schedule.Windows[i].End = shiftClock(schedule.Windows[i].End, -preWarm)
+		}
+	}
+
+	// The exceptions annotation adds to the exceptions of a JSON schedule
+	exceptionsAnnotation := config.Annotation(EXCEPTIONS_ANNOTATION)
+	if exceptionsText, exists := deployment.GetAnnotations()[exceptionsAnnotation]; exists {
+		exceptions, err := ParseExceptions(exceptionsText)
+		if err != nil {
+			return Schedule{}, fmt.Errorf("invalid %s annotation: %s", exceptionsAnnotation, err)
+		}
+		schedule.Exceptions = append(schedule.Exceptions, exceptions...)
+	}
+
+	// Off days keep the deployment down all day, whatever the windows
+	offDaysAnnotation := config.Annotation(OFF_DAYS_ANNOTATION)
+	if offDaysText, exists := deployment.GetAnnotations()[offDaysAnnotation]; exists {
+		schedule.OffDays, err = ParseOffDays(offDaysText)
+		if err != nil {
+			return Schedule{}, fmt.Errorf("invalid %s annotation: %s", offDaysAnnotation, err)
+		}
+	}
+
+	return schedule, nil
+}
+
+// resolveWindows finds the off-windows that apply to the deployment. A
+// schedule referenced through a ConfigMap takes precedence over the inline
+// schedule annotation, which is used as a fallback if the reference can not
+// be resolved. The configured default schedule applies when the deployment
+// has no schedule of its own. Only JSON schedules may set the location and
+// the exceptions of the returned schedule.
+func (c *Controller) resolveWindows(deployment *apps_v1.Deployment) (Schedule, error) {
+	annotations := deployment.GetAnnotations()
+	refAnnotation := c.config.Annotation(SCHEDULE_REF_ANNOTATION)
+	if ref, exists := annotations[refAnnotation]; exists {
+		ref, err := ExpandVariables(ref, deployment.GetLabels())
+		if err != nil {
+			return Schedule{}, fmt.Errorf("invalid %s annotation: %s", refAnnotation, err)
+		}
+		scheduleText, err := c.lookupScheduleRef(deployment.Namespace, ref)
+		if err == nil {
+			schedule, err := ParseScheduleSpec(scheduleText)
+			if err != nil {
+				return Schedule{}, fmt.Errorf("invalid schedule referenced by %s '%s': %s", refAnnotation, ref, err)
 			}
+			return schedule, nil
 		}
+		if _, inline := annotations[c.config.Annotation(SCHEDULE_ANNOTATION)]; !inline {
+			return Schedule{}, err
+		}
+		slog.Warn(fmt.Sprintf("%s. Falling back to the %s annotation", err, c.config.Annotation(SCHEDULE_ANNOTATION)))
 	}
+
+	// Deployments without their own schedule use the one of the schedule
+	// ConfigMap, or else the default one of their namespace, or else the
+	// cluster default, if any
+	if _, inline := annotations[c.config.Annotation(SCHEDULE_ANNOTATION)]; !inline {
+		if scheduleText, mapped := c.lookupMappedSchedule(deployment.Namespace, deployment.Name); mapped {
+			return c.parseMappedSchedule(deployment, scheduleText)
+		}
+		namespaceSchedule, exists, err := c.lookupNamespaceSchedule(deployment.Namespace)
+		if err != nil {
+			return Schedule{}, err
+		}
+		if exists {
+			return Schedule{Range: namespaceSchedule}, nil
+		}
+		if c.config.DefaultSchedule != "" {
+			timeRange, err := ParseSchedule(c.config.DefaultSchedule)
+			return Schedule{Range: timeRange}, err
+		}
+	}
+
+	return ParseScheduleAnnotation(c.config, annotations, deployment.GetLabels())
 }
 
-// parseScheduleAnnotation parse annotation that contains the shutdown schedule
-func (c *Controller) parseScheduleAnnotation(annotations map[string]string) (TimeRange, error) {
-	scheduleText, exists := annotations[SCHEDULE_ANNOTATION]
+// resolveLocation returns the time zone the schedule of the deployment is
+// evaluated in. The timezone annotation takes precedence over the configured
+// default time zone, which in turn defaults to UTC.
+func resolveLocation(config ControllerConfig, deployment *apps_v1.Deployment) (*time.Location, error) {
+	timezoneAnnotation := config.Annotation(TIMEZONE_ANNOTATION)
+	if name, exists := deployment.GetAnnotations()[timezoneAnnotation]; exists {
+		location, err := LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation: %s", timezoneAnnotation, err)
+		}
+		return location, nil
+	}
+
+	return LoadLocation(config.DefaultTimezone)
+}
+
+// lookupNamespaceSchedule reads the default schedule of a namespace from
+// its annotation, using the informer's cache of Namespaces. False is
+// returned if the namespace has no default schedule.
+func (c *Controller) lookupNamespaceSchedule(namespace string) (TimeRange, bool, error) {
+	obj, exists, err := c.namespaceInformer.GetIndexer().GetByKey(namespace)
+	if err != nil || !exists {
+		return TimeRange{}, false, err
+	}
+	namespaceObj, ok := obj.(*core_v1.Namespace)
+	if !ok {
+		return TimeRange{}, false, fmt.Errorf("unexpected object in Namespace cache for '%s'", namespace)
+	}
+
+	namespaceAnnotation := c.config.Annotation(NAMESPACE_SCHEDULE_ANNOTATION)
+	scheduleText, exists := namespaceObj.GetAnnotations()[namespaceAnnotation]
 	if !exists {
-		return TimeRange{}, fmt.Errorf("could not find %s annotation", SCHEDULE_ANNOTATION)
+		return TimeRange{}, false, nil
+	}
+	schedule, err := ParseSchedule(scheduleText)
+	if err != nil {
+		return TimeRange{}, false, fmt.Errorf("invalid %s annotation of namespace '%s': %s", namespaceAnnotation, namespace, err)
 	}
-	tokens := strings.Split(scheduleText, "-")
+	return schedule, true, nil
+}
 
-	start, err := time.Parse("15:04", strings.Trim(tokens[0], " "))
+// lookupScheduleRef reads a schedule from the informer's cache of ConfigMaps.
+// The reference has the '<configmap>/<key>' format and the ConfigMap is
+// expected in the same namespace with the deployment.
+func (c *Controller) lookupScheduleRef(namespace, ref string) (string, error) {
+	tokens := strings.Split(ref, "/")
+	if len(tokens) != 2 || tokens[0] == "" || tokens[1] == "" {
+		return "", fmt.Errorf("invalid schedule reference '%s', expected format '<configmap>/<key>'", ref)
+	}
+
+	obj, exists, err := c.configMapInformer.GetIndexer().GetByKey(namespace + "/" + tokens[0])
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", fmt.Errorf("could not find ConfigMap '%s.%s' of schedule reference", namespace, tokens[0])
+	}
+	configMap, ok := obj.(*core_v1.ConfigMap)
+	if !ok {
+		return "", fmt.Errorf("unexpected object in ConfigMap cache for '%s.%s'", namespace, tokens[0])
+	}
+	scheduleText, exists := configMap.Data[tokens[1]]
+	if !exists {
+		return "", fmt.Errorf("could not find key '%s' in ConfigMap '%s.%s'", tokens[1], namespace, tokens[0])
+	}
+
+	return scheduleText, nil
+}
+
+// ParseScheduleAnnotation parse annotation that contains the shutdown schedule.
+// The variables of the schedule are expanded using the provided labels. The
+// annotation is in either of the forms accepted by ParseScheduleSpec.
+func ParseScheduleAnnotation(config ControllerConfig, annotations, labels map[string]string) (Schedule, error) {
+	scheduleAnnotation := config.Annotation(SCHEDULE_ANNOTATION)
+	scheduleText, exists := annotations[scheduleAnnotation]
+	if !exists {
+		return Schedule{}, noScheduleError{scheduleAnnotation}
+	}
+	scheduleText, err := ExpandVariables(scheduleText, labels)
+	if err != nil {
+		return Schedule{}, fmt.Errorf("invalid %s annotation: %s", scheduleAnnotation, err)
+	}
+	schedule, err := ParseScheduleSpec(scheduleText)
+	if err != nil {
+		return Schedule{}, fmt.Errorf("invalid %s annotation: %s", scheduleAnnotation, err)
+	}
+	return schedule, nil
+}
+
+// noScheduleError is returned for deployments without any schedule, which
+// is told apart from a schedule that can not be parsed
+type noScheduleError struct {
+	annotation string
+}
+
+func (e noScheduleError) Error() string {
+	return fmt.Sprintf("could not find %s annotation", e.annotation)
+}
+
+// ParseSchedule parses a schedule expression in the '[days] HH:MM-HH:MM'
+// format, where the optional days prefix is parsed by ParseWeekdays. The
+// boundaries can also have second precision ('HH:MM:SS'), in which case the
+// range is evaluated with second precision.
+// It is the parser used for the schedule annotation so it can be used to
+// check expressions outside of the controller.
+func ParseSchedule(scheduleText string) (TimeRange, error) {
+	// A leading letter means the schedule starts with the days prefix
+	var days Weekdays
+	rangeText := strings.TrimSpace(scheduleText)
+	if rangeText != "" && unicode.IsLetter(rune(rangeText[0])) {
+		daysText, rest, _ := strings.Cut(rangeText, " ")
+		var err error
+		days, err = ParseWeekdays(daysText)
+		if err != nil {
+			return TimeRange{}, err
+		}
+		rangeText = rest
+	}
+
+	tokens := strings.Split(rangeText, "-")
+	if len(tokens) != 2 {
+		return TimeRange{}, fmt.Errorf("invalid schedule '%s', expected format '[days] HH:MM-HH:MM'", scheduleText)
+	}
+
+	start, startSeconds, err := parseClock(tokens[0])
 	if err != nil {
 		return TimeRange{}, err
 	}
 
-	end, err := time.Parse("15:04", strings.Trim(tokens[1], " "))
+	end, endSeconds, err := parseClock(tokens[1])
 	if err != nil {
 		return TimeRange{}, err
 	}
 
-	return TimeRange{start, end}, nil
+	// A window ending when it starts is never entered, and most likely a
+	// typo rather than a full day
+	if start.Equal(end) {
+		return TimeRange{}, ZeroLengthWindowError{Window: strings.TrimSpace(scheduleText)}
+	}
+
+	return TimeRange{start, end, days, startSeconds || endSeconds}, nil
+}
+
+// parseClock parses a boundary of a schedule. The layout is detected by the
+// length of the boundary and true is returned if it has second precision.
+func parseClock(text string) (time.Time, bool, error) {
+	text = strings.Trim(text, " ")
+	if len(text) == len(CLOCK_LAYOUT_SECONDS) {
+		clock, err := time.Parse(CLOCK_LAYOUT_SECONDS, text)
+		return clock, true, err
+	}
+	clock, err := time.Parse(CLOCK_LAYOUT_MINUTES, text)
+	return clock, false, err
 }
 
 // Boostraps and start the deployment resource watcher and the controller
-// Returns a channel which will close the watcher when closed.
-func Start() (chan struct{}, error) {
-	kubeClient, err := LoadK8SClientConfigFile()
+// Returns the controller and a channel which will close the watcher when closed.
+func Start(config ControllerConfig) (*Controller, chan struct{}, error) {
+	if config.DefaultSchedule != "" {
+		if _, err := ParseSchedule(config.DefaultSchedule); err != nil {
+			return nil, nil, fmt.Errorf("invalid default schedule: %s", err)
+		}
+	}
+	if config.ScheduleConfigMap != "" {
+		if namespace, name, err := cache.SplitMetaNamespaceKey(config.ScheduleConfigMap); err != nil || namespace == "" || name == "" {
+			return nil, nil, fmt.Errorf("invalid schedule ConfigMap '%s', expected format '<namespace>/<name>'", config.ScheduleConfigMap)
+		}
+	}
+	if _, err := openAuditLog(config.AuditLog); err != nil {
+		return nil, nil, fmt.Errorf("invalid audit log: %s", err)
+	}
+	ignoredLabels, err := parseIgnoreLabels(config.IgnoreLabels)
 	if err != nil {
-		return nil, err
+		return nil, nil, fmt.Errorf("invalid ignore labels: %s", err)
+	}
+	if config.ReplicasConfigMap != "" {
+		if namespace, name, err := cache.SplitMetaNamespaceKey(config.ReplicasConfigMap); err != nil || namespace == "" || name == "" {
+			return nil, nil, fmt.Errorf("invalid replicas ConfigMap '%s', expected format '<namespace>/<name>'", config.ReplicasConfigMap)
+		}
+	}
+	switch config.UpdateStrategy {
+	case UPDATE_STRATEGY_UPDATE, UPDATE_STRATEGY_PATCH, UPDATE_STRATEGY_APPLY:
+	default:
+		return nil, nil, fmt.Errorf("invalid update strategy '%s', expected one of update, patch, apply", config.UpdateStrategy)
+	}
+	if _, err := LoadLocation(config.DefaultTimezone); err != nil {
+		return nil, nil, fmt.Errorf("invalid default timezone: %s", err)
+	}
+	if config.MaxScaleDownPerLoop < 0 {
+		return nil, nil, fmt.Errorf("invalid max scale down per loop %d, expected a non-negative number", config.MaxScaleDownPerLoop)
+	}
+	if config.StartupAttempts < 1 {
+		return nil, nil, fmt.Errorf("invalid startup attempts %d, expected at least 1", config.StartupAttempts)
+	}
+	if config.NotifyRetries < 0 {
+		return nil, nil, fmt.Errorf("invalid notify retries %d, expected a non-negative number", config.NotifyRetries)
+	}
+	if config.PrometheusURL != "" {
+		if _, err := url.ParseRequestURI(config.PrometheusURL); err != nil {
+			return nil, nil, fmt.Errorf("invalid Prometheus URL: %s", err)
+		}
+	}
+	if config.MaxDeploymentSeries < 0 {
+		return nil, nil, fmt.Errorf("invalid max deployment series %d, expected a non-negative number", config.MaxDeploymentSeries)
+	}
+	if config.ToggleConcurrency < 1 {
+		return nil, nil, fmt.Errorf("invalid toggle concurrency %d, expected at least 1", config.ToggleConcurrency)
 	}
+	if config.MaxClockSkew < 0 {
+		return nil, nil, fmt.Errorf("invalid max clock skew %s, expected a non-negative duration", config.MaxClockSkew)
+	}
+	if config.MaxWindowLength < 0 {
+		return nil, nil, fmt.Errorf("invalid max window length %s, expected a non-negative duration", config.MaxWindowLength)
+	}
+	if config.FallbackReplicas < 0 {
+		return nil, nil, fmt.Errorf("invalid fallback replicas %d, expected a non-negative number", config.FallbackReplicas)
+	}
+
+	kubeClient, err := connectK8S(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !config.SkipRBACCheck {
+		ctx, cancel := context.WithTimeout(context.Background(), config.APITimeout)
+		err = checkRBAC(ctx, kubeClient, config)
+		cancel()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	stopCh := make(chan struct{}) // Closing this will terminate the controller
+	ctx := wait.ContextForChannel(stopCh)
 
+	deploymentInformer, configMapInformer, namespaceInformer := newInformers(ctx, kubeClient)
+
+	c := NewResourceController(
+		kubeClient,
+		deploymentInformer,
+		configMapInformer,
+		namespaceInformer,
+		config,
+	)
+	c.ignoredLabels = ignoredLabels
+
+	go c.Run(stopCh)
+
+	return c, stopCh, nil
+}
+
+// newInformers creates the informers of the Deployments, ConfigMaps and
+// Namespaces the controller watches. The informers are not started, their
+// list and watch calls are bound to ctx.
+func newInformers(ctx context.Context, kubeClient kubernetes.Interface) (deploymentInformer, configMapInformer, namespaceInformer cache.SharedIndexInformer) {
 	// Watch Deployments
-	deploymentInformer := cache.NewSharedIndexInformer(
+	deploymentInformer = cache.NewSharedIndexInformer(
 		&cache.ListWatch{
 			ListFunc: func(options meta_v1.ListOptions) (runtime.Object, error) {
-				return kubeClient.AppsV1().Deployments("").List(context.Background(), options)
+				return kubeClient.AppsV1().Deployments("").List(ctx, options)
 			},
 			WatchFunc: func(options meta_v1.ListOptions) (watch.Interface, error) {
-				return kubeClient.AppsV1().Deployments("").Watch(context.Background(), options)
+				return kubeClient.AppsV1().Deployments("").Watch(ctx, options)
 			},
 		},
 		&apps_v1.Deployment{},
 		5*time.Minute,
-		cache.Indexers{},
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
 	)
 
-	c := NewResourceController(
-		kubeClient,
-		deploymentInformer,
+	// Watch ConfigMaps which may hold schedules shared across deployments
+	configMapInformer = cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options meta_v1.ListOptions) (runtime.Object, error) {
+				return kubeClient.CoreV1().ConfigMaps("").List(ctx, options)
+			},
+			WatchFunc: func(options meta_v1.ListOptions) (watch.Interface, error) {
+				return kubeClient.CoreV1().ConfigMaps("").Watch(ctx, options)
+			},
+		},
+		&core_v1.ConfigMap{},
+		5*time.Minute,
+		cache.Indexers{},
 	)
 
-	stopCh := make(chan struct{}) // Closing this will terminate the controller
-	go c.Run(stopCh)
+	// Watch Namespaces which may hold the default schedule of their
+	// deployments
+	namespaceInformer = cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options meta_v1.ListOptions) (runtime.Object, error) {
+				return kubeClient.CoreV1().Namespaces().List(ctx, options)
+			},
+			WatchFunc: func(options meta_v1.ListOptions) (watch.Interface, error) {
+				return kubeClient.CoreV1().Namespaces().Watch(ctx, options)
+			},
+		},
+		&core_v1.Namespace{},
+		5*time.Minute,
+		cache.Indexers{},
+	)
 
-	return stopCh, nil
+	return deploymentInformer, configMapInformer, namespaceInformer
 }
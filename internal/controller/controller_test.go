@@ -0,0 +1,1762 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+	apps_v1 "k8s.io/api/apps/v1"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	k8s_testing "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+// fakeClock is a Clock stuck at a fixed time, until it is set to another
+type fakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Set(now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = now
+}
+
+// discardLogs silences the default logger for the duration of the test
+func discardLogs(tb testing.TB) {
+	tb.Helper()
+	logger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	tb.Cleanup(func() { slog.SetDefault(logger) })
+}
+
+// newTestController creates a controller backed by a fake clientset holding
+// the deployments. The informers are not started, their caches are filled
+// with the deployments directly.
+func newTestController(tb testing.TB, config ControllerConfig, deployments ...*apps_v1.Deployment) (*Controller, *fake.Clientset) {
+	tb.Helper()
+	objects := make([]runtime.Object, 0, len(deployments))
+	for _, deployment := range deployments {
+		objects = append(objects, deployment)
+	}
+	clientset := fake.NewSimpleClientset(objects...)
+
+	deploymentInformer := cache.NewSharedIndexInformer(&cache.ListWatch{}, &apps_v1.Deployment{}, 0, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	configMapInformer := cache.NewSharedIndexInformer(&cache.ListWatch{}, &core_v1.ConfigMap{}, 0, cache.Indexers{})
+	namespaceInformer := cache.NewSharedIndexInformer(&cache.ListWatch{}, &core_v1.Namespace{}, 0, cache.Indexers{})
+	for _, deployment := range deployments {
+		if err := deploymentInformer.GetIndexer().Add(deployment); err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	c := NewResourceController(clientset, deploymentInformer, configMapInformer, namespaceInformer, config)
+	tb.Cleanup(c.queue.ShutDown)
+	return c, clientset
+}
+
+// newTestDeployment creates a deployment with the replicas and annotations,
+// created long enough ago to be past any create grace period
+func newTestDeployment(name string, replicas int32, annotations map[string]string) *apps_v1.Deployment {
+	return &apps_v1.Deployment{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Namespace:         "default",
+			Name:              name,
+			Annotations:       annotations,
+			CreationTimestamp: meta_v1.NewTime(time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)),
+		},
+		Spec: apps_v1.DeploymentSpec{Replicas: &replicas},
+	}
+}
+
+// newBenchmarkController creates a controller with a synthetic set of
+// scheduled deployments, all already in the state of their schedule, with an
+// unlimited reconcile rate
+func newBenchmarkController(b *testing.B, size int) *Controller {
+	b.Helper()
+	discardLogs(b)
+	config := NewDefaultControllerConfig()
+	config.ReconcileQPS = math.Inf(1)
+	config.PerDeploymentMetrics = false
+
+	deployments := make([]*apps_v1.Deployment, 0, size)
+	for i := 0; i < size; i++ {
+		deployments = append(deployments, newTestDeployment(fmt.Sprintf("deployment-%d", i), 2, map[string]string{
+			"scheduler.enabled":      "true",
+			"scheduler.off-schedule": "20:00-08:00",
+		}))
+	}
+	c, _ := newTestController(b, config, deployments...)
+	c.SetClock(&fakeClock{now: time.Date(2024, time.June, 3, 12, 0, 0, 0, time.UTC)})
+	return c
+}
+
+var benchmarkSizes = []int{100, 1000, 5000}
+
+// BenchmarkFullRelist reconciles every deployment of the cache in turn, as
+// the loop did on every iteration before the queue
+func BenchmarkFullRelist(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(fmt.Sprintf("deployments=%d", size), func(b *testing.B) {
+			c := newBenchmarkController(b, size)
+			ctx := context.Background()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for _, key := range c.deploymentInformer.GetIndexer().ListKeys() {
+					if err := c.reconcile(ctx, key); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkQueuedResync queues every deployment of the cache and drains the
+// queue, as the periodic resync does
+func BenchmarkQueuedResync(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(fmt.Sprintf("deployments=%d", size), func(b *testing.B) {
+			c := newBenchmarkController(b, size)
+			ctx := context.Background()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				c.loopIteration(ctx)
+				for c.queue.Len() > 0 {
+					c.processNextItem(ctx)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkQueuedChange reconciles a single changed deployment through the
+// queue, which is all the controller does on an informer event between two
+// resyncs, instead of a full relist
+func BenchmarkQueuedChange(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(fmt.Sprintf("deployments=%d", size), func(b *testing.B) {
+			c := newBenchmarkController(b, size)
+			ctx := context.Background()
+			changed := c.deploymentInformer.GetIndexer().List()[0]
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				c.enqueue(changed)
+				c.processNextItem(ctx)
+			}
+		})
+	}
+}
+
+func TestDecide(t *testing.T) {
+	// 2024-06-03 is a Monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, time.June, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		now         time.Time
+		state       DeploymentState
+		err         bool
+	}{
+		{"inside window", map[string]string{"scheduler.off-schedule": "20:00-08:00"}, at(3, 22, 0), DISABLED, false},
+		{"outside window", map[string]string{"scheduler.off-schedule": "20:00-08:00"}, at(3, 12, 0), ENABLED, false},
+		{"window start", map[string]string{"scheduler.off-schedule": "20:00-08:00"}, at(3, 20, 0), DISABLED, false},
+		{"before window start", map[string]string{"scheduler.off-schedule": "20:00-08:00"}, at(3, 19, 59), ENABLED, false},
+		{"before window end", map[string]string{"scheduler.off-schedule": "20:00-08:00"}, at(4, 7, 59), DISABLED, false},
+		{"window end", map[string]string{"scheduler.off-schedule": "20:00-08:00"}, at(4, 8, 0), ENABLED, false},
+		{"after midnight", map[string]string{"scheduler.off-schedule": "20:00-08:00"}, at(4, 3, 0), DISABLED, false},
+		{"window within a day", map[string]string{"scheduler.off-schedule": "12:00-13:00"}, at(3, 12, 30), DISABLED, false},
+		{"window with seconds", map[string]string{"scheduler.off-schedule": "12:00:30-13:00:00"}, at(3, 12, 0), ENABLED, false},
+		{"weekday window", map[string]string{"scheduler.off-schedule": "weekdays 20:00-08:00"}, at(7, 22, 0), DISABLED, false},
+		{"weekend outside weekday window", map[string]string{"scheduler.off-schedule": "weekdays 20:00-08:00"}, at(8, 22, 0), ENABLED, false},
+		{"weekday window past midnight", map[string]string{"scheduler.off-schedule": "weekdays 20:00-08:00"}, at(8, 3, 0), DISABLED, false},
+		{"first of multiple windows", map[string]string{"scheduler.off-schedule": `{"windows":["00:00-06:00","12:00-13:00"]}`}, at(3, 5, 0), DISABLED, false},
+		{"second of multiple windows", map[string]string{"scheduler.off-schedule": `{"windows":["00:00-06:00","12:00-13:00"]}`}, at(3, 12, 30), DISABLED, false},
+		{"between multiple windows", map[string]string{"scheduler.off-schedule": `{"windows":["00:00-06:00","12:00-13:00"]}`}, at(3, 9, 0), ENABLED, false},
+		{"timezone inside window", map[string]string{"scheduler.off-schedule": "20:00-08:00", "scheduler.timezone": "Europe/Athens"}, at(3, 17, 30), DISABLED, false},
+		{"timezone outside window", map[string]string{"scheduler.off-schedule": "20:00-08:00", "scheduler.timezone": "Europe/Athens"}, at(3, 5, 30), ENABLED, false},
+		{"JSON timezone", map[string]string{"scheduler.off-schedule": `{"windows":["20:00-08:00"],"timezone":"Europe/Athens"}`}, at(3, 17, 30), DISABLED, false},
+		{"timezone set twice", map[string]string{"scheduler.off-schedule": `{"windows":["20:00-08:00"],"timezone":"Europe/Athens"}`, "scheduler.timezone": "UTC"}, at(3, 12, 0), ENABLED, true},
+		{"invalid timezone", map[string]string{"scheduler.off-schedule": "20:00-08:00", "scheduler.timezone": "Europe/Nowhere"}, at(3, 12, 0), ENABLED, true},
+		{"override up", map[string]string{"scheduler.off-schedule": "20:00-08:00", "scheduler.override": "up"}, at(3, 22, 0), ENABLED, false},
+		{"override down", map[string]string{"scheduler.off-schedule": "20:00-08:00", "scheduler.override": "down"}, at(3, 12, 0), DISABLED, false},
+		{"override down without schedule", map[string]string{"scheduler.override": "down"}, at(3, 12, 0), DISABLED, false},
+		{"override none", map[string]string{"scheduler.off-schedule": "20:00-08:00", "scheduler.override": "none"}, at(3, 22, 0), DISABLED, false},
+		{"invalid override", map[string]string{"scheduler.off-schedule": "20:00-08:00", "scheduler.override": "sideways"}, at(3, 22, 0), ENABLED, true},
+		{"pre-warm", map[string]string{"scheduler.off-schedule": "20:00-08:00", "scheduler.pre-warm": "30m"}, at(4, 7, 45), ENABLED, false},
+		{"before pre-warm", map[string]string{"scheduler.off-schedule": "20:00-08:00", "scheduler.pre-warm": "30m"}, at(4, 7, 15), DISABLED, false},
+		{"pre-warm longer than window", map[string]string{"scheduler.off-schedule": "12:00-13:00", "scheduler.pre-warm": "2h"}, at(3, 12, 30), ENABLED, true},
+		{"exception", map[string]string{"scheduler.off-schedule": "12:00-13:00", "scheduler.schedule-exceptions": "2024-06-03"}, at(3, 12, 30), ENABLED, false},
+		{"outside exception", map[string]string{"scheduler.off-schedule": "12:00-13:00", "scheduler.schedule-exceptions": "2024-06-03"}, at(4, 12, 30), DISABLED, false},
+		{"invalid exception", map[string]string{"scheduler.off-schedule": "12:00-13:00", "scheduler.schedule-exceptions": "June 3rd"}, at(3, 12, 30), ENABLED, true},
+		{"off day", map[string]string{"scheduler.off-schedule": "20:00-08:00", "scheduler.off-days": "mon"}, at(3, 12, 0), DISABLED, false},
+		{"not an off day", map[string]string{"scheduler.off-schedule": "20:00-08:00", "scheduler.off-days": "sat,sun"}, at(3, 12, 0), ENABLED, false},
+		{"weekend off day outside the window", map[string]string{"scheduler.off-schedule": "weekdays 20:00-08:00", "scheduler.off-days": "Saturday,Sunday"}, at(8, 12, 0), DISABLED, false},
+		{"exception on an off day", map[string]string{"scheduler.off-schedule": "12:00-13:00", "scheduler.off-days": "mon", "scheduler.schedule-exceptions": "2024-06-03"}, at(3, 10, 0), ENABLED, false},
+		{"invalid off days", map[string]string{"scheduler.off-schedule": "20:00-08:00", "scheduler.off-days": "sat,someday"}, at(8, 12, 0), ENABLED, true},
+		{"invalid jitter", map[string]string{"scheduler.off-schedule": "12:00-13:00", "scheduler.jitter": "1h"}, at(3, 12, 30), ENABLED, true},
+		{"no schedule", nil, at(3, 12, 0), ENABLED, true},
+		{"invalid schedule", map[string]string{"scheduler.off-schedule": "20:00"}, at(3, 12, 0), ENABLED, true},
+	}
+
+	c, _ := newTestController(t, NewDefaultControllerConfig())
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state, err := c.Decide(newTestDeployment("foo", 1, test.annotations), test.now)
+			if test.err && err == nil {
+				t.Errorf("expected an error")
+			}
+			if !test.err && err != nil {
+				t.Errorf("expected no error, got '%s'", err)
+			}
+			if state != test.state {
+				t.Errorf("expected state %s, got %s", test.state, state)
+			}
+		})
+	}
+}
+
+func TestDecidePreWarm(t *testing.T) {
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, time.June, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name     string
+		schedule string
+		preWarm  string
+		now      time.Time
+		state    DeploymentState
+		err      bool
+	}{
+		{"inside window", "20:00-08:00", "1h", at(4, 6, 59), DISABLED, false},
+		{"warming up", "20:00-08:00", "1h", at(4, 7, 0), ENABLED, false},
+		{"window start unchanged", "20:00-08:00", "1h", at(3, 20, 0), DISABLED, false},
+		{"end moved before midnight", "22:00-00:30", "1h", at(3, 23, 29), DISABLED, false},
+		{"warming up before midnight", "22:00-00:30", "1h", at(3, 23, 30), ENABLED, false},
+		{"warming up past midnight", "22:00-00:30", "1h", at(4, 0, 15), ENABLED, false},
+		{"end at midnight", "22:00-00:30", "30m", at(4, 0, 0), ENABLED, false},
+		{"before end at midnight", "22:00-00:30", "30m", at(3, 23, 59), DISABLED, false},
+		{"window after midnight", "01:00-03:00", "30m", at(4, 2, 30), ENABLED, false},
+		{"every window", `{"windows":["00:00-06:00","12:00-13:00"]}`, "30m", at(3, 12, 45), ENABLED, false},
+		{"zero", "20:00-08:00", "0s", at(4, 7, 59), DISABLED, false},
+		{"as long as the window", "22:00-00:30", "150m", at(3, 23, 0), ENABLED, true},
+		{"negative", "20:00-08:00", "-1h", at(4, 8, 30), ENABLED, true},
+		{"invalid duration", "20:00-08:00", "an hour", at(3, 22, 0), ENABLED, true},
+	}
+
+	c, _ := newTestController(t, NewDefaultControllerConfig())
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			annotations := map[string]string{"scheduler.off-schedule": test.schedule, "scheduler.pre-warm": test.preWarm}
+			state, err := c.Decide(newTestDeployment("foo", 1, annotations), test.now)
+			if test.err != (err != nil) {
+				t.Errorf("expected error %t, got '%v'", test.err, err)
+			}
+			if state != test.state {
+				t.Errorf("expected state %s, got %s", test.state, state)
+			}
+		})
+	}
+}
+
+func TestDecideFallbackSchedules(t *testing.T) {
+	night := time.Date(2024, time.June, 3, 22, 0, 0, 0, time.UTC)
+	noon := time.Date(2024, time.June, 3, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		deployment  string
+		annotations map[string]string
+		now         time.Time
+		state       DeploymentState
+		err         bool
+	}{
+		{"schedule reference", "foo", map[string]string{"scheduler.schedule-ref": "schedules/night"}, night, DISABLED, false},
+		{"schedule reference over annotation", "foo", map[string]string{"scheduler.schedule-ref": "schedules/lunch", "scheduler.off-schedule": "20:00-08:00"}, noon, DISABLED, false},
+		{"missing reference falls back to annotation", "foo", map[string]string{"scheduler.schedule-ref": "schedules/missing", "scheduler.off-schedule": "20:00-08:00"}, night, DISABLED, false},
+		{"missing reference", "foo", map[string]string{"scheduler.schedule-ref": "schedules/missing"}, night, ENABLED, true},
+		{"schedule ConfigMap", "bar", map[string]string{"scheduler.enabled": "true"}, night, DISABLED, false},
+		{"annotation over schedule ConfigMap", "bar", map[string]string{"scheduler.off-schedule": "12:00-13:00"}, night, ENABLED, false},
+		{"namespace schedule", "foo", nil, noon, DISABLED, false},
+		{"annotation over namespace schedule", "foo", map[string]string{"scheduler.off-schedule": "20:00-08:00"}, noon, ENABLED, false},
+	}
+
+	config := NewDefaultControllerConfig()
+	config.ScheduleConfigMap = "scheduler/schedules"
+	c, _ := newTestController(t, config)
+	configMaps := []*core_v1.ConfigMap{
+		{
+			ObjectMeta: meta_v1.ObjectMeta{Namespace: "default", Name: "schedules"},
+			Data:       map[string]string{"night": "20:00-08:00", "lunch": "11:00-13:00"},
+		},
+		{
+			ObjectMeta: meta_v1.ObjectMeta{Namespace: "scheduler", Name: "schedules"},
+			Data:       map[string]string{"default.bar": "20:00-08:00"},
+		},
+	}
+	for _, configMap := range configMaps {
+		if err := c.configMapInformer.GetIndexer().Add(configMap); err != nil {
+			t.Fatal(err)
+		}
+	}
+	namespace := &core_v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: "default", Annotations: map[string]string{"scheduler.default-off-schedule": "11:00-14:00"}}}
+	if err := c.namespaceInformer.GetIndexer().Add(namespace); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state, err := c.Decide(newTestDeployment(test.deployment, 1, test.annotations), test.now)
+			if test.err && err == nil {
+				t.Errorf("expected an error")
+			}
+			if !test.err && err != nil {
+				t.Errorf("expected no error, got '%s'", err)
+			}
+			if state != test.state {
+				t.Errorf("expected state %s, got %s", test.state, state)
+			}
+		})
+	}
+}
+
+func TestDecideDefaultSchedule(t *testing.T) {
+	night := time.Date(2024, time.June, 3, 22, 0, 0, 0, time.UTC)
+	noon := time.Date(2024, time.June, 3, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name            string
+		defaultSchedule string
+		namespace       string
+		annotations     map[string]string
+		now             time.Time
+		state           DeploymentState
+		err             bool
+	}{
+		{"default schedule", "20:00-08:00", "default", map[string]string{"scheduler.enabled": "true"}, night, DISABLED, false},
+		{"outside the default schedule", "20:00-08:00", "default", map[string]string{"scheduler.enabled": "true"}, noon, ENABLED, false},
+		{"annotation over default schedule", "20:00-08:00", "default", map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "12:00-13:00"}, noon, DISABLED, false},
+		{"annotation outside its window", "20:00-08:00", "default", map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "12:00-13:00"}, night, ENABLED, false},
+		{"schedule reference over default schedule", "20:00-08:00", "default", map[string]string{"scheduler.enabled": "true", "scheduler.schedule-ref": "schedules/lunch"}, noon, DISABLED, false},
+		{"namespace schedule over default schedule", "20:00-08:00", "team", map[string]string{"scheduler.enabled": "true"}, noon, DISABLED, false},
+		{"default timezone of the default schedule", "20:00-08:00", "default", map[string]string{"scheduler.enabled": "true", "scheduler.timezone": "Europe/Athens"}, time.Date(2024, time.June, 3, 17, 30, 0, 0, time.UTC), DISABLED, false},
+		{"no default schedule", "", "default", map[string]string{"scheduler.enabled": "true"}, night, ENABLED, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := NewDefaultControllerConfig()
+			config.DefaultSchedule = test.defaultSchedule
+			c, _ := newTestController(t, config)
+			configMap := &core_v1.ConfigMap{
+				ObjectMeta: meta_v1.ObjectMeta{Namespace: "default", Name: "schedules"},
+				Data:       map[string]string{"lunch": "11:00-13:00"},
+			}
+			if err := c.configMapInformer.GetIndexer().Add(configMap); err != nil {
+				t.Fatal(err)
+			}
+			namespace := &core_v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: "team", Annotations: map[string]string{"scheduler.default-off-schedule": "11:00-14:00"}}}
+			if err := c.namespaceInformer.GetIndexer().Add(namespace); err != nil {
+				t.Fatal(err)
+			}
+
+			deployment := newTestDeployment("foo", 1, test.annotations)
+			deployment.Namespace = test.namespace
+			state, err := c.Decide(deployment, test.now)
+			if test.err && err == nil {
+				t.Errorf("expected an error")
+			}
+			if !test.err && err != nil {
+				t.Errorf("expected no error, got '%s'", err)
+			}
+			if state != test.state {
+				t.Errorf("expected state %s, got %s", test.state, state)
+			}
+		})
+	}
+}
+
+func TestDecideNamespaceSchedulePrecedence(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, time.June, 3, hour, minute, 0, 0, time.UTC)
+	}
+	// Each layer has a window only it covers: the deployment's the morning,
+	// the namespace's the noon and the cluster's the night
+	times := []time.Time{at(6, 30), at(12, 0), at(22, 0)}
+	deploymentLayer := map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "06:00-07:00"}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		namespace   map[string]string
+		cluster     string
+		states      []DeploymentState
+		err         string
+	}{
+		{"deployment over namespace and cluster", deploymentLayer, map[string]string{"scheduler.default-off-schedule": "11:00-14:00"}, "20:00-05:00", []DeploymentState{DISABLED, ENABLED, ENABLED}, ""},
+		{"deployment over namespace", deploymentLayer, map[string]string{"scheduler.default-off-schedule": "11:00-14:00"}, "", []DeploymentState{DISABLED, ENABLED, ENABLED}, ""},
+		{"namespace over cluster", map[string]string{"scheduler.enabled": "true"}, map[string]string{"scheduler.default-off-schedule": "11:00-14:00"}, "20:00-05:00", []DeploymentState{ENABLED, DISABLED, ENABLED}, ""},
+		{"namespace only", map[string]string{"scheduler.enabled": "true"}, map[string]string{"scheduler.default-off-schedule": "11:00-14:00"}, "", []DeploymentState{ENABLED, DISABLED, ENABLED}, ""},
+		{"namespace without default", map[string]string{"scheduler.enabled": "true"}, map[string]string{"team": "foo"}, "20:00-05:00", []DeploymentState{ENABLED, ENABLED, DISABLED}, ""},
+		{"namespace not cached", map[string]string{"scheduler.enabled": "true"}, nil, "20:00-05:00", []DeploymentState{ENABLED, ENABLED, DISABLED}, ""},
+		{"invalid namespace default", map[string]string{"scheduler.enabled": "true"}, map[string]string{"scheduler.default-off-schedule": "noon"}, "20:00-05:00", []DeploymentState{ENABLED, ENABLED, ENABLED}, "invalid scheduler.default-off-schedule annotation of namespace 'default'"},
+		{"no schedule", map[string]string{"scheduler.enabled": "true"}, nil, "", []DeploymentState{ENABLED, ENABLED, ENABLED}, "could not find scheduler.off-schedule annotation"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := NewDefaultControllerConfig()
+			config.DefaultSchedule = test.cluster
+			c, _ := newTestController(t, config)
+			if test.namespace != nil {
+				namespace := &core_v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: "default", Annotations: test.namespace}}
+				if err := c.namespaceInformer.GetIndexer().Add(namespace); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for i, now := range times {
+				state, err := c.Decide(newTestDeployment("foo", 1, test.annotations), now)
+				if test.err == "" && err != nil {
+					t.Errorf("expected no error at %s, got '%s'", now.Format(time.TimeOnly), err)
+				}
+				if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+					t.Errorf("expected the error '%s' at %s, got '%v'", test.err, now.Format(time.TimeOnly), err)
+				}
+				if state != test.states[i] {
+					t.Errorf("expected state %s at %s, got %s", test.states[i], now.Format(time.TimeOnly), state)
+				}
+			}
+		})
+	}
+}
+
+func TestStartRejectsInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(config *ControllerConfig)
+		err    string
+	}{
+		{"invalid default schedule", func(config *ControllerConfig) { config.DefaultSchedule = "20:00" }, "invalid default schedule"},
+		{"invalid default timezone", func(config *ControllerConfig) { config.DefaultTimezone = "Europe/Nowhere" }, "invalid default timezone"},
+		{"invalid ignore labels", func(config *ControllerConfig) { config.IgnoreLabels = "app.kubernetes.io/managed-by=Helm,=foo" }, "invalid ignore labels"},
+		{"negative max window length", func(config *ControllerConfig) { config.MaxWindowLength = -time.Hour }, "invalid max window length"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := NewDefaultControllerConfig()
+			test.modify(&config)
+			_, _, err := Start(config)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected the error '%s', got '%v'", test.err, err)
+			}
+		})
+	}
+}
+
+func TestDecideScheduleReference(t *testing.T) {
+	night := time.Date(2024, time.June, 3, 22, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		state       DeploymentState
+		err         string
+	}{
+		{"schedule reference", map[string]string{"scheduler.schedule-ref": "schedules/night"}, DISABLED, ""},
+		{"reference of another ConfigMap key", map[string]string{"scheduler.schedule-ref": "schedules/lunch"}, ENABLED, ""},
+		{"missing ConfigMap key", map[string]string{"scheduler.schedule-ref": "schedules/missing"}, ENABLED, "could not find key 'missing' in ConfigMap 'default.schedules'"},
+		{"missing ConfigMap", map[string]string{"scheduler.schedule-ref": "other/night"}, ENABLED, "could not find ConfigMap 'default.other'"},
+		{"ConfigMap of another namespace", map[string]string{"scheduler.schedule-ref": "shared/night"}, ENABLED, "could not find ConfigMap 'default.shared'"},
+		{"missing ConfigMap falls back to annotation", map[string]string{"scheduler.schedule-ref": "other/night", "scheduler.off-schedule": "20:00-08:00"}, DISABLED, ""},
+		{"malformed reference", map[string]string{"scheduler.schedule-ref": "night"}, ENABLED, "invalid schedule reference 'night'"},
+		{"reference with an empty key", map[string]string{"scheduler.schedule-ref": "schedules/"}, ENABLED, "invalid schedule reference 'schedules/'"},
+		{"invalid referenced schedule", map[string]string{"scheduler.schedule-ref": "schedules/invalid"}, ENABLED, "invalid schedule referenced by scheduler.schedule-ref 'schedules/invalid'"},
+		{"invalid referenced schedule over annotation", map[string]string{"scheduler.schedule-ref": "schedules/invalid", "scheduler.off-schedule": "20:00-08:00"}, ENABLED, "invalid schedule referenced by"},
+	}
+
+	c, _ := newTestController(t, NewDefaultControllerConfig())
+	configMaps := []*core_v1.ConfigMap{
+		{
+			ObjectMeta: meta_v1.ObjectMeta{Namespace: "default", Name: "schedules"},
+			Data:       map[string]string{"night": "20:00-08:00", "lunch": "11:00-13:00", "invalid": "20:00"},
+		},
+		{
+			ObjectMeta: meta_v1.ObjectMeta{Namespace: "shared", Name: "night"},
+			Data:       map[string]string{"night": "20:00-08:00"},
+		},
+	}
+	for _, configMap := range configMaps {
+		if err := c.configMapInformer.GetIndexer().Add(configMap); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state, err := c.Decide(newTestDeployment("foo", 1, test.annotations), night)
+			if test.err == "" && err != nil {
+				t.Errorf("expected no error, got '%s'", err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("expected the error '%s', got '%v'", test.err, err)
+			}
+			if state != test.state {
+				t.Errorf("expected state %s, got %s", test.state, state)
+			}
+		})
+	}
+}
+
+func TestDecideFollowsScheduleReferenceUpdates(t *testing.T) {
+	night := time.Date(2024, time.June, 3, 22, 0, 0, 0, time.UTC)
+	c, _ := newTestController(t, NewDefaultControllerConfig())
+	deployment := newTestDeployment("foo", 1, map[string]string{"scheduler.schedule-ref": "schedules/nightly"})
+	configMap := &core_v1.ConfigMap{
+		ObjectMeta: meta_v1.ObjectMeta{Namespace: "default", Name: "schedules"},
+		Data:       map[string]string{"nightly": "20:00-08:00"},
+	}
+
+	steps := []struct {
+		name     string
+		schedule string
+		state    DeploymentState
+	}{
+		{"initial schedule", "20:00-08:00", DISABLED},
+		{"updated schedule", "23:00-06:00", ENABLED},
+		{"restored schedule", "21:00-06:00", DISABLED},
+	}
+	for _, step := range steps {
+		configMap = configMap.DeepCopy()
+		configMap.Data["nightly"] = step.schedule
+		if err := c.configMapInformer.GetIndexer().Update(configMap); err != nil {
+			t.Fatal(err)
+		}
+		state, err := c.Decide(deployment, night)
+		if err != nil {
+			t.Fatalf("%s: %s", step.name, err)
+		}
+		if state != step.state {
+			t.Errorf("%s: expected state %s, got %s", step.name, step.state, state)
+		}
+	}
+}
+
+func TestTimeRangeInRange(t *testing.T) {
+	// 2024-06-03 is a Monday
+	at := func(day, hour, minute, second int) time.Time {
+		return time.Date(2024, time.June, day, hour, minute, second, 0, time.UTC)
+	}
+	tests := []struct {
+		schedule string
+		now      time.Time
+		inRange  bool
+	}{
+		{"18:00-19:00", at(3, 17, 59, 59), false},
+		{"18:00-19:00", at(3, 18, 0, 0), true},
+		{"18:00-19:00", at(3, 18, 59, 59), true},
+		{"18:00-19:00", at(3, 19, 0, 0), false},
+		{"22:00-06:00", at(3, 21, 59, 59), false},
+		{"22:00-06:00", at(3, 22, 0, 0), true},
+		{"22:00-06:00", at(3, 23, 59, 59), true},
+		{"22:00-06:00", at(4, 0, 0, 0), true},
+		{"22:00-06:00", at(4, 5, 59, 59), true},
+		{"22:00-06:00", at(4, 6, 0, 0), false},
+		{"00:00-06:00", at(3, 0, 0, 0), true},
+		{"18:00-00:00", at(3, 23, 59, 59), true},
+		{"18:00-00:00", at(4, 0, 0, 0), false},
+		{"18:00:30-19:00:00", at(3, 18, 0, 29), false},
+		{"18:00:30-19:00:00", at(3, 18, 0, 30), true},
+		{"18:00:30-19:00:00", at(3, 18, 59, 59), true},
+		{"18:00:30-19:00:00", at(3, 19, 0, 0), false},
+		{"18:00-19:00:30", at(3, 19, 0, 29), true},
+		{"18:00-19:00:30", at(3, 19, 0, 30), false},
+		{"18:01-19:00", at(3, 18, 0, 59), false},
+		{"18:01-19:00", at(3, 18, 1, 0), true},
+		{"18:00-18:01", at(3, 18, 0, 59), true},
+		{"18:00-18:01", at(3, 18, 1, 1), false},
+		{"23:59:59-00:00:01", at(3, 23, 59, 59), true},
+		{"23:59:59-00:00:01", at(4, 0, 0, 0), true},
+		{"23:59:59-00:00:01", at(4, 0, 0, 1), false},
+		{"M 22:00-06:00", at(3, 22, 0, 0), true},
+		{"M 22:00-06:00", at(4, 5, 59, 59), true},
+		{"M 22:00-06:00", at(4, 22, 0, 0), false},
+		{"M 22:00-06:00", at(3, 5, 0, 0), false},
+		{"SaSu 10:00-12:00", at(8, 11, 0, 0), true},
+		{"SaSu 10:00-12:00", at(7, 11, 0, 0), false},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s at %s", test.schedule, test.now.Format(time.DateTime)), func(t *testing.T) {
+			timeRange, err := ParseSchedule(test.schedule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inRange := timeRange.InRange(test.now); inRange != test.inRange {
+				t.Errorf("expected in range %t, got %t", test.inRange, inRange)
+			}
+		})
+	}
+}
+
+func TestParseScheduleSeconds(t *testing.T) {
+	tests := []struct {
+		schedule string
+		seconds  bool
+		text     string
+		err      bool
+	}{
+		{"18:00-19:00", false, "18:00-19:00", false},
+		{"18:00:30-19:00:00", true, "18:00:30-19:00:00", false},
+		{"18:00:00-19:00:00", true, "18:00:00-19:00:00", false},
+		{"18:00-19:00:30", true, "18:00:00-19:00:30", false},
+		{"M 18:00:30-19:00", true, "M 18:00:30-19:00:00", false},
+		{"18:00:60-19:00", false, "", true},
+		{"18:00:3-19:00", false, "", true},
+		{"18:00:30-18:00:30", false, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.schedule, func(t *testing.T) {
+			timeRange, err := ParseSchedule(test.schedule)
+			if test.err {
+				if err == nil {
+					t.Errorf("expected an error, got %s", timeRange)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if timeRange.Seconds != test.seconds || timeRange.String() != test.text {
+				t.Errorf("expected '%s' (seconds %t), got '%s' (seconds %t)", test.text, test.seconds, timeRange, timeRange.Seconds)
+			}
+		})
+	}
+}
+
+func TestReconcileAtMinuteBoundaries(t *testing.T) {
+	discardLogs(t)
+	at := func(day, hour, minute, second int) time.Time {
+		return time.Date(2024, time.June, day, hour, minute, second, 0, time.UTC)
+	}
+	annotations := map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "18:00-08:00"}
+	c, clientset := newTestController(t, NewDefaultControllerConfig(), newTestDeployment("foo", 2, annotations))
+	clock := &fakeClock{}
+	c.SetClock(clock)
+
+	// The steps run in order, every reconcile sees the result of the
+	// previous one
+	steps := []struct {
+		now      time.Time
+		replicas int32
+	}{
+		{at(3, 17, 59, 58), 2},
+		{at(3, 17, 59, 59), 2},
+		{at(3, 18, 0, 0), 0},
+		{at(3, 18, 0, 2), 0},
+		{at(4, 7, 59, 58), 0},
+		{at(4, 7, 59, 59), 0},
+		{at(4, 8, 0, 0), 2},
+		{at(4, 8, 0, 2), 2},
+	}
+	for _, step := range steps {
+		clock.Set(step.now)
+		if err := c.reconcile(context.Background(), "default/foo"); err != nil {
+			t.Fatal(err)
+		}
+		deployment, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *deployment.Spec.Replicas != step.replicas {
+			t.Errorf("expected %d replicas at %s, got %d", step.replicas, step.now.Format(time.TimeOnly), *deployment.Spec.Replicas)
+		}
+		// The informer is not running, its cache is updated by hand
+		if err := c.deploymentInformer.GetIndexer().Update(deployment); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTimeRangeInRangeUsesLocation(t *testing.T) {
+	athens, err := LoadLocation("Europe/Athens")
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeRange, err := ParseSchedule("18:00-19:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 15:30 UTC is 18:30 in Athens during summer time
+	now := time.Date(2024, time.June, 3, 15, 30, 0, 0, time.UTC)
+	if timeRange.InRange(now) {
+		t.Errorf("expected %s to be out of range in UTC", now)
+	}
+	if !timeRange.InRange(now.In(athens)) {
+		t.Errorf("expected %s to be in range in Europe/Athens", now.In(athens))
+	}
+}
+
+// newSignalServer starts a signal endpoint responding after the delay. It
+// calls handled for every request and counts the requests it serves at once.
+func newSignalServer(tb testing.TB, delay time.Duration, handled func()) (*httptest.Server, *atomic.Int64) {
+	tb.Helper()
+	var inFlight, maxInFlight atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := inFlight.Add(1)
+		for {
+			max := maxInFlight.Load()
+			if current <= max || maxInFlight.CompareAndSwap(max, current) {
+				break
+			}
+		}
+		time.Sleep(delay)
+		inFlight.Add(-1)
+		handled()
+	}))
+	tb.Cleanup(server.Close)
+	return server, &maxInFlight
+}
+
+// newSignalDeployments creates deployments in their off-window at 22:00,
+// held up by their own signal endpoint of the server
+func newSignalDeployments(server *httptest.Server, count int) []*apps_v1.Deployment {
+	deployments := make([]*apps_v1.Deployment, 0, count)
+	for i := 0; i < count; i++ {
+		name := fmt.Sprintf("deployment-%d", i)
+		deployments = append(deployments, newTestDeployment(name, 2, map[string]string{
+			"scheduler.enabled":        "true",
+			"scheduler.off-schedule":   "20:00-08:00",
+			"scheduler.require-signal": server.URL + "/" + name,
+		}))
+	}
+	return deployments
+}
+
+func TestWorkersRespectToggleConcurrency(t *testing.T) {
+	discardLogs(t)
+	const deployments = 20
+	var handled sync.WaitGroup
+	handled.Add(deployments)
+	server, maxInFlight := newSignalServer(t, 20*time.Millisecond, handled.Done)
+
+	config := NewDefaultControllerConfig()
+	config.ReconcileQPS = math.Inf(1)
+	config.ToggleConcurrency = 3
+	c, _ := newTestController(t, config, newSignalDeployments(server, deployments)...)
+	c.SetClock(&fakeClock{now: time.Date(2024, time.June, 3, 22, 0, 0, 0, time.UTC)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.startWorkers(ctx)
+	c.loopIteration(ctx)
+	handled.Wait()
+
+	if max := maxInFlight.Load(); max > int64(config.ToggleConcurrency) {
+		t.Errorf("expected at most %d deployments reconciled at once, got %d", config.ToggleConcurrency, max)
+	} else if max < 2 {
+		t.Errorf("expected the deployments to be reconciled in parallel, got %d at once", max)
+	}
+}
+
+func TestWorkersContinueAfterFailedToggles(t *testing.T) {
+	discardLogs(t)
+	config := NewDefaultControllerConfig()
+	config.ReconcileQPS = math.Inf(1)
+	var deployments []*apps_v1.Deployment
+	for i := 0; i < 5; i++ {
+		deployments = append(deployments, newTestDeployment(fmt.Sprintf("deployment-%d", i), 2, map[string]string{
+			"scheduler.enabled":      "true",
+			"scheduler.off-schedule": "20:00-08:00",
+		}))
+	}
+	c, clientset := newTestController(t, config, deployments...)
+	c.SetClock(&fakeClock{now: time.Date(2024, time.June, 3, 22, 0, 0, 0, time.UTC)})
+	clientset.PrependReactor("update", "deployments", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+		if action.(k8s_testing.UpdateAction).GetObject().(*apps_v1.Deployment).Name == "deployment-0" {
+			return true, nil, fmt.Errorf("injected failure")
+		}
+		return false, nil, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.startWorkers(ctx)
+	c.loopIteration(ctx)
+
+	err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 10*time.Second, true, func(ctx context.Context) (bool, error) {
+		for _, deployment := range deployments[1:] {
+			current, err := clientset.AppsV1().Deployments("default").Get(ctx, deployment.Name, meta_v1.GetOptions{})
+			if err != nil || *current.Spec.Replicas != 0 {
+				return false, err
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		t.Fatalf("expected the other deployments to be scaled down: %s", err)
+	}
+	if c.queue.NumRequeues("default/deployment-0") == 0 {
+		t.Errorf("expected the failed deployment to be requeued")
+	}
+}
+
+// BenchmarkToggleConcurrency reconciles deployments waiting on a slow
+// signal endpoint, as the API calls of a toggle do, with the workers of each
+// concurrency
+func BenchmarkToggleConcurrency(b *testing.B) {
+	const deployments = 100
+	for _, concurrency := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			discardLogs(b)
+			var handled sync.WaitGroup
+			server, _ := newSignalServer(b, time.Millisecond, handled.Done)
+
+			config := NewDefaultControllerConfig()
+			config.ReconcileQPS = math.Inf(1)
+			config.ToggleConcurrency = concurrency
+			c, _ := newTestController(b, config, newSignalDeployments(server, deployments)...)
+			c.SetClock(&fakeClock{now: time.Date(2024, time.June, 3, 22, 0, 0, 0, time.UTC)})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			c.startWorkers(ctx)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// Dropping the cached signals makes every reconcile query
+				// its endpoint again
+				c.signals = newSignalChecker(server.Client())
+				handled.Add(deployments)
+				c.loopIteration(ctx)
+				handled.Wait()
+			}
+		})
+	}
+}
+
+// deploymentWrites counts the update, patch and apply actions on deployments
+// recorded by the fake clientset
+func deploymentWrites(clientset *fake.Clientset) int {
+	writes := 0
+	for _, action := range clientset.Actions() {
+		if action.GetResource().Resource == "deployments" && (action.GetVerb() == "update" || action.GetVerb() == "patch") {
+			writes++
+		}
+	}
+	return writes
+}
+
+func TestReconcileRestoresPausedDeploymentsOnce(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		replicas    int32
+		restored    bool
+	}{
+		{"scaled down", map[string]string{"scheduler.enabled": "false", "scheduler.replicas-memory": "3"}, 3, true},
+		{"scaled down with a falsy variant", map[string]string{"scheduler.enabled": "off", "scheduler.replicas-memory": "3"}, 3, true},
+		{"not scaled down", map[string]string{"scheduler.enabled": "false"}, 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The deployment stays inside its off-schedule the whole time
+			test.annotations["scheduler.off-schedule"] = "00:00-23:59"
+			c, clientset := newTestController(t, NewDefaultControllerConfig(), newTestDeployment("foo", 0, test.annotations))
+			c.SetClock(&fakeClock{now: time.Date(2024, time.June, 3, 12, 0, 0, 0, time.UTC)})
+
+			if err := c.reconcile(context.Background(), "default/foo"); err != nil {
+				t.Fatal(err)
+			}
+			syncCache(t, c, clientset)
+			restoreWrites := deploymentWrites(clientset)
+			if restored := restoreWrites > 0; restored != test.restored {
+				t.Errorf("expected restored %t, got %d writes", test.restored, restoreWrites)
+			}
+
+			// The following loops leave the deployment alone
+			for i := 0; i < 3; i++ {
+				if err := c.reconcile(context.Background(), "default/foo"); err != nil {
+					t.Fatal(err)
+				}
+				syncCache(t, c, clientset)
+			}
+			if writes := deploymentWrites(clientset); writes != restoreWrites {
+				t.Errorf("expected no writes after the restore, got %d", writes-restoreWrites)
+			}
+
+			deployment, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *deployment.Spec.Replicas != test.replicas {
+				t.Errorf("expected %d replicas, got %d", test.replicas, *deployment.Spec.Replicas)
+			}
+			if _, remembered := deployment.Annotations["scheduler.replicas-memory"]; remembered {
+				t.Errorf("expected the replicas memory to be removed, got %v", deployment.Annotations)
+			}
+		})
+	}
+}
+
+func TestReconcileLimitsScaleDownsPerLoop(t *testing.T) {
+	discardLogs(t)
+	names := []string{"a", "b", "c", "d", "e"}
+	tests := []struct {
+		name    string
+		limit   int
+		down    string
+		failing string
+		scaled  []string
+	}{
+		{"unlimited", 0, "", "", []string{"a", "b", "c", "d", "e"}},
+		{"limit", 2, "", "", []string{"a", "b"}},
+		{"failed scale down", 2, "", "a", []string{"b", "c"}},
+		{"already scaled down", 2, "a", "", []string{"a", "b", "c"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := NewDefaultControllerConfig()
+			config.MaxScaleDownPerLoop = test.limit
+			var deployments []*apps_v1.Deployment
+			for _, name := range names {
+				annotations := map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00"}
+				replicas := int32(2)
+				if name == test.down {
+					annotations["scheduler.replicas-memory"] = "2"
+					replicas = 0
+				}
+				deployments = append(deployments, newTestDeployment(name, replicas, annotations))
+			}
+			c, clientset := newTestController(t, config, deployments...)
+			c.SetClock(&fakeClock{now: time.Date(2024, time.June, 3, 22, 0, 0, 0, time.UTC)})
+			clientset.PrependReactor("update", "deployments", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+				if action.(k8s_testing.UpdateAction).GetObject().(*apps_v1.Deployment).Name == test.failing {
+					return true, nil, fmt.Errorf("injected failure")
+				}
+				return false, nil, nil
+			})
+
+			c.loopIteration(context.Background())
+			for _, name := range names {
+				err := c.reconcile(context.Background(), "default/"+name)
+				if err != nil && name != test.failing {
+					t.Fatal(err)
+				}
+			}
+
+			var scaled []string
+			for _, name := range names {
+				deployment, err := clientset.AppsV1().Deployments("default").Get(context.Background(), name, meta_v1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if *deployment.Spec.Replicas == 0 {
+					scaled = append(scaled, name)
+				}
+			}
+			if fmt.Sprint(scaled) != fmt.Sprint(test.scaled) {
+				t.Errorf("expected %v to be scaled down, got %v", test.scaled, scaled)
+			}
+		})
+	}
+}
+
+func TestReconcileWithCustomAnnotationPrefix(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		down        int32
+		memory      string
+	}{
+		{"custom prefix", map[string]string{"mycompany.io/scheduler.enabled": "true", "mycompany.io/scheduler.off-schedule": "20:00-08:00"}, 0, "3"},
+		{"default prefix", map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00"}, 3, ""},
+		{"custom prefix disabled", map[string]string{"mycompany.io/scheduler.enabled": "false", "mycompany.io/scheduler.off-schedule": "20:00-08:00", "scheduler.enabled": "true"}, 3, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := NewDefaultControllerConfig()
+			config.AnnotationPrefix = "mycompany.io/scheduler."
+			c, clientset := newTestController(t, config, newTestDeployment("foo", 3, test.annotations))
+			clock := &fakeClock{now: time.Date(2024, time.June, 3, 22, 0, 0, 0, time.UTC)}
+			c.SetClock(clock)
+
+			// Scaled down in the off-schedule, with the replicas remembered
+			// under the custom prefix
+			if err := c.reconcile(context.Background(), "default/foo"); err != nil {
+				t.Fatal(err)
+			}
+			deployment, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *deployment.Spec.Replicas != test.down {
+				t.Errorf("expected %d replicas in the off-schedule, got %d", test.down, *deployment.Spec.Replicas)
+			}
+			if memory := deployment.Annotations["mycompany.io/scheduler.replicas-memory"]; memory != test.memory {
+				t.Errorf("expected the replicas memory '%s', got '%s'", test.memory, memory)
+			}
+			if memory, exists := deployment.Annotations["scheduler.replicas-memory"]; exists {
+				t.Errorf("expected no replicas memory under the default prefix, got '%s'", memory)
+			}
+
+			// Restored after the off-schedule, with the memory dropped
+			if err := c.deploymentInformer.GetIndexer().Update(deployment); err != nil {
+				t.Fatal(err)
+			}
+			clock.Set(time.Date(2024, time.June, 4, 12, 0, 0, 0, time.UTC))
+			if err := c.reconcile(context.Background(), "default/foo"); err != nil {
+				t.Fatal(err)
+			}
+			deployment, err = clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *deployment.Spec.Replicas != 3 {
+				t.Errorf("expected 3 replicas after the off-schedule, got %d", *deployment.Spec.Replicas)
+			}
+			if memory, exists := deployment.Annotations["mycompany.io/scheduler.replicas-memory"]; exists {
+				t.Errorf("expected the replicas memory to be dropped, got '%s'", memory)
+			}
+		})
+	}
+}
+
+func TestReconcileReportsScheduleErrors(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		err         string
+		writes      int
+	}{
+		{"invalid schedule", map[string]string{"scheduler.off-schedule": "20:00"}, "invalid schedule", 1},
+		{"invalid timezone", map[string]string{"scheduler.off-schedule": "20:00-08:00", "scheduler.timezone": "Europe/Nowhere"}, "invalid scheduler.timezone annotation", 1},
+		{"stale error", map[string]string{"scheduler.off-schedule": "20:00-08:00", "scheduler.error": "invalid schedule"}, "", 1},
+		{"valid schedule", map[string]string{"scheduler.off-schedule": "20:00-08:00"}, "", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			annotations := map[string]string{"scheduler.enabled": "true"}
+			for key, value := range test.annotations {
+				annotations[key] = value
+			}
+			c, clientset := newTestController(t, NewDefaultControllerConfig(), newTestDeployment("foo", 2, annotations))
+			c.SetClock(&fakeClock{now: time.Date(2024, time.June, 3, 12, 0, 0, 0, time.UTC)})
+
+			if err := c.reconcile(context.Background(), "default/foo"); err != nil {
+				t.Fatal(err)
+			}
+			deployment, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			value, exists := deployment.Annotations["scheduler.error"]
+			if exists != (test.err != "") || !strings.Contains(value, test.err) {
+				t.Errorf("expected the error annotation '%s', got '%s' (exists %t)", test.err, value, exists)
+			}
+			if writes := deploymentWrites(clientset); writes != test.writes {
+				t.Errorf("expected %d writes, got %d", test.writes, writes)
+			}
+			if *deployment.Spec.Replicas != 2 {
+				t.Errorf("expected the replicas to be left alone, got %d", *deployment.Spec.Replicas)
+			}
+
+			// The reconcile triggered by the write of the annotation does
+			// not write it again
+			if err := c.deploymentInformer.GetIndexer().Update(deployment); err != nil {
+				t.Fatal(err)
+			}
+			clientset.ClearActions()
+			if err := c.reconcile(context.Background(), "default/foo"); err != nil {
+				t.Fatal(err)
+			}
+			if writes := deploymentWrites(clientset); writes != 0 {
+				t.Errorf("expected no writes on the next reconcile, got %d", writes)
+			}
+		})
+	}
+}
+
+// contextStore is a ReplicaStore keeping the replicas in memory, recording
+// the context of the last call to Remember and its error at the time
+type contextStore struct {
+	*MemoryReplicaStore
+	ctx context.Context
+	err error
+}
+
+func (s *contextStore) Remember(ctx context.Context, deployment *apps_v1.Deployment, replicas int32) error {
+	s.ctx, s.err = ctx, ctx.Err()
+	return s.MemoryReplicaStore.Remember(ctx, deployment, replicas)
+}
+
+func TestToggleBoundsAPICalls(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name      string
+		timeout   time.Duration
+		cancelled bool
+		err       error
+	}{
+		{"API timeout", 5 * time.Second, false, nil},
+		{"short API timeout", time.Second, false, nil},
+		{"cancelled on shutdown", 5 * time.Second, true, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := &contextStore{MemoryReplicaStore: NewMemoryReplicaStore()}
+			config := NewDefaultControllerConfig()
+			config.APITimeout = test.timeout
+			config.ReplicaStore = store
+			deployment := newTestDeployment("foo", 2, map[string]string{"scheduler.enabled": "true"})
+			c, _ := newTestController(t, config, deployment)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if test.cancelled {
+				cancel()
+			}
+			start := time.Now()
+			c.toggle(ctx, deployment, DISABLED)
+			if store.ctx == nil {
+				t.Fatal("expected the replicas to be remembered")
+			}
+			deadline, bounded := store.ctx.Deadline()
+			if !bounded || deadline.Before(start) || deadline.After(start.Add(test.timeout+time.Second)) {
+				t.Errorf("expected a deadline within %s, got %s (bounded %t)", test.timeout, deadline.Sub(start), bounded)
+			}
+			if store.err != test.err {
+				t.Errorf("expected the context error %v, got %v", test.err, store.err)
+			}
+		})
+	}
+}
+
+func TestReconcileFollowsOverrides(t *testing.T) {
+	discardLogs(t)
+	night := time.Date(2024, time.June, 3, 22, 0, 0, 0, time.UTC)
+	noon := time.Date(2024, time.June, 4, 12, 0, 0, 0, time.UTC)
+	steps := []struct {
+		name     string
+		override string
+		now      time.Time
+		replicas int32
+	}{
+		{"schedule", "", night, 0},
+		{"override up in the off-schedule", "up", night, 3},
+		{"override none", "none", night, 0},
+		{"override down outside the off-schedule", "down", noon, 0},
+		{"override removed", "", noon, 3},
+		{"override up outside the off-schedule", "up", noon, 3},
+	}
+
+	annotations := map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00"}
+	c, clientset := newTestController(t, NewDefaultControllerConfig(), newTestDeployment("foo", 3, annotations))
+	clock := &fakeClock{}
+	c.SetClock(clock)
+	for _, step := range steps {
+		deployment, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		delete(deployment.Annotations, "scheduler.override")
+		if step.override != "" {
+			deployment.Annotations["scheduler.override"] = step.override
+		}
+		deployment, err = clientset.AppsV1().Deployments("default").Update(context.Background(), deployment, meta_v1.UpdateOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.deploymentInformer.GetIndexer().Update(deployment); err != nil {
+			t.Fatal(err)
+		}
+		clock.Set(step.now)
+
+		if err := c.reconcile(context.Background(), "default/foo"); err != nil {
+			t.Fatalf("%s: %s", step.name, err)
+		}
+		deployment, err = clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *deployment.Spec.Replicas != step.replicas {
+			t.Errorf("%s: expected %d replicas, got %d", step.name, step.replicas, *deployment.Spec.Replicas)
+		}
+	}
+}
+
+func TestReloadCoalescesRequests(t *testing.T) {
+	discardLogs(t)
+	c, _ := newTestController(t, NewDefaultControllerConfig(), newTestDeployment("foo", 1, nil))
+
+	// Requests made while a reload is pending are coalesced into it
+	for i := 0; i < 3; i++ {
+		c.Reload()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.runReloads(ctx)
+		close(done)
+	}()
+	if err := wait.PollUntilContextTimeout(context.Background(), 5*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+		return c.ReconcileLoops() > 0, nil
+	}); err != nil {
+		t.Fatal("the reload did not run")
+	}
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+
+	if loops := c.ReconcileLoops(); loops != 1 {
+		t.Errorf("expected a single resync, got %d", loops)
+	}
+	if queued := c.queue.Len(); queued != 1 {
+		t.Errorf("expected the deployment to be queued, got %d queued", queued)
+	}
+}
+
+func TestQueueRateLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		qps    float64
+		burst  int
+		keys   []string
+		delays []time.Duration
+	}{
+		{"backoff of a failing deployment", math.Inf(1), 1, []string{"a", "a", "a", "a", "a"}, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 8 * time.Second}},
+		{"backoff per deployment", math.Inf(1), 1, []string{"a", "a", "b", "a", "b"}, []time.Duration{time.Second, 2 * time.Second, time.Second, 4 * time.Second, 2 * time.Second}},
+		{"overall rate", 0.1, 2, []string{"a", "b", "c", "d"}, []time.Duration{time.Second, time.Second, 10 * time.Second, 20 * time.Second}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := NewDefaultControllerConfig()
+			config.ReconcileQPS = test.qps
+			config.ReconcileBurst = test.burst
+			config.RetryBaseDelay = time.Second
+			config.RetryMaxDelay = 8 * time.Second
+			limiter := newQueueRateLimiter(config, rate.NewLimiter(rate.Limit(test.qps), test.burst))
+
+			for i, key := range test.keys {
+				delay := limiter.When(key)
+				// The overall rate is only accurate to the time spent
+				// between the calls
+				if delay > test.delays[i] || delay < test.delays[i]-100*time.Millisecond {
+					t.Errorf("retry %d of %s: expected a delay of %s, got %s", i, key, test.delays[i], delay)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessNextItemRequeuesFailures(t *testing.T) {
+	discardLogs(t)
+	config := NewDefaultControllerConfig()
+	config.ReconcileQPS = math.Inf(1)
+	config.RetryBaseDelay = time.Millisecond
+	annotations := map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00"}
+	c, clientset := newTestController(t, config, newTestDeployment("foo", 2, annotations))
+	c.SetClock(&fakeClock{now: time.Date(2024, time.June, 3, 22, 0, 0, 0, time.UTC)})
+	failures := 2
+	clientset.PrependReactor("update", "deployments", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+		if failures > 0 {
+			failures--
+			return true, nil, fmt.Errorf("injected failure")
+		}
+		return false, nil, nil
+	})
+
+	c.queue.Add("default/foo")
+	for _, requeues := range []int{1, 2, 0} {
+		c.processNextItem(context.Background())
+		if got := c.queue.NumRequeues("default/foo"); got != requeues {
+			t.Errorf("expected %d requeues, got %d", requeues, got)
+		}
+	}
+	deployment, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *deployment.Spec.Replicas != 0 {
+		t.Errorf("expected the deployment to be scaled down once the failures stop, got %d replicas", *deployment.Spec.Replicas)
+	}
+}
+
+func TestProcessNextItemTagsLogsWithLoopID(t *testing.T) {
+	logs := captureLogs(t)
+	annotations := map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00"}
+	c, _ := newTestController(t, NewDefaultControllerConfig(), newTestDeployment("foo", 2, annotations))
+	clock := &fakeClock{}
+	c.SetClock(clock)
+	ctx := context.Background()
+
+	// Every loop tags the reconciles it queued with its own ID
+	ids := map[string]bool{}
+	for _, step := range []struct {
+		now     time.Time
+		message string
+	}{
+		{time.Date(2024, time.June, 3, 22, 0, 0, 0, time.UTC), "Scaling down deployment"},
+		{time.Date(2024, time.June, 4, 12, 0, 0, 0, time.UTC), "Scaling up deployment"},
+	} {
+		clock.Set(step.now)
+		logs.Reset()
+		c.loopIteration(ctx)
+		c.processNextItem(ctx)
+		id, _ := c.loopID.Load().(string)
+		ids[id] = true
+
+		expected := fmt.Sprintf("correlation_id=%s deployment=default/foo", id)
+		found := false
+		for _, line := range strings.Split(logs.String(), "\n") {
+			if strings.Contains(line, step.message) {
+				found = strings.Contains(line, expected)
+			}
+		}
+		if !found {
+			t.Errorf("expected the log '%s' tagged with '%s', got the logs %s", step.message, expected, logs)
+		}
+		deployment, err := c.clientset.AppsV1().Deployments("default").Get(ctx, "foo", meta_v1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.deploymentInformer.GetIndexer().Update(deployment); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(ids) != 2 {
+		t.Errorf("expected a different ID per loop, got %v", ids)
+	}
+}
+
+func TestParseBoolAnnotation(t *testing.T) {
+	tests := []struct {
+		value  string
+		result bool
+		ok     bool
+	}{
+		{"true", true, true},
+		{"True", true, true},
+		{" TRUE ", true, true},
+		{"1", true, true},
+		{"t", true, true},
+		{"yes", true, true},
+		{"Yes\n", true, true},
+		{"on", true, true},
+		{"false", false, true},
+		{"0", false, true},
+		{"no", false, true},
+		{"OFF", false, true},
+		{"", false, false},
+		{"enabled", false, false},
+		{"y", false, false},
+		{"2", false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			result, ok := ParseBoolAnnotation(test.value)
+			if result != test.result || ok != test.ok {
+				t.Errorf("expected %t (ok %t), got %t (ok %t)", test.result, test.ok, result, ok)
+			}
+		})
+	}
+}
+
+func TestReconcileEnabledVariants(t *testing.T) {
+	tests := []struct {
+		value        string
+		replicas     int32
+		unrecognized bool
+	}{
+		{"true", 0, false},
+		{" True ", 0, false},
+		{"yes", 0, false},
+		{"1", 0, false},
+		{"on", 0, false},
+		{"false", 2, false},
+		{"no", 2, false},
+		{"enabled", 2, true},
+		{"maybe", 2, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			logger := slog.Default()
+			logs := &bytes.Buffer{}
+			slog.SetDefault(slog.New(slog.NewTextHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+			t.Cleanup(func() { slog.SetDefault(logger) })
+			deployment := newTestDeployment("foo", 2, map[string]string{"scheduler.enabled": test.value, "scheduler.off-schedule": "20:00-08:00"})
+			c, clientset := newTestController(t, NewDefaultControllerConfig(), deployment)
+			c.SetClock(&fakeClock{now: time.Date(2024, time.June, 3, 22, 0, 0, 0, time.UTC)})
+
+			if err := c.reconcile(context.Background(), "default/foo"); err != nil {
+				t.Fatal(err)
+			}
+			current, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *current.Spec.Replicas != test.replicas {
+				t.Errorf("expected %d replicas, got %d", test.replicas, *current.Spec.Replicas)
+			}
+			if unrecognized := strings.Contains(logs.String(), "Unrecognized scheduler.enabled annotation"); unrecognized != test.unrecognized {
+				t.Errorf("expected unrecognized %t, got the logs %s", test.unrecognized, logs)
+			}
+		})
+	}
+}
+
+func TestReconcileExcludedDeployments(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name        string
+		namespace   string
+		replicas    int32
+		annotations map[string]string
+		expected    int32
+	}{
+		{"not excluded", "default", 2, map[string]string{"scheduler.enabled": "true"}, 0},
+		{"default schedule", "default", 2, map[string]string{"scheduler.enabled": "true", "scheduler.exclude": "true"}, 2},
+		{"namespace schedule", "team", 2, map[string]string{"scheduler.enabled": "true", "scheduler.exclude": "true"}, 2},
+		{"own schedule", "default", 2, map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00", "scheduler.exclude": "yes"}, 2},
+		{"forced", "default", 2, map[string]string{"scheduler.enabled": "true", "scheduler.force": "true", "scheduler.exclude": "true"}, 2},
+		{"scaled down before the exclusion", "default", 0, map[string]string{"scheduler.enabled": "true", "scheduler.replicas-memory": "2", "scheduler.exclude": "true"}, 0},
+		{"exclusion turned off", "default", 2, map[string]string{"scheduler.enabled": "true", "scheduler.exclude": "false"}, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := NewDefaultControllerConfig()
+			config.DefaultSchedule = "20:00-08:00"
+			deployment := newTestDeployment("foo", test.replicas, test.annotations)
+			deployment.Namespace = test.namespace
+			c, clientset := newTestController(t, config, deployment)
+			c.SetClock(&fakeClock{now: time.Date(2024, time.June, 3, 22, 0, 0, 0, time.UTC)})
+			namespace := &core_v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: "team", Annotations: map[string]string{"scheduler.default-off-schedule": "21:00-07:00"}}}
+			if err := c.namespaceInformer.GetIndexer().Add(namespace); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := c.reconcile(context.Background(), test.namespace+"/foo"); err != nil {
+				t.Fatal(err)
+			}
+			current, err := clientset.AppsV1().Deployments(test.namespace).Get(context.Background(), "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *current.Spec.Replicas != test.expected {
+				t.Errorf("expected %d replicas, got %d", test.expected, *current.Spec.Replicas)
+			}
+			if writes := deploymentWrites(clientset); test.replicas == test.expected && writes != 0 {
+				t.Errorf("expected no writes to an excluded deployment, got %d", writes)
+			}
+		})
+	}
+}
+
+func TestProcessNextItemSkipsDeletedDeployments(t *testing.T) {
+	tests := []struct {
+		name        string
+		replicas    int32
+		annotations map[string]string
+		now         time.Time
+	}{
+		{"deleted before the scale down", 2, map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00"}, time.Date(2024, time.June, 3, 22, 0, 0, 0, time.UTC)},
+		{"deleted before the scale up", 0, map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00", "scheduler.replicas-memory": "2"}, time.Date(2024, time.June, 4, 12, 0, 0, 0, time.UTC)},
+		{"deleted before the restore of a paused deployment", 0, map[string]string{"scheduler.enabled": "false", "scheduler.off-schedule": "20:00-08:00", "scheduler.replicas-memory": "2"}, time.Date(2024, time.June, 4, 12, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			config := NewDefaultControllerConfig()
+			config.ReconcileQPS = math.Inf(1)
+			c, clientset := newTestController(t, config, newTestDeployment("foo", test.replicas, test.annotations))
+			c.SetClock(&fakeClock{now: test.now})
+			// The informer cache still holds the deployment
+			if err := clientset.AppsV1().Deployments("default").Delete(context.Background(), "foo", meta_v1.DeleteOptions{}); err != nil {
+				t.Fatal(err)
+			}
+
+			c.queue.Add("default/foo")
+			c.processNextItem(context.Background())
+			if requeues := c.queue.NumRequeues("default/foo"); requeues != 0 || c.queue.Len() != 0 {
+				t.Errorf("expected the deployment not to be requeued, got %d requeues and %d queued", requeues, c.queue.Len())
+			}
+			if strings.Contains(logs.String(), "level=ERROR") {
+				t.Errorf("expected no errors, got the logs %s", logs)
+			}
+		})
+	}
+}
+
+func TestRunReconcilesOnStart(t *testing.T) {
+	discardLogs(t)
+	noon := time.Date(2024, time.June, 4, 12, 0, 0, 0, time.UTC)
+	night := time.Date(2024, time.June, 3, 22, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		replicas    int32
+		annotations map[string]string
+		now         time.Time
+		expected    int32
+	}{
+		{"stuck down past its window", 0, map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00", "scheduler.replicas-memory": "3"}, noon, 3},
+		{"up in its window", 3, map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00"}, night, 0},
+		{"stuck down and paused", 0, map[string]string{"scheduler.enabled": "false", "scheduler.off-schedule": "20:00-08:00", "scheduler.replicas-memory": "3"}, noon, 3},
+		{"scaled down by someone else", 0, map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00"}, noon, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(newTestDeployment("foo", test.replicas, test.annotations))
+			factory := informers.NewSharedInformerFactory(clientset, 0)
+			c := NewResourceController(clientset,
+				factory.Apps().V1().Deployments().Informer(),
+				factory.Core().V1().ConfigMaps().Informer(),
+				factory.Core().V1().Namespaces().Informer(),
+				NewDefaultControllerConfig())
+			c.SetClock(&fakeClock{now: test.now})
+			stopCh := make(chan struct{})
+			defer close(stopCh)
+			go c.Run(stopCh)
+
+			// Well within the resync interval, so only the reconcile on
+			// start can have scaled the deployment
+			var replicas int32
+			err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, resyncInterval/5, true, func(ctx context.Context) (bool, error) {
+				deployment, err := clientset.AppsV1().Deployments("default").Get(ctx, "foo", meta_v1.GetOptions{})
+				if err != nil {
+					return false, err
+				}
+				replicas = *deployment.Spec.Replicas
+				return replicas == test.expected && c.ReconcileLoops() > 0, nil
+			})
+			if err != nil {
+				t.Errorf("expected %d replicas right after the start, got %d: %s", test.expected, replicas, err)
+			}
+		})
+	}
+}
+
+func TestDeploymentFromObject(t *testing.T) {
+	deployment := newTestDeployment("foo", 2, nil)
+	tests := []struct {
+		name  string
+		obj   interface{}
+		found bool
+	}{
+		{"deployment", deployment, true},
+		{"tombstone", cache.DeletedFinalStateUnknown{Key: "default/foo", Obj: deployment}, true},
+		{"empty tombstone", cache.DeletedFinalStateUnknown{Key: "default/foo"}, false},
+		{"tombstone of another object", cache.DeletedFinalStateUnknown{Key: "default/foo", Obj: &core_v1.ConfigMap{}}, false},
+		{"another object", &core_v1.ConfigMap{}, false},
+		{"nil", nil, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, found := deploymentFromObject(test.obj)
+			if found != test.found {
+				t.Fatalf("expected found %t, got %t", test.found, found)
+			}
+			if found && got != deployment {
+				t.Errorf("expected the wrapped deployment, got %v", got)
+			}
+		})
+	}
+}
+
+func TestOnDeploymentDeleted(t *testing.T) {
+	discardLogs(t)
+	const signalURL, drainURL = "http://signal.example.com", "http://drain.example.com"
+	deployment := newTestDeployment("foo", 2, map[string]string{
+		"scheduler.enabled":         "true",
+		"scheduler.require-signal":  signalURL,
+		"scheduler.drain-check-url": drainURL,
+	})
+	deployment.Namespace = "deleted"
+	tests := []struct {
+		name string
+		obj  interface{}
+		// cleared is whether the cached results of the endpoints of the
+		// deployment are dropped, which needs its last known state
+		cleared bool
+	}{
+		{"deployment", deployment, true},
+		{"tombstone", cache.DeletedFinalStateUnknown{Key: "deleted/foo", Obj: deployment}, true},
+		{"empty tombstone", cache.DeletedFinalStateUnknown{Key: "deleted/foo"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, _ := newTestController(t, NewDefaultControllerConfig())
+			c.setNextTransition("deleted", "foo", 60)
+			if series := scrapeSeries(t, "scheduler_seconds_until_next_transition", "deleted"); series != 1 {
+				t.Fatalf("expected a series of the deployment, got %d", series)
+			}
+			c.queue.AddRateLimited("deleted/foo")
+			c.signals.cache[signalURL] = signalResult{holdUp: true, expires: time.Now().Add(time.Hour)}
+			c.drains.cache[drainURL] = drainResult{active: 1, expires: time.Now().Add(time.Hour)}
+
+			c.onDeploymentDeleted(test.obj)
+			if series := scrapeSeries(t, "scheduler_seconds_until_next_transition", "deleted"); series != 0 {
+				t.Errorf("expected the metrics of the deployment to be deleted, got %d series", series)
+			}
+			if requeues := c.queue.NumRequeues("deleted/foo"); requeues != 0 {
+				t.Errorf("expected the backoff of the deployment to be reset, got %d requeues", requeues)
+			}
+			_, signalCached := c.signals.cache[signalURL]
+			_, drainCached := c.drains.cache[drainURL]
+			if signalCached == test.cleared || drainCached == test.cleared {
+				t.Errorf("expected cleared caches %t, got the signal cached %t and the drain cached %t", test.cleared, signalCached, drainCached)
+			}
+		})
+	}
+}
+
+func TestOnDeploymentDeletedIgnoresUnknownObjects(t *testing.T) {
+	discardLogs(t)
+	c, _ := newTestController(t, NewDefaultControllerConfig())
+	// Neither a deployment nor a tombstone, which has no key
+	c.onDeploymentDeleted("foo")
+	if c.queue.Len() != 0 {
+		t.Errorf("expected an empty queue, got %d keys", c.queue.Len())
+	}
+}
+
+func TestParseIgnoreLabels(t *testing.T) {
+	tests := []struct {
+		text    string
+		ignored []ignoredLabel
+		err     string
+	}{
+		{"", nil, ""},
+		{"app.kubernetes.io/managed-by=Helm", []ignoredLabel{{key: "app.kubernetes.io/managed-by", value: "Helm"}}, ""},
+		{"tier=critical, job", []ignoredLabel{{key: "tier", value: "critical"}, {key: "job", any: true}}, ""},
+		{" tier = critical ,,", []ignoredLabel{{key: "tier", value: "critical"}}, ""},
+		{"tier=", []ignoredLabel{{key: "tier"}}, ""},
+		{"=critical", nil, "invalid label '=critical'"},
+		{"tier=critical,bad key", nil, "invalid label 'bad key'"},
+		{"tier=not valid", nil, "invalid label 'tier=not valid'"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			ignored, err := parseIgnoreLabels(test.text)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("expected the error '%s', got '%v'", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(ignored) != fmt.Sprint(test.ignored) {
+				t.Errorf("expected the labels %v, got %v", test.ignored, ignored)
+			}
+		})
+	}
+}
+
+func TestIgnoredLabelOf(t *testing.T) {
+	ignored, err := parseIgnoreLabels("app.kubernetes.io/managed-by=Helm,job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		labels  map[string]string
+		label   string
+		matched bool
+	}{
+		{"no labels", nil, "", false},
+		{"other labels", map[string]string{"app": "foo"}, "", false},
+		{"matching label", map[string]string{"app": "foo", "app.kubernetes.io/managed-by": "Helm"}, "app.kubernetes.io/managed-by=Helm", true},
+		{"other value", map[string]string{"app.kubernetes.io/managed-by": "Kustomize"}, "", false},
+		{"value in another case", map[string]string{"app.kubernetes.io/managed-by": "helm"}, "", false},
+		{"bare key", map[string]string{"job": "nightly"}, "job=nightly", true},
+		{"bare key with an empty value", map[string]string{"job": ""}, "job=", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			label, matched := ignoredLabelOf(ignored, test.labels)
+			if matched != test.matched || label != test.label {
+				t.Errorf("expected the label '%s' (matched %t), got '%s' (matched %t)", test.label, test.matched, label, matched)
+			}
+		})
+	}
+}
+
+func TestReconcileIgnoresLabels(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name     string
+		labels   map[string]string
+		replicas int32
+	}{
+		{"no labels", nil, 0},
+		{"non-matching label", map[string]string{"app.kubernetes.io/managed-by": "Kustomize"}, 0},
+		{"matching label", map[string]string{"app.kubernetes.io/managed-by": "Helm"}, 2},
+		{"matching bare key", map[string]string{"job": "nightly"}, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			deployment := newTestDeployment("foo", 2, map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00"})
+			deployment.Labels = test.labels
+			c, clientset := newTestController(t, NewDefaultControllerConfig(), deployment)
+			c.SetClock(&fakeClock{now: time.Date(2024, time.June, 3, 22, 0, 0, 0, time.UTC)})
+			ignoredLabels, err := parseIgnoreLabels("app.kubernetes.io/managed-by=Helm,job")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.ignoredLabels = ignoredLabels
+
+			if err := c.reconcile(context.Background(), "default/foo"); err != nil {
+				t.Fatal(err)
+			}
+			current, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *current.Spec.Replicas != test.replicas {
+				t.Errorf("expected %d replicas, got %d", test.replicas, *current.Spec.Replicas)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Weekdays is a set of days of the week. The zero value stands for every
+// day of the week.
+type Weekdays uint8
+
+// Keywords that can be used instead of listing the days explicitly
+const (
+	DAYS_KEYWORD_DAILY    = "daily"
+	DAYS_KEYWORD_WEEKDAYS = "weekdays"
+	DAYS_KEYWORD_WEEKENDS = "weekends"
+)
+
+// dayLetters holds the tokens of the explicit day prefix (e.g. 'MTuWThF').
+// Two letter tokens come first so they are matched before single letters.
+var dayLetters = []struct {
+	token string
+	day   time.Weekday
+}{
+	{"Tu", time.Tuesday},
+	{"Th", time.Thursday},
+	{"Sa", time.Saturday},
+	{"Su", time.Sunday},
+	{"M", time.Monday},
+	{"W", time.Wednesday},
+	{"F", time.Friday},
+}
+
+// NewWeekdays creates a Weekdays set out of the provided days
+func NewWeekdays(days ...time.Weekday) Weekdays {
+	var weekdays Weekdays
+	for _, day := range days {
+		weekdays |= 1 << uint(day)
+	}
+	return weekdays
+}
+
+// Contains checks if the day is part of the set
+func (w Weekdays) Contains(day time.Weekday) bool {
+	return w == 0 || w&(1<<uint(day)) != 0
+}
+
+// String returns the set in the explicit day letters form, or an empty
+// string if the set stands for every day.
+func (w Weekdays) String() string {
+	if w == 0 || w == NewWeekdays(time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday) {
+		return ""
+	}
+	var builder strings.Builder
+	for _, day := range []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday} {
+		if !w.Contains(day) {
+			continue
+		}
+		for _, letter := range dayLetters {
+			if letter.day == day {
+				builder.WriteString(letter.token)
+			}
+		}
+	}
+	return builder.String()
+}
+
+// ParseOffDays parses the days of the off-days annotation. Besides the forms
+// of ParseWeekdays, it accepts a comma separated list of day names, either
+// full or abbreviated (e.g. 'Saturday,Sunday' or 'sat,sun'). Unlike the
+// days of a schedule, the 'daily' keyword stands for every day.
+func ParseOffDays(daysText string) (Weekdays, error) {
+	daysText = strings.TrimSpace(daysText)
+	if !strings.Contains(daysText, ",") {
+		if day, exists := dayNames[strings.ToLower(daysText)]; exists {
+			return NewWeekdays(day), nil
+		}
+		if strings.ToLower(daysText) == DAYS_KEYWORD_DAILY {
+			return NewWeekdays(time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday), nil
+		}
+		return ParseWeekdays(daysText)
+	}
+
+	var weekdays Weekdays
+	for _, name := range strings.Split(daysText, ",") {
+		day, exists := dayNames[strings.ToLower(strings.TrimSpace(name))]
+		if !exists {
+			return 0, fmt.Errorf("invalid day '%s', expected a day name like Saturday or sat", strings.TrimSpace(name))
+		}
+		weekdays |= NewWeekdays(day)
+	}
+	return weekdays, nil
+}
+
+// dayNames maps the full and abbreviated lower case names of the days
+var dayNames = map[string]time.Weekday{
+	"monday": time.Monday, "mon": time.Monday,
+	"tuesday": time.Tuesday, "tue": time.Tuesday,
+	"wednesday": time.Wednesday, "wed": time.Wednesday,
+	"thursday": time.Thursday, "thu": time.Thursday,
+	"friday": time.Friday, "fri": time.Friday,
+	"saturday": time.Saturday, "sat": time.Saturday,
+	"sunday": time.Sunday, "sun": time.Sunday,
+}
+
+// ParseWeekdays parses the day prefix of a schedule. It is either one of the
+// 'daily', 'weekdays', 'weekends' keywords or a list of day letters
+// (M, Tu, W, Th, F, Sa, Su), e.g. 'MTuWThF'. Keywords can not be combined
+// with day letters.
+func ParseWeekdays(daysText string) (Weekdays, error) {
+	switch strings.ToLower(daysText) {
+	case DAYS_KEYWORD_DAILY:
+		return 0, nil
+	case DAYS_KEYWORD_WEEKDAYS:
+		return NewWeekdays(time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday), nil
+	case DAYS_KEYWORD_WEEKENDS:
+		return NewWeekdays(time.Saturday, time.Sunday), nil
+	}
+	for _, keyword := range []string{DAYS_KEYWORD_DAILY, DAYS_KEYWORD_WEEKDAYS, DAYS_KEYWORD_WEEKENDS} {
+		if strings.Contains(strings.ToLower(daysText), keyword) {
+			return 0, fmt.Errorf("invalid days '%s', the '%s' keyword can not be combined with other days", daysText, keyword)
+		}
+	}
+
+	var weekdays Weekdays
+	remaining := daysText
+	for remaining != "" {
+		matched := false
+		for _, letter := range dayLetters {
+			if strings.HasPrefix(remaining, letter.token) {
+				weekdays |= NewWeekdays(letter.day)
+				remaining = remaining[len(letter.token):]
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return 0, fmt.Errorf("invalid days '%s', expected day letters (M, Tu, W, Th, F, Sa, Su) or one of %s, %s, %s", daysText, DAYS_KEYWORD_DAILY, DAYS_KEYWORD_WEEKDAYS, DAYS_KEYWORD_WEEKENDS)
+		}
+	}
+	if weekdays == 0 {
+		return 0, fmt.Errorf("invalid days '%s', no days found", daysText)
+	}
+
+	return weekdays, nil
+}
@@ -0,0 +1,124 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseWeekdays(t *testing.T) {
+	weekdays := NewWeekdays(time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday)
+	weekends := NewWeekdays(time.Saturday, time.Sunday)
+	tests := []struct {
+		days     string
+		expected Weekdays
+		err      string
+	}{
+		{"daily", 0, ""},
+		{"weekdays", weekdays, ""},
+		{"weekends", weekends, ""},
+		{"Weekends", weekends, ""},
+		{"MTuWThF", weekdays, ""},
+		{"SaSu", weekends, ""},
+		{"MSu", NewWeekdays(time.Monday, time.Sunday), ""},
+		{"weekendsM", 0, "the 'weekends' keyword can not be combined with other days"},
+		{"Mdaily", 0, "the 'daily' keyword can not be combined with other days"},
+		{"weekdaysweekends", 0, "the 'weekdays' keyword can not be combined with other days"},
+		{"weekday", 0, "expected day letters (M, Tu, W, Th, F, Sa, Su) or one of daily, weekdays, weekends"},
+		{"MX", 0, "expected day letters"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.days, func(t *testing.T) {
+			days, err := ParseWeekdays(test.days)
+			if test.err == "" && err != nil {
+				t.Fatalf("expected no error, got '%s'", err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Fatalf("expected the error '%s', got '%v'", test.err, err)
+			}
+			if days != test.expected {
+				t.Errorf("expected the days %b, got %b", test.expected, days)
+			}
+		})
+	}
+}
+
+func TestParseOffDays(t *testing.T) {
+	weekends := NewWeekdays(time.Saturday, time.Sunday)
+	everyDay := NewWeekdays(time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday)
+	tests := []struct {
+		days     string
+		expected Weekdays
+		err      string
+	}{
+		{"sat,sun", weekends, ""},
+		{"Saturday,Sunday", weekends, ""},
+		{" sat , SUN ", weekends, ""},
+		{"saturday", NewWeekdays(time.Saturday), ""},
+		{"Fri", NewWeekdays(time.Friday), ""},
+		{"weekends", weekends, ""},
+		{"SaSu", weekends, ""},
+		{"daily", everyDay, ""},
+		{"sat,someday", 0, "invalid day 'someday'"},
+		{"sat,", 0, "invalid day ''"},
+		{"holiday", 0, "expected day letters"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.days, func(t *testing.T) {
+			days, err := ParseOffDays(test.days)
+			if test.err == "" && err != nil {
+				t.Fatalf("expected no error, got '%s'", err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Fatalf("expected the error '%s', got '%v'", test.err, err)
+			}
+			if days != test.expected {
+				t.Errorf("expected the days %b, got %b", test.expected, days)
+			}
+		})
+	}
+}
+
+func TestDayKeywordsAcrossMidnight(t *testing.T) {
+	// 2024-06-07 is a Friday
+	at := func(day, hour int) time.Time {
+		return time.Date(2024, time.June, day, hour, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		schedule string
+		now      time.Time
+		inRange  bool
+	}{
+		{"weekends 22:00-06:00", at(7, 23), false},
+		{"weekends 22:00-06:00", at(8, 3), false},
+		{"weekends 22:00-06:00", at(8, 23), true},
+		{"weekends 22:00-06:00", at(9, 23), true},
+		{"weekends 22:00-06:00", at(10, 3), true},
+		{"weekends 22:00-06:00", at(10, 23), false},
+		{"weekdays 22:00-06:00", at(7, 23), true},
+		{"weekdays 22:00-06:00", at(8, 3), true},
+		{"weekdays 22:00-06:00", at(8, 23), false},
+		{"weekdays 22:00-06:00", at(10, 3), false},
+		{"weekdays 22:00-06:00", at(10, 23), true},
+		{"daily 22:00-06:00", at(8, 23), true},
+		{"daily 22:00-06:00", at(10, 3), true},
+		{"daily 22:00-06:00", at(10, 12), false},
+		{"weekends 00:00-23:59", at(9, 12), true},
+		{"weekends 00:00-23:59", at(10, 12), false},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s at %s", test.schedule, test.now.Format("Mon 15:04")), func(t *testing.T) {
+			timeRange, err := ParseSchedule(test.schedule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inRange := timeRange.InRange(test.now); inRange != test.inRange {
+				t.Errorf("expected in range %t, got %t", test.inRange, inRange)
+			}
+		})
+	}
+}
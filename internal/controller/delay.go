@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dimitris4000/concept02/internal/logging"
+	apps_v1 "k8s.io/api/apps/v1"
+)
+
+// scaleDownPending delays the scale down of deployments with the
+// scale-down-delay annotation, so requests in flight at the start of the
+// off-window can complete. The time the deployment entered the off-window is
+// remembered in the off-since annotation and true is returned until the
+// delay has elapsed since then. Leaving the window removes the annotation,
+// cancelling the pending scale down.
+func (c *Controller) scaleDownPending(ctx context.Context, deployment *apps_v1.Deployment, state DeploymentState) (bool, error) {
+	annotations := deployment.GetAnnotations()
+	offSinceAnnotation := c.config.Annotation(OFF_SINCE_ANNOTATION)
+	offSinceText, pending := annotations[offSinceAnnotation]
+
+	if state == ENABLED {
+		if !pending {
+			return false, nil
+		}
+		if _, scaledDown := scaledDownMemory(c.config, annotations); !scaledDown {
+			logging.FromContext(ctx).Info(fmt.Sprintf("Cancelling pending scale down of deployment '%s.%s'", deployment.Namespace, deployment.Name))
+		}
+		return false, c.patchAnnotation(ctx, deployment, offSinceAnnotation, nil)
+	}
+
+	delayAnnotation := c.config.Annotation(SCALE_DOWN_DELAY_ANNOTATION)
+	delayText, exists := annotations[delayAnnotation]
+	if !exists {
+		return false, nil
+	}
+	delay, err := time.ParseDuration(delayText)
+	if err != nil || delay < 0 {
+		return false, fmt.Errorf("invalid %s annotation '%s', expected a positive duration like '2m'", delayAnnotation, delayText)
+	}
+	// Deployments already scaled down have nothing to wait for
+	if _, scaledDown := scaledDownMemory(c.config, annotations); scaledDown || *deployment.Spec.Replicas == 0 {
+		return false, nil
+	}
+
+	now := c.clock.Now()
+	if !pending {
+		logging.FromContext(ctx).Info(fmt.Sprintf("Delaying scale down of deployment '%s.%s' by %s", deployment.Namespace, deployment.Name, delay))
+		value := now.UTC().Format(time.RFC3339)
+		return delay > 0, c.patchAnnotation(ctx, deployment, offSinceAnnotation, &value)
+	}
+	offSince, err := time.Parse(time.RFC3339, offSinceText)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s annotation '%s', expected an RFC 3339 timestamp", offSinceAnnotation, offSinceText)
+	}
+	return now.Sub(offSince) < delay, nil
+}
+
+// inCreateGrace checks if the deployment is younger than the period of its
+// grace-after-create annotation, judged by its creation timestamp. Such
+// deployments are not scaled yet, so a deployment created within an
+// off-window is not scaled down right after it is deployed.
+func (c *Controller) inCreateGrace(deployment *apps_v1.Deployment) (bool, error) {
+	graceAnnotation := c.config.Annotation(GRACE_AFTER_CREATE_ANNOTATION)
+	graceText, exists := deployment.GetAnnotations()[graceAnnotation]
+	if !exists {
+		return false, nil
+	}
+	grace, err := time.ParseDuration(strings.TrimSpace(graceText))
+	if err != nil || grace < 0 {
+		return false, fmt.Errorf("invalid %s annotation '%s', expected a positive duration like '15m'", graceAnnotation, graceText)
+	}
+	return c.clock.Now().Sub(deployment.CreationTimestamp.Time) < grace, nil
+}
+
+// patchAnnotation sets, or removes if value is nil, a single annotation of
+// the deployment
+func (c *Controller) patchAnnotation(ctx context.Context, deployment *apps_v1.Deployment, annotation string, value *string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.config.APITimeout)
+	defer cancel()
+	return PatchDeploymentAnnotations(ctx, c.clientset, deployment.Namespace, deployment.Name, map[string]*string{annotation: value})
+}
@@ -0,0 +1,166 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestReconcileScaleDownDelay(t *testing.T) {
+	discardLogs(t)
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, time.June, 3, hour, minute, 0, 0, time.UTC)
+	}
+	type step struct {
+		now      time.Time
+		replicas int32
+		offSince string
+	}
+	tests := []struct {
+		name     string
+		schedule string
+		delay    string
+		steps    []step
+	}{
+		{"scaled down after the delay", "20:00-08:00", "2m", []step{
+			{at(19, 59), 2, ""},
+			{at(20, 0), 2, "2024-06-03T20:00:00Z"},
+			{at(20, 1), 2, "2024-06-03T20:00:00Z"},
+			{at(20, 2), 0, "2024-06-03T20:00:00Z"},
+			{at(20, 3), 0, "2024-06-03T20:00:00Z"},
+		}},
+		{"window left within the delay", "20:00-20:01", "2m", []step{
+			{at(20, 0), 2, "2024-06-03T20:00:00Z"},
+			{at(20, 1), 2, ""},
+			{at(20, 3), 2, ""},
+		}},
+		{"window entered again", "20:00-20:01", "90s", []step{
+			{at(20, 0), 2, "2024-06-03T20:00:00Z"},
+			{at(20, 1), 2, ""},
+			{at(20, 0).AddDate(0, 0, 1), 2, "2024-06-04T20:00:00Z"},
+		}},
+		{"zero delay", "20:00-08:00", "0s", []step{
+			{at(20, 0), 0, "2024-06-03T20:00:00Z"},
+		}},
+		{"restored after the window", "20:00-21:00", "1m", []step{
+			{at(20, 0), 2, "2024-06-03T20:00:00Z"},
+			{at(20, 1), 0, "2024-06-03T20:00:00Z"},
+			{at(21, 0), 2, ""},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			annotations := map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": test.schedule, "scheduler.scale-down-delay": test.delay}
+			c, clientset := newTestController(t, NewDefaultControllerConfig(), newTestDeployment("foo", 2, annotations))
+			clock := &fakeClock{}
+			c.SetClock(clock)
+
+			for _, step := range test.steps {
+				clock.Set(step.now)
+				if err := c.reconcile(context.Background(), "default/foo"); err != nil {
+					t.Fatal(err)
+				}
+				deployment, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if *deployment.Spec.Replicas != step.replicas {
+					t.Errorf("expected %d replicas at %s, got %d", step.replicas, step.now.Format(time.DateTime), *deployment.Spec.Replicas)
+				}
+				if offSince := deployment.Annotations["scheduler.off-since"]; offSince != step.offSince {
+					t.Errorf("expected off since '%s' at %s, got '%s'", step.offSince, step.now.Format(time.DateTime), offSince)
+				}
+				if err := c.deploymentInformer.GetIndexer().Update(deployment); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileInvalidScaleDownDelay(t *testing.T) {
+	for _, delay := range []string{"soon", "-1m"} {
+		t.Run(delay, func(t *testing.T) {
+			logs := captureLogs(t)
+			annotations := map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00", "scheduler.scale-down-delay": delay}
+			c, clientset := newTestController(t, NewDefaultControllerConfig(), newTestDeployment("foo", 2, annotations))
+			c.SetClock(&fakeClock{now: time.Date(2024, time.June, 3, 20, 0, 0, 0, time.UTC)})
+
+			// The error is logged, retrying would not help
+			if err := c.reconcile(context.Background(), "default/foo"); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(logs.String(), "invalid scheduler.scale-down-delay annotation") {
+				t.Errorf("expected the error to be logged, got the logs %s", logs)
+			}
+			if writes := deploymentWrites(clientset); writes != 0 {
+				t.Errorf("expected the deployment to be left as is, got %d writes", writes)
+			}
+		})
+	}
+}
+
+func TestReconcileGraceAfterCreate(t *testing.T) {
+	discardLogs(t)
+	night := time.Date(2024, time.June, 3, 22, 0, 0, 0, time.UTC)
+	noon := time.Date(2024, time.June, 3, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		grace       string
+		age         time.Duration
+		now         time.Time
+		annotations map[string]string
+		replicas    int32
+		expected    int32
+		reason      string
+	}{
+		{"freshly created in an off-window", "15m", 5 * time.Minute, night, nil, 2, 2, SKIP_REASON_CREATE_GRACE},
+		{"old in an off-window", "15m", time.Hour, night, nil, 2, 0, ""},
+		{"grace just over", "15m", 15 * time.Minute, night, nil, 2, 0, ""},
+		{"freshly created while scaled down", "15m", 5 * time.Minute, noon, map[string]string{"scheduler.replicas-memory": "3"}, 0, 0, SKIP_REASON_CREATE_GRACE},
+		{"old while scaled down", "15m", time.Hour, noon, map[string]string{"scheduler.replicas-memory": "3"}, 0, 3, ""},
+		{"without grace", "", 5 * time.Minute, night, nil, 2, 0, ""},
+		{"zero grace", "0s", 0, night, nil, 2, 0, ""},
+		{"invalid grace", "soon", 5 * time.Minute, night, nil, 2, 2, SKIP_REASON_PARSE_ERROR},
+		{"negative grace", "-15m", 5 * time.Minute, night, nil, 2, 2, SKIP_REASON_PARSE_ERROR},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			annotations := map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00"}
+			for key, value := range test.annotations {
+				annotations[key] = value
+			}
+			if test.grace != "" {
+				annotations["scheduler.grace-after-create"] = test.grace
+			}
+			deployment := newTestDeployment("foo", test.replicas, annotations)
+			deployment.CreationTimestamp = meta_v1.NewTime(test.now.Add(-test.age))
+			c, clientset := newTestController(t, NewDefaultControllerConfig(), deployment)
+			c.SetClock(&fakeClock{now: test.now})
+			series := `scheduler_deployments_skipped_total{reason="` + test.reason + `"}`
+			before := scrapeMetric(t, series)
+
+			if err := c.reconcile(context.Background(), "default/foo"); err != nil {
+				t.Fatal(err)
+			}
+			current, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *current.Spec.Replicas != test.expected {
+				t.Errorf("expected %d replicas, got %d", test.expected, *current.Spec.Replicas)
+			}
+			if test.reason == "" {
+				return
+			}
+			if delta := scrapeMetric(t, series) - before; delta != 1 {
+				t.Errorf("expected one skip for %s, got %v", test.reason, delta)
+			}
+		})
+	}
+}
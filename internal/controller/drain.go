@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dimitris4000/concept02/internal/logging"
+	apps_v1 "k8s.io/api/apps/v1"
+)
+
+// drainCacheTTL is how long the result of a drain check is reused before
+// the drain endpoint is queried again. It is shorter than signalCacheTTL
+// since connections come and go quickly.
+const drainCacheTTL = 10 * time.Second
+
+// maxDrainResponseSize bounds the response body read from a drain endpoint
+const maxDrainResponseSize = 1024
+
+// drainResult is a cached response of a drain endpoint
+type drainResult struct {
+	active  int64
+	expires time.Time
+}
+
+// drainChecker queries the drain endpoints of the deployments using the
+// drain-check-url annotation. The results are cached for drainCacheTTL so
+// the endpoints are not queried on every reconcile.
+type drainChecker struct {
+	client *http.Client
+	mutex  sync.Mutex
+	cache  map[string]drainResult
+}
+
+// newDrainChecker creates a drainChecker using the provided HTTP client
+func newDrainChecker(client *http.Client) *drainChecker {
+	return &drainChecker{
+		client: client,
+		cache:  map[string]drainResult{},
+	}
+}
+
+// ActiveConnections returns the number of active connections reported by
+// the drain endpoint at url, which responds with the plain number. A failed
+// request, or a response that is not a number, is logged and reported as
+// zero connections, since an unreachable endpoint most likely has no pods
+// left to drain.
+func (d *drainChecker) ActiveConnections(ctx context.Context, url string) int64 {
+	d.mutex.Lock()
+	cached, exists := d.cache[url]
+	d.mutex.Unlock()
+	if exists && time.Now().Before(cached.expires) {
+		return cached.active
+	}
+
+	active, err := d.query(ctx, url)
+	if err != nil {
+		logging.FromContext(ctx).Warn(fmt.Sprintf("Failed to check drain endpoint %s: %s", url, err))
+	}
+
+	d.mutex.Lock()
+	d.cache[url] = drainResult{active: active, expires: time.Now().Add(drainCacheTTL)}
+	d.mutex.Unlock()
+	return active
+}
+
+// Forget drops the cached result of the drain endpoint at url
+func (d *drainChecker) Forget(url string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.cache, url)
+}
+
+// query sends a single request to the drain endpoint at url
+func (d *drainChecker) query(ctx context.Context, url string) (int64, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	response, err := d.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxDrainResponseSize))
+	if err != nil {
+		return 0, err
+	}
+	active, err := strconv.ParseInt(strings.TrimSpace(string(body)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid response '%s', expected the number of active connections", strings.TrimSpace(string(body)))
+	}
+	return active, nil
+}
+
+// drainPending defers the scale down of deployments with the
+// drain-check-url annotation while their drain endpoint reports active
+// connections, so requests in flight are not cut off. Deployments already
+// scaled down are not checked.
+func (c *Controller) drainPending(ctx context.Context, deployment *apps_v1.Deployment) bool {
+	url := strings.TrimSpace(deployment.GetAnnotations()[c.config.Annotation(DRAIN_CHECK_URL_ANNOTATION)])
+	if url == "" {
+		return false
+	}
+	if _, scaledDown := scaledDownMemory(c.config, deployment.GetAnnotations()); scaledDown || *deployment.Spec.Replicas == 0 {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.config.APITimeout)
+	defer cancel()
+	active := c.drains.ActiveConnections(ctx, url)
+	if active > 0 {
+		logging.FromContext(ctx).Info(fmt.Sprintf("Deferring scale down of deployment '%s.%s', %d connections are still active", deployment.Namespace, deployment.Name, active))
+	}
+	return active > 0
+}
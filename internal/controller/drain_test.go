@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// drainEndpoint is a drain endpoint responding with its status and body,
+// which counts the requests it receives
+type drainEndpoint struct {
+	mutex    sync.Mutex
+	status   int
+	body     string
+	requests atomic.Int32
+}
+
+func (d *drainEndpoint) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	d.requests.Add(1)
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	writer.WriteHeader(d.status)
+	writer.Write([]byte(d.body))
+}
+
+// set changes the response of the endpoint
+func (d *drainEndpoint) set(status int, body string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.status, d.body = status, body
+}
+
+func TestDrainCheckerActiveConnections(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		active int64
+		warned bool
+	}{
+		{"idle", http.StatusOK, "0", 0, false},
+		{"busy", http.StatusOK, "3", 3, false},
+		{"surrounding whitespace", http.StatusOK, " 12\n", 12, false},
+		{"failed request", http.StatusInternalServerError, "3", 0, true},
+		{"not a number", http.StatusOK, "busy", 0, true},
+		{"oversized response", http.StatusOK, strings.Repeat("1", maxDrainResponseSize+1), 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			endpoint := &drainEndpoint{}
+			endpoint.set(test.status, test.body)
+			server := httptest.NewServer(endpoint)
+			defer server.Close()
+			d := newDrainChecker(server.Client())
+
+			if active := d.ActiveConnections(context.Background(), server.URL); active != test.active {
+				t.Errorf("expected %d active connections, got %d", test.active, active)
+			}
+			if warned := strings.Contains(logs.String(), "Failed to check drain endpoint"); warned != test.warned {
+				t.Errorf("expected warned %t, got the logs %s", test.warned, logs)
+			}
+			// The result is cached, even if the endpoint changes
+			endpoint.set(http.StatusOK, "42")
+			if active := d.ActiveConnections(context.Background(), server.URL); active != test.active {
+				t.Errorf("expected the cached %d active connections, got %d", test.active, active)
+			}
+			if requests := endpoint.requests.Load(); requests != 1 {
+				t.Errorf("expected a single request, got %d", requests)
+			}
+			// Forgetting the endpoint queries it again
+			d.Forget(server.URL)
+			if active := d.ActiveConnections(context.Background(), server.URL); active != 42 {
+				t.Errorf("expected 42 active connections once forgotten, got %d", active)
+			}
+		})
+	}
+}
+
+func TestDrainCheckerUnreachable(t *testing.T) {
+	discardLogs(t)
+	server := httptest.NewServer(&drainEndpoint{})
+	url := server.URL
+	server.Close()
+
+	d := newDrainChecker(&http.Client{Timeout: time.Second})
+	if active := d.ActiveConnections(context.Background(), url); active != 0 {
+		t.Errorf("expected no active connections of an unreachable endpoint, got %d", active)
+	}
+}
+
+func TestReconcileWaitsForDrain(t *testing.T) {
+	discardLogs(t)
+	endpoint := &drainEndpoint{}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+	annotations := map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00", "scheduler.drain-check-url": server.URL}
+	c, clientset := newTestController(t, NewDefaultControllerConfig(), newTestDeployment("foo", 2, annotations))
+	c.SetClock(&fakeClock{now: time.Date(2024, time.June, 3, 22, 0, 0, 0, time.UTC)})
+
+	// The cache of the drain checker expires between the steps
+	steps := []struct {
+		name     string
+		status   int
+		body     string
+		replicas int32
+		requests int32
+	}{
+		{"busy", http.StatusOK, "5", 2, 1},
+		{"still busy", http.StatusOK, "1", 2, 2},
+		{"idle", http.StatusOK, "0", 0, 3},
+		{"scaled down", http.StatusOK, "7", 0, 3},
+	}
+	for _, step := range steps {
+		endpoint.set(step.status, step.body)
+		c.drains.Forget(server.URL)
+		if err := c.reconcile(context.Background(), "default/foo"); err != nil {
+			t.Fatalf("%s: %s", step.name, err)
+		}
+		current, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+		if err != nil {
+			t.Fatalf("%s: %s", step.name, err)
+		}
+		if *current.Spec.Replicas != step.replicas {
+			t.Errorf("%s: expected %d replicas, got %d", step.name, step.replicas, *current.Spec.Replicas)
+		}
+		if requests := endpoint.requests.Load(); requests != step.requests {
+			t.Errorf("%s: expected %d drain checks, got %d", step.name, step.requests, requests)
+		}
+		if err := c.deploymentInformer.GetIndexer().Update(current); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
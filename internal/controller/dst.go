@@ -0,0 +1,54 @@
+package controller
+
+import "time"
+
+// zonedClock returns the instant at which the wall clock of loc shows the
+// provided clock offset (the time passed since midnight) on the provided
+// date. Daylight saving time transitions make some wall clock times
+// ambiguous or nonexistent, which is resolved consistently:
+//
+//   - a time repeated by a fall-back transition maps to its first
+//     occurrence, so a window within the repeated hour only applies once
+//   - a time skipped by a spring-forward transition maps to the instant the
+//     gap ends, so a window ending in the gap ends with it and a window
+//     entirely in the gap does not apply on that day
+func zonedClock(year int, month time.Month, day int, clock time.Duration, loc *time.Location) time.Time {
+	wall := time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Add(clock)
+	// Transitions are months apart, so the offsets a day before and after
+	// the date are the ones on either side of any transition on the date
+	_, before := wall.Add(-24 * time.Hour).In(loc).Zone()
+	_, after := wall.Add(24 * time.Hour).In(loc).Zone()
+
+	var first time.Time
+	for _, offset := range []int{before, after} {
+		t := wall.Add(-time.Duration(offset) * time.Second).In(loc)
+		if !wallClock(t).Equal(wall) {
+			continue
+		}
+		if first.IsZero() || t.Before(first) {
+			first = t
+		}
+	}
+	if !first.IsZero() {
+		return first
+	}
+
+	// The time falls in a spring-forward gap, which lies between the
+	// instants of the time with the offsets after and before the transition
+	low := wall.Add(-time.Duration(after) * time.Second)
+	high := wall.Add(-time.Duration(before) * time.Second)
+	for high.Sub(low) > time.Second {
+		middle := low.Add(high.Sub(low) / 2)
+		if _, offset := middle.In(loc).Zone(); offset == before {
+			low = middle
+		} else {
+			high = middle
+		}
+	}
+	return high.In(loc)
+}
+
+// wallClock returns the date and time t's wall clock shows, as a UTC time
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+}
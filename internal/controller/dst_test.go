@@ -0,0 +1,124 @@
+package controller
+
+import (
+	"testing"
+	"time"
+)
+
+// In Europe/Athens the clocks jump from 03:00 to 04:00 on 2024-03-31 (at
+// 01:00 UTC) and fall back from 04:00 to 03:00 on 2024-10-27 (at 01:00 UTC)
+func loadAthens(t *testing.T) *time.Location {
+	t.Helper()
+	athens, err := LoadLocation("Europe/Athens")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return athens
+}
+
+func utc(month time.Month, day, hour, minute int) time.Time {
+	return time.Date(2024, month, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestZonedClock(t *testing.T) {
+	athens := loadAthens(t)
+	tests := []struct {
+		name    string
+		month   time.Month
+		day     int
+		clock   time.Duration
+		instant time.Time
+	}{
+		{"summer time", time.June, 3, 20 * time.Hour, utc(time.June, 3, 17, 0)},
+		{"winter time", time.January, 15, 20 * time.Hour, utc(time.January, 15, 18, 0)},
+		{"before the spring-forward gap", time.March, 31, 2*time.Hour + 30*time.Minute, utc(time.March, 31, 0, 30)},
+		{"start of the spring-forward gap", time.March, 31, 3 * time.Hour, utc(time.March, 31, 1, 0)},
+		{"inside the spring-forward gap", time.March, 31, 3*time.Hour + 30*time.Minute, utc(time.March, 31, 1, 0)},
+		{"end of the spring-forward gap", time.March, 31, 4 * time.Hour, utc(time.March, 31, 1, 0)},
+		{"after the spring-forward gap", time.March, 31, 4*time.Hour + 30*time.Minute, utc(time.March, 31, 1, 30)},
+		{"before the fall-back repetition", time.October, 27, 2*time.Hour + 30*time.Minute, utc(time.October, 26, 23, 30)},
+		{"start of the fall-back repetition", time.October, 27, 3 * time.Hour, utc(time.October, 27, 0, 0)},
+		{"inside the fall-back repetition", time.October, 27, 3*time.Hour + 30*time.Minute, utc(time.October, 27, 0, 30)},
+		{"after the fall-back repetition", time.October, 27, 4 * time.Hour, utc(time.October, 27, 2, 0)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instant := zonedClock(2024, test.month, test.day, test.clock, athens)
+			if !instant.Equal(test.instant) {
+				t.Errorf("expected %s, got %s", test.instant, instant.UTC())
+			}
+			if instant.Location() != athens {
+				t.Errorf("expected the instant in %s, got %s", athens, instant.Location())
+			}
+		})
+	}
+}
+
+func TestTimeRangeInRangeAcrossDST(t *testing.T) {
+	athens := loadAthens(t)
+	tests := []struct {
+		name     string
+		schedule string
+		now      time.Time
+		inRange  bool
+	}{
+		{"before a window ending in the gap", "02:30-03:30", utc(time.March, 31, 0, 29), false},
+		{"inside a window ending in the gap", "02:30-03:30", utc(time.March, 31, 0, 45), true},
+		{"window ending in the gap ends with it", "02:30-03:30", utc(time.March, 31, 1, 0), false},
+		{"window starting in the gap starts with its end", "03:30-05:00", utc(time.March, 31, 1, 0), true},
+		{"window in the gap before it", "03:10-03:40", utc(time.March, 31, 0, 59), false},
+		{"window in the gap after it", "03:10-03:40", utc(time.March, 31, 1, 0), false},
+		{"overnight window before spring-forward", "20:00-08:00", utc(time.March, 31, 4, 59), true},
+		{"overnight window end after spring-forward", "20:00-08:00", utc(time.March, 31, 5, 0), false},
+		{"first occurrence of the repeated hour", "03:00-03:30", utc(time.October, 27, 0, 15), true},
+		{"second occurrence of the repeated hour", "03:00-03:30", utc(time.October, 27, 1, 15), false},
+		{"overnight window before fall-back", "20:00-08:00", utc(time.October, 27, 5, 59), true},
+		{"overnight window end after fall-back", "20:00-08:00", utc(time.October, 27, 6, 0), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			timeRange, err := ParseSchedule(test.schedule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			now := test.now.In(athens)
+			if inRange := timeRange.InRange(now); inRange != test.inRange {
+				t.Errorf("expected in range %t at %s, got %t", test.inRange, now, inRange)
+			}
+		})
+	}
+}
+
+func TestScheduleNextTransitionAcrossDST(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule string
+		now      time.Time
+		next     time.Time
+		state    DeploymentState
+	}{
+		{"window ending in the gap starts", `{"windows":["02:30-03:30"],"timezone":"Europe/Athens"}`, utc(time.March, 31, 0, 0), utc(time.March, 31, 0, 30), DISABLED},
+		{"window ending in the gap ends with it", `{"windows":["02:30-03:30"],"timezone":"Europe/Athens"}`, utc(time.March, 31, 0, 30), utc(time.March, 31, 1, 0), ENABLED},
+		{"window in the gap is skipped", `{"windows":["03:10-03:40"],"timezone":"Europe/Athens"}`, utc(time.March, 30, 23, 0), utc(time.April, 1, 0, 10), DISABLED},
+		{"repeated hour ends once", `{"windows":["03:00-03:30"],"timezone":"Europe/Athens"}`, utc(time.October, 27, 0, 15), utc(time.October, 27, 0, 30), ENABLED},
+		{"repeated hour is not entered again", `{"windows":["03:00-03:30"],"timezone":"Europe/Athens"}`, utc(time.October, 27, 0, 30), utc(time.October, 28, 1, 0), DISABLED},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schedule, err := ParseScheduleSpec(test.schedule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			next, state := schedule.NextTransition(test.now)
+			if !next.Equal(test.next) {
+				t.Errorf("expected the next transition at %s, got %s", test.next, next.UTC())
+			}
+			if state != test.state {
+				t.Errorf("expected state %s, got %s", test.state, state)
+			}
+		})
+	}
+}
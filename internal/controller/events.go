@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"sync"
+	"time"
+
+	apps_v1 "k8s.io/api/apps/v1"
+)
+
+// eventBufferSize bounds the events waiting to be read by a subscriber.
+// Subscribers falling further behind are dropped, so a slow client never
+// blocks the informer.
+const eventBufferSize = 32
+
+// DeploymentEvent describes a change of the replicas of a scheduled
+// deployment, as observed by the deployment informer.
+type DeploymentEvent struct {
+	Namespace        string
+	Name             string
+	State            string
+	Replicas         int32
+	PreviousReplicas int32
+	Time             time.Time
+}
+
+// eventBroadcaster fans out the deployment events to its subscribers
+type eventBroadcaster struct {
+	mutex       sync.Mutex
+	subscribers map[chan DeploymentEvent]struct{}
+}
+
+func newEventBroadcaster() *eventBroadcaster {
+	return &eventBroadcaster{subscribers: map[chan DeploymentEvent]struct{}{}}
+}
+
+// subscribe registers a new subscriber. The returned function unsubscribes
+// it and must always be called.
+func (b *eventBroadcaster) subscribe() (<-chan DeploymentEvent, func()) {
+	events := make(chan DeploymentEvent, eventBufferSize)
+	b.mutex.Lock()
+	b.subscribers[events] = struct{}{}
+	b.mutex.Unlock()
+
+	return events, func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		if _, exists := b.subscribers[events]; exists {
+			delete(b.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// publish sends the event to all the subscribers without blocking.
+// Subscribers with a full buffer have their channel closed and removed.
+func (b *eventBroadcaster) publish(event DeploymentEvent) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for events := range b.subscribers {
+		select {
+		case events <- event:
+		default:
+			delete(b.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// Subscribe streams the replica changes of the scheduled deployments. The
+// channel is closed if the subscriber falls too far behind. The returned
+// function unsubscribes and must be called once the events are not needed.
+func (c *Controller) Subscribe() (<-chan DeploymentEvent, func()) {
+	return c.events.subscribe()
+}
+
+// publishReplicaChange publishes an event if the replicas of a scheduled
+// deployment changed between the two versions of the informer
+func (c *Controller) publishReplicaChange(oldObj, newObj interface{}) {
+	oldDeployment, ok := oldObj.(*apps_v1.Deployment)
+	if !ok {
+		return
+	}
+	newDeployment, ok := newObj.(*apps_v1.Deployment)
+	if !ok || oldDeployment.Spec.Replicas == nil || newDeployment.Spec.Replicas == nil {
+		return
+	}
+	if _, scheduled := newDeployment.GetAnnotations()[c.config.Annotation(ENABLED_ANNOTATION)]; !scheduled {
+		return
+	}
+	previous, replicas := *oldDeployment.Spec.Replicas, *newDeployment.Spec.Replicas
+	if previous == replicas {
+		return
+	}
+
+	state := ENABLED
+	if replicas < previous {
+		state = DISABLED
+	}
+	c.events.publish(DeploymentEvent{
+		Namespace:        newDeployment.Namespace,
+		Name:             newDeployment.Name,
+		State:            state.String(),
+		Replicas:         replicas,
+		PreviousReplicas: previous,
+		Time:             c.clock.Now(),
+	})
+}
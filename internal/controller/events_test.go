@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPublishReplicaChange(t *testing.T) {
+	now := time.Date(2024, time.June, 3, 20, 0, 0, 0, time.UTC)
+	scheduled := map[string]string{"scheduler.enabled": "true"}
+	tests := []struct {
+		name     string
+		old      interface{}
+		new      interface{}
+		expected *DeploymentEvent
+	}{
+		{"scaled down", newTestDeployment("foo", 3, scheduled), newTestDeployment("foo", 0, scheduled), &DeploymentEvent{Namespace: "default", Name: "foo", State: "down", Replicas: 0, PreviousReplicas: 3, Time: now}},
+		{"scaled up", newTestDeployment("foo", 0, scheduled), newTestDeployment("foo", 3, scheduled), &DeploymentEvent{Namespace: "default", Name: "foo", State: "up", Replicas: 3, PreviousReplicas: 0, Time: now}},
+		{"partially scaled down", newTestDeployment("foo", 3, scheduled), newTestDeployment("foo", 1, scheduled), &DeploymentEvent{Namespace: "default", Name: "foo", State: "down", Replicas: 1, PreviousReplicas: 3, Time: now}},
+		{"unchanged replicas", newTestDeployment("foo", 3, scheduled), newTestDeployment("foo", 3, scheduled), nil},
+		{"not scheduled", newTestDeployment("foo", 3, nil), newTestDeployment("foo", 0, nil), nil},
+		{"not a deployment", "foo", newTestDeployment("foo", 0, scheduled), nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, _ := newTestController(t, NewDefaultControllerConfig())
+			c.SetClock(&fakeClock{now: now})
+			events, unsubscribe := c.Subscribe()
+			defer unsubscribe()
+
+			c.publishReplicaChange(test.old, test.new)
+			select {
+			case event := <-events:
+				if test.expected == nil || event != *test.expected {
+					t.Errorf("expected the event %+v, got %+v", test.expected, event)
+				}
+			default:
+				if test.expected != nil {
+					t.Errorf("expected the event %+v, got none", *test.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestEventBroadcasterDropsSlowSubscribers(t *testing.T) {
+	b := newEventBroadcaster()
+	slow, unsubscribeSlow := b.subscribe()
+	fast, unsubscribeFast := b.subscribe()
+	defer unsubscribeFast()
+
+	for i := 0; i <= eventBufferSize; i++ {
+		b.publish(DeploymentEvent{Name: "foo", Replicas: int32(i)})
+		<-fast
+	}
+	// The slow subscriber gets its buffered events before its channel is
+	// closed
+	received := 0
+	for range slow {
+		received++
+	}
+	if received != eventBufferSize {
+		t.Errorf("expected %d buffered events, got %d", eventBufferSize, received)
+	}
+	// Unsubscribing a dropped subscriber is safe
+	unsubscribeSlow()
+
+	b.publish(DeploymentEvent{Name: "foo"})
+	select {
+	case _, open := <-fast:
+		if !open {
+			t.Errorf("expected the subscriber keeping up to stay subscribed")
+		}
+	default:
+		t.Errorf("expected the subscriber keeping up to receive the event")
+	}
+}
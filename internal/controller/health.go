@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// LastReconcileTime returns the time the controller's loop last ran, as
+// told by its clock. It is the zero time if the loop has not run yet.
+func (c *Controller) LastReconcileTime() time.Time {
+	nanos := c.lastReconcileTime.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// ReconcileLoops returns the number of times the controller's loop has run.
+// It only increases, so dashboards can alert when it stops doing so.
+func (c *Controller) ReconcileLoops() int64 {
+	return c.reconcileLoops.Load()
+}
+
+// CheckHealth confirms the controller can reach the k8s API and that its loop
+// ran within the last maxStaleIntervals resync intervals.
+func (c *Controller) CheckHealth(ctx context.Context, maxStaleIntervals int) error {
+	lastReconcile := c.LastReconcileTime()
+	if lastReconcile.IsZero() {
+		return fmt.Errorf("controller has not reconciled yet")
+	}
+	if age := c.clock.Now().Sub(lastReconcile); age > time.Duration(maxStaleIntervals)*resyncInterval {
+		return fmt.Errorf("last reconcile was %s ago", age.Round(time.Second))
+	}
+
+	// ServerVersion does not accept a context, so the timeout is enforced
+	// around it.
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := c.clientset.Discovery().ServerVersion()
+		errCh <- err
+	}()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			return fmt.Errorf("k8s API is unreachable: %s", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("k8s API is unreachable: %s", ctx.Err())
+	}
+}
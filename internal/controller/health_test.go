@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	k8s_testing "k8s.io/client-go/testing"
+)
+
+func TestCheckHealth(t *testing.T) {
+	now := time.Date(2024, time.June, 3, 12, 0, 0, 0, time.UTC)
+	unreachable := func(action k8s_testing.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("connection refused")
+	}
+	tests := []struct {
+		name          string
+		lastReconcile time.Time
+		reactor       k8s_testing.ReactionFunc
+		hang          bool
+		err           string
+	}{
+		{"healthy", now.Add(-resyncInterval), nil, false, ""},
+		{"oldest healthy reconcile", now.Add(-3 * resyncInterval), nil, false, ""},
+		{"not reconciled yet", time.Time{}, nil, false, "controller has not reconciled yet"},
+		{"stale reconcile", now.Add(-3*resyncInterval - time.Second), nil, false, "last reconcile was 16s ago"},
+		{"stale and unreachable", now.Add(-time.Hour), unreachable, false, "last reconcile was 1h0m0s ago"},
+		{"unreachable", now, unreachable, false, "k8s API is unreachable: connection refused"},
+		{"unresponsive", now, nil, true, "k8s API is unreachable: context deadline exceeded"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, clientset := newTestController(t, NewDefaultControllerConfig())
+			c.SetClock(&fakeClock{now: now})
+			if !test.lastReconcile.IsZero() {
+				c.lastReconcileTime.Store(test.lastReconcile.UnixNano())
+			}
+			if test.reactor != nil {
+				clientset.PrependReactor("get", "version", test.reactor)
+			}
+			if test.hang {
+				release := make(chan struct{})
+				defer close(release)
+				clientset.PrependReactor("get", "version", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+					<-release
+					return false, nil, nil
+				})
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			err := c.CheckHealth(ctx, 3)
+			if test.err == "" && err != nil {
+				t.Errorf("expected no error, got '%s'", err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("expected the error '%s', got '%v'", test.err, err)
+			}
+		})
+	}
+}
+
+func TestLoopIterationCountsLoops(t *testing.T) {
+	discardLogs(t)
+	c, _ := newTestController(t, NewDefaultControllerConfig(), newTestDeployment("foo", 2, nil))
+	clock := &fakeClock{}
+	c.SetClock(clock)
+	start := time.Date(2024, time.June, 3, 12, 0, 0, 0, time.UTC)
+	metric := scrapeMetric(t, "scheduler_reconcile_total")
+
+	steps := []struct {
+		name  string
+		now   time.Time
+		loops int64
+	}{
+		{"first loop", start, 1},
+		{"next interval", start.Add(resyncInterval), 2},
+		{"same time", start.Add(resyncInterval), 3},
+		{"much later", start.Add(time.Hour), 4},
+	}
+	if loops := c.ReconcileLoops(); loops != 0 || !c.LastReconcileTime().IsZero() {
+		t.Fatalf("expected no loops before the first one, got %d at %s", loops, c.LastReconcileTime())
+	}
+	for _, step := range steps {
+		clock.Set(step.now)
+		c.loopIteration(context.Background())
+		if loops := c.ReconcileLoops(); loops != step.loops {
+			t.Errorf("%s: expected %d loops, got %d", step.name, step.loops, loops)
+		}
+		if last := c.LastReconcileTime(); !last.Equal(step.now) {
+			t.Errorf("%s: expected the last loop at %s, got %s", step.name, step.now, last)
+		}
+		if delta := scrapeMetric(t, "scheduler_reconcile_total") - metric; delta != float64(step.loops) {
+			t.Errorf("%s: expected the metric to count %d loops, got %g", step.name, step.loops, delta)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/dimitris4000/concept02/internal/logging"
+	apps_v1 "k8s.io/api/apps/v1"
+	autoscaling_v2 "k8s.io/api/autoscaling/v2"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Values of the target annotation. The hpa target scales a deployment by
+// changing the minReplicas of its HorizontalPodAutoscaler instead of its
+// replicas, which are left to the autoscaler.
+const (
+	TARGET_REPLICAS = "replicas"
+	TARGET_HPA      = "hpa"
+)
+
+// isHPATarget checks if the deployment is scaled through the minReplicas of
+// its HorizontalPodAutoscaler.
+func isHPATarget(config ControllerConfig, deployment *apps_v1.Deployment) (bool, error) {
+	targetAnnotation := config.Annotation(TARGET_ANNOTATION)
+	switch target := deployment.GetAnnotations()[targetAnnotation]; strings.ToLower(strings.TrimSpace(target)) {
+	case "", TARGET_REPLICAS:
+		return false, nil
+	case TARGET_HPA:
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid %s annotation '%s', expected one of %s, %s", targetAnnotation, target, TARGET_REPLICAS, TARGET_HPA)
+	}
+}
+
+// findHPA returns the HorizontalPodAutoscaler whose scaleTargetRef is the
+// deployment.
+func findHPA(ctx context.Context, clientset kubernetes.Interface, deployment *apps_v1.Deployment) (*autoscaling_v2.HorizontalPodAutoscaler, error) {
+	hpas, err := clientset.AutoscalingV2().HorizontalPodAutoscalers(deployment.Namespace).List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range hpas.Items {
+		ref := hpas.Items[i].Spec.ScaleTargetRef
+		gv, err := schema.ParseGroupVersion(ref.APIVersion)
+		if err != nil || gv.Group != apps_v1.GroupName {
+			continue
+		}
+		if ref.Kind == "Deployment" && ref.Name == deployment.Name {
+			return &hpas.Items[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no HorizontalPodAutoscaler targets deployment '%s.%s'", deployment.Namespace, deployment.Name)
+}
+
+// toggleHPA "disables" or "enables" a deployment with the hpa target by
+// changing the minReplicas of its HorizontalPodAutoscaler. The original
+// minReplicas are remembered in the store of the deployment's replicas,
+// before the autoscaler is changed so they are never lost. The off-replicas
+// and min-replicas annotations apply to the minReplicas, which can not go
+// below one. It reports whether the autoscaler was changed.
+func toggleHPA(ctx context.Context, clientset kubernetes.Interface, config ControllerConfig, store ReplicaStore, deployment *apps_v1.Deployment, targetState DeploymentState) (bool, error) {
+	namespace := deployment.Namespace
+	deploymentName := deployment.Name
+	offReplicas, err := parseOffReplicas(config, deployment)
+	if err != nil {
+		return false, err
+	}
+	minReplicas, err := parseMinReplicas(config, deployment)
+	if err != nil {
+		return false, err
+	}
+	hpa, err := findHPA(ctx, clientset, deployment)
+	if err != nil {
+		return false, err
+	}
+	current := int32(1)
+	if hpa.Spec.MinReplicas != nil {
+		current = *hpa.Spec.MinReplicas
+	}
+
+	original := deployment.DeepCopy()
+	if deployment.ObjectMeta.Annotations == nil {
+		deployment.ObjectMeta.Annotations = map[string]string{}
+	}
+	stored, remembered, err := store.Recall(ctx, deployment)
+	if err != nil {
+		return false, fmt.Errorf("could not recall the replicas of deployment '%s.%s': %w", namespace, deploymentName, err)
+	}
+	remembered = remembered && !isRestored(config, deployment.GetAnnotations())
+
+	var target int32
+	if targetState == DISABLED {
+		base := current
+		if remembered {
+			base = stored
+		}
+		target = max(offReplicas.target(base), minReplicas, 1)
+		if current <= target {
+			return false, nil
+		}
+		if !remembered {
+			if err := store.Remember(ctx, deployment, base); err != nil {
+				return false, err
+			}
+			delete(deployment.ObjectMeta.Annotations, config.Annotation(LAST_RESTORED_ANNOTATION))
+			if err := updateDeploymentIfChanged(ctx, clientset, config, original, deployment); err != nil {
+				return false, err
+			}
+		}
+		logging.FromContext(ctx).Info(fmt.Sprintf("Scaling down HorizontalPodAutoscaler '%s.%s' of deployment '%s.%s'\n", namespace, hpa.Name, namespace, deploymentName))
+	} else {
+		if !remembered {
+			return false, nil
+		}
+		target = stored
+		logging.FromContext(ctx).Info(fmt.Sprintf("Scaling up HorizontalPodAutoscaler '%s.%s' of deployment '%s.%s'\n", namespace, hpa.Name, namespace, deploymentName))
+	}
+
+	if target != current {
+		hpa.Spec.MinReplicas = int32Ptr(target)
+		if _, err := clientset.AutoscalingV2().HorizontalPodAutoscalers(namespace).Update(ctx, hpa, meta_v1.UpdateOptions{}); err != nil {
+			return false, err
+		}
+		audit(ctx, config, "horizontalpodautoscalers", namespace, hpa.Name, current, target)
+	}
+	if targetState == ENABLED {
+		// The minReplicas are kept remembered, marked as restored, with
+		// KeepReplicasMemory
+		if config.KeepReplicasMemory {
+			if err := store.Remember(ctx, deployment, target); err != nil {
+				return false, err
+			}
+			deployment.ObjectMeta.Annotations[config.Annotation(LAST_RESTORED_ANNOTATION)] = lastRestoredReplicas(target, config.now())
+		}
+		if err := updateDeploymentIfChanged(ctx, clientset, config, original, deployment); err != nil {
+			return false, err
+		}
+		if !config.KeepReplicasMemory {
+			if err := store.Forget(ctx, deployment); err != nil {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
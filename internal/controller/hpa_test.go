@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	autoscaling_v2 "k8s.io/api/autoscaling/v2"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newTestHPA creates a HorizontalPodAutoscaler of the deployment with the
+// provided minReplicas
+func newTestHPA(deployment string, minReplicas int32) *autoscaling_v2.HorizontalPodAutoscaler {
+	return &autoscaling_v2.HorizontalPodAutoscaler{
+		ObjectMeta: meta_v1.ObjectMeta{Namespace: "default", Name: deployment + "-hpa"},
+		Spec: autoscaling_v2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscaling_v2.CrossVersionObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Name: deployment},
+			MinReplicas:    int32Ptr(minReplicas),
+			MaxReplicas:    10,
+		},
+	}
+}
+
+func TestIsHPATarget(t *testing.T) {
+	tests := []struct {
+		target string
+		hpa    bool
+		err    bool
+	}{
+		{"", false, false},
+		{"replicas", false, false},
+		{"hpa", true, false},
+		{" HPA ", true, false},
+		{"autoscaler", false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.target, func(t *testing.T) {
+			deployment := newTestDeployment("foo", 2, map[string]string{"scheduler.target": test.target})
+			hpa, err := isHPATarget(NewDefaultControllerConfig(), deployment)
+			if hpa != test.hpa || (err != nil) != test.err {
+				t.Errorf("expected %t (error %t), got %t (error %v)", test.hpa, test.err, hpa, err)
+			}
+		})
+	}
+}
+
+func TestToggleTargetModes(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name        string
+		replicas    int32
+		annotations map[string]string
+		hpaTarget   string
+		minReplicas int32
+		state       DeploymentState
+		expected    int32
+		expectedMin int32
+		memory      string
+		err         string
+	}{
+		{"replicas target", 5, map[string]string{"scheduler.target": "replicas"}, "foo", 3, DISABLED, 0, 3, "5", ""},
+		{"default target", 5, nil, "foo", 3, DISABLED, 0, 3, "5", ""},
+		{"hpa target", 5, map[string]string{"scheduler.target": "hpa"}, "foo", 3, DISABLED, 5, 1, "3", ""},
+		{"hpa target with off-replicas", 5, map[string]string{"scheduler.target": "hpa", "scheduler.off-replicas": "2"}, "foo", 3, DISABLED, 5, 2, "3", ""},
+		{"hpa target with min-replicas", 5, map[string]string{"scheduler.target": "hpa", "scheduler.min-replicas": "2"}, "foo", 3, DISABLED, 5, 2, "3", ""},
+		{"hpa target already down", 5, map[string]string{"scheduler.target": "hpa"}, "foo", 1, DISABLED, 5, 1, "", ""},
+		{"hpa target scaled up", 5, map[string]string{"scheduler.target": "hpa", "scheduler.replicas-memory": "3"}, "foo", 1, ENABLED, 5, 3, "", ""},
+		{"hpa target already up", 5, map[string]string{"scheduler.target": "hpa"}, "foo", 3, ENABLED, 5, 3, "", ""},
+		{"hpa target without an autoscaler", 5, map[string]string{"scheduler.target": "hpa"}, "bar", 3, DISABLED, 5, 3, "", "no HorizontalPodAutoscaler targets deployment 'default.foo'"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			config := NewDefaultControllerConfig()
+			_, clientset := newTestController(t, config, newTestDeployment("foo", test.replicas, test.annotations))
+			if _, err := clientset.AutoscalingV2().HorizontalPodAutoscalers("default").Create(ctx, newTestHPA(test.hpaTarget, test.minReplicas), meta_v1.CreateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			deployment, err := clientset.AppsV1().Deployments("default").Get(ctx, "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = toggleTarget(ctx, clientset, config, newReplicaStore(clientset, config), deployment, test.state)
+			if test.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Fatalf("expected the error '%s', got '%v'", test.err, err)
+			}
+			current, err := clientset.AppsV1().Deployments("default").Get(ctx, "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *current.Spec.Replicas != test.expected {
+				t.Errorf("expected %d replicas, got %d", test.expected, *current.Spec.Replicas)
+			}
+			if memory := current.Annotations["scheduler.replicas-memory"]; memory != test.memory {
+				t.Errorf("expected the replicas memory '%s', got '%s'", test.memory, memory)
+			}
+			hpa, err := clientset.AutoscalingV2().HorizontalPodAutoscalers("default").Get(ctx, test.hpaTarget+"-hpa", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *hpa.Spec.MinReplicas != test.expectedMin {
+				t.Errorf("expected %d minReplicas, got %d", test.expectedMin, *hpa.Spec.MinReplicas)
+			}
+		})
+	}
+}
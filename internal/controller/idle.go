@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dimitris4000/concept02/internal/logging"
+	apps_v1 "k8s.io/api/apps/v1"
+)
+
+// DEFAULT_IDLE_QUERY is the default Prometheus query returning the request
+// rate of a deployment in idle mode. ${namespace} and ${name} are replaced
+// by the namespace and name of the deployment.
+const DEFAULT_IDLE_QUERY = `sum(rate(http_requests_total{namespace="${namespace}",pod=~"${name}-.*"}[5m]))`
+
+// isIdleMode checks if the deployment is scaled by its traffic, through the
+// idle-timeout annotation, instead of a schedule
+func (c *Controller) isIdleMode(deployment *apps_v1.Deployment) bool {
+	_, exists := deployment.GetAnnotations()[c.config.Annotation(IDLE_TIMEOUT_ANNOTATION)]
+	return exists
+}
+
+// decideIdle returns the state of a deployment in idle mode. The deployment
+// is scaled down once the request rate of the idle query has been zero for
+// the idle timeout, the start of which is remembered in the idle-since
+// annotation. Any traffic scales it back up, although that takes metrics
+// recorded in front of the deployment (e.g. by the ingress) since a
+// deployment without pods serves no requests. It can otherwise be scaled up
+// by hand. An override pins the deployment like it does for schedules.
+func (c *Controller) decideIdle(ctx context.Context, deployment *apps_v1.Deployment) (DeploymentState, error) {
+	overrideState, overridden, err := c.parseOverride(deployment)
+	if err != nil || overridden {
+		return overrideState, err
+	}
+
+	annotations := deployment.GetAnnotations()
+	timeoutAnnotation := c.config.Annotation(IDLE_TIMEOUT_ANNOTATION)
+	timeout, err := time.ParseDuration(annotations[timeoutAnnotation])
+	if err != nil || timeout <= 0 {
+		return ENABLED, fmt.Errorf("invalid %s annotation '%s', expected a positive duration like '30m'", timeoutAnnotation, annotations[timeoutAnnotation])
+	}
+	if c.prometheus == nil {
+		return ENABLED, fmt.Errorf("the %s annotation requires the --prometheus-url flag", timeoutAnnotation)
+	}
+
+	idleSinceAnnotation := c.config.Annotation(IDLE_SINCE_ANNOTATION)
+	idleSinceText, idling := annotations[idleSinceAnnotation]
+	if _, scaledDown := scaledDownMemory(c.config, annotations); scaledDown && idling {
+		// The idle period ends with the scale down, the next one starts
+		// over once the deployment is scaled back up
+		return DISABLED, c.patchAnnotation(ctx, deployment, idleSinceAnnotation, nil)
+	}
+
+	query, err := ExpandVariables(c.config.IdleQuery, map[string]string{"namespace": deployment.Namespace, "name": deployment.Name})
+	if err != nil {
+		return ENABLED, fmt.Errorf("invalid idle query: %s", err)
+	}
+	queryCtx, cancel := context.WithTimeout(ctx, c.config.APITimeout)
+	defer cancel()
+	rate, err := c.prometheus.Query(queryCtx, query)
+	if err != nil {
+		return ENABLED, err
+	}
+
+	if rate > 0 {
+		if idling {
+			return ENABLED, c.patchAnnotation(ctx, deployment, idleSinceAnnotation, nil)
+		}
+		return ENABLED, nil
+	}
+	if _, scaledDown := scaledDownMemory(c.config, annotations); scaledDown {
+		return DISABLED, nil
+	}
+
+	now := c.clock.Now()
+	if !idling {
+		logging.FromContext(ctx).Info(fmt.Sprintf("Deployment '%s.%s' is idle, scaling it down in %s unless it receives traffic", deployment.Namespace, deployment.Name, timeout))
+		value := now.UTC().Format(time.RFC3339)
+		return ENABLED, c.patchAnnotation(ctx, deployment, idleSinceAnnotation, &value)
+	}
+	idleSince, err := time.Parse(time.RFC3339, idleSinceText)
+	if err != nil {
+		return ENABLED, fmt.Errorf("invalid %s annotation '%s', expected an RFC 3339 timestamp", idleSinceAnnotation, idleSinceText)
+	}
+	if now.Sub(idleSince) < timeout {
+		return ENABLED, nil
+	}
+	return DISABLED, nil
+}
@@ -0,0 +1,135 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// prometheusEndpoint is a Prometheus server answering every query with the
+// request rate, which remembers the last query
+type prometheusEndpoint struct {
+	mutex sync.Mutex
+	rate  string
+	query string
+}
+
+func (p *prometheusEndpoint) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.query = request.URL.Query().Get("query")
+	fmt.Fprintf(writer, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1717440000,"%s"]}]}}`, p.rate)
+}
+
+func TestReconcileIdleMode(t *testing.T) {
+	discardLogs(t)
+	endpoint := &prometheusEndpoint{}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+	config := NewDefaultControllerConfig()
+	config.PrometheusURL = server.URL
+	c, clientset := newTestController(t, config, newTestDeployment("foo", 2, map[string]string{"scheduler.enabled": "true", "scheduler.idle-timeout": "30m"}))
+	clock := &fakeClock{}
+	c.SetClock(clock)
+	start := time.Date(2024, time.June, 3, 12, 0, 0, 0, time.UTC)
+
+	// The steps run in order on the same deployment
+	steps := []struct {
+		name      string
+		now       time.Time
+		rate      string
+		replicas  int32
+		idleSince string
+	}{
+		{"serving traffic", start, "1.5", 2, ""},
+		{"idle", start.Add(time.Minute), "0", 2, "2024-06-03T12:01:00Z"},
+		{"idle within the timeout", start.Add(20 * time.Minute), "0", 2, "2024-06-03T12:01:00Z"},
+		{"traffic within the timeout", start.Add(25 * time.Minute), "0.1", 2, ""},
+		{"idle again", start.Add(26 * time.Minute), "0", 2, "2024-06-03T12:26:00Z"},
+		{"idle for the timeout", start.Add(56 * time.Minute), "0", 0, "2024-06-03T12:26:00Z"},
+		{"scaled down", start.Add(57 * time.Minute), "0", 0, ""},
+		{"still idle", start.Add(2 * time.Hour), "0", 0, ""},
+		{"first request", start.Add(3 * time.Hour), "0.01", 2, ""},
+	}
+	for _, step := range steps {
+		clock.Set(step.now)
+		endpoint.mutex.Lock()
+		endpoint.rate = step.rate
+		endpoint.mutex.Unlock()
+		if err := c.reconcile(context.Background(), "default/foo"); err != nil {
+			t.Fatalf("%s: %s", step.name, err)
+		}
+		current, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+		if err != nil {
+			t.Fatalf("%s: %s", step.name, err)
+		}
+		if *current.Spec.Replicas != step.replicas {
+			t.Errorf("%s: expected %d replicas, got %d", step.name, step.replicas, *current.Spec.Replicas)
+		}
+		if idleSince := current.Annotations["scheduler.idle-since"]; idleSince != step.idleSince {
+			t.Errorf("%s: expected idle since '%s', got '%s'", step.name, step.idleSince, idleSince)
+		}
+		if err := c.deploymentInformer.GetIndexer().Update(current); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	endpoint.mutex.Lock()
+	defer endpoint.mutex.Unlock()
+	if expected := `sum(rate(http_requests_total{namespace="default",pod=~"foo-.*"}[5m]))`; endpoint.query != expected {
+		t.Errorf("expected the query '%s', got '%s'", expected, endpoint.query)
+	}
+}
+
+func TestReconcileIdleModeErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		prometheus  bool
+		annotations map[string]string
+		err         string
+	}{
+		{"invalid timeout", true, map[string]string{"scheduler.idle-timeout": "soon"}, "invalid scheduler.idle-timeout annotation 'soon'"},
+		{"negative timeout", true, map[string]string{"scheduler.idle-timeout": "-5m"}, "invalid scheduler.idle-timeout annotation '-5m'"},
+		{"without Prometheus", false, map[string]string{"scheduler.idle-timeout": "30m"}, "requires the --prometheus-url flag"},
+		{"invalid idle since", true, map[string]string{"scheduler.idle-timeout": "30m", "scheduler.idle-since": "yesterday"}, "invalid scheduler.idle-since annotation 'yesterday'"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			server := httptest.NewServer(&prometheusEndpoint{rate: "0"})
+			defer server.Close()
+			config := NewDefaultControllerConfig()
+			if test.prometheus {
+				config.PrometheusURL = server.URL
+			}
+			annotations := map[string]string{"scheduler.enabled": "true"}
+			for key, value := range test.annotations {
+				annotations[key] = value
+			}
+			c, clientset := newTestController(t, config, newTestDeployment("foo", 2, annotations))
+			c.SetClock(&fakeClock{now: time.Date(2024, time.June, 3, 12, 0, 0, 0, time.UTC)})
+
+			if err := c.reconcile(context.Background(), "default/foo"); err != nil {
+				t.Fatal(err)
+			}
+			current, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *current.Spec.Replicas != 2 {
+				t.Errorf("expected the deployment left alone, got %d replicas", *current.Spec.Replicas)
+			}
+			if errorAnnotation := current.Annotations["scheduler.error"]; !strings.Contains(errorAnnotation+logs.String(), test.err) {
+				t.Errorf("expected the error '%s', got the annotation '%s' and the logs %s", test.err, errorAnnotation, logs)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package controller
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	apps_v1 "k8s.io/api/apps/v1"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+// defaultEnvtestAssets is where envtest looks for its binaries when
+// KUBEBUILDER_ASSETS is not set
+const defaultEnvtestAssets = "/usr/local/kubebuilder/bin"
+
+// startTestEnvironment starts an API server with envtest and returns a
+// clientset connected to it. The test is skipped if the etcd and
+// kube-apiserver binaries of envtest are not installed, e.g. with
+// 'setup-envtest use -p path' and KUBEBUILDER_ASSETS set to the path.
+func startTestEnvironment(t *testing.T) kubernetes.Interface {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("skipping the envtest integration test in short mode")
+	}
+	assets := os.Getenv("KUBEBUILDER_ASSETS")
+	if assets == "" {
+		assets = defaultEnvtestAssets
+	}
+	for _, binary := range []string{"etcd", "kube-apiserver"} {
+		if _, err := os.Stat(filepath.Join(assets, binary)); err != nil {
+			t.Skipf("skipping the envtest integration test, %s is not installed: %s", binary, err)
+		}
+	}
+
+	environment := &envtest.Environment{BinaryAssetsDirectory: assets}
+	restConfig, err := environment.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := environment.Stop(); err != nil {
+			t.Error(err)
+		}
+	})
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return clientset
+}
+
+// startTestController runs a controller against the API server with its
+// clock set to now, until the test ends
+func startTestController(t *testing.T, clientset kubernetes.Interface, now time.Time) (*Controller, *fakeClock) {
+	t.Helper()
+	config := NewDefaultControllerConfig()
+	config.ReconcileQPS = 100
+
+	stopCh := make(chan struct{})
+	deploymentInformer, configMapInformer, namespaceInformer := newInformers(wait.ContextForChannel(stopCh), clientset)
+	c := NewResourceController(clientset, deploymentInformer, configMapInformer, namespaceInformer, config)
+	clock := &fakeClock{now: now}
+	c.SetClock(clock)
+	go c.Run(stopCh)
+	t.Cleanup(func() {
+		close(stopCh)
+		<-c.Done()
+	})
+
+	if !waitForSync(c) {
+		t.Fatal("timed out waiting for the caches of the controller to sync")
+	}
+	return c, clock
+}
+
+func waitForSync(c *Controller) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err := wait.PollUntilContextCancel(ctx, 100*time.Millisecond, true, func(ctx context.Context) (bool, error) {
+		return c.HasSynced(), nil
+	})
+	return err == nil
+}
+
+// createTestDeployment creates a deployment with the replicas and
+// annotations in the default namespace
+func createTestDeployment(t *testing.T, clientset kubernetes.Interface, name string, replicas int32, annotations map[string]string) {
+	t.Helper()
+	labels := map[string]string{"app": name}
+	deployment := &apps_v1.Deployment{
+		ObjectMeta: meta_v1.ObjectMeta{Namespace: "default", Name: name, Annotations: annotations},
+		Spec: apps_v1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &meta_v1.LabelSelector{MatchLabels: labels},
+			Template: core_v1.PodTemplateSpec{
+				ObjectMeta: meta_v1.ObjectMeta{Labels: labels},
+				Spec: core_v1.PodSpec{
+					Containers: []core_v1.Container{{Name: "app", Image: "nginx"}},
+				},
+			},
+		},
+	}
+	if _, err := clientset.AppsV1().Deployments("default").Create(context.Background(), deployment, meta_v1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitForDeployment waits until the deployment satisfies the condition and
+// fails the test with the last state of the deployment otherwise
+func waitForDeployment(t *testing.T, clientset kubernetes.Interface, name string, condition func(*apps_v1.Deployment) bool) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var deployment *apps_v1.Deployment
+	err := wait.PollUntilContextCancel(ctx, 100*time.Millisecond, true, func(ctx context.Context) (bool, error) {
+		var err error
+		deployment, err = clientset.AppsV1().Deployments("default").Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return condition(deployment), nil
+	})
+	if err != nil {
+		t.Fatalf("deployment %s did not reach the expected state: %s, last replicas %d and annotations %v", name, err, *deployment.Spec.Replicas, deployment.Annotations)
+	}
+}
+
+func TestIntegrationScalesAcrossTheWindow(t *testing.T) {
+	discardLogs(t)
+	clientset := startTestEnvironment(t)
+	createTestDeployment(t, clientset, "scheduled", 3, map[string]string{
+		"scheduler.enabled":      "true",
+		"scheduler.off-schedule": "20:00-08:00",
+	})
+	createTestDeployment(t, clientset, "unscheduled", 3, nil)
+	c, clock := startTestController(t, clientset, time.Date(2024, time.June, 3, 12, 0, 0, 0, time.UTC))
+
+	// Entering the window scales the deployment down
+	clock.Set(time.Date(2024, time.June, 3, 20, 0, 0, 0, time.UTC))
+	c.Reload()
+	waitForDeployment(t, clientset, "scheduled", func(deployment *apps_v1.Deployment) bool {
+		return *deployment.Spec.Replicas == 0 && deployment.Annotations["scheduler.replicas-memory"] == "3"
+	})
+
+	// Leaving it restores the remembered replicas
+	clock.Set(time.Date(2024, time.June, 4, 8, 0, 0, 0, time.UTC))
+	c.Reload()
+	waitForDeployment(t, clientset, "scheduled", func(deployment *apps_v1.Deployment) bool {
+		_, remembered := deployment.Annotations["scheduler.replicas-memory"]
+		return *deployment.Spec.Replicas == 3 && !remembered
+	})
+
+	deployment, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "unscheduled", meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *deployment.Spec.Replicas != 3 {
+		t.Errorf("expected the deployment without a schedule to keep 3 replicas, got %d", *deployment.Spec.Replicas)
+	}
+}
+
+func TestIntegrationRestoresPausedDeployments(t *testing.T) {
+	discardLogs(t)
+	clientset := startTestEnvironment(t)
+	createTestDeployment(t, clientset, "paused", 3, map[string]string{
+		"scheduler.enabled":      "true",
+		"scheduler.off-schedule": "20:00-08:00",
+	})
+	startTestController(t, clientset, time.Date(2024, time.June, 3, 22, 0, 0, 0, time.UTC))
+	waitForDeployment(t, clientset, "paused", func(deployment *apps_v1.Deployment) bool {
+		return *deployment.Spec.Replicas == 0
+	})
+
+	// Disabling the schedule inside the window restores the deployment
+	patch := []byte(`{"metadata":{"annotations":{"scheduler.enabled":"false"}}}`)
+	if _, err := clientset.AppsV1().Deployments("default").Patch(context.Background(), "paused", types.MergePatchType, patch, meta_v1.PatchOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForDeployment(t, clientset, "paused", func(deployment *apps_v1.Deployment) bool {
+		return *deployment.Spec.Replicas == 3
+	})
+}
@@ -0,0 +1,231 @@
+package controller
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Metrics of the controller's workqueue. They are registered on the default
+// prometheus registry which is exposed by the http service.
+var (
+	workqueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "scheduler_workqueue_depth",
+		Help: "Current depth of the workqueue.",
+	}, []string{"name"})
+	workqueueAdds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "scheduler_workqueue_adds_total",
+		Help: "Total number of adds handled by the workqueue.",
+	}, []string{"name"})
+	workqueueLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "scheduler_workqueue_queue_duration_seconds",
+		Help:    "How long in seconds an item stays in the workqueue before being requested.",
+		Buckets: prometheus.ExponentialBuckets(10e-9, 10, 10),
+	}, []string{"name"})
+	workqueueWorkDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "scheduler_workqueue_work_duration_seconds",
+		Help:    "How long in seconds processing an item from the workqueue takes.",
+		Buckets: prometheus.ExponentialBuckets(10e-9, 10, 10),
+	}, []string{"name"})
+	workqueueUnfinishedWork = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "scheduler_workqueue_unfinished_work_seconds",
+		Help: "How many seconds of work has been done that is in progress and hasn't been observed by work_duration.",
+	}, []string{"name"})
+	workqueueLongestRunningProcessor = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "scheduler_workqueue_longest_running_processor_seconds",
+		Help: "How many seconds has the longest running processor for the workqueue been running.",
+	}, []string{"name"})
+	workqueueRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "scheduler_workqueue_retries_total",
+		Help: "Total number of retries handled by the workqueue.",
+	}, []string{"name"})
+)
+
+// Metrics of the reconciles. The duration is observed per deployment since
+// the resync loop only queues the deployments for the workers.
+var (
+	reconcileDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "scheduler_reconcile_duration_seconds",
+		Help:    "How long in seconds the reconcile of a deployment takes.",
+		Buckets: prometheus.DefBuckets,
+	})
+	reconcileTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "scheduler_reconcile_total",
+		Help: "Total number of resync loops of the controller.",
+	})
+	reconcileDeployments = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "scheduler_reconcile_deployments",
+		Help: "Number of deployments queued for reconcile by the last resync loop.",
+	})
+	nextTransitionSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "scheduler_seconds_until_next_transition",
+		Help: "Seconds until the schedule of a deployment changes its state.",
+	}, []string{"namespace", "name"})
+	deploymentsSkipped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "scheduler_deployments_skipped_total",
+		Help: "Total number of reconciles of annotated deployments that were skipped, by reason.",
+	}, []string{"reason"})
+	advisoryDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "scheduler_advisory_decisions_total",
+		Help: "Total number of reconciles of deployments in advisory mode, by the recommended state.",
+	}, []string{"state"})
+	clockSkewSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "scheduler_clock_skew_seconds",
+		Help: "Seconds the clock of the controller is ahead of the clock of the k8s API server, negative if behind.",
+	})
+	replicaDrift = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "scheduler_replica_drift_total",
+		Help: "Total number of scaled down deployments whose replicas were changed by hand, replacing their remembered replicas.",
+	})
+)
+
+// Reasons of the skipped deployments metric
+const (
+	SKIP_REASON_NOT_ENABLED   = "not_enabled"
+	SKIP_REASON_PAUSED        = "paused"
+	SKIP_REASON_MANAGED       = "managed"
+	SKIP_REASON_NO_SCHEDULE   = "no_schedule"
+	SKIP_REASON_PARSE_ERROR   = "parse_error"
+	SKIP_REASON_EXCLUDED      = "excluded"
+	SKIP_REASON_PAUSED_UNTIL  = "paused_until"
+	SKIP_REASON_CREATE_GRACE  = "create_grace"
+	SKIP_REASON_IGNORED_LABEL = "ignored_label"
+)
+
+func init() {
+	prometheus.MustRegister(
+		reconcileDuration,
+		reconcileTotal,
+		reconcileDeployments,
+		nextTransitionSeconds,
+		deploymentsSkipped,
+		advisoryDecisions,
+		clockSkewSeconds,
+		replicaDrift,
+		workqueueDepth,
+		workqueueAdds,
+		workqueueLatency,
+		workqueueWorkDuration,
+		workqueueUnfinishedWork,
+		workqueueLongestRunningProcessor,
+		workqueueRetries,
+	)
+	workqueue.SetProvider(workqueueMetricsProvider{})
+
+	// Export all the reasons from the start, so rates work from zero
+	for _, reason := range []string{SKIP_REASON_NOT_ENABLED, SKIP_REASON_PAUSED, SKIP_REASON_MANAGED, SKIP_REASON_NO_SCHEDULE, SKIP_REASON_PARSE_ERROR, SKIP_REASON_EXCLUDED, SKIP_REASON_PAUSED_UNTIL, SKIP_REASON_CREATE_GRACE, SKIP_REASON_IGNORED_LABEL} {
+		deploymentsSkipped.WithLabelValues(reason)
+	}
+	for _, state := range []DeploymentState{ENABLED, DISABLED} {
+		advisoryDecisions.WithLabelValues(state.String())
+	}
+}
+
+// deploymentSeries bounds the number of deployments with series in the
+// per-deployment metrics. Every series is labeled with the namespace and
+// name of a deployment, so clusters with thousands of deployments would
+// otherwise blow up the cardinality of the metrics.
+type deploymentSeries struct {
+	enabled bool
+	max     int
+	mutex   sync.Mutex
+	keys    map[string]struct{}
+	warned  bool
+}
+
+// newDeploymentSeries creates a deploymentSeries with the metrics mode of
+// the configuration
+func newDeploymentSeries(config ControllerConfig) *deploymentSeries {
+	return &deploymentSeries{
+		enabled: config.PerDeploymentMetrics,
+		max:     config.MaxDeploymentSeries,
+		keys:    map[string]struct{}{},
+	}
+}
+
+// allow checks if the metrics of the deployment can be recorded. Once the
+// cap is reached, deployments without series are left out, with a warning
+// logged once until the number of series drops below the cap.
+func (s *deploymentSeries) allow(namespace, name string) bool {
+	if !s.enabled {
+		return false
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := namespace + "/" + name
+	if _, exists := s.keys[key]; exists {
+		return true
+	}
+	if s.max > 0 && len(s.keys) >= s.max {
+		if !s.warned {
+			slog.Warn(fmt.Sprintf("Reached the limit of %d deployments with per-deployment metrics, the metrics of deployment %s and any further ones are not recorded", s.max, key))
+			s.warned = true
+		}
+		return false
+	}
+	s.keys[key] = struct{}{}
+	return true
+}
+
+// forget releases the series of the deployment
+func (s *deploymentSeries) forget(namespace, name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.keys, namespace+"/"+name)
+	if len(s.keys) < s.max {
+		s.warned = false
+	}
+}
+
+// setNextTransition records the seconds until the next transition of the
+// deployment, if its series is allowed
+func (c *Controller) setNextTransition(namespace, name string, seconds float64) {
+	if !c.series.allow(namespace, name) {
+		return
+	}
+	nextTransitionSeconds.WithLabelValues(namespace, name).Set(seconds)
+}
+
+// deleteDeploymentMetrics removes the per-deployment series of the
+// deployment, making room for other deployments under the cap
+func (c *Controller) deleteDeploymentMetrics(namespace, name string) {
+	nextTransitionSeconds.DeleteLabelValues(namespace, name)
+	c.series.forget(namespace, name)
+}
+
+// workqueueMetricsProvider implements workqueue.MetricsProvider on top of
+// the prometheus metrics above.
+type workqueueMetricsProvider struct{}
+
+func (workqueueMetricsProvider) NewDepthMetric(name string) workqueue.GaugeMetric {
+	return workqueueDepth.WithLabelValues(name)
+}
+
+func (workqueueMetricsProvider) NewAddsMetric(name string) workqueue.CounterMetric {
+	return workqueueAdds.WithLabelValues(name)
+}
+
+func (workqueueMetricsProvider) NewLatencyMetric(name string) workqueue.HistogramMetric {
+	return workqueueLatency.WithLabelValues(name)
+}
+
+func (workqueueMetricsProvider) NewWorkDurationMetric(name string) workqueue.HistogramMetric {
+	return workqueueWorkDuration.WithLabelValues(name)
+}
+
+func (workqueueMetricsProvider) NewUnfinishedWorkSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return workqueueUnfinishedWork.WithLabelValues(name)
+}
+
+func (workqueueMetricsProvider) NewLongestRunningProcessorSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return workqueueLongestRunningProcessor.WithLabelValues(name)
+}
+
+func (workqueueMetricsProvider) NewRetriesMetric(name string) workqueue.CounterMetric {
+	return workqueueRetries.WithLabelValues(name)
+}
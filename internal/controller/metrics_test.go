@@ -0,0 +1,217 @@
+package controller
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	apps_v1 "k8s.io/api/apps/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// scrapeMetric scrapes the metrics handler and returns the value of the
+// series, which is 0 if the series is not exported
+func scrapeMetric(t *testing.T, series string) float64 {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	promhttp.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	scanner := bufio.NewScanner(recorder.Body)
+	for scanner.Scan() {
+		name, value, found := strings.Cut(scanner.Text(), " ")
+		if !found || name != series {
+			continue
+		}
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			t.Fatalf("invalid value of %s: %s", series, err)
+		}
+		return number
+	}
+	return 0
+}
+
+func TestReconcileMetrics(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name        string
+		deployments int
+	}{
+		{"no deployments", 0},
+		{"single deployment", 1},
+		{"multiple deployments", 5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			deployments := make([]*apps_v1.Deployment, 0, test.deployments)
+			for i := 0; i < test.deployments; i++ {
+				deployments = append(deployments, newTestDeployment(fmt.Sprintf("foo-%d", i), 1, map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00"}))
+			}
+			c, _ := newTestController(t, NewDefaultControllerConfig(), deployments...)
+			ctx := context.Background()
+			loops := scrapeMetric(t, "scheduler_reconcile_total")
+			reconciles := scrapeMetric(t, "scheduler_reconcile_duration_seconds_count")
+
+			c.loopIteration(ctx)
+			for c.queue.Len() > 0 {
+				c.processNextItem(ctx)
+			}
+			if delta := scrapeMetric(t, "scheduler_reconcile_total") - loops; delta != 1 {
+				t.Errorf("expected a single resync loop, got %g", delta)
+			}
+			if queued := scrapeMetric(t, "scheduler_reconcile_deployments"); queued != float64(test.deployments) {
+				t.Errorf("expected %d queued deployments, got %g", test.deployments, queued)
+			}
+			if delta := scrapeMetric(t, "scheduler_reconcile_duration_seconds_count") - reconciles; delta != float64(test.deployments) {
+				t.Errorf("expected %d observed reconciles, got %g", test.deployments, delta)
+			}
+		})
+	}
+}
+
+func TestSkippedDeploymentsMetric(t *testing.T) {
+	discardLogs(t)
+	now := time.Date(2024, time.June, 3, 22, 0, 0, 0, time.UTC)
+	reasons := []string{SKIP_REASON_NOT_ENABLED, SKIP_REASON_PAUSED, SKIP_REASON_MANAGED, SKIP_REASON_NO_SCHEDULE, SKIP_REASON_PARSE_ERROR, SKIP_REASON_EXCLUDED, SKIP_REASON_PAUSED_UNTIL, SKIP_REASON_CREATE_GRACE, SKIP_REASON_IGNORED_LABEL}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		labels      map[string]string
+		reason      string
+	}{
+		{"not annotated", nil, nil, ""},
+		{"scheduled", map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00"}, nil, ""},
+		{"not enabled", map[string]string{"scheduler.off-schedule": "20:00-08:00"}, nil, SKIP_REASON_NOT_ENABLED},
+		{"unrecognized enabled value", map[string]string{"scheduler.enabled": "maybe", "scheduler.off-schedule": "20:00-08:00"}, nil, SKIP_REASON_NOT_ENABLED},
+		{"paused", map[string]string{"scheduler.enabled": "false", "scheduler.off-schedule": "20:00-08:00"}, nil, SKIP_REASON_PAUSED},
+		{"managed", map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00", "argocd.argoproj.io/tracking-id": "foo"}, nil, SKIP_REASON_MANAGED},
+		{"no schedule", map[string]string{"scheduler.enabled": "true"}, nil, SKIP_REASON_NO_SCHEDULE},
+		{"parse error", map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00"}, nil, SKIP_REASON_PARSE_ERROR},
+		{"excluded", map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00", "scheduler.exclude": "true"}, nil, SKIP_REASON_EXCLUDED},
+		{"paused until", map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00", "scheduler.pause-until": "2024-06-04T00:00:00Z"}, nil, SKIP_REASON_PAUSED_UNTIL},
+		{"create grace", map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00", "scheduler.grace-after-create": "1h"}, nil, SKIP_REASON_CREATE_GRACE},
+		{"ignored label", map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00"}, map[string]string{"tier": "critical"}, SKIP_REASON_IGNORED_LABEL},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			deployment := newTestDeployment("foo", 2, test.annotations)
+			deployment.Labels = test.labels
+			deployment.CreationTimestamp = meta_v1.NewTime(now.Add(-time.Minute))
+			c, _ := newTestController(t, NewDefaultControllerConfig(), deployment)
+			c.SetClock(&fakeClock{now: now})
+			ignoredLabels, err := parseIgnoreLabels("tier=critical")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.ignoredLabels = ignoredLabels
+			before := map[string]float64{}
+			for _, reason := range reasons {
+				before[reason] = scrapeMetric(t, fmt.Sprintf(`scheduler_deployments_skipped_total{reason="%s"}`, reason))
+			}
+
+			if err := c.reconcile(context.Background(), "default/foo"); err != nil {
+				t.Fatal(err)
+			}
+			for _, reason := range reasons {
+				expected := 0.0
+				if reason == test.reason {
+					expected = 1
+				}
+				if delta := scrapeMetric(t, fmt.Sprintf(`scheduler_deployments_skipped_total{reason="%s"}`, reason)) - before[reason]; delta != expected {
+					t.Errorf("expected %g skips with the reason %s, got %g", expected, reason, delta)
+				}
+			}
+		})
+	}
+}
+
+// scrapeSeries returns the number of series of the metric exported for the
+// deployments of the namespace
+func scrapeSeries(t *testing.T, metric, namespace string) int {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	promhttp.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	series := 0
+	for _, line := range strings.Split(recorder.Body.String(), "\n") {
+		if strings.HasPrefix(line, metric+"{") && strings.Contains(line, fmt.Sprintf(`namespace="%s"`, namespace)) {
+			series++
+		}
+	}
+	return series
+}
+
+func TestPerDeploymentMetricsCap(t *testing.T) {
+	tests := []struct {
+		name          string
+		perDeployment bool
+		max           int
+		deployments   int
+		series        int
+		warnings      int
+	}{
+		{"aggregate only", false, 0, 3, 0, 0},
+		{"unlimited", true, 0, 5, 5, 0},
+		{"under the cap", true, 5, 3, 3, 0},
+		{"at the cap", true, 3, 3, 3, 0},
+		{"over the cap", true, 2, 5, 2, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			// Every test has its own namespace, the metrics are global
+			namespace := strings.ReplaceAll(test.name, " ", "-")
+			config := NewDefaultControllerConfig()
+			config.PerDeploymentMetrics = test.perDeployment
+			config.MaxDeploymentSeries = test.max
+			c, _ := newTestController(t, config)
+			deployments := make([]*apps_v1.Deployment, 0, test.deployments)
+			for i := 0; i < test.deployments; i++ {
+				deployment := newTestDeployment(fmt.Sprintf("foo-%d", i), 2, map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00"})
+				deployment.Namespace = namespace
+				deployments = append(deployments, deployment)
+			}
+			t.Cleanup(func() {
+				for _, deployment := range deployments {
+					c.deleteDeploymentMetrics(deployment.Namespace, deployment.Name)
+				}
+			})
+			c.SetClock(&fakeClock{now: time.Date(2024, time.June, 3, 12, 0, 0, 0, time.UTC)})
+
+			// Recording the metrics again does not take more room
+			for i := 0; i < 2; i++ {
+				for _, deployment := range deployments {
+					c.recordNextTransition(deployment)
+				}
+			}
+			if series := scrapeSeries(t, "scheduler_seconds_until_next_transition", namespace); series != test.series {
+				t.Errorf("expected %d series, got %d", test.series, series)
+			}
+			if count := strings.Count(logs.String(), "Reached the limit"); count != test.warnings {
+				t.Errorf("expected %d warnings, got %d", test.warnings, count)
+			}
+			if test.warnings == 0 {
+				return
+			}
+
+			// Deleting a series makes room for one of the left out deployments
+			c.deleteDeploymentMetrics(namespace, "foo-0")
+			c.recordNextTransition(deployments[test.deployments-1])
+			c.recordNextTransition(deployments[test.deployments-2])
+			if series := scrapeSeries(t, "scheduler_seconds_until_next_transition", namespace); series != test.series {
+				t.Errorf("expected %d series after a deletion, got %d", test.series, series)
+			}
+			if count := strings.Count(logs.String(), "Reached the limit"); count != 2 {
+				t.Errorf("expected another warning once the cap was reached again, got %d warnings", count)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// notificationQueueSize bounds the notifications waiting to be sent, so a
+// slow webhook never blocks the reconciles.
+const notificationQueueSize = 100
+
+// Notification is the payload sent to the notification webhook when the
+// controller scales a deployment.
+type Notification struct {
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	State     string    `json:"state"`
+	Time      time.Time `json:"time"`
+}
+
+// notifier sends the notifications to the configured webhook from a single
+// worker. Failed requests are retried with exponential backoff and
+// notifications that can not be delivered are logged so they can be
+// recovered from the logs.
+type notifier struct {
+	client  *http.Client
+	url     string
+	retries int
+	backoff time.Duration
+	queue   chan Notification
+}
+
+// newNotifier creates the notifier of the configured webhook, or returns
+// nil if no webhook is configured.
+func newNotifier(config ControllerConfig) *notifier {
+	if config.NotifyURL == "" {
+		return nil
+	}
+	return &notifier{
+		client:  &http.Client{Timeout: config.NotifyTimeout},
+		url:     config.NotifyURL,
+		retries: config.NotifyRetries,
+		backoff: config.NotifyBackoff,
+		queue:   make(chan Notification, notificationQueueSize),
+	}
+}
+
+// Notify queues a notification without blocking. If the queue is full the
+// notification is dropped and logged.
+func (n *notifier) Notify(notification Notification) {
+	select {
+	case n.queue <- notification:
+	default:
+		n.deadLetter(notification, fmt.Errorf("notification queue is full"))
+	}
+}
+
+// Run sends the queued notifications until the context is done
+func (n *notifier) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case notification := <-n.queue:
+			err := n.send(ctx, notification)
+			if err != nil {
+				n.deadLetter(notification, err)
+			}
+		}
+	}
+}
+
+// send posts the notification to the webhook, retrying failed attempts
+func (n *notifier) send(ctx context.Context, notification Notification) error {
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	backoff := n.backoff
+	for attempt := 0; ; attempt++ {
+		err = n.post(ctx, payload)
+		if err == nil || attempt >= n.retries {
+			return err
+		}
+		slog.Warn(fmt.Sprintf("Failed to send notification (attempt %d): %s", attempt+1, err))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post makes a single request to the webhook
+func (n *notifier) post(ctx context.Context, payload []byte) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := n.client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", response.Status)
+	}
+	return nil
+}
+
+// deadLetter logs a notification that could not be delivered
+func (n *notifier) deadLetter(notification Notification, err error) {
+	payload, _ := json.Marshal(notification)
+	slog.Error(fmt.Sprintf("Dropping notification %s: %s", payload, err))
+}
@@ -0,0 +1,152 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyWebhook is a notification webhook failing its first requests
+type flakyWebhook struct {
+	mutex         sync.Mutex
+	failures      int
+	attempts      []time.Time
+	notifications []Notification
+}
+
+func (w *flakyWebhook) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.attempts = append(w.attempts, time.Now())
+	if len(w.attempts) <= w.failures {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var notification Notification
+	if err := json.NewDecoder(request.Body).Decode(&notification); err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.notifications = append(w.notifications, notification)
+}
+
+// captureLogs sends the default logger to the returned buffer for the
+// duration of the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	logger := slog.Default()
+	logs := &bytes.Buffer{}
+	slog.SetDefault(slog.New(slog.NewTextHandler(logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(logger) })
+	return logs
+}
+
+func TestNotifierRetries(t *testing.T) {
+	const backoff = 20 * time.Millisecond
+	tests := []struct {
+		name       string
+		failures   int
+		retries    int
+		attempts   int
+		delivered  bool
+		deadLetter bool
+	}{
+		{"delivered at once", 0, 3, 1, true, false},
+		{"delivered on a retry", 2, 3, 3, true, false},
+		{"delivered on the last retry", 3, 3, 4, true, false},
+		{"out of retries", 4, 3, 4, false, true},
+		{"without retries", 1, 0, 1, false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			webhook := &flakyWebhook{failures: test.failures}
+			server := httptest.NewServer(webhook)
+			defer server.Close()
+
+			config := NewDefaultControllerConfig()
+			config.NotifyURL = server.URL
+			config.NotifyRetries = test.retries
+			config.NotifyBackoff = backoff
+			n := newNotifier(config)
+			notification := Notification{Namespace: "default", Name: "foo", State: "down", Time: time.Date(2024, time.June, 3, 20, 0, 0, 0, time.UTC)}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				n.Run(ctx)
+				close(done)
+			}()
+			n.Notify(notification)
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				webhook.mutex.Lock()
+				attempts := len(webhook.attempts)
+				webhook.mutex.Unlock()
+				if attempts >= test.attempts || time.Now().After(deadline) {
+					break
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			// The dead letter is logged right after the last attempt
+			time.Sleep(50 * time.Millisecond)
+			cancel()
+			<-done
+
+			webhook.mutex.Lock()
+			defer webhook.mutex.Unlock()
+			if len(webhook.attempts) != test.attempts {
+				t.Fatalf("expected %d attempts, got %d", test.attempts, len(webhook.attempts))
+			}
+			// The backoff doubles after every failed attempt
+			for i := 1; i < len(webhook.attempts); i++ {
+				expected := backoff << (i - 1)
+				if elapsed := webhook.attempts[i].Sub(webhook.attempts[i-1]); elapsed < expected {
+					t.Errorf("expected attempt %d at least %s after the previous one, got %s", i+1, expected, elapsed)
+				}
+			}
+			if delivered := len(webhook.notifications) == 1; delivered != test.delivered {
+				t.Errorf("expected delivered %t, got %v", test.delivered, webhook.notifications)
+			}
+			if test.delivered && webhook.notifications[0] != notification {
+				t.Errorf("expected the notification %+v, got %+v", notification, webhook.notifications[0])
+			}
+			deadLetter := strings.Contains(logs.String(), "Dropping notification")
+			if deadLetter != test.deadLetter {
+				t.Errorf("expected dead letter %t, got the logs %s", test.deadLetter, logs)
+			}
+			if deadLetter && !strings.Contains(logs.String(), `\"name\":\"foo\"`) {
+				t.Errorf("expected the dead letter to hold the payload, got the logs %s", logs)
+			}
+		})
+	}
+}
+
+func TestNotifierDropsNotificationsOfAFullQueue(t *testing.T) {
+	logs := captureLogs(t)
+	config := NewDefaultControllerConfig()
+	config.NotifyURL = "http://127.0.0.1:0"
+	n := newNotifier(config)
+
+	// Nothing sends the queued notifications
+	for i := 0; i < notificationQueueSize+1; i++ {
+		n.Notify(Notification{Namespace: "default", Name: "foo", State: "down"})
+	}
+	if count := strings.Count(logs.String(), "notification queue is full"); count != 1 {
+		t.Errorf("expected a single dead letter, got %d in the logs %s", count, logs)
+	}
+}
+
+func TestNewNotifierWithoutURL(t *testing.T) {
+	if n := newNotifier(NewDefaultControllerConfig()); n != nil {
+		t.Errorf("expected no notifier without a webhook URL")
+	}
+}
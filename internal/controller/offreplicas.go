@@ -0,0 +1,124 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	apps_v1 "k8s.io/api/apps/v1"
+)
+
+// offReplicas is the parsed off-replicas annotation. It is either an
+// absolute replicas number or a percentage of the remembered replicas.
+type offReplicas struct {
+	value   int32
+	percent bool
+}
+
+// parseOffReplicas reads the off-replicas annotation of the deployment. It
+// returns nil if the deployment is scaled down to zero replicas.
+func parseOffReplicas(config ControllerConfig, deployment *apps_v1.Deployment) (*offReplicas, error) {
+	offReplicasAnnotation := config.Annotation(OFF_REPLICAS_ANNOTATION)
+	text, exists := deployment.GetAnnotations()[offReplicasAnnotation]
+	if !exists {
+		return nil, nil
+	}
+
+	text = strings.TrimSpace(text)
+	percentText, percent := strings.CutSuffix(text, "%")
+	value, err := strconv.ParseInt(strings.TrimSpace(percentText), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation '%s', expected a replicas number or a percentage (e.g. 25%%)", offReplicasAnnotation, text)
+	}
+	if value < 0 || (percent && value > 100) {
+		return nil, fmt.Errorf("invalid %s annotation '%s', expected a replicas number or a percentage between 0%% and 100%%", offReplicasAnnotation, text)
+	}
+
+	return &offReplicas{value: int32(value), percent: percent}, nil
+}
+
+// parseMinReplicas reads the min-replicas annotation of the deployment, the
+// floor of its replicas while it is scaled down. Zero is returned if the
+// annotation is not set.
+func parseMinReplicas(config ControllerConfig, deployment *apps_v1.Deployment) (int32, error) {
+	minReplicasAnnotation := config.Annotation(MIN_REPLICAS_ANNOTATION)
+	text, exists := deployment.GetAnnotations()[minReplicasAnnotation]
+	if !exists {
+		return 0, nil
+	}
+	value, err := strconv.ParseInt(strings.TrimSpace(text), 10, 32)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid %s annotation '%s', expected a non-negative replicas number", minReplicasAnnotation, text)
+	}
+	return int32(value), nil
+}
+
+// parseOnReplicas reads the on-replicas annotation of the deployment, the
+// replicas it is scaled up to instead of the remembered ones. Zero is
+// returned if the annotation is not set. The deployment must not be scaled
+// up below its min-replicas floor.
+func parseOnReplicas(config ControllerConfig, deployment *apps_v1.Deployment, minReplicas int32) (int32, error) {
+	onReplicasAnnotation := config.Annotation(ON_REPLICAS_ANNOTATION)
+	text, exists := deployment.GetAnnotations()[onReplicasAnnotation]
+	if !exists {
+		return 0, nil
+	}
+	value, err := strconv.ParseInt(strings.TrimSpace(text), 10, 32)
+	if err != nil || value < 1 {
+		return 0, fmt.Errorf("invalid %s annotation '%s', expected a positive replicas number", onReplicasAnnotation, text)
+	}
+	if int32(value) < minReplicas {
+		return 0, fmt.Errorf("invalid %s annotation '%s', expected at least the %d replicas of the %s annotation", onReplicasAnnotation, text, minReplicas, config.Annotation(MIN_REPLICAS_ANNOTATION))
+	}
+	return int32(value), nil
+}
+
+// target returns the replicas number a deployment with the remembered
+// replicas is scaled down to. Percentages are rounded down, but never to
+// zero unless 0% is requested, and the target never exceeds the remembered
+// replicas.
+func (o *offReplicas) target(remembered int32) int32 {
+	if o == nil {
+		return 0
+	}
+	if !o.percent {
+		return min(o.value, remembered)
+	}
+	target := int32(int64(remembered) * int64(o.value) / 100)
+	if target == 0 && o.value > 0 && remembered > 0 {
+		target = 1
+	}
+	return target
+}
+
+// validateScaling checks the annotations that configure how the deployment
+// is scaled, i.e. its scale-down-mode, off-replicas, min-replicas,
+// on-replicas, target and replica-windows. Invalid ones fail every toggle of
+// the deployment.
+func validateScaling(config ControllerConfig, deployment *apps_v1.Deployment) error {
+	if _, err := isPauseScaleDown(config, deployment); err != nil {
+		return err
+	}
+	if _, err := parseOffReplicas(config, deployment); err != nil {
+		return err
+	}
+	minReplicas, err := parseMinReplicas(config, deployment)
+	if err != nil {
+		return err
+	}
+	if _, err := parseOnReplicas(config, deployment, minReplicas); err != nil {
+		return err
+	}
+	if _, err := isHPATarget(config, deployment); err != nil {
+		return err
+	}
+	if hasReplicaWindows(config, deployment) {
+		if _, err := parseReplicaWindows(config, deployment); err != nil {
+			return err
+		}
+		if _, err := resolveLocation(config, deployment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
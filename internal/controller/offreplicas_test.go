@@ -0,0 +1,267 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseOffReplicas(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected *offReplicas
+		err      string
+	}{
+		{"2", &offReplicas{value: 2}, ""},
+		{"0", &offReplicas{value: 0}, ""},
+		{"25%", &offReplicas{value: 25, percent: true}, ""},
+		{" 25 % ", &offReplicas{value: 25, percent: true}, ""},
+		{"0%", &offReplicas{value: 0, percent: true}, ""},
+		{"100%", &offReplicas{value: 100, percent: true}, ""},
+		{"101%", nil, "between 0% and 100%"},
+		{"-1", nil, "between 0% and 100%"},
+		{"-5%", nil, "between 0% and 100%"},
+		{"2.5%", nil, "expected a replicas number or a percentage (e.g. 25%)"},
+		{"a quarter", nil, "expected a replicas number or a percentage (e.g. 25%)"},
+		{"%", nil, "expected a replicas number or a percentage (e.g. 25%)"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			deployment := newTestDeployment("foo", 1, map[string]string{"scheduler.off-replicas": test.text})
+			parsed, err := parseOffReplicas(NewDefaultControllerConfig(), deployment)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("expected the error '%s', got '%v'", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *parsed != *test.expected {
+				t.Errorf("expected %+v, got %+v", *test.expected, *parsed)
+			}
+		})
+	}
+}
+
+func TestOffReplicasTarget(t *testing.T) {
+	tests := []struct {
+		name       string
+		off        *offReplicas
+		remembered int32
+		target     int32
+	}{
+		{"no off-replicas", nil, 5, 0},
+		{"absolute", &offReplicas{value: 2}, 5, 2},
+		{"absolute above the remembered", &offReplicas{value: 8}, 5, 5},
+		{"quarter", &offReplicas{value: 25, percent: true}, 8, 2},
+		{"rounded down", &offReplicas{value: 25, percent: true}, 7, 1},
+		{"rounded up to one", &offReplicas{value: 25, percent: true}, 3, 1},
+		{"smallest percentage", &offReplicas{value: 1, percent: true}, 10, 1},
+		{"zero percent", &offReplicas{value: 0, percent: true}, 8, 0},
+		{"full percentage", &offReplicas{value: 100, percent: true}, 8, 8},
+		{"nothing remembered", &offReplicas{value: 50, percent: true}, 0, 0},
+		{"large deployment", &offReplicas{value: 33, percent: true}, 1000, 330},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if target := test.off.target(test.remembered); target != test.target {
+				t.Errorf("expected the target %d, got %d", test.target, target)
+			}
+		})
+	}
+}
+
+func TestToggleDeploymentToOffReplicasPercentage(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name        string
+		offReplicas string
+		replicas    int32
+		down        int32
+	}{
+		{"quarter", "25%", 8, 2},
+		{"rounded up to one", "25%", 3, 1},
+		{"zero percent", "0%", 8, 0},
+		{"full percentage", "100%", 8, 8},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			config := NewDefaultControllerConfig()
+			_, clientset := newTestController(t, config, newTestDeployment("foo", test.replicas, map[string]string{"scheduler.off-replicas": test.offReplicas}))
+			store := newReplicaStore(clientset, config)
+
+			// The deployment is scaled down to the percentage and back up to
+			// the remembered replicas
+			for _, step := range []struct {
+				state    DeploymentState
+				replicas int32
+			}{{DISABLED, test.down}, {ENABLED, test.replicas}} {
+				if _, err := toggleDeployment(ctx, clientset, config, store, "default", "foo", step.state); err != nil {
+					t.Fatal(err)
+				}
+				deployment, err := clientset.AppsV1().Deployments("default").Get(ctx, "foo", meta_v1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if *deployment.Spec.Replicas != step.replicas {
+					t.Errorf("expected %d replicas once %s, got %d", step.replicas, step.state, *deployment.Spec.Replicas)
+				}
+			}
+		})
+	}
+}
+
+func TestParseMinReplicas(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected int32
+		err      bool
+	}{
+		{"1", 1, false},
+		{" 2 ", 2, false},
+		{"0", 0, false},
+		{"-1", 0, true},
+		{"50%", 0, true},
+		{"one", 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			deployment := newTestDeployment("foo", 3, map[string]string{"scheduler.min-replicas": test.text})
+			minReplicas, err := parseMinReplicas(NewDefaultControllerConfig(), deployment)
+			if test.err != (err != nil) {
+				t.Fatalf("expected error %t, got '%v'", test.err, err)
+			}
+			if minReplicas != test.expected {
+				t.Errorf("expected %d replicas, got %d", test.expected, minReplicas)
+			}
+		})
+	}
+}
+
+func TestToggleDeploymentMinReplicasFloor(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name        string
+		replicas    int32
+		annotations map[string]string
+		down        int32
+		err         string
+	}{
+		{"floor", 4, map[string]string{"scheduler.min-replicas": "1"}, 1, ""},
+		{"zero floor", 4, map[string]string{"scheduler.min-replicas": "0"}, 0, ""},
+		{"floor above the off-replicas", 4, map[string]string{"scheduler.min-replicas": "2", "scheduler.off-replicas": "1"}, 2, ""},
+		{"floor below the off-replicas", 4, map[string]string{"scheduler.min-replicas": "1", "scheduler.off-replicas": "3"}, 3, ""},
+		{"floor above the percentage", 8, map[string]string{"scheduler.min-replicas": "3", "scheduler.off-replicas": "25%"}, 3, ""},
+		{"floor of all the replicas", 4, map[string]string{"scheduler.min-replicas": "4"}, 4, ""},
+		{"floor above the replicas", 2, map[string]string{"scheduler.min-replicas": "3"}, 2, "invalid scheduler.min-replicas annotation 3, the deployment only has 2 replicas"},
+		{"negative floor", 4, map[string]string{"scheduler.min-replicas": "-1"}, 4, "expected a non-negative replicas number"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			config := NewDefaultControllerConfig()
+			_, clientset := newTestController(t, config, newTestDeployment("foo", test.replicas, test.annotations))
+			store := newReplicaStore(clientset, config)
+
+			_, err := toggleDeployment(ctx, clientset, config, store, "default", "foo", DISABLED)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("expected the error '%s', got '%v'", test.err, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			deployment, err := clientset.AppsV1().Deployments("default").Get(ctx, "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *deployment.Spec.Replicas != test.down {
+				t.Errorf("expected %d replicas, got %d", test.down, *deployment.Spec.Replicas)
+			}
+			if test.err != "" {
+				return
+			}
+
+			// Scaling up restores the replicas the floor was applied to
+			if _, err := toggleDeployment(ctx, clientset, config, store, "default", "foo", ENABLED); err != nil {
+				t.Fatal(err)
+			}
+			deployment, err = clientset.AppsV1().Deployments("default").Get(ctx, "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *deployment.Spec.Replicas != test.replicas {
+				t.Errorf("expected %d replicas once scaled up, got %d", test.replicas, *deployment.Spec.Replicas)
+			}
+		})
+	}
+}
+
+func TestToggleDeploymentOnReplicas(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		down        int32
+		up          int32
+		err         string
+	}{
+		{"on-replicas", map[string]string{"scheduler.on-replicas": "6"}, 0, 6, ""},
+		{"below the resting replicas", map[string]string{"scheduler.on-replicas": "2"}, 0, 2, ""},
+		{"with off-replicas", map[string]string{"scheduler.on-replicas": "6", "scheduler.off-replicas": "1"}, 1, 6, ""},
+		{"with a percentage off-replicas", map[string]string{"scheduler.on-replicas": "6", "scheduler.off-replicas": "50%"}, 1, 6, ""},
+		{"with min-replicas", map[string]string{"scheduler.on-replicas": "6", "scheduler.min-replicas": "2"}, 2, 6, ""},
+		{"below min-replicas", map[string]string{"scheduler.on-replicas": "1", "scheduler.min-replicas": "2"}, 3, 3, "expected at least the 2 replicas of the scheduler.min-replicas annotation"},
+		{"zero", map[string]string{"scheduler.on-replicas": "0"}, 3, 3, "expected a positive replicas number"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			config := NewDefaultControllerConfig()
+			_, clientset := newTestController(t, config, newTestDeployment("foo", 3, test.annotations))
+			store := newReplicaStore(clientset, config)
+
+			// Two cycles, the second one starts from the on-replicas and
+			// must still remember the resting replicas
+			for cycle := 1; cycle <= 2; cycle++ {
+				for _, step := range []struct {
+					state    DeploymentState
+					replicas int32
+					resting  string
+				}{{DISABLED, test.down, ""}, {ENABLED, test.up, "3"}} {
+					_, err := toggleDeployment(ctx, clientset, config, store, "default", "foo", step.state)
+					if test.err != "" {
+						if err == nil || !strings.Contains(err.Error(), test.err) {
+							t.Fatalf("expected the error '%s', got '%v'", test.err, err)
+						}
+						return
+					}
+					if err != nil {
+						t.Fatal(err)
+					}
+					deployment, err := clientset.AppsV1().Deployments("default").Get(ctx, "foo", meta_v1.GetOptions{})
+					if err != nil {
+						t.Fatal(err)
+					}
+					if *deployment.Spec.Replicas != step.replicas {
+						t.Errorf("cycle %d: expected %d replicas once %s, got %d", cycle, step.replicas, step.state, *deployment.Spec.Replicas)
+					}
+					if resting := deployment.Annotations["scheduler.resting-replicas"]; resting != step.resting {
+						t.Errorf("cycle %d: expected the resting replicas '%s' once %s, got '%s'", cycle, step.resting, step.state, resting)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	apps_v1 "k8s.io/api/apps/v1"
+)
+
+// gitOpsMarkers are the prefixes of the labels and annotations that GitOps
+// tools set on the objects they manage. Such tools revert replica changes,
+// so the controller ends up fighting with them.
+var gitOpsMarkers = map[string]string{
+	"argocd.argoproj.io/":          "Argo CD",
+	"kustomize.toolkit.fluxcd.io/": "Flux",
+	"helm.toolkit.fluxcd.io/":      "Flux",
+}
+
+// managedBy checks if the deployment is managed by another controller. It
+// returns a description of the manager, or an empty string if the deployment
+// is not managed by anything the controller knows of.
+func managedBy(deployment *apps_v1.Deployment) string {
+	if owners := deployment.GetOwnerReferences(); len(owners) > 0 {
+		return fmt.Sprintf("%s '%s'", owners[0].Kind, owners[0].Name)
+	}
+	for _, keys := range []map[string]string{deployment.GetAnnotations(), deployment.GetLabels()} {
+		for key := range keys {
+			for prefix, manager := range gitOpsMarkers {
+				if strings.HasPrefix(key, prefix) {
+					return manager
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// isForced checks if the user opted in to schedule the deployment even
+// though it is managed by another controller.
+func isForced(config ControllerConfig, deployment *apps_v1.Deployment) bool {
+	forced, _ := ParseBoolAnnotation(deployment.GetAnnotations()[config.Annotation(FORCE_ANNOTATION)])
+	return forced
+}
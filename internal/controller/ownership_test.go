@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestManagedBy(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		labels      map[string]string
+		owners      []meta_v1.OwnerReference
+		manager     string
+	}{
+		{"unmanaged", map[string]string{"scheduler.enabled": "true"}, map[string]string{"app": "foo"}, nil, ""},
+		{"Argo CD annotation", map[string]string{"argocd.argoproj.io/tracking-id": "foo:apps/Deployment:default/foo"}, nil, nil, "Argo CD"},
+		{"Argo CD label", nil, map[string]string{"argocd.argoproj.io/instance": "foo"}, nil, "Argo CD"},
+		{"Flux kustomization label", nil, map[string]string{"kustomize.toolkit.fluxcd.io/name": "apps"}, nil, "Flux"},
+		{"Flux helm release label", nil, map[string]string{"helm.toolkit.fluxcd.io/name": "foo"}, nil, "Flux"},
+		{"similar prefix", map[string]string{"argocd.argoproj.io.example.com/note": "foo"}, nil, nil, ""},
+		{"owner reference", nil, nil, []meta_v1.OwnerReference{{Kind: "Rollout", Name: "foo"}}, "Rollout 'foo'"},
+		{"owner reference and marker", map[string]string{"argocd.argoproj.io/tracking-id": "foo"}, nil, []meta_v1.OwnerReference{{Kind: "Rollout", Name: "foo"}}, "Rollout 'foo'"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			deployment := newTestDeployment("foo", 2, test.annotations)
+			deployment.Labels = test.labels
+			deployment.OwnerReferences = test.owners
+			if manager := managedBy(deployment); manager != test.manager {
+				t.Errorf("expected the manager '%s', got '%s'", test.manager, manager)
+			}
+		})
+	}
+}
+
+func TestReconcileSkipsManagedDeployments(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		owners      []meta_v1.OwnerReference
+		replicas    int32
+	}{
+		{"unmanaged", nil, nil, 0},
+		{"Argo CD annotation", map[string]string{"argocd.argoproj.io/tracking-id": "foo"}, nil, 2},
+		{"owner reference", nil, []meta_v1.OwnerReference{{Kind: "Rollout", Name: "foo"}}, 2},
+		{"forced Argo CD annotation", map[string]string{"argocd.argoproj.io/tracking-id": "foo", "scheduler.force": "true"}, nil, 0},
+		{"forced owner reference", map[string]string{"scheduler.force": "true"}, []meta_v1.OwnerReference{{Kind: "Rollout", Name: "foo"}}, 0},
+		{"not forced", map[string]string{"argocd.argoproj.io/tracking-id": "foo", "scheduler.force": "false"}, nil, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			annotations := map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00"}
+			for key, value := range test.annotations {
+				annotations[key] = value
+			}
+			deployment := newTestDeployment("foo", 2, annotations)
+			deployment.OwnerReferences = test.owners
+			c, clientset := newTestController(t, NewDefaultControllerConfig(), deployment)
+			c.SetClock(&fakeClock{now: time.Date(2024, time.June, 3, 22, 0, 0, 0, time.UTC)})
+
+			if err := c.reconcile(context.Background(), "default/foo"); err != nil {
+				t.Fatal(err)
+			}
+			current, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *current.Spec.Replicas != test.replicas {
+				t.Errorf("expected %d replicas, got %d", test.replicas, *current.Spec.Replicas)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dimitris4000/concept02/internal/logging"
+	apps_v1 "k8s.io/api/apps/v1"
+)
+
+// pausedUntil checks if the reconcile of the deployment is paused by the
+// pause-until annotation, which lets users control the replicas by hand
+// during short maintenance. Pauses that have expired are cleared so
+// scheduling resumes.
+func (c *Controller) pausedUntil(ctx context.Context, deployment *apps_v1.Deployment) (bool, error) {
+	pauseAnnotation := c.config.Annotation(PAUSE_UNTIL_ANNOTATION)
+	text, exists := deployment.GetAnnotations()[pauseAnnotation]
+	if !exists {
+		return false, nil
+	}
+	until, err := time.Parse(time.RFC3339, strings.TrimSpace(text))
+	if err != nil {
+		return false, fmt.Errorf("invalid %s annotation '%s', expected an RFC 3339 timestamp like '2024-06-01T18:00:00Z'", pauseAnnotation, text)
+	}
+	if c.clock.Now().Before(until) {
+		return true, nil
+	}
+
+	logging.FromContext(ctx).Info(fmt.Sprintf("Pause of deployment '%s.%s' expired at %s, resuming scheduling", deployment.Namespace, deployment.Name, until.Format(time.RFC3339)))
+	return false, c.patchAnnotation(ctx, deployment, pauseAnnotation, nil)
+}
@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestReconcilePauseUntil(t *testing.T) {
+	night := time.Date(2024, time.June, 3, 22, 0, 0, 0, time.UTC)
+	noon := time.Date(2024, time.June, 4, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		replicas int32
+		memory   string
+		pause    string
+		now      time.Time
+		expected int32
+		kept     bool
+		err      string
+	}{
+		{"active pause in the window", 2, "", "2024-06-04T06:00:00Z", night, 2, true, ""},
+		{"active pause out of the window", 0, "2", "2024-06-04T18:00:00Z", noon, 0, true, ""},
+		{"active pause with an offset", 2, "", "2024-06-04T01:00:00+02:00", night, 2, true, ""},
+		{"active pause with whitespace", 2, "", " 2024-06-04T06:00:00Z ", night, 2, true, ""},
+		{"expired pause in the window", 2, "", "2024-06-03T21:00:00Z", night, 0, false, ""},
+		{"expired pause out of the window", 0, "2", "2024-06-04T11:00:00Z", noon, 2, false, ""},
+		{"pause expiring now", 2, "", "2024-06-03T22:00:00Z", night, 0, false, ""},
+		{"expired pause with an offset", 2, "", "2024-06-03T23:30:00+02:00", night, 0, false, ""},
+		{"invalid pause", 2, "", "tomorrow", night, 2, true, "invalid scheduler.pause-until annotation 'tomorrow'"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			annotations := map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00", "scheduler.pause-until": test.pause}
+			if test.memory != "" {
+				annotations["scheduler.replicas-memory"] = test.memory
+			}
+			c, clientset := newTestController(t, NewDefaultControllerConfig(), newTestDeployment("foo", test.replicas, annotations))
+			c.SetClock(&fakeClock{now: test.now})
+
+			if err := c.reconcile(context.Background(), "default/foo"); err != nil {
+				t.Fatal(err)
+			}
+			current, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *current.Spec.Replicas != test.expected {
+				t.Errorf("expected %d replicas, got %d", test.expected, *current.Spec.Replicas)
+			}
+			// Expired pauses are cleared
+			if _, kept := current.Annotations["scheduler.pause-until"]; kept != test.kept {
+				t.Errorf("expected the pause kept %t, got %t", test.kept, kept)
+			}
+			if test.err != "" && !strings.Contains(logs.String(), test.err) {
+				t.Errorf("expected the error '%s' in the logs, got %s", test.err, logs)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package controller
+
+import (
+	"context"
+
+	apps_v1 "k8s.io/api/apps/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// isGracefulScaleDown checks if the deployment has opted in the graceful
+// scale down, where replicas are stepped down respecting any
+// PodDisruptionBudget that covers the deployment's pods.
+func isGracefulScaleDown(config ControllerConfig, deployment *apps_v1.Deployment) bool {
+	graceful, _ := ParseBoolAnnotation(deployment.GetAnnotations()[config.Annotation(GRACEFUL_SCALE_DOWN_ANNOTATION)])
+	return graceful
+}
+
+// gracefulScaleDownTarget calculates the replicas number the deployment can
+// be scaled down to in one step without exceeding the allowed disruptions of
+// the PodDisruptionBudgets matching its pods. If no budget matches the
+// deployment can go straight to zero.
+func gracefulScaleDownTarget(ctx context.Context, clientset kubernetes.Interface, deployment *apps_v1.Deployment) (int32, error) {
+	pdbs, err := clientset.PolicyV1().PodDisruptionBudgets(deployment.Namespace).List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return 0, err
+	}
+
+	replicas := *deployment.Spec.Replicas
+	allowed := replicas
+	podLabels := labels.Set(deployment.Spec.Template.Labels)
+	for _, pdb := range pdbs.Items {
+		selector, err := meta_v1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil {
+			return 0, err
+		}
+		if !selector.Matches(podLabels) {
+			continue
+		}
+		allowed = min(allowed, max(pdb.Status.DisruptionsAllowed, 0))
+	}
+
+	return replicas - allowed, nil
+}
@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	apps_v1 "k8s.io/api/apps/v1"
+	core_v1 "k8s.io/api/core/v1"
+	policy_v1 "k8s.io/api/policy/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newTestPDB creates a PodDisruptionBudget selecting the pods with the app
+// label, allowing the disruptions
+func newTestPDB(namespace, name, app string, allowed int32) *policy_v1.PodDisruptionBudget {
+	return &policy_v1.PodDisruptionBudget{
+		ObjectMeta: meta_v1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: policy_v1.PodDisruptionBudgetSpec{
+			Selector: &meta_v1.LabelSelector{MatchLabels: map[string]string{"app": app}},
+		},
+		Status: policy_v1.PodDisruptionBudgetStatus{DisruptionsAllowed: allowed},
+	}
+}
+
+// newTestPodDeployment creates a deployment whose pods have the app label
+func newTestPodDeployment(name string, replicas int32, annotations map[string]string) *apps_v1.Deployment {
+	deployment := newTestDeployment(name, replicas, annotations)
+	deployment.Spec.Template = core_v1.PodTemplateSpec{ObjectMeta: meta_v1.ObjectMeta{Labels: map[string]string{"app": name}}}
+	return deployment
+}
+
+func TestGracefulScaleDownTarget(t *testing.T) {
+	tests := []struct {
+		name   string
+		pdbs   []*policy_v1.PodDisruptionBudget
+		target int32
+	}{
+		{"no budget", nil, 0},
+		{"no disruptions allowed", []*policy_v1.PodDisruptionBudget{newTestPDB("default", "foo", "foo", 0)}, 5},
+		{"some disruptions allowed", []*policy_v1.PodDisruptionBudget{newTestPDB("default", "foo", "foo", 2)}, 3},
+		{"all disruptions allowed", []*policy_v1.PodDisruptionBudget{newTestPDB("default", "foo", "foo", 5)}, 0},
+		{"more disruptions allowed than replicas", []*policy_v1.PodDisruptionBudget{newTestPDB("default", "foo", "foo", 10)}, 0},
+		{"negative disruptions allowed", []*policy_v1.PodDisruptionBudget{newTestPDB("default", "foo", "foo", -1)}, 5},
+		{"budget of other pods", []*policy_v1.PodDisruptionBudget{newTestPDB("default", "bar", "bar", 0)}, 0},
+		{"budget of another namespace", []*policy_v1.PodDisruptionBudget{newTestPDB("other", "foo", "foo", 0)}, 0},
+		{"strictest of several budgets", []*policy_v1.PodDisruptionBudget{newTestPDB("default", "foo", "foo", 3), newTestPDB("default", "all", "foo", 1)}, 4},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			for _, pdb := range test.pdbs {
+				if _, err := clientset.PolicyV1().PodDisruptionBudgets(pdb.Namespace).Create(context.Background(), pdb, meta_v1.CreateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			target, err := gracefulScaleDownTarget(context.Background(), clientset, newTestPodDeployment("foo", 5, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if target != test.target {
+				t.Errorf("expected a target of %d replicas, got %d", test.target, target)
+			}
+		})
+	}
+}
+
+func TestToggleDeploymentScalesDownGracefully(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name     string
+		allowed  []int32
+		replicas []int32
+	}{
+		{"no disruptions allowed", []int32{0, 0}, []int32{5, 5}},
+		{"stepped down", []int32{2, 2, 2}, []int32{3, 1, 0}},
+		{"more disruptions allowed than replicas", []int32{10}, []int32{0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := NewDefaultControllerConfig()
+			deployment := newTestPodDeployment("foo", 5, map[string]string{"scheduler.graceful-scale-down": "true"})
+			pdb := newTestPDB("default", "foo", "foo", 0)
+			_, clientset := newTestController(t, config, deployment)
+			if _, err := clientset.PolicyV1().PodDisruptionBudgets("default").Create(context.Background(), pdb, meta_v1.CreateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			store := newReplicaStore(clientset, config)
+
+			// Every step scales down as far as the budget allows at the time
+			for i, allowed := range test.allowed {
+				pdb.Status.DisruptionsAllowed = allowed
+				if _, err := clientset.PolicyV1().PodDisruptionBudgets("default").Update(context.Background(), pdb, meta_v1.UpdateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := toggleDeployment(context.Background(), clientset, config, store, "default", "foo", DISABLED); err != nil {
+					t.Fatal(err)
+				}
+				current, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if *current.Spec.Replicas != test.replicas[i] {
+					t.Errorf("step %d: expected %d replicas, got %d", i, test.replicas[i], *current.Spec.Replicas)
+				}
+				// The replicas are only remembered once the deployment is
+				// actually scaled down
+				if memory := current.Annotations["scheduler.replicas-memory"]; test.replicas[i] < 5 && memory != "5" {
+					t.Errorf("step %d: expected 5 replicas to be remembered, got '%s'", i, memory)
+				}
+			}
+		})
+	}
+}
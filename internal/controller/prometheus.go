@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// prometheusClient runs instant queries against the HTTP API of Prometheus
+type prometheusClient struct {
+	url    string
+	client *http.Client
+}
+
+// newPrometheusClient creates a prometheusClient for the Prometheus server
+// at baseURL, e.g. 'http://prometheus.monitoring:9090'
+func newPrometheusClient(baseURL string, client *http.Client) *prometheusClient {
+	return &prometheusClient{
+		url:    strings.TrimSuffix(baseURL, "/"),
+		client: client,
+	}
+}
+
+// prometheusResponse is the part of the responses of the query API the
+// client reads
+type prometheusResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+// Query runs an instant query and returns its value. Vector results are
+// summed up, so an empty vector (e.g. no requests were ever recorded) is
+// zero.
+func (p *prometheusClient) Query(ctx context.Context, query string) (float64, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url+"/api/v1/query?"+url.Values{"query": {query}}.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	response, err := p.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	var body prometheusResponse
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+		return 0, fmt.Errorf("invalid response of Prometheus query '%s' (status %d): %s", query, response.StatusCode, err)
+	}
+	if body.Status != "success" {
+		return 0, fmt.Errorf("Prometheus query '%s' failed: %s", query, body.Error)
+	}
+
+	switch body.Data.ResultType {
+	case "scalar":
+		var sample [2]interface{}
+		if err := json.Unmarshal(body.Data.Result, &sample); err != nil {
+			return 0, err
+		}
+		return parseSampleValue(sample[1])
+	case "vector":
+		var samples []struct {
+			Value [2]interface{} `json:"value"`
+		}
+		if err := json.Unmarshal(body.Data.Result, &samples); err != nil {
+			return 0, err
+		}
+		total := 0.0
+		for _, sample := range samples {
+			value, err := parseSampleValue(sample.Value[1])
+			if err != nil {
+				return 0, err
+			}
+			total += value
+		}
+		return total, nil
+	default:
+		return 0, fmt.Errorf("unsupported result type '%s' of Prometheus query '%s', expected a scalar or a vector", body.Data.ResultType, query)
+	}
+}
+
+// parseSampleValue parses the value of a sample, which Prometheus encodes
+// as a string
+func parseSampleValue(value interface{}) (float64, error) {
+	text, ok := value.(string)
+	if !ok {
+		return 0, fmt.Errorf("invalid sample value %v", value)
+	}
+	return strconv.ParseFloat(text, 64)
+}
@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPrometheusClientQuery(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		value  float64
+		err    string
+	}{
+		{"scalar", http.StatusOK, `{"status":"success","data":{"resultType":"scalar","result":[1717440000,"2.5"]}}`, 2.5, ""},
+		{"single sample", http.StatusOK, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1717440000,"0.2"]}]}}`, 0.2, ""},
+		{"several samples", http.StatusOK, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"pod":"a"},"value":[1717440000,"1"]},{"metric":{"pod":"b"},"value":[1717440000,"2"]}]}}`, 3, ""},
+		{"empty vector", http.StatusOK, `{"status":"success","data":{"resultType":"vector","result":[]}}`, 0, ""},
+		{"failed query", http.StatusBadRequest, `{"status":"error","errorType":"bad_data","error":"parse error"}`, 0, "failed: parse error"},
+		{"not JSON", http.StatusBadGateway, "Bad Gateway", 0, "invalid response of Prometheus query 'up' (status 502)"},
+		{"matrix", http.StatusOK, `{"status":"success","data":{"resultType":"matrix","result":[]}}`, 0, "unsupported result type 'matrix'"},
+		{"invalid sample value", http.StatusOK, `{"status":"success","data":{"resultType":"scalar","result":[1717440000,2.5]}}`, 0, "invalid sample value 2.5"},
+		{"sample value not a number", http.StatusOK, `{"status":"success","data":{"resultType":"scalar","result":[1717440000,"many"]}}`, 0, "invalid syntax"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var query string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/api/v1/query" {
+					query = r.URL.Query().Get("query")
+				}
+				w.WriteHeader(test.status)
+				w.Write([]byte(test.body))
+			}))
+			defer server.Close()
+			// A trailing slash of the base URL is ignored
+			p := newPrometheusClient(server.URL+"/", server.Client())
+
+			value, err := p.Query(context.Background(), "up")
+			if test.err == "" && err != nil {
+				t.Fatalf("expected no error, got '%s'", err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Fatalf("expected the error '%s', got '%v'", test.err, err)
+			}
+			if value != test.value {
+				t.Errorf("expected the value %g, got %g", test.value, value)
+			}
+			if query != "up" {
+				t.Errorf("expected the query 'up', got '%s'", query)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	authorization_v1 "k8s.io/api/authorization/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// rbacRequirement is a permission the controller needs, cluster wide unless
+// a namespace is set. Permissions of a feature are only needed by the
+// deployments that opt in it with their annotations.
+type rbacRequirement struct {
+	group     string
+	resource  string
+	verb      string
+	namespace string
+	feature   string
+}
+
+// rbacRequirements returns the permissions the controller needs with the
+// provided configuration.
+func rbacRequirements(config ControllerConfig) []rbacRequirement {
+	// Annotations are always patched, replicas are patched too unless the
+	// update strategy is used
+	requirements := []rbacRequirement{
+		{"apps", "deployments", "get", "", ""},
+		{"apps", "deployments", "list", "", ""},
+		{"apps", "deployments", "watch", "", ""},
+		{"apps", "deployments", "patch", "", ""},
+		{"", "configmaps", "list", "", ""},
+		{"", "configmaps", "watch", "", ""},
+		{"", "namespaces", "list", "", ""},
+		{"", "namespaces", "watch", "", ""},
+	}
+	if config.UpdateStrategy == UPDATE_STRATEGY_UPDATE {
+		requirements = append(requirements, rbacRequirement{"apps", "deployments", "update", "", ""})
+	}
+	if config.ReplicasConfigMap != "" {
+		namespace, _, _ := cache.SplitMetaNamespaceKey(config.ReplicasConfigMap)
+		requirements = append(requirements,
+			rbacRequirement{"", "configmaps", "get", namespace, ""},
+			rbacRequirement{"", "configmaps", "create", namespace, ""},
+			rbacRequirement{"", "configmaps", "patch", namespace, ""},
+		)
+	}
+	requirements = append(requirements,
+		rbacRequirement{"autoscaling", "horizontalpodautoscalers", "list", "", "the hpa target"},
+		rbacRequirement{"autoscaling", "horizontalpodautoscalers", "update", "", "the hpa target"},
+		rbacRequirement{"policy", "poddisruptionbudgets", "list", "", "graceful scale downs"},
+		rbacRequirement{"", "nodes", "list", "", "capacity-aware scale ups"},
+		rbacRequirement{"", "pods", "list", "", "capacity-aware scale ups"},
+		rbacRequirement{"apps", "deployments/scale", "get", "", "scaling through the scale subresource"},
+		rbacRequirement{"apps", "deployments/scale", "update", "", "scaling through the scale subresource"},
+	)
+	return requirements
+}
+
+// checkRBAC makes sure the controller has the permissions it needs, using
+// SelfSubjectAccessReviews. Missing permissions otherwise only show up as
+// errors on every reconcile. Since any deployment can opt in a feature, the
+// permissions of the features are checked too, but missing ones are only
+// logged as warnings.
+func checkRBAC(ctx context.Context, clientset kubernetes.Interface, config ControllerConfig) error {
+	var missing []string
+	for _, requirement := range rbacRequirements(config) {
+		review := &authorization_v1.SelfSubjectAccessReview{
+			Spec: authorization_v1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorization_v1.ResourceAttributes{
+					Group:     requirement.group,
+					Resource:  requirement.resource,
+					Verb:      requirement.verb,
+					Namespace: requirement.namespace,
+				},
+			},
+		}
+		result, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, meta_v1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("could not check the permissions of the controller: %s", err)
+		}
+		if !result.Status.Allowed {
+			scope := "cluster wide"
+			if requirement.namespace != "" {
+				scope = fmt.Sprintf("in namespace %s", requirement.namespace)
+			}
+			permission := fmt.Sprintf("%s %s %s", requirement.verb, requirement.resource, scope)
+			if requirement.feature != "" {
+				slog.Warn(fmt.Sprintf("The controller is missing the permission to %s, needed by %s", permission, requirement.feature))
+				continue
+			}
+			missing = append(missing, permission)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("the controller is missing the permissions to %s, fix its RBAC or use --skip-rbac-check", strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	authorization_v1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8s_testing "k8s.io/client-go/testing"
+)
+
+func TestCheckRBAC(t *testing.T) {
+	tests := []struct {
+		name           string
+		updateStrategy string
+		replicasStore  string
+		denied         []string
+		reviewErr      error
+		err            string
+		warning        string
+	}{
+		{"all allowed", UPDATE_STRATEGY_UPDATE, "", nil, nil, "", ""},
+		{"update denied", UPDATE_STRATEGY_UPDATE, "", []string{"update deployments"}, nil, "missing the permissions to update deployments cluster wide, fix its RBAC or use --skip-rbac-check", ""},
+		{"update not needed", UPDATE_STRATEGY_PATCH, "", []string{"update deployments"}, nil, "", ""},
+		{"several denied", UPDATE_STRATEGY_PATCH, "", []string{"watch deployments", "list namespaces"}, nil, "missing the permissions to watch deployments cluster wide, list namespaces cluster wide", ""},
+		{"replicas ConfigMap denied", UPDATE_STRATEGY_PATCH, "scheduler/replicas", []string{"create configmaps"}, nil, "missing the permissions to create configmaps in namespace scheduler", ""},
+		{"feature denied", UPDATE_STRATEGY_UPDATE, "", []string{"update horizontalpodautoscalers"}, nil, "", "missing the permission to update horizontalpodautoscalers cluster wide, needed by the hpa target"},
+		{"review failed", UPDATE_STRATEGY_UPDATE, "", nil, errors.New("forbidden"), "could not check the permissions of the controller: forbidden", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			clientset := fake.NewSimpleClientset()
+			clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+				if test.reviewErr != nil {
+					return true, nil, test.reviewErr
+				}
+				review := action.(k8s_testing.CreateAction).GetObject().(*authorization_v1.SelfSubjectAccessReview)
+				attributes := review.Spec.ResourceAttributes
+				review.Status.Allowed = true
+				for _, denied := range test.denied {
+					if denied == attributes.Verb+" "+attributes.Resource {
+						review.Status.Allowed = false
+					}
+				}
+				return true, review, nil
+			})
+			config := NewDefaultControllerConfig()
+			config.UpdateStrategy = test.updateStrategy
+			config.ReplicasConfigMap = test.replicasStore
+
+			err := checkRBAC(context.Background(), clientset, config)
+			if test.err == "" && err != nil {
+				t.Errorf("expected no error, got '%s'", err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("expected the error '%s', got '%v'", test.err, err)
+			}
+			if test.warning != "" && !strings.Contains(logs.String(), test.warning) {
+				t.Errorf("expected the warning '%s', got the logs %s", test.warning, logs)
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/dimitris4000/concept02/internal/logging"
+	apps_v1 "k8s.io/api/apps/v1"
+	core_v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ReplicaStore keeps the replicas that deployments are scaled back up to
+// while they are scaled down. Stores can keep the replicas on the deployment
+// object itself, which is written to the k8s API after Remember is called
+// and before Forget is called.
+type ReplicaStore interface {
+	// Remember stores the replicas of a deployment being scaled down
+	Remember(ctx context.Context, deployment *apps_v1.Deployment, replicas int32) error
+	// Recall returns the stored replicas of a deployment, false if there
+	// are none, i.e. the deployment is not scaled down
+	Recall(ctx context.Context, deployment *apps_v1.Deployment) (int32, bool, error)
+	// Forget drops the replicas of a deployment that was scaled back up
+	Forget(ctx context.Context, deployment *apps_v1.Deployment) error
+}
+
+// newReplicaStore returns the ReplicaStore of the configuration: the
+// configured store, the replicas ConfigMap or else the replicas memory
+// annotation of the deployments. Deployments scaled down with the annotation
+// before another store was configured are still restored from it.
+func newReplicaStore(clientset kubernetes.Interface, config ControllerConfig) ReplicaStore {
+	annotations := annotationReplicaStore{clientset: clientset, config: config}
+	if config.ReplicaStore != nil {
+		return legacyReplicaStore{store: config.ReplicaStore, annotations: annotations}
+	}
+	if config.ReplicasConfigMap != "" {
+		namespace, name, _ := cache.SplitMetaNamespaceKey(config.ReplicasConfigMap)
+		store := configMapReplicaStore{clientset: clientset, config: config, namespace: namespace, name: name}
+		return legacyReplicaStore{store: store, annotations: annotations}
+	}
+	return annotations
+}
+
+// annotationReplicaStore keeps the replicas in the replicas memory
+// annotation of the deployments themselves, so they are written together
+// with the replicas of the deployment
+type annotationReplicaStore struct {
+	clientset kubernetes.Interface
+	config    ControllerConfig
+}
+
+func (s annotationReplicaStore) Remember(ctx context.Context, deployment *apps_v1.Deployment, replicas int32) error {
+	if deployment.Annotations == nil {
+		deployment.Annotations = map[string]string{}
+	}
+	deployment.Annotations[s.config.Annotation(REPLICAS_MEMORY_ANNOTATION)] = strconv.Itoa(int(replicas))
+	return nil
+}
+
+func (s annotationReplicaStore) Recall(ctx context.Context, deployment *apps_v1.Deployment) (int32, bool, error) {
+	value, exists := deployment.GetAnnotations()[s.config.Annotation(REPLICAS_MEMORY_ANNOTATION)]
+	if !exists {
+		return 0, false, nil
+	}
+	return rememberedReplicas(s.config, value, fmt.Sprintf("deployment '%s.%s'", deployment.Namespace, deployment.Name)), true, nil
+}
+
+// Forget removes the annotation from the deployment, which was already
+// written with the restored replicas, using a merge patch
+func (s annotationReplicaStore) Forget(ctx context.Context, deployment *apps_v1.Deployment) error {
+	memoryAnnotation := s.config.Annotation(REPLICAS_MEMORY_ANNOTATION)
+	if _, exists := deployment.GetAnnotations()[memoryAnnotation]; !exists {
+		return nil
+	}
+	err := PatchDeploymentAnnotations(ctx, s.clientset, deployment.Namespace, deployment.Name, map[string]*string{memoryAnnotation: nil})
+	if err != nil {
+		return err
+	}
+	delete(deployment.Annotations, memoryAnnotation)
+	return nil
+}
+
+// legacyReplicaStore wraps the stores that keep the replicas outside of the
+// deployments. A replicas memory annotation left on a deployment, e.g. from
+// before the store was configured, takes precedence and is moved to the
+// store on the next scale down, or removed once the deployment is restored.
+type legacyReplicaStore struct {
+	store       ReplicaStore
+	annotations annotationReplicaStore
+}
+
+func (s legacyReplicaStore) Remember(ctx context.Context, deployment *apps_v1.Deployment, replicas int32) error {
+	if err := s.store.Remember(ctx, deployment, replicas); err != nil {
+		return fmt.Errorf("could not store the replicas of deployment '%s.%s': %w", deployment.Namespace, deployment.Name, err)
+	}
+	delete(deployment.Annotations, s.annotations.config.Annotation(REPLICAS_MEMORY_ANNOTATION))
+	return nil
+}
+
+func (s legacyReplicaStore) Recall(ctx context.Context, deployment *apps_v1.Deployment) (int32, bool, error) {
+	if replicas, remembered, _ := s.annotations.Recall(ctx, deployment); remembered {
+		return replicas, true, nil
+	}
+	return s.store.Recall(ctx, deployment)
+}
+
+func (s legacyReplicaStore) Forget(ctx context.Context, deployment *apps_v1.Deployment) error {
+	if err := s.store.Forget(ctx, deployment); err != nil {
+		return fmt.Errorf("could not drop the stored replicas of deployment '%s.%s': %w", deployment.Namespace, deployment.Name, err)
+	}
+	return s.annotations.Forget(ctx, deployment)
+}
+
+// configMapReplicaStore keeps the replicas of all the deployments in a
+// single ConfigMap under '<namespace>.<deployment>' keys, so the scaled
+// deployments carry no annotation that GitOps tools would report as drift.
+// The ConfigMap is created on first use.
+type configMapReplicaStore struct {
+	clientset kubernetes.Interface
+	config    ControllerConfig
+	namespace string
+	name      string
+}
+
+func (s configMapReplicaStore) Remember(ctx context.Context, deployment *apps_v1.Deployment, replicas int32) error {
+	value := strconv.Itoa(int(replicas))
+	key := deployment.Namespace + "." + deployment.Name
+	err := s.patch(ctx, key, &value)
+	if !apierrors.IsNotFound(err) {
+		return err
+	}
+	configMap := &core_v1.ConfigMap{
+		ObjectMeta: meta_v1.ObjectMeta{Namespace: s.namespace, Name: s.name},
+		Data:       map[string]string{key: value},
+	}
+	_, err = s.clientset.CoreV1().ConfigMaps(s.namespace).Create(ctx, configMap, meta_v1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		// Created concurrently by another deployment's scale down
+		return s.patch(ctx, key, &value)
+	}
+	return err
+}
+
+func (s configMapReplicaStore) Recall(ctx context.Context, deployment *apps_v1.Deployment) (int32, bool, error) {
+	configMap, err := s.clientset.CoreV1().ConfigMaps(s.namespace).Get(ctx, s.name, meta_v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	value, exists := configMap.Data[deployment.Namespace+"."+deployment.Name]
+	if !exists {
+		return 0, false, nil
+	}
+	return rememberedReplicas(s.config, value, fmt.Sprintf("deployment '%s.%s' in ConfigMap '%s'", deployment.Namespace, deployment.Name, s.config.ReplicasConfigMap)), true, nil
+}
+
+func (s configMapReplicaStore) Forget(ctx context.Context, deployment *apps_v1.Deployment) error {
+	err := s.patch(ctx, deployment.Namespace+"."+deployment.Name, nil)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// patch sets a key of the ConfigMap using a merge patch, a nil value
+// removes the key
+func (s configMapReplicaStore) patch(ctx context.Context, key string, value *string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"data": map[string]*string{key: value},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.clientset.CoreV1().ConfigMaps(s.namespace).Patch(ctx, s.name, types.MergePatchType, patch, meta_v1.PatchOptions{})
+	return err
+}
+
+// MemoryReplicaStore keeps the replicas in memory, so they are lost when the
+// process exits. It allows using the scaling logic without a store in the
+// cluster, e.g. in tests.
+type MemoryReplicaStore struct {
+	mutex    sync.Mutex
+	replicas map[string]int32
+}
+
+// NewMemoryReplicaStore creates an empty MemoryReplicaStore
+func NewMemoryReplicaStore() *MemoryReplicaStore {
+	return &MemoryReplicaStore{replicas: map[string]int32{}}
+}
+
+func (s *MemoryReplicaStore) Remember(ctx context.Context, deployment *apps_v1.Deployment, replicas int32) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.replicas[deployment.Namespace+"/"+deployment.Name] = replicas
+	return nil
+}
+
+func (s *MemoryReplicaStore) Recall(ctx context.Context, deployment *apps_v1.Deployment) (int32, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	replicas, remembered := s.replicas[deployment.Namespace+"/"+deployment.Name]
+	return replicas, remembered, nil
+}
+
+func (s *MemoryReplicaStore) Forget(ctx context.Context, deployment *apps_v1.Deployment) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.replicas, deployment.Namespace+"/"+deployment.Name)
+	return nil
+}
+
+// withRememberedReplicas copies the replicas remembered outside of the
+// deployment into the replicas memory annotation of a copy of the informer's
+// object, so the checks of the controller work the same whatever the store.
+// The replicas ConfigMap is read from the informer's cache. Replicas that can
+// not be recalled are logged and left out.
+func (c *Controller) withRememberedReplicas(ctx context.Context, deployment *apps_v1.Deployment) *apps_v1.Deployment {
+	memoryAnnotation := c.config.Annotation(REPLICAS_MEMORY_ANNOTATION)
+	if _, exists := deployment.GetAnnotations()[memoryAnnotation]; exists {
+		return deployment
+	}
+	if c.config.ReplicaStore != nil {
+		replicas, remembered, err := c.replicas.Recall(ctx, deployment)
+		if err != nil {
+			logging.FromContext(ctx).Warn(fmt.Sprintf("Failed to recall the replicas of deployment '%s.%s': %s", deployment.Namespace, deployment.Name, err))
+			return deployment
+		}
+		if !remembered {
+			return deployment
+		}
+		deployment = deployment.DeepCopy()
+		if deployment.ObjectMeta.Annotations == nil {
+			deployment.ObjectMeta.Annotations = map[string]string{}
+		}
+		deployment.ObjectMeta.Annotations[memoryAnnotation] = strconv.Itoa(int(replicas))
+		return deployment
+	}
+	if c.config.ReplicasConfigMap == "" {
+		return deployment
+	}
+	obj, exists, err := c.configMapInformer.GetIndexer().GetByKey(c.config.ReplicasConfigMap)
+	if err != nil || !exists {
+		return deployment
+	}
+	configMap, ok := obj.(*core_v1.ConfigMap)
+	if !ok {
+		return deployment
+	}
+	value, exists := configMap.Data[deployment.Namespace+"."+deployment.Name]
+	if !exists {
+		return deployment
+	}
+
+	deployment = deployment.DeepCopy()
+	if deployment.ObjectMeta.Annotations == nil {
+		deployment.ObjectMeta.Annotations = map[string]string{}
+	}
+	deployment.ObjectMeta.Annotations[memoryAnnotation] = value
+	return deployment
+}
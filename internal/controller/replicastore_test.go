@@ -0,0 +1,204 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	apps_v1 "k8s.io/api/apps/v1"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMemoryReplicaStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryReplicaStore()
+	foo := newTestDeployment("foo", 3, nil)
+	other := newTestDeployment("foo", 3, nil)
+	other.Namespace = "other"
+
+	steps := []struct {
+		name       string
+		apply      func() error
+		deployment *apps_v1.Deployment
+		replicas   int32
+		remembered bool
+	}{
+		{"nothing remembered", func() error { return nil }, foo, 0, false},
+		{"remembered", func() error { return store.Remember(ctx, foo, 3) }, foo, 3, true},
+		{"same name in another namespace", func() error { return nil }, other, 0, false},
+		{"remembered again", func() error { return store.Remember(ctx, foo, 5) }, foo, 5, true},
+		{"other deployment remembered", func() error { return store.Remember(ctx, other, 2) }, foo, 5, true},
+		{"forgotten", func() error { return store.Forget(ctx, foo) }, foo, 0, false},
+		{"other deployment kept", func() error { return nil }, other, 2, true},
+		{"forgotten twice", func() error { return store.Forget(ctx, foo) }, foo, 0, false},
+	}
+	for _, step := range steps {
+		if err := step.apply(); err != nil {
+			t.Fatalf("%s: %s", step.name, err)
+		}
+		replicas, remembered, err := store.Recall(ctx, step.deployment)
+		if err != nil {
+			t.Fatalf("%s: %s", step.name, err)
+		}
+		if replicas != step.replicas || remembered != step.remembered {
+			t.Errorf("%s: expected %d replicas (remembered %t), got %d (remembered %t)", step.name, step.replicas, step.remembered, replicas, remembered)
+		}
+	}
+}
+
+func TestToggleDeploymentWithMemoryReplicaStore(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name        string
+		replicas    int32
+		annotations map[string]string
+		stored      int32
+		state       DeploymentState
+		expected    int32
+		remembered  bool
+	}{
+		{"scale down", 3, nil, 0, DISABLED, 0, true},
+		{"scale up", 0, nil, 3, ENABLED, 3, false},
+		{"scale up without stored replicas", 0, nil, 0, ENABLED, 0, false},
+		{"scale up from a legacy annotation", 0, map[string]string{"scheduler.replicas-memory": "4"}, 0, ENABLED, 4, false},
+		{"legacy annotation takes precedence", 0, map[string]string{"scheduler.replicas-memory": "4"}, 3, ENABLED, 4, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			memory := NewMemoryReplicaStore()
+			config := NewDefaultControllerConfig()
+			config.ReplicaStore = memory
+			deployment := newTestDeployment("foo", test.replicas, test.annotations)
+			_, clientset := newTestController(t, config, deployment)
+			if test.stored > 0 {
+				if err := memory.Remember(ctx, deployment, test.stored); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if _, err := toggleDeployment(ctx, clientset, config, newReplicaStore(clientset, config), "default", "foo", test.state); err != nil {
+				t.Fatal(err)
+			}
+			current, err := clientset.AppsV1().Deployments("default").Get(ctx, "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *current.Spec.Replicas != test.expected {
+				t.Errorf("expected %d replicas, got %d", test.expected, *current.Spec.Replicas)
+			}
+			// The replicas are kept out of the deployment
+			if value, exists := current.Annotations["scheduler.replicas-memory"]; exists {
+				t.Errorf("expected no replicas memory annotation, got '%s'", value)
+			}
+			replicas, remembered, err := memory.Recall(ctx, current)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if remembered != test.remembered || (remembered && replicas != test.replicas) {
+				t.Errorf("expected remembered %t, got %d replicas (remembered %t)", test.remembered, replicas, remembered)
+			}
+		})
+	}
+}
+
+func TestReplicaStoresRoundTrip(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name        string
+		configMap   string
+		existing    map[string]string
+		annotations map[string]string
+		legacy      int32
+	}{
+		{"annotation", "", nil, nil, 0},
+		{"new ConfigMap", "scheduler/replicas", nil, nil, 0},
+		{"existing ConfigMap", "scheduler/replicas", map[string]string{"default.bar": "5"}, nil, 0},
+		{"legacy annotation", "scheduler/replicas", nil, map[string]string{"scheduler.replicas-memory": "4"}, 4},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			config := NewDefaultControllerConfig()
+			config.ReplicasConfigMap = test.configMap
+			deployment := newTestDeployment("foo", 3, test.annotations)
+			_, clientset := newTestController(t, config, deployment)
+			if test.existing != nil {
+				configMap := &core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Namespace: "scheduler", Name: "replicas"}, Data: test.existing}
+				if _, err := clientset.CoreV1().ConfigMaps("scheduler").Create(ctx, configMap, meta_v1.CreateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			store := newReplicaStore(clientset, config)
+			// The stores write to the deployment object, which is written to
+			// the k8s API with the replicas
+			write := func() error {
+				var err error
+				deployment, err = clientset.AppsV1().Deployments("default").Update(ctx, deployment, meta_v1.UpdateOptions{})
+				return err
+			}
+
+			steps := []struct {
+				name       string
+				apply      func() error
+				replicas   int32
+				remembered bool
+			}{
+				{"before the scale down", func() error { return nil }, test.legacy, test.legacy > 0},
+				{"remembered", func() error {
+					if err := store.Remember(ctx, deployment, 3); err != nil {
+						return err
+					}
+					return write()
+				}, 3, true},
+				{"remembered again", func() error {
+					if err := store.Remember(ctx, deployment, 6); err != nil {
+						return err
+					}
+					return write()
+				}, 6, true},
+				{"forgotten", func() error { return store.Forget(ctx, deployment) }, 0, false},
+				{"forgotten twice", func() error { return store.Forget(ctx, deployment) }, 0, false},
+			}
+			for _, step := range steps {
+				if err := step.apply(); err != nil {
+					t.Fatalf("%s: %s", step.name, err)
+				}
+				current, err := clientset.AppsV1().Deployments("default").Get(ctx, "foo", meta_v1.GetOptions{})
+				if err != nil {
+					t.Fatalf("%s: %s", step.name, err)
+				}
+				replicas, remembered, err := store.Recall(ctx, current)
+				if err != nil {
+					t.Fatalf("%s: %s", step.name, err)
+				}
+				if replicas != step.replicas || remembered != step.remembered {
+					t.Errorf("%s: expected %d replicas (remembered %t), got %d (remembered %t)", step.name, step.replicas, step.remembered, replicas, remembered)
+				}
+				// Once remembered, the ConfigMap store keeps the deployment
+				// clean of the annotation
+				_, annotated := current.Annotations["scheduler.replicas-memory"]
+				if expected := (test.configMap == "" && step.remembered) || (step.name == "before the scale down" && test.legacy > 0); annotated != expected {
+					t.Errorf("%s: expected annotated %t, got %v", step.name, expected, current.Annotations)
+				}
+			}
+
+			if test.configMap == "" {
+				return
+			}
+			// Entries of other deployments are left alone
+			configMap, err := clientset.CoreV1().ConfigMaps("scheduler").Get(ctx, "replicas", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, exists := configMap.Data["default.foo"]; exists {
+				t.Errorf("expected the entry of the deployment to be removed, got %v", configMap.Data)
+			}
+			if len(configMap.Data) != len(test.existing) {
+				t.Errorf("expected the entries %v, got %v", test.existing, configMap.Data)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dimitris4000/concept02/internal/logging"
+	apps_v1 "k8s.io/api/apps/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// ReplicaWindow is a time range during which a scaled up deployment runs a
+// specific number of replicas instead of its resting ones
+type ReplicaWindow struct {
+	Range    TimeRange
+	Replicas int32
+}
+
+// String returns the window in the format accepted by ParseReplicaWindows
+func (w ReplicaWindow) String() string {
+	return fmt.Sprintf("%s=%d", w.Range, w.Replicas)
+}
+
+// ReplicaWindows are the rules of the replica-windows annotation, in order
+// of precedence
+type ReplicaWindows []ReplicaWindow
+
+// ParseReplicaWindows parses a comma separated list of '<window>=<replicas>'
+// rules, where the window has the format of ParseSchedule, e.g.
+// '22:00-06:00=2, weekdays 09:00-17:00=10'. The replicas must be positive.
+func ParseReplicaWindows(text string) (ReplicaWindows, error) {
+	var windows ReplicaWindows
+	for _, token := range strings.Split(text, ",") {
+		token = strings.TrimSpace(token)
+		if token == "" {
+			continue
+		}
+		rangeText, replicasText, found := strings.Cut(token, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid replica window '%s', expected the '<window>=<replicas>' format (e.g. 09:00-17:00=10)", token)
+		}
+		timeRange, err := ParseSchedule(rangeText)
+		if err != nil {
+			return nil, fmt.Errorf("invalid replica window '%s': %s", token, err)
+		}
+		replicas, err := strconv.ParseInt(strings.TrimSpace(replicasText), 10, 32)
+		if err != nil || replicas < 1 {
+			return nil, fmt.Errorf("invalid replica window '%s', expected a positive replicas number", token)
+		}
+		windows = append(windows, ReplicaWindow{Range: timeRange, Replicas: int32(replicas)})
+	}
+	if len(windows) == 0 {
+		return nil, fmt.Errorf("expected at least one replica window")
+	}
+	return windows, nil
+}
+
+// Match returns the first of the windows in range at now, in the provided
+// location. Earlier windows take precedence over later ones that overlap
+// them. False is returned if no window is in range.
+func (w ReplicaWindows) Match(now time.Time, location *time.Location) (ReplicaWindow, bool) {
+	if location != nil {
+		now = now.In(location)
+	}
+	for _, window := range w {
+		if window.Range.InRange(now) {
+			return window, true
+		}
+	}
+	return ReplicaWindow{}, false
+}
+
+// hasReplicaWindows checks if the deployment has the replica-windows
+// annotation
+func hasReplicaWindows(config ControllerConfig, deployment *apps_v1.Deployment) bool {
+	_, exists := deployment.GetAnnotations()[config.Annotation(REPLICA_WINDOWS_ANNOTATION)]
+	return exists
+}
+
+// parseReplicaWindows reads the replica-windows annotation of the
+// deployment. It can not be combined with the on-replicas annotation, which
+// is the single window equivalent, nor with the hpa target, and none of the
+// windows may go below the min-replicas floor.
+func parseReplicaWindows(config ControllerConfig, deployment *apps_v1.Deployment) (ReplicaWindows, error) {
+	windowsAnnotation := config.Annotation(REPLICA_WINDOWS_ANNOTATION)
+	windows, err := ParseReplicaWindows(deployment.GetAnnotations()[windowsAnnotation])
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %s", windowsAnnotation, err)
+	}
+	if _, exists := deployment.GetAnnotations()[config.Annotation(ON_REPLICAS_ANNOTATION)]; exists {
+		return nil, fmt.Errorf("invalid %s annotation: it can not be combined with the %s annotation", windowsAnnotation, config.Annotation(ON_REPLICAS_ANNOTATION))
+	}
+	if hpa, err := isHPATarget(config, deployment); err != nil || hpa {
+		return nil, fmt.Errorf("invalid %s annotation: it is not supported by the %s target", windowsAnnotation, TARGET_HPA)
+	}
+	minReplicas, err := parseMinReplicas(config, deployment)
+	if err != nil {
+		return nil, err
+	}
+	for _, window := range windows {
+		if window.Replicas < minReplicas {
+			return nil, fmt.Errorf("invalid %s annotation: window '%s' is below the %d replicas of the %s annotation", windowsAnnotation, window, minReplicas, config.Annotation(MIN_REPLICAS_ANNOTATION))
+		}
+	}
+	return windows, nil
+}
+
+// applyReplicaWindows scales a deployment that is up to the replicas of its
+// replica window in range, evaluated in the time zone of its schedule. The
+// resting replicas are kept in the resting-replicas annotation while a
+// window is in range, and restored once none is. It reports whether the
+// deployment was scaled.
+func (c *Controller) applyReplicaWindows(ctx context.Context, deployment *apps_v1.Deployment) (bool, error) {
+	windows, err := parseReplicaWindows(c.config, deployment)
+	if err != nil {
+		return false, err
+	}
+	location, err := resolveLocation(c.config, deployment)
+	if schedule, scheduleErr := c.resolveSchedule(deployment); scheduleErr == nil {
+		location, err = schedule.Location, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	window, inWindow := windows.Match(c.clock.Now(), location)
+
+	ctx, cancel := context.WithTimeout(ctx, c.config.APITimeout)
+	defer cancel()
+	reason := "resting replicas"
+	if inWindow {
+		reason = fmt.Sprintf("replica window %s", window)
+	}
+	ctx = WithAuditSource(ctx, AUDIT_ACTOR_SCHEDULE, reason)
+	return scaleToReplicaWindow(ctx, c.clientset, c.config, c.replicas, deployment.Namespace, deployment.Name, window, inWindow)
+}
+
+// scaleToReplicaWindow sets the replicas of the deployment to the ones of
+// the window, or back to its resting replicas if none is in range. The
+// function will retry the change if it conflicts.
+func scaleToReplicaWindow(ctx context.Context, clientset kubernetes.Interface, config ControllerConfig, store ReplicaStore, namespace, name string, window ReplicaWindow, inWindow bool) (bool, error) {
+	scaled := false
+	restingAnnotation := config.Annotation(RESTING_REPLICAS_ANNOTATION)
+	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		deployment, err := clientset.AppsV1().Deployments(namespace).Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("Failed to get latest version of Deployment: %w", err)
+		}
+		// A deployment scaled down in the meantime is left to the next
+		// reconcile
+		_, remembered, err := store.Recall(ctx, deployment)
+		if err != nil || remembered && !isRestored(config, deployment.GetAnnotations()) {
+			return err
+		}
+		original := deployment.DeepCopy()
+		if deployment.Annotations == nil {
+			deployment.Annotations = map[string]string{}
+		}
+
+		// Without the annotation the deployment is at its resting replicas
+		resting := *deployment.Spec.Replicas
+		value, exists := deployment.Annotations[restingAnnotation]
+		if exists {
+			resting = rememberedReplicas(config, value, fmt.Sprintf("deployment '%s.%s'", namespace, name))
+		}
+		if inWindow {
+			deployment.Annotations[restingAnnotation] = strconv.Itoa(int(resting))
+			deployment.Spec.Replicas = int32Ptr(window.Replicas)
+		} else {
+			delete(deployment.Annotations, restingAnnotation)
+			deployment.Spec.Replicas = int32Ptr(resting)
+		}
+
+		scaled = *deployment.Spec.Replicas != *original.Spec.Replicas
+		if scaled {
+			logging.FromContext(ctx).Info(fmt.Sprintf("Scaling deployment '%s.%s' from %d to %d replicas", namespace, name, *original.Spec.Replicas, *deployment.Spec.Replicas))
+		}
+		return updateDeploymentIfChanged(ctx, clientset, config, original, deployment)
+	})
+	if retryErr != nil {
+		return false, fmt.Errorf("Update failed: %w", retryErr)
+	}
+	return scaled, nil
+}
@@ -0,0 +1,270 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseReplicaWindows(t *testing.T) {
+	tests := []struct {
+		text     string
+		windows  []string
+		replicas []int32
+		err      bool
+	}{
+		{"09:00-17:00=10", []string{"09:00-17:00"}, []int32{10}, false},
+		{"22:00-06:00=2, MTuWThF 09:00-17:00=10", []string{"22:00-06:00", "MTuWThF 09:00-17:00"}, []int32{2, 10}, false},
+		{" 09:00-17:00 = 10 ,", []string{"09:00-17:00"}, []int32{10}, false},
+		{"09:00:30-17:00:00=1", []string{"09:00:30-17:00:00"}, []int32{1}, false},
+		{"", nil, nil, true},
+		{",", nil, nil, true},
+		{"09:00-17:00", nil, nil, true},
+		{"09:00=10", nil, nil, true},
+		{"09:00-09:00=10", nil, nil, true},
+		{"09:00-17:00=0", nil, nil, true},
+		{"09:00-17:00=-1", nil, nil, true},
+		{"09:00-17:00=ten", nil, nil, true},
+		{"09:00-17:00=10, 18:00-19:00", nil, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			windows, err := ParseReplicaWindows(test.text)
+			if test.err {
+				if err == nil {
+					t.Errorf("expected an error, got %v", windows)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got '%s'", err)
+			}
+			if len(windows) != len(test.windows) {
+				t.Fatalf("expected %d windows, got %v", len(test.windows), windows)
+			}
+			for i, window := range windows {
+				if window.Range.String() != test.windows[i] || window.Replicas != test.replicas[i] {
+					t.Errorf("expected window %s=%d, got %s", test.windows[i], test.replicas[i], window)
+				}
+			}
+		})
+	}
+}
+
+func TestReplicaWindowsMatch(t *testing.T) {
+	windows, err := ParseReplicaWindows("22:00-06:00=2, MTuWThF 09:00-17:00=10, 12:00-13:00=20, 09:00-10:00=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	athens, err := LoadLocation("Europe/Athens")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2024-06-03 is a Monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, time.June, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name     string
+		now      time.Time
+		location *time.Location
+		matched  bool
+		replicas int32
+	}{
+		{"single window", at(3, 23, 0), nil, true, 2},
+		{"window past midnight", at(4, 3, 0), nil, true, 2},
+		{"earlier window over overlapping later one", at(3, 12, 30), nil, true, 10},
+		{"earlier window over overlapping later one at its start", at(3, 9, 0), nil, true, 10},
+		{"later window when the earlier one is off that day", at(8, 12, 30), nil, true, 20},
+		{"last window when the others are off that day", at(8, 9, 30), nil, true, 5},
+		{"window end", at(3, 17, 0), nil, false, 0},
+		{"no window", at(3, 18, 0), nil, false, 0},
+		{"no window on that day", at(8, 15, 0), nil, false, 0},
+		{"window in the location", at(3, 6, 30), athens, true, 10},
+		{"no window in the location", at(3, 14, 30), athens, false, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			window, matched := windows.Match(test.now, test.location)
+			if matched != test.matched {
+				t.Fatalf("expected matched %t, got %t with %s", test.matched, matched, window)
+			}
+			if window.Replicas != test.replicas {
+				t.Errorf("expected %d replicas, got %s", test.replicas, window)
+			}
+		})
+	}
+}
+
+func TestParseReplicaWindowsAnnotation(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		err         bool
+	}{
+		{"valid", map[string]string{"scheduler.replica-windows": "09:00-17:00=10"}, false},
+		{"above the min replicas", map[string]string{"scheduler.replica-windows": "09:00-17:00=10", "scheduler.min-replicas": "10"}, false},
+		{"invalid windows", map[string]string{"scheduler.replica-windows": "09:00-17:00"}, true},
+		{"with on replicas", map[string]string{"scheduler.replica-windows": "09:00-17:00=10", "scheduler.on-replicas": "5"}, true},
+		{"with the hpa target", map[string]string{"scheduler.replica-windows": "09:00-17:00=10", "scheduler.target": "hpa"}, true},
+		{"below the min replicas", map[string]string{"scheduler.replica-windows": "22:00-06:00=2, 09:00-17:00=10", "scheduler.min-replicas": "3"}, true},
+	}
+
+	config := NewDefaultControllerConfig()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseReplicaWindows(config, newTestDeployment("foo", 3, test.annotations))
+			if test.err && err == nil {
+				t.Errorf("expected an error")
+			}
+			if !test.err && err != nil {
+				t.Errorf("expected no error, got '%s'", err)
+			}
+		})
+	}
+}
+
+func TestScaleToReplicaWindow(t *testing.T) {
+	discardLogs(t)
+	config := NewDefaultControllerConfig()
+	c, clientset := newTestController(t, config,
+		newTestDeployment("foo", 3, nil),
+		newTestDeployment("down", 0, map[string]string{"scheduler.replicas-memory": "3"}),
+	)
+	ctx := context.Background()
+	peak := ReplicaWindow{Range: TimeRange{}, Replicas: 10}
+	night := ReplicaWindow{Range: TimeRange{}, Replicas: 2}
+
+	steps := []struct {
+		name     string
+		window   ReplicaWindow
+		inWindow bool
+		scaled   bool
+		replicas int32
+		resting  string
+	}{
+		{"entering a window", peak, true, true, 10, "3"},
+		{"staying in the window", peak, true, false, 10, "3"},
+		{"moving to another window", night, true, true, 2, "3"},
+		{"leaving the windows", ReplicaWindow{}, false, true, 3, ""},
+		{"staying out of the windows", ReplicaWindow{}, false, false, 3, ""},
+	}
+	for _, step := range steps {
+		scaled, err := scaleToReplicaWindow(ctx, clientset, config, c.replicas, "default", "foo", step.window, step.inWindow)
+		if err != nil {
+			t.Fatalf("%s: %s", step.name, err)
+		}
+		if scaled != step.scaled {
+			t.Errorf("%s: expected scaled %t, got %t", step.name, step.scaled, scaled)
+		}
+		deployment, err := clientset.AppsV1().Deployments("default").Get(ctx, "foo", meta_v1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *deployment.Spec.Replicas != step.replicas {
+			t.Errorf("%s: expected %d replicas, got %d", step.name, step.replicas, *deployment.Spec.Replicas)
+		}
+		if resting := deployment.Annotations["scheduler.resting-replicas"]; resting != step.resting {
+			t.Errorf("%s: expected resting replicas '%s', got '%s'", step.name, step.resting, resting)
+		}
+	}
+
+	// Scaled down deployments are left alone
+	scaled, err := scaleToReplicaWindow(ctx, clientset, config, c.replicas, "default", "down", peak, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deployment, err := clientset.AppsV1().Deployments("default").Get(ctx, "down", meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scaled || *deployment.Spec.Replicas != 0 {
+		t.Errorf("expected the scaled down deployment to be left alone, got %d replicas", *deployment.Spec.Replicas)
+	}
+}
+
+// replicaWindowStep is the state a deployment is expected in after a
+// reconcile at now
+type replicaWindowStep struct {
+	now        time.Time
+	replicas   int32
+	remembered string
+}
+
+func TestReconcileAppliesReplicaWindows(t *testing.T) {
+	discardLogs(t)
+	// 2024-06-03 is a Monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, time.June, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		steps       []replicaWindowStep
+	}{
+		{
+			name: "without an off-schedule",
+			annotations: map[string]string{
+				"scheduler.enabled":         "true",
+				"scheduler.replica-windows": "22:00-06:00=2, 09:00-17:00=10",
+			},
+			steps: []replicaWindowStep{
+				{at(3, 8, 0), 3, ""},
+				{at(3, 9, 0), 10, ""},
+				{at(3, 17, 0), 3, ""},
+				{at(3, 22, 0), 2, ""},
+				{at(4, 6, 0), 3, ""},
+			},
+		},
+		{
+			name: "with an off-schedule",
+			annotations: map[string]string{
+				"scheduler.enabled":         "true",
+				"scheduler.off-schedule":    "20:00-08:00",
+				"scheduler.replica-windows": "09:00-17:00=10, 19:00-21:00=5",
+			},
+			steps: []replicaWindowStep{
+				{at(3, 12, 0), 10, ""},
+				{at(3, 18, 0), 3, ""},
+				{at(3, 19, 30), 5, ""},
+				{at(3, 20, 30), 0, "3"},
+				{at(4, 8, 0), 3, ""},
+				{at(4, 9, 0), 10, ""},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, clientset := newTestController(t, NewDefaultControllerConfig(), newTestDeployment("foo", 3, test.annotations))
+			clock := &fakeClock{}
+			c.SetClock(clock)
+			// The steps run in order, each one reconciling the outcome of
+			// the previous one
+			for _, step := range test.steps {
+				clock.Set(step.now)
+				if err := c.reconcile(context.Background(), "default/foo"); err != nil {
+					t.Fatalf("at %s: %s", step.now.Format(time.DateTime), err)
+				}
+				syncCache(t, c, clientset)
+
+				deployment, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if *deployment.Spec.Replicas != step.replicas {
+					t.Errorf("at %s: expected %d replicas, got %d", step.now.Format(time.DateTime), step.replicas, *deployment.Spec.Replicas)
+				}
+				// The resting replicas are remembered, not the ones of the
+				// replica window
+				if remembered := deployment.Annotations["scheduler.replicas-memory"]; remembered != step.remembered {
+					t.Errorf("at %s: expected remembered replicas '%s', got '%s'", step.now.Format(time.DateTime), step.remembered, remembered)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	apps_v1 "k8s.io/api/apps/v1"
+)
+
+// restoreAll scales the deployments the controller has scaled down back up
+// to their remembered replicas. It is used on shutdown so uninstalling the
+// controller does not leave deployments down forever. The restore is bounded
+// by the configured RestoreTimeout.
+func (c *Controller) restoreAll() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.RestoreTimeout)
+	defer cancel()
+
+	for _, obj := range c.deploymentInformer.GetIndexer().List() {
+		deployment, ok := obj.(*apps_v1.Deployment)
+		if !ok {
+			continue
+		}
+		deployment = c.withRememberedReplicas(ctx, deployment)
+		// Deployments are scheduled by their annotations or by the schedule
+		// ConfigMap
+		if !c.isScheduled(deployment) {
+			continue
+		}
+		if _, remembered := scaledDownMemory(c.config, deployment.GetAnnotations()); !remembered {
+			continue
+		}
+
+		slog.Info(fmt.Sprintf("Restoring deployment %s/%s on shutdown", deployment.Namespace, deployment.Name))
+		_, err := toggleDeployment(WithAuditSource(ctx, AUDIT_ACTOR_SHUTDOWN, "restore on shutdown"), c.clientset, c.config, c.replicas, deployment.Namespace, deployment.Name, ENABLED)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Failed to restore deployment %s/%s on shutdown: %s", deployment.Namespace, deployment.Name, err))
+		}
+		if ctx.Err() != nil {
+			slog.Error("Timed out restoring the deployments on shutdown")
+			return
+		}
+	}
+}
+
+// Done returns a channel that is closed once the controller has stopped,
+// including the restore of the deployments on shutdown.
+func (c *Controller) Done() <-chan struct{} {
+	return c.done
+}
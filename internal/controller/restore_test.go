@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	apps_v1 "k8s.io/api/apps/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRestoreAll(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name        string
+		replicas    int32
+		annotations map[string]string
+		labels      map[string]string
+		expected    int32
+	}{
+		{"scaled down", 0, map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00", "scheduler.replicas-memory": "3"}, nil, 3},
+		{"partially scaled down", 1, map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00", "scheduler.off-replicas": "1", "scheduler.replicas-memory": "4"}, nil, 4},
+		{"up", 2, map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00"}, nil, 2},
+		{"already restored", 3, map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00", "scheduler.replicas-memory": "3", "scheduler.last-restored-replicas": "3@2024-06-04T08:00:00Z"}, nil, 3},
+		{"not enabled", 0, map[string]string{"scheduler.off-schedule": "20:00-08:00", "scheduler.replicas-memory": "3"}, nil, 0},
+		{"ignored label", 0, map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00", "scheduler.replicas-memory": "3"}, map[string]string{"app.kubernetes.io/managed-by": "Helm"}, 0},
+	}
+
+	deployments := make([]*apps_v1.Deployment, 0, len(tests))
+	for _, test := range tests {
+		deployment := newTestDeployment(strings.ReplaceAll(test.name, " ", "-"), test.replicas, test.annotations)
+		deployment.Labels = test.labels
+		deployments = append(deployments, deployment)
+	}
+	c, clientset := newTestController(t, NewDefaultControllerConfig(), deployments...)
+	ignoredLabels, err := parseIgnoreLabels("app.kubernetes.io/managed-by=Helm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ignoredLabels = ignoredLabels
+
+	c.restoreAll()
+	for _, test := range tests {
+		deployment, err := clientset.AppsV1().Deployments("default").Get(context.Background(), strings.ReplaceAll(test.name, " ", "-"), meta_v1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *deployment.Spec.Replicas != test.expected {
+			t.Errorf("%s: expected %d replicas, got %d", test.name, test.expected, *deployment.Spec.Replicas)
+		}
+	}
+}
+
+func TestRestoreAllTimesOut(t *testing.T) {
+	logs := captureLogs(t)
+	annotations := map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00", "scheduler.replicas-memory": "3"}
+	config := NewDefaultControllerConfig()
+	config.RestoreTimeout = 0
+	c, clientset := newTestController(t, config, newTestDeployment("foo", 0, annotations), newTestDeployment("bar", 0, annotations))
+
+	// The fake clientset ignores the expired context, so the first
+	// deployment is restored before the timeout is noticed
+	c.restoreAll()
+	restored := 0
+	for _, name := range []string{"foo", "bar"} {
+		deployment, err := clientset.AppsV1().Deployments("default").Get(context.Background(), name, meta_v1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *deployment.Spec.Replicas != 0 {
+			restored++
+		}
+	}
+	if restored != 1 {
+		t.Errorf("expected the restore to stop after the first deployment, got %d restored", restored)
+	}
+	if count := strings.Count(logs.String(), "Timed out restoring the deployments on shutdown"); count != 1 {
+		t.Errorf("expected the timeout to be logged once, got the logs %s", logs)
+	}
+}
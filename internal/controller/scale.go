@@ -0,0 +1,153 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/dimitris4000/concept02/internal/logging"
+	"k8s.io/apimachinery/pkg/api/meta"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/scale"
+	"k8s.io/client-go/util/retry"
+)
+
+// ScaleClients holds the clients needed to scale any resource that supports
+// the scale subresource (Deployments, StatefulSets, ReplicaSets,
+// ReplicationControllers and CRDs).
+type ScaleClients struct {
+	Scales   scale.ScalesGetter
+	Metadata metadata.Interface
+	Mapper   meta.RESTMapper
+}
+
+// LoadK8SScaleClients initializes the ScaleClients object using the same
+// configuration sources with LoadK8SClientConfigFile.
+func LoadK8SScaleClients(controllerConfig ControllerConfig) (*ScaleClients, error) {
+	config, err := loadK8SRestConfig(controllerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	cachedDiscovery := memory.NewMemCacheClient(discoveryClient)
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(cachedDiscovery)
+	scales, err := scale.NewForConfig(config, mapper, dynamic.LegacyAPIPathResolverFunc, scale.NewDiscoveryScaleKindResolver(cachedDiscovery))
+	if err != nil {
+		return nil, err
+	}
+	metadataClient, err := metadata.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ScaleClients{
+		Scales:   scales,
+		Metadata: metadataClient,
+		Mapper:   mapper,
+	}, nil
+}
+
+// ToggleScalable "disables" or "enables" any resource that supports the scale
+// subresource, by changing its replicas through the scale subresource. Like
+// ToggleDeployment, the replicas number is remembered in an annotation on the
+// target object, which is kept on restore with KeepReplicasMemory. The
+// function will retry the change if it conflicts.
+func ToggleScalable(ctx context.Context, clients *ScaleClients, config ControllerConfig, resource schema.GroupResource, namespace, name string, targetState DeploymentState) error {
+	gvr, err := clients.Mapper.ResourceFor(resource.WithVersion(""))
+	if err != nil {
+		return err
+	}
+	memoryAnnotation := config.Annotation(REPLICAS_MEMORY_ANNOTATION)
+	restoredAnnotation := config.Annotation(LAST_RESTORED_ANNOTATION)
+	objects := clients.Metadata.Resource(gvr).Namespace(namespace)
+
+	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		object, err := objects.Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("Failed to get latest version of %s: %v", resource, err)
+		}
+		scaleObj, err := clients.Scales.Scales(namespace).Get(ctx, resource, name, meta_v1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("Failed to get scale of %s: %v", resource, err)
+		}
+
+		replicas := scaleObj.Spec.Replicas
+		if targetState == DISABLED {
+			if replicas == 0 {
+				return nil
+			}
+			// Memorize current replicas number before scaling, so it is
+			// never lost
+			value := strconv.Itoa(int(replicas))
+			if _, restored := object.GetAnnotations()[restoredAnnotation]; restored || object.GetAnnotations()[memoryAnnotation] != value {
+				err = patchObjectAnnotations(ctx, objects, name, map[string]*string{memoryAnnotation: &value, restoredAnnotation: nil})
+				if err != nil {
+					return err
+				}
+			}
+			logging.FromContext(ctx).Info(fmt.Sprintf("Scaling down %s '%s.%s'\n", resource, namespace, name))
+			scaleObj.Spec.Replicas = 0
+			_, err = clients.Scales.Scales(namespace).Update(ctx, resource, scaleObj, meta_v1.UpdateOptions{})
+			if err != nil {
+				return err
+			}
+			audit(ctx, config, resource.String(), namespace, name, replicas, 0)
+			return nil
+		}
+
+		if replicas != 0 {
+			return nil
+		}
+		value, exists := scaledDownMemory(config, object.GetAnnotations())
+		if !exists {
+			return nil
+		}
+		logging.FromContext(ctx).Info(fmt.Sprintf("Scaling up %s '%s.%s'\n", resource, namespace, name))
+		scaleObj.Spec.Replicas = rememberedReplicas(config, value, fmt.Sprintf("%s '%s.%s'", resource, namespace, name))
+		_, err = clients.Scales.Scales(namespace).Update(ctx, resource, scaleObj, meta_v1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+		audit(ctx, config, resource.String(), namespace, name, 0, scaleObj.Spec.Replicas)
+		annotations := map[string]*string{memoryAnnotation: nil}
+		if config.KeepReplicasMemory {
+			kept := strconv.Itoa(int(scaleObj.Spec.Replicas))
+			restored := lastRestoredReplicas(scaleObj.Spec.Replicas, config.now())
+			annotations = map[string]*string{memoryAnnotation: &kept, restoredAnnotation: &restored}
+		}
+		return patchObjectAnnotations(ctx, objects, name, annotations)
+	})
+	if retryErr != nil {
+		return fmt.Errorf("Update failed: %v", retryErr)
+	}
+
+	return nil
+}
+
+// patchObjectAnnotations is the PatchDeploymentAnnotations equivalent for
+// objects accessed through the metadata client.
+func patchObjectAnnotations(ctx context.Context, objects metadata.ResourceInterface, name string, annotations map[string]*string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = objects.Patch(ctx, name, types.MergePatchType, patch, meta_v1.PatchOptions{})
+	return err
+}
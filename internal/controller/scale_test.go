@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	autoscaling_v1 "k8s.io/api/autoscaling/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	metadata_fake "k8s.io/client-go/metadata/fake"
+	scale_fake "k8s.io/client-go/scale/fake"
+	k8s_testing "k8s.io/client-go/testing"
+)
+
+var statefulSets = schema.GroupResource{Group: "apps", Resource: "statefulsets"}
+
+// newTestScaleClients creates the ScaleClients of a fake statefulset with
+// the annotations, whose scale subresource holds the replicas. The returned
+// function reads the current replicas of the scale subresource.
+func newTestScaleClients(replicas int32, annotations map[string]string) (*ScaleClients, *metadata_fake.FakeMetadataClient, func() int32) {
+	scheme := metadata_fake.NewTestScheme()
+	meta_v1.AddMetaToScheme(scheme)
+	object := &meta_v1.PartialObjectMetadata{
+		TypeMeta:   meta_v1.TypeMeta{APIVersion: "apps/v1", Kind: "StatefulSet"},
+		ObjectMeta: meta_v1.ObjectMeta{Namespace: "default", Name: "foo", Annotations: annotations},
+	}
+	metadataClient := metadata_fake.NewSimpleMetadataClient(scheme, object)
+
+	scales := &scale_fake.FakeScaleClient{}
+	scales.AddReactor("get", "statefulsets", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+		return true, &autoscaling_v1.Scale{
+			ObjectMeta: meta_v1.ObjectMeta{Namespace: "default", Name: "foo"},
+			Spec:       autoscaling_v1.ScaleSpec{Replicas: replicas},
+		}, nil
+	})
+	scales.AddReactor("update", "statefulsets", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+		scaleObj := action.(k8s_testing.UpdateAction).GetObject().(*autoscaling_v1.Scale)
+		replicas = scaleObj.Spec.Replicas
+		return true, scaleObj, nil
+	})
+
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{{Group: "apps", Version: "v1"}})
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "StatefulSet"}, meta.RESTScopeNamespace)
+
+	clients := &ScaleClients{Scales: scales, Metadata: metadataClient, Mapper: mapper}
+	return clients, metadataClient, func() int32 { return replicas }
+}
+
+func TestToggleScalable(t *testing.T) {
+	discardLogs(t)
+	now := time.Date(2024, time.June, 4, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		replicas    int32
+		annotations map[string]string
+		state       DeploymentState
+		keep        bool
+		expected    int32
+		memory      string
+		restored    string
+	}{
+		{"scale down", 3, nil, DISABLED, false, 0, "3", ""},
+		{"scale down a restored object", 3, map[string]string{"scheduler.replicas-memory": "2", "scheduler.last-restored-replicas": "2@2024-06-03T08:00:00Z"}, DISABLED, false, 0, "3", ""},
+		{"already scaled down", 0, map[string]string{"scheduler.replicas-memory": "3"}, DISABLED, false, 0, "3", ""},
+		{"scale up", 0, map[string]string{"scheduler.replicas-memory": "3"}, ENABLED, false, 3, "", ""},
+		{"scale up keeping the memory", 0, map[string]string{"scheduler.replicas-memory": "3"}, ENABLED, true, 3, "3", "3@2024-06-04T08:00:00Z"},
+		{"scale up a corrupt memory", 0, map[string]string{"scheduler.replicas-memory": "many"}, ENABLED, false, 1, "", ""},
+		{"scale up without a memory", 0, nil, ENABLED, false, 0, "", ""},
+		{"already scaled up", 2, map[string]string{"scheduler.replicas-memory": "3"}, ENABLED, false, 2, "3", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := NewDefaultControllerConfig()
+			config.KeepReplicasMemory = test.keep
+			config.Clock = &fakeClock{now: now}
+			clients, metadataClient, replicas := newTestScaleClients(test.replicas, test.annotations)
+
+			if err := ToggleScalable(context.Background(), clients, config, statefulSets, "default", "foo", test.state); err != nil {
+				t.Fatal(err)
+			}
+			if replicas() != test.expected {
+				t.Errorf("expected %d replicas, got %d", test.expected, replicas())
+			}
+			object, err := metadataClient.Resource(statefulSets.WithVersion("v1")).Namespace("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if memory := object.Annotations["scheduler.replicas-memory"]; memory != test.memory {
+				t.Errorf("expected the replicas memory '%s', got '%s'", test.memory, memory)
+			}
+			if restored := object.Annotations["scheduler.last-restored-replicas"]; restored != test.restored {
+				t.Errorf("expected the last restored replicas '%s', got '%s'", test.restored, restored)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package controller
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Schedule is the fully resolved off-schedule of a deployment. It combines
+// the daily TimeRange with the time zone it is evaluated in and the dates on
+// which it does not apply. Schedules in the JSON form may have additional
+// off-windows in Windows. On OffDays the schedule is in range all day.
+type Schedule struct {
+	Range      TimeRange
+	Windows    []TimeRange
+	Location   *time.Location
+	Exceptions []DateRange
+	OffDays    Weekdays
+}
+
+// ZeroLengthWindowError is returned by ParseSchedule for a window ending
+// when it starts (e.g. '09:00-09:00')
+type ZeroLengthWindowError struct {
+	Window string
+}
+
+func (e ZeroLengthWindowError) Error() string {
+	return fmt.Sprintf("invalid schedule '%s', the window has zero length", e.Window)
+}
+
+// WindowTooLongError is returned by CheckWindowLength for a window longer
+// than the maximum length
+type WindowTooLongError struct {
+	Window TimeRange
+	Max    time.Duration
+}
+
+func (e WindowTooLongError) Error() string {
+	return fmt.Sprintf("window '%s' lasts %s, longer than the maximum of %s", e.Window, e.Window.Length(), e.Max)
+}
+
+// CheckWindowLength returns a WindowTooLongError for the first window of
+// the schedule longer than max. Windows crossing midnight are measured up
+// to their end on the next day. Zero max disables the check.
+func (s Schedule) CheckWindowLength(max time.Duration) error {
+	if max <= 0 {
+		return nil
+	}
+	for _, timeRange := range s.Ranges() {
+		if timeRange.Length() > max {
+			return WindowTooLongError{Window: timeRange, Max: max}
+		}
+	}
+	return nil
+}
+
+// Ranges returns all the off-windows of the schedule, starting with Range
+func (s Schedule) Ranges() []TimeRange {
+	return append([]TimeRange{s.Range}, s.Windows...)
+}
+
+// String returns the off-windows of the schedule separated by commas
+func (s Schedule) String() string {
+	var windows []string
+	for _, timeRange := range s.Ranges() {
+		windows = append(windows, timeRange.String())
+	}
+	return strings.Join(windows, ", ")
+}
+
+// InRange checks if the provided time falls in the off-schedule. The time is
+// converted to the schedule's location first. On exception dates the
+// schedule is skipped for the whole day, as judged by the date of now, so a
+// window crossing midnight into an exception date ends at midnight.
+func (s Schedule) InRange(now time.Time) bool {
+	_, inRange := s.Match(now)
+	return inRange
+}
+
+// Match returns the off-window the provided time falls in, following the
+// same rules with InRange. False is returned if no window is in range. Off
+// days are matched before the windows, with a zero TimeRange.
+func (s Schedule) Match(now time.Time) (TimeRange, bool) {
+	if s.Location != nil {
+		now = now.In(s.Location)
+	}
+	for _, exception := range s.Exceptions {
+		if exception.Contains(now) {
+			return TimeRange{}, false
+		}
+	}
+	if s.OffDays != 0 && s.OffDays.Contains(now.Weekday()) {
+		return TimeRange{}, true
+	}
+	for _, timeRange := range s.Ranges() {
+		if timeRange.InRange(now) {
+			return timeRange, true
+		}
+	}
+	return TimeRange{}, false
+}
+
+// transitionSearchDays bounds how far NextTransition looks ahead, long
+// enough to get past exception ranges of several months.
+const transitionSearchDays = 366
+
+// NextTransition returns the next time after now at which the deployment
+// changes state according to the schedule, together with the state it
+// changes to. The state only changes around the boundaries of the range and
+// around midnight (for the days and exceptions), so only these times are
+// checked. A zero time is returned if the state never changes.
+func (s Schedule) NextTransition(now time.Time) (time.Time, DeploymentState) {
+	if s.Location != nil {
+		now = now.In(s.Location)
+	}
+	inRange := s.InRange(now)
+	boundaries := []time.Duration{0}
+	step := time.Minute
+	for _, timeRange := range s.Ranges() {
+		boundaries = append(boundaries, clockOffset(timeRange.Start), clockOffset(timeRange.End))
+		if timeRange.Seconds {
+			step = time.Second
+		}
+	}
+	sort.Slice(boundaries, func(i, j int) bool { return boundaries[i] < boundaries[j] })
+
+	for day := 0; day <= transitionSearchDays; day++ {
+		date := now.AddDate(0, 0, day)
+		for _, boundary := range boundaries {
+			boundary = boundary.Truncate(step)
+			candidate := zonedClock(date.Year(), date.Month(), date.Day(), boundary, now.Location())
+			// A boundary with a finer precision than the range takes
+			// effect on the minute (or second) after it
+			for _, t := range []time.Time{candidate, candidate.Add(step)} {
+				if t.After(now) && s.InRange(t) != inRange {
+					if inRange {
+						return t, ENABLED
+					}
+					return t, DISABLED
+				}
+			}
+		}
+	}
+
+	if inRange {
+		return time.Time{}, DISABLED
+	}
+	return time.Time{}, ENABLED
+}
+
+// Length returns the duration of the time range. Ranges crossing midnight
+// are measured up to their End on the next day.
+func (t TimeRange) Length() time.Duration {
+	length := clockOffset(t.End) - clockOffset(t.Start)
+	if length < 0 {
+		length += 24 * time.Hour
+	}
+	return length
+}
+
+// WithJitter returns a copy of the time range with both boundaries moved
+// inward by an offset between 0 and jitter. The offset is derived from the
+// seed (e.g. the deployment's key) so it is stable across reconciles while
+// different deployments get different offsets. Boundaries only move inward
+// so the deployment is never scaled down outside the intended window.
+func (t TimeRange) WithJitter(jitter time.Duration, seed string) TimeRange {
+	seconds := uint64(jitter / time.Second)
+	if seconds == 0 {
+		return t
+	}
+	hash := fnv.New64a()
+	hash.Write([]byte(seed))
+	offset := time.Duration(hash.Sum64()%seconds) * time.Second
+
+	t.Start = shiftClock(t.Start, offset)
+	t.End = shiftClock(t.End, -offset)
+	return t
+}
+
+// clockOffset returns the time passed since the midnight of t's date
+func clockOffset(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+}
+
+// shiftClock moves the time of day of t by d, wrapping around midnight so
+// the date of t is kept. This keeps shifted TimeRange values comparable.
+func shiftClock(t time.Time, d time.Duration) time.Time {
+	offset := (clockOffset(t) + d) % (24 * time.Hour)
+	if offset < 0 {
+		offset += 24 * time.Hour
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()).Add(offset)
+}
+
+// DateRange is an inclusive range of calendar dates. Single dates are
+// represented with the same Start and End.
+type DateRange struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Contains checks if the date of t, in t's location, is within the range
+func (d DateRange) Contains(t time.Time) bool {
+	date := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return !date.Before(d.Start) && !date.After(d.End)
+}
+
+// String returns the range in the format accepted by ParseExceptions
+func (d DateRange) String() string {
+	if d.Start.Equal(d.End) {
+		return d.Start.Format("2006-01-02")
+	}
+	return d.Start.Format("2006-01-02") + "/" + d.End.Format("2006-01-02")
+}
+
+// ParseExceptions parses a comma separated list of dates ('2006-01-02') and
+// date ranges ('2006-01-02/2006-01-05') on which a schedule does not apply.
+func ParseExceptions(exceptionsText string) ([]DateRange, error) {
+	var exceptions []DateRange
+	for _, token := range strings.Split(exceptionsText, ",") {
+		token = strings.TrimSpace(token)
+		if token == "" {
+			continue
+		}
+
+		dates := strings.Split(token, "/")
+		if len(dates) > 2 {
+			return nil, fmt.Errorf("invalid exception '%s', expected format 'YYYY-MM-DD' or 'YYYY-MM-DD/YYYY-MM-DD'", token)
+		}
+		start, err := time.Parse("2006-01-02", strings.TrimSpace(dates[0]))
+		if err != nil {
+			return nil, err
+		}
+		end := start
+		if len(dates) == 2 {
+			end, err = time.Parse("2006-01-02", strings.TrimSpace(dates[1]))
+			if err != nil {
+				return nil, err
+			}
+			if end.Before(start) {
+				return nil, fmt.Errorf("invalid exception '%s', range ends before it starts", token)
+			}
+		}
+		exceptions = append(exceptions, DateRange{start, end})
+	}
+
+	return exceptions, nil
+}
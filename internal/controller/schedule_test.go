@@ -0,0 +1,388 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestScheduleNextTransition(t *testing.T) {
+	// 2024-06-03 is a Monday
+	at := func(day, hour, minute, second int) time.Time {
+		return time.Date(2024, time.June, day, hour, minute, second, 0, time.UTC)
+	}
+	everyDay := NewWeekdays(time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday)
+	tests := []struct {
+		name     string
+		schedule string
+		offDays  Weekdays
+		now      time.Time
+		next     time.Time
+		state    DeploymentState
+	}{
+		{"window start", "20:00-08:00", 0, at(3, 12, 0, 0), at(3, 20, 0, 0), DISABLED},
+		{"window end after midnight", "20:00-08:00", 0, at(3, 22, 0, 0), at(4, 8, 0, 0), ENABLED},
+		{"on the window start", "20:00-08:00", 0, at(3, 20, 0, 0), at(4, 8, 0, 0), ENABLED},
+		{"right before the window end", "20:00-08:00", 0, at(4, 7, 59, 59), at(4, 8, 0, 0), ENABLED},
+		{"window starting at midnight", "00:00-06:00", 0, at(3, 23, 0, 0), at(4, 0, 0, 0), DISABLED},
+		{"window ending at midnight", "18:00-00:00", 0, at(3, 19, 0, 0), at(4, 0, 0, 0), ENABLED},
+		{"window with seconds", "12:00:30-13:00:00", 0, at(3, 12, 0, 0), at(3, 12, 0, 30), DISABLED},
+		{"before multiple windows", `{"windows":["00:00-06:00","12:00-13:00"]}`, 0, at(3, 7, 0, 0), at(3, 12, 0, 0), DISABLED},
+		{"inside second window", `{"windows":["00:00-06:00","12:00-13:00"]}`, 0, at(3, 12, 30, 0), at(3, 13, 0, 0), ENABLED},
+		{"after the last window of the day", `{"windows":["00:00-06:00","12:00-13:00"]}`, 0, at(3, 14, 0, 0), at(4, 0, 0, 0), DISABLED},
+		{"adjacent windows", `{"windows":["10:00-12:00","12:00-14:00"]}`, 0, at(3, 11, 0, 0), at(3, 14, 0, 0), ENABLED},
+		{"last weekday window", "MTuWThF 20:00-08:00", 0, at(7, 22, 0, 0), at(8, 8, 0, 0), ENABLED},
+		{"over the weekend", "MTuWThF 20:00-08:00", 0, at(8, 12, 0, 0), at(10, 20, 0, 0), DISABLED},
+		{"JSON days", `{"windows":["12:00-13:00"],"days":["weekends"]}`, 0, at(3, 12, 0, 0), at(8, 12, 0, 0), DISABLED},
+		{"timezone", `{"windows":["20:00-08:00"],"timezone":"Europe/Athens"}`, 0, at(3, 12, 0, 0), at(3, 17, 0, 0), DISABLED},
+		{"exception date", `{"windows":["20:00-08:00"],"exceptions":["2024-06-03"]}`, 0, at(3, 12, 0, 0), at(4, 0, 0, 0), DISABLED},
+		{"exception range", `{"windows":["12:00-13:00"],"exceptions":["2024-06-03/2024-06-05"]}`, 0, at(3, 10, 0, 0), at(6, 12, 0, 0), DISABLED},
+		{"off day start", "12:00-13:00", NewWeekdays(time.Saturday), at(7, 14, 0, 0), at(8, 0, 0, 0), DISABLED},
+		{"off day end", "12:00-13:00", NewWeekdays(time.Saturday), at(8, 10, 0, 0), at(9, 0, 0, 0), ENABLED},
+		{"off every day", "12:00-13:00", everyDay, at(3, 10, 0, 0), time.Time{}, DISABLED},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schedule, err := ParseScheduleSpec(test.schedule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			schedule.OffDays = test.offDays
+			next, state := schedule.NextTransition(test.now)
+			if !next.Equal(test.next) {
+				t.Errorf("expected the next transition at %s, got %s", test.next, next)
+			}
+			if state != test.state {
+				t.Errorf("expected state %s, got %s", test.state, state)
+			}
+		})
+	}
+}
+
+func TestScheduleNextTransitionReturnsScheduleLocation(t *testing.T) {
+	schedule, err := ParseScheduleSpec(`{"windows":["20:00-08:00"],"timezone":"Europe/Athens"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, _ := schedule.NextTransition(time.Date(2024, time.June, 3, 12, 0, 0, 0, time.UTC))
+	if next.Location() != schedule.Location {
+		t.Errorf("expected the transition in %s, got %s", schedule.Location, next.Location())
+	}
+	if next.Hour() != 20 || next.Minute() != 0 {
+		t.Errorf("expected the transition at 20:00 local time, got %s", next.Format(CLOCK_LAYOUT_MINUTES))
+	}
+}
+
+func TestParseExceptions(t *testing.T) {
+	date := func(month time.Month, day int) time.Time { return time.Date(2024, month, day, 0, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name       string
+		text       string
+		exceptions []DateRange
+		valid      bool
+	}{
+		{"empty", "", nil, true},
+		{"single date", "2024-12-25", []DateRange{{date(time.December, 25), date(time.December, 25)}}, true},
+		{"date range", "2024-12-24/2024-12-26", []DateRange{{date(time.December, 24), date(time.December, 26)}}, true},
+		{"dates and ranges", " 2024-12-25 , 2024-12-31/2025-01-01,", []DateRange{{date(time.December, 25), date(time.December, 25)}, {date(time.December, 31), time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)}}, true},
+		{"single day range", "2024-12-25/2024-12-25", []DateRange{{date(time.December, 25), date(time.December, 25)}}, true},
+		{"invalid date", "2024-12-32", nil, false},
+		{"time of day", "2024-12-25T00:00:00Z", nil, false},
+		{"reversed range", "2024-12-26/2024-12-24", nil, false},
+		{"too many dates", "2024-12-24/2024-12-25/2024-12-26", nil, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			exceptions, err := ParseExceptions(test.text)
+			if (err == nil) != test.valid {
+				t.Fatalf("expected valid %t, got the error %v", test.valid, err)
+			}
+			if len(exceptions) != len(test.exceptions) {
+				t.Fatalf("expected the exceptions %v, got %v", test.exceptions, exceptions)
+			}
+			for i := range exceptions {
+				if !exceptions[i].Start.Equal(test.exceptions[i].Start) || !exceptions[i].End.Equal(test.exceptions[i].End) {
+					t.Errorf("expected the exceptions %v, got %v", test.exceptions, exceptions)
+				}
+			}
+		})
+	}
+}
+
+func TestScheduleInRangeAroundExceptions(t *testing.T) {
+	at := func(day, hour, minute, second int) time.Time {
+		return time.Date(2024, time.December, day, hour, minute, second, 0, time.UTC)
+	}
+	tests := []struct {
+		name     string
+		schedule string
+		now      time.Time
+		inRange  bool
+	}{
+		{"window before the exception date", `{"windows":["20:00-08:00"],"exceptions":["2024-12-25"]}`, at(24, 23, 59, 59), true},
+		{"midnight of the exception date", `{"windows":["20:00-08:00"],"exceptions":["2024-12-25"]}`, at(25, 0, 0, 0), false},
+		{"morning of the exception date", `{"windows":["20:00-08:00"],"exceptions":["2024-12-25"]}`, at(25, 7, 0, 0), false},
+		{"window of the exception date", `{"windows":["20:00-08:00"],"exceptions":["2024-12-25"]}`, at(25, 23, 59, 59), false},
+		{"midnight after the exception date", `{"windows":["20:00-08:00"],"exceptions":["2024-12-25"]}`, at(26, 0, 0, 0), true},
+		{"first date of an exception range", `{"windows":["20:00-08:00"],"exceptions":["2024-12-24/2024-12-26"]}`, at(24, 21, 0, 0), false},
+		{"after an exception range", `{"windows":["20:00-08:00"],"exceptions":["2024-12-24/2024-12-26"]}`, at(27, 0, 30, 0), true},
+		// Europe/Athens is UTC+2 in December
+		{"exception date started in the time zone", `{"windows":["20:00-08:00"],"timezone":"Europe/Athens","exceptions":["2024-12-25"]}`, at(24, 22, 30, 0), false},
+		{"exception date not started in the time zone", `{"windows":["20:00-08:00"],"timezone":"Europe/Athens","exceptions":["2024-12-25"]}`, at(24, 21, 30, 0), true},
+		{"exception date ended in the time zone", `{"windows":["20:00-08:00"],"timezone":"Europe/Athens","exceptions":["2024-12-25"]}`, at(25, 22, 30, 0), true},
+		// America/New_York is UTC-5 in December
+		{"exception date not ended in the time zone", `{"windows":["20:00-08:00"],"timezone":"America/New_York","exceptions":["2024-12-25"]}`, at(26, 3, 0, 0), false},
+		{"exception date ended later in the time zone", `{"windows":["20:00-08:00"],"timezone":"America/New_York","exceptions":["2024-12-25"]}`, at(26, 5, 0, 0), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schedule, err := ParseScheduleSpec(test.schedule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inRange := schedule.InRange(test.now); inRange != test.inRange {
+				t.Errorf("expected in range %t at %s, got %t", test.inRange, test.now, inRange)
+			}
+		})
+	}
+}
+
+func TestScheduleNextTransitionAroundExceptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule string
+		now      time.Time
+		next     time.Time
+		state    DeploymentState
+	}{
+		{"window cut at the midnight of the exception date", `{"windows":["20:00-08:00"],"exceptions":["2024-12-25"]}`, time.Date(2024, time.December, 24, 22, 0, 0, 0, time.UTC), time.Date(2024, time.December, 25, 0, 0, 0, 0, time.UTC), ENABLED},
+		{"window resumed at the midnight after the exception date", `{"windows":["20:00-08:00"],"exceptions":["2024-12-25"]}`, time.Date(2024, time.December, 25, 12, 0, 0, 0, time.UTC), time.Date(2024, time.December, 26, 0, 0, 0, 0, time.UTC), DISABLED},
+		{"midnight of the time zone", `{"windows":["20:00-08:00"],"timezone":"Europe/Athens","exceptions":["2024-12-25"]}`, time.Date(2024, time.December, 24, 20, 0, 0, 0, time.UTC), time.Date(2024, time.December, 24, 22, 0, 0, 0, time.UTC), ENABLED},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schedule, err := ParseScheduleSpec(test.schedule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			next, state := schedule.NextTransition(test.now)
+			if !next.Equal(test.next) || state != test.state {
+				t.Errorf("expected the transition to %s at %s, got %s at %s", test.state, test.next, state, next)
+			}
+		})
+	}
+}
+
+func TestReconcileSkipsExceptionDates(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name       string
+		exceptions string
+		replicas   int32
+	}{
+		{"no exception", "", 0},
+		{"exception date", "2024-06-03", 2},
+		{"exception range", "2024-06-01/2024-06-05", 2},
+		{"other exception date", "2024-06-04", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			annotations := map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00", "scheduler.schedule-exceptions": test.exceptions}
+			c, clientset := newTestController(t, NewDefaultControllerConfig(), newTestDeployment("foo", 2, annotations))
+			c.SetClock(&fakeClock{now: time.Date(2024, time.June, 3, 22, 0, 0, 0, time.UTC)})
+
+			if err := c.reconcile(context.Background(), "default/foo"); err != nil {
+				t.Fatal(err)
+			}
+			deployment, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *deployment.Spec.Replicas != test.replicas {
+				t.Errorf("expected %d replicas, got %d", test.replicas, *deployment.Spec.Replicas)
+			}
+		})
+	}
+}
+
+func TestTimeRangeWithJitter(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule string
+		jitter   time.Duration
+		seed     string
+	}{
+		{"within a day", "09:00-18:00", 5 * time.Minute, "default/foo"},
+		{"other deployment", "09:00-18:00", 5 * time.Minute, "default/bar"},
+		{"other namespace", "09:00-18:00", 5 * time.Minute, "other/foo"},
+		{"across midnight", "23:58-06:00", 5 * time.Minute, "default/foo"},
+		{"subsecond", "09:00-18:00", time.Millisecond, "default/foo"},
+		{"zero", "09:00-18:00", 0, "default/foo"},
+	}
+
+	offsets := map[string]time.Duration{}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			timeRange, err := ParseSchedule(test.schedule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			jittered := timeRange.WithJitter(test.jitter, test.seed)
+			// The same seed always yields the same offset
+			if again := timeRange.WithJitter(test.jitter, test.seed); again != jittered {
+				t.Errorf("expected the same range for the same seed, got %+v and %+v", jittered, again)
+			}
+			// Both boundaries move inward by the same offset
+			offset := clockOffset(jittered.Start) - clockOffset(timeRange.Start)
+			if offset < 0 {
+				offset += 24 * time.Hour
+			}
+			if offset < 0 || (test.jitter >= time.Second && offset >= test.jitter) || (test.jitter < time.Second && offset != 0) {
+				t.Fatalf("expected an offset in [0, %s), got %s", test.jitter, offset)
+			}
+			if end := shiftClock(timeRange.End, -offset); !jittered.End.Equal(end) {
+				t.Errorf("expected the end %s, got %s", end.Format(time.TimeOnly), jittered.End.Format(time.TimeOnly))
+			}
+			if length := timeRange.Length() - 2*offset; jittered.Length() != length {
+				t.Errorf("expected the length %s, got %s", length, jittered.Length())
+			}
+			if test.jitter >= time.Second {
+				offsets[test.seed] = offset
+			}
+		})
+	}
+	// Different deployments are spread over the jitter
+	if offsets["default/foo"] == offsets["default/bar"] && offsets["default/foo"] == offsets["other/foo"] {
+		t.Errorf("expected different offsets for different seeds, got %v", offsets)
+	}
+}
+
+func TestParseScheduleWindowLength(t *testing.T) {
+	tests := []struct {
+		schedule string
+		length   time.Duration
+		zero     bool
+	}{
+		{"09:00-09:00", 0, true},
+		{"weekdays 09:00-09:00", 0, true},
+		{"09:00:30-09:00:30", 0, true},
+		{"00:00-00:00", 0, true},
+		{"09:00-09:00:30", 30 * time.Second, false},
+		{"09:00-17:00", 8 * time.Hour, false},
+		{"20:00-08:00", 12 * time.Hour, false},
+		{"23:00-00:00", time.Hour, false},
+		{"00:00-23:59:59", 24*time.Hour - time.Second, false},
+		{"09:00-08:59", 24*time.Hour - time.Minute, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.schedule, func(t *testing.T) {
+			timeRange, err := ParseSchedule(test.schedule)
+			var zeroLength ZeroLengthWindowError
+			if test.zero {
+				if !errors.As(err, &zeroLength) {
+					t.Fatalf("expected a ZeroLengthWindowError, got '%v'", err)
+				}
+				if zeroLength.Window != test.schedule {
+					t.Errorf("expected the window '%s' in the error, got '%s'", test.schedule, zeroLength.Window)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if length := timeRange.Length(); length != test.length {
+				t.Errorf("expected the length %s, got %s", test.length, length)
+			}
+		})
+	}
+}
+
+func TestScheduleCheckWindowLength(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule string
+		max      time.Duration
+		window   string
+	}{
+		{"disabled", "08:00-07:00", 0, ""},
+		{"short window", "09:00-17:00", 16 * time.Hour, ""},
+		{"exactly the maximum", "20:00-12:00", 16 * time.Hour, ""},
+		{"crossing midnight", "20:00-12:01", 16 * time.Hour, "20:00-12:01"},
+		{"inverted window", "08:00-07:00", 16 * time.Hour, "08:00-07:00"},
+		{"full day", "00:00-23:59:59", 16 * time.Hour, "00:00:00-23:59:59"},
+		{"second window", `{"windows":["12:00-13:00","18:00-17:00"]}`, 16 * time.Hour, "18:00-17:00"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schedule, err := ParseScheduleSpec(test.schedule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = schedule.CheckWindowLength(test.max)
+			var tooLong WindowTooLongError
+			if test.window == "" {
+				if err != nil {
+					t.Errorf("expected no error, got '%s'", err)
+				}
+				return
+			}
+			if !errors.As(err, &tooLong) {
+				t.Fatalf("expected a WindowTooLongError, got '%v'", err)
+			}
+			if tooLong.Window.String() != test.window || tooLong.Max != test.max {
+				t.Errorf("expected the window '%s' above %s, got '%s' above %s", test.window, test.max, tooLong.Window, tooLong.Max)
+			}
+		})
+	}
+}
+
+func TestWarnLongWindows(t *testing.T) {
+	long := map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "08:00-07:00"}
+	otherLong := map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "09:00-08:00"}
+	short := map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00"}
+	tests := []struct {
+		name   string
+		max    time.Duration
+		old    map[string]string
+		new    map[string]string
+		warned bool
+	}{
+		{"added", 16 * time.Hour, nil, long, true},
+		{"added with a short window", 16 * time.Hour, nil, short, false},
+		{"warnings disabled", 0, nil, long, false},
+		{"not scheduled", 16 * time.Hour, nil, map[string]string{"scheduler.off-schedule": "08:00-07:00"}, false},
+		{"invalid schedule", 16 * time.Hour, nil, map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "08:00"}, false},
+		{"resynced", 16 * time.Hour, long, long, false},
+		{"window changed", 16 * time.Hour, long, otherLong, true},
+		{"window lengthened", 16 * time.Hour, short, long, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			config := NewDefaultControllerConfig()
+			config.MaxWindowLength = test.max
+			c, _ := newTestController(t, config)
+
+			var oldObj interface{}
+			if test.old != nil {
+				oldObj = newTestDeployment("foo", 2, test.old)
+			}
+			c.warnLongWindows(oldObj, newTestDeployment("foo", 2, test.new))
+			if warned := strings.Contains(logs.String(), "Suspicious schedule of deployment 'default.foo'"); warned != test.warned {
+				t.Errorf("expected warned %t, got %t: %s", test.warned, warned, logs)
+			}
+		})
+	}
+}
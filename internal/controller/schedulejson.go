@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// scheduleDocument is the JSON form of a schedule, an alternative to the
+// '[days] HH:MM-HH:MM' form for schedules with several windows, e.g.
+// {"windows":["20:00-08:00","12:00-13:00"],"timezone":"Europe/Athens",
+// "days":["weekdays"],"exceptions":["2024-12-25"]}
+type scheduleDocument struct {
+	Windows    []string `json:"windows"`
+	Timezone   string   `json:"timezone"`
+	Days       []string `json:"days"`
+	Exceptions []string `json:"exceptions"`
+}
+
+// ParseScheduleSpec parses a schedule in either of its forms. Text starting
+// with '{' is parsed as a JSON schedule, anything else by ParseSchedule. The
+// location of the returned schedule is nil unless the JSON sets a time zone.
+func ParseScheduleSpec(scheduleText string) (Schedule, error) {
+	if !strings.HasPrefix(strings.TrimSpace(scheduleText), "{") {
+		timeRange, err := ParseSchedule(scheduleText)
+		return Schedule{Range: timeRange}, err
+	}
+
+	var document scheduleDocument
+	decoder := json.NewDecoder(strings.NewReader(scheduleText))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&document); err != nil {
+		return Schedule{}, fmt.Errorf("invalid JSON schedule: %s", err)
+	}
+	if len(document.Windows) == 0 {
+		return Schedule{}, fmt.Errorf("invalid JSON schedule, expected at least one window")
+	}
+
+	// The days apply to the windows without days of their own
+	var days Weekdays
+	if len(document.Days) > 0 {
+		var err error
+		days, err = ParseWeekdays(strings.Join(document.Days, ""))
+		if err != nil {
+			return Schedule{}, err
+		}
+	}
+	var ranges []TimeRange
+	for _, window := range document.Windows {
+		timeRange, err := ParseSchedule(window)
+		if err != nil {
+			return Schedule{}, err
+		}
+		if timeRange.Days == 0 {
+			timeRange.Days = days
+		}
+		ranges = append(ranges, timeRange)
+	}
+
+	schedule := Schedule{Range: ranges[0], Windows: ranges[1:]}
+	if document.Timezone != "" {
+		location, err := LoadLocation(document.Timezone)
+		if err != nil {
+			return Schedule{}, err
+		}
+		schedule.Location = location
+	}
+	if len(document.Exceptions) > 0 {
+		exceptions, err := ParseExceptions(strings.Join(document.Exceptions, ","))
+		if err != nil {
+			return Schedule{}, err
+		}
+		schedule.Exceptions = exceptions
+	}
+	return schedule, nil
+}
@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseScheduleSpecFormsAreEquivalent(t *testing.T) {
+	tests := []struct {
+		name   string
+		legacy map[string]string
+		json   string
+	}{
+		{"single window", map[string]string{"scheduler.off-schedule": "20:00-08:00"}, `{"windows":["20:00-08:00"]}`},
+		{"days", map[string]string{"scheduler.off-schedule": "weekdays 20:00-08:00"}, `{"windows":["20:00-08:00"],"days":["weekdays"]}`},
+		{"day letters", map[string]string{"scheduler.off-schedule": "MW 09:00-17:00"}, `{"windows":["09:00-17:00"],"days":["M","W"]}`},
+		{"time zone", map[string]string{"scheduler.off-schedule": "20:00-08:00", "scheduler.timezone": "Europe/Athens"}, `{"windows":["20:00-08:00"],"timezone":"Europe/Athens"}`},
+		{"exceptions", map[string]string{"scheduler.off-schedule": "20:00-08:00", "scheduler.schedule-exceptions": "2024-06-05,2024-06-07/2024-06-08"}, `{"windows":["20:00-08:00"],"exceptions":["2024-06-05","2024-06-07/2024-06-08"]}`},
+		{"whitespace around the JSON", map[string]string{"scheduler.off-schedule": "20:00-08:00"}, " \n{\"windows\": [\"20:00-08:00\"]}\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, _ := newTestController(t, NewDefaultControllerConfig())
+			legacyAnnotations := map[string]string{"scheduler.enabled": "true"}
+			for key, value := range test.legacy {
+				legacyAnnotations[key] = value
+			}
+			legacy := newTestDeployment("legacy", 2, legacyAnnotations)
+			structured := newTestDeployment("structured", 2, map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": test.json})
+
+			// Every half hour of a week, 2024-06-03 being a Monday
+			start := time.Date(2024, time.June, 3, 0, 0, 0, 0, time.UTC)
+			for now := start; now.Before(start.AddDate(0, 0, 7)); now = now.Add(30 * time.Minute) {
+				legacyState, err := c.Decide(legacy, now)
+				if err != nil {
+					t.Fatal(err)
+				}
+				structuredState, err := c.Decide(structured, now)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if legacyState != structuredState {
+					t.Fatalf("expected the state %s of the string form at %s, got %s", legacyState, now, structuredState)
+				}
+			}
+		})
+	}
+}
+
+func TestParseScheduleSpecMultipleWindows(t *testing.T) {
+	schedule, err := ParseScheduleSpec(`{"windows":["20:00-08:00","Sa 12:00-13:00"],"days":["weekdays"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		now     time.Time
+		inRange bool
+	}{
+		{"first window", time.Date(2024, time.June, 3, 22, 0, 0, 0, time.UTC), true},
+		{"outside the windows", time.Date(2024, time.June, 3, 12, 30, 0, 0, time.UTC), false},
+		{"first window on a weekend", time.Date(2024, time.June, 8, 22, 0, 0, 0, time.UTC), false},
+		{"second window on its own day", time.Date(2024, time.June, 8, 12, 30, 0, 0, time.UTC), true},
+		{"second window on another day", time.Date(2024, time.June, 4, 12, 30, 0, 0, time.UTC), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if inRange := schedule.InRange(test.now); inRange != test.inRange {
+				t.Errorf("expected in range %t at %s, got %t", test.inRange, test.now, inRange)
+			}
+		})
+	}
+}
+
+func TestParseScheduleSpecErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule string
+		err      string
+	}{
+		{"invalid JSON", `{"windows":`, "invalid JSON schedule"},
+		{"unknown field", `{"windows":["20:00-08:00"],"zone":"UTC"}`, "invalid JSON schedule"},
+		{"no windows", `{"windows":[]}`, "expected at least one window"},
+		{"invalid window", `{"windows":["20:00"]}`, "20:00"},
+		{"invalid days", `{"windows":["20:00-08:00"],"days":["weekday"]}`, "expected day letters"},
+		{"invalid time zone", `{"windows":["20:00-08:00"],"timezone":"Europe/Nowhere"}`, "Europe/Nowhere"},
+		{"invalid exception", `{"windows":["20:00-08:00"],"exceptions":["2024-13-01"]}`, "2024-13-01"},
+		{"invalid string form", "20:00-", "cannot parse"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := ParseScheduleSpec(test.schedule); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected the error '%s', got '%v'", test.err, err)
+			}
+		})
+	}
+}
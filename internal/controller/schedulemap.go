@@ -0,0 +1,54 @@
+package controller
+
+import (
+	"fmt"
+
+	apps_v1 "k8s.io/api/apps/v1"
+	core_v1 "k8s.io/api/core/v1"
+)
+
+// lookupMappedSchedule reads the schedule of a deployment from the schedule
+// ConfigMap, where the schedules of many deployments are kept under
+// '<namespace>.<deployment>' keys. False is returned if no schedule
+// ConfigMap is configured, or if it does not list the deployment.
+func (c *Controller) lookupMappedSchedule(namespace, name string) (string, bool) {
+	if c.config.ScheduleConfigMap == "" {
+		return "", false
+	}
+	obj, exists, err := c.configMapInformer.GetIndexer().GetByKey(c.config.ScheduleConfigMap)
+	if err != nil || !exists {
+		return "", false
+	}
+	configMap, ok := obj.(*core_v1.ConfigMap)
+	if !ok {
+		return "", false
+	}
+	schedule, exists := configMap.Data[namespace+"."+name]
+	return schedule, exists
+}
+
+// parseMappedSchedule parses the schedule of the deployment in the schedule
+// ConfigMap
+func (c *Controller) parseMappedSchedule(deployment *apps_v1.Deployment, scheduleText string) (Schedule, error) {
+	schedule, err := ParseScheduleSpec(scheduleText)
+	if err != nil {
+		return Schedule{}, fmt.Errorf("invalid schedule of '%s.%s' in ConfigMap '%s': %s", deployment.Namespace, deployment.Name, c.config.ScheduleConfigMap, err)
+	}
+	return schedule, nil
+}
+
+// isScheduled checks if the deployment is enabled for scheduling, either by
+// its own annotation or by being listed in the schedule ConfigMap. The
+// annotation takes precedence, so listed deployments can still be paused.
+// Deployments with any of the ignored labels are never scheduled.
+func (c *Controller) isScheduled(deployment *apps_v1.Deployment) bool {
+	annotations := deployment.GetAnnotations()
+	if _, ignored := ignoredLabelOf(c.ignoredLabels, deployment.GetLabels()); ignored {
+		return false
+	}
+	if _, exists := annotations[c.config.Annotation(ENABLED_ANNOTATION)]; exists {
+		return IsEnabled(c.config, annotations)
+	}
+	_, mapped := c.lookupMappedSchedule(deployment.Namespace, deployment.Name)
+	return mapped && !isExcluded(c.config, annotations)
+}
@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestReconcileFollowsScheduleConfigMapEntries(t *testing.T) {
+	discardLogs(t)
+	config := NewDefaultControllerConfig()
+	config.ScheduleConfigMap = "scheduler/schedules"
+	c, clientset := newTestController(t, config, newTestDeployment("foo", 2, nil))
+	clock := &fakeClock{}
+	c.SetClock(clock)
+	night := time.Date(2024, time.June, 3, 22, 0, 0, 0, time.UTC)
+	morning := time.Date(2024, time.June, 4, 9, 0, 0, 0, time.UTC)
+	lunch := time.Date(2024, time.June, 4, 12, 30, 0, 0, time.UTC)
+	setEntries := func(data map[string]string) func() error {
+		return func() error {
+			configMap := &core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Namespace: "scheduler", Name: "schedules"}, Data: data}
+			return c.configMapInformer.GetIndexer().Update(configMap)
+		}
+	}
+
+	steps := []struct {
+		name     string
+		apply    func() error
+		now      time.Time
+		replicas int32
+	}{
+		{"no ConfigMap", func() error { return nil }, night, 2},
+		{"other entries", setEntries(map[string]string{"default.bar": "20:00-08:00", "other.foo": "20:00-08:00"}), night, 2},
+		{"entry added", setEntries(map[string]string{"default.foo": "20:00-08:00"}), night, 0},
+		{"outside the mapped window", func() error { return nil }, morning, 2},
+		{"entry changed", setEntries(map[string]string{"default.foo": "12:00-13:00"}), lunch, 0},
+		{"outside the changed window", setEntries(map[string]string{"default.foo": "12:00-13:00"}), night.AddDate(0, 0, 1), 2},
+		{"entry removed", setEntries(map[string]string{"default.bar": "20:00-08:00"}), night.AddDate(0, 0, 1), 2},
+		{"ConfigMap deleted", func() error {
+			return c.configMapInformer.GetIndexer().Delete(&core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Namespace: "scheduler", Name: "schedules"}})
+		}, night.AddDate(0, 0, 2), 2},
+	}
+	for _, step := range steps {
+		if err := step.apply(); err != nil {
+			t.Fatalf("%s: %s", step.name, err)
+		}
+		clock.Set(step.now)
+		if err := c.reconcile(context.Background(), "default/foo"); err != nil {
+			t.Fatalf("%s: %s", step.name, err)
+		}
+		current, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+		if err != nil {
+			t.Fatalf("%s: %s", step.name, err)
+		}
+		if *current.Spec.Replicas != step.replicas {
+			t.Errorf("%s: expected %d replicas, got %d", step.name, step.replicas, *current.Spec.Replicas)
+		}
+		// Keep the informer cache in step with the clientset
+		if err := c.deploymentInformer.GetIndexer().Update(current); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestIsScheduled(t *testing.T) {
+	tests := []struct {
+		name        string
+		deployment  string
+		annotations map[string]string
+		labels      map[string]string
+		scheduled   bool
+	}{
+		{"not listed", "bar", nil, nil, false},
+		{"listed", "foo", nil, nil, true},
+		{"annotated", "bar", map[string]string{"scheduler.enabled": "true"}, nil, true},
+		{"listed but paused", "foo", map[string]string{"scheduler.enabled": "false"}, nil, false},
+		{"listed but excluded", "foo", map[string]string{"scheduler.exclude": "true"}, nil, false},
+		{"listed but ignored", "foo", nil, map[string]string{"tier": "critical"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := NewDefaultControllerConfig()
+			config.ScheduleConfigMap = "scheduler/schedules"
+			c, _ := newTestController(t, config)
+			configMap := &core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Namespace: "scheduler", Name: "schedules"}, Data: map[string]string{"default.foo": "20:00-08:00"}}
+			if err := c.configMapInformer.GetIndexer().Add(configMap); err != nil {
+				t.Fatal(err)
+			}
+			ignoredLabels, err := parseIgnoreLabels("tier=critical")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.ignoredLabels = ignoredLabels
+
+			deployment := newTestDeployment(test.deployment, 2, test.annotations)
+			deployment.Labels = test.labels
+			if scheduled := c.isScheduled(deployment); scheduled != test.scheduled {
+				t.Errorf("expected scheduled %t, got %t", test.scheduled, scheduled)
+			}
+		})
+	}
+}
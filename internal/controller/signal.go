@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dimitris4000/concept02/internal/logging"
+)
+
+// signalCacheTTL is how long the result of an external signal is reused
+// before the signal endpoint is queried again.
+const signalCacheTTL = 30 * time.Second
+
+// signalResult is a cached response of a signal endpoint
+type signalResult struct {
+	holdUp  bool
+	expires time.Time
+}
+
+// signalChecker queries the external signal endpoints of the deployments
+// using the require-signal annotation. The results are cached for
+// signalCacheTTL so the endpoints are not queried on every reconcile.
+type signalChecker struct {
+	client *http.Client
+	mutex  sync.Mutex
+	cache  map[string]signalResult
+}
+
+// newSignalChecker creates a signalChecker using the provided HTTP client
+func newSignalChecker(client *http.Client) *signalChecker {
+	return &signalChecker{
+		client: client,
+		cache:  map[string]signalResult{},
+	}
+}
+
+// HoldUp checks if the signal at url asks for the deployment to be kept up,
+// which is the case when the endpoint responds with 200. Any other status or
+// a failed request allows the scale down.
+func (s *signalChecker) HoldUp(ctx context.Context, url string) bool {
+	s.mutex.Lock()
+	cached, exists := s.cache[url]
+	s.mutex.Unlock()
+	if exists && time.Now().Before(cached.expires) {
+		return cached.holdUp
+	}
+
+	holdUp := false
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err == nil {
+		var response *http.Response
+		response, err = s.client.Do(request)
+		if err == nil {
+			response.Body.Close()
+			holdUp = response.StatusCode == http.StatusOK
+		}
+	}
+	if err != nil {
+		logging.FromContext(ctx).Warn(fmt.Sprintf("Failed to check signal %s: %s", url, err))
+	}
+
+	s.mutex.Lock()
+	s.cache[url] = signalResult{holdUp: holdUp, expires: time.Now().Add(signalCacheTTL)}
+	s.mutex.Unlock()
+	return holdUp
+}
+
+// Forget drops the cached result of the signal endpoint at url
+func (s *signalChecker) Forget(url string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.cache, url)
+}
@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// signalEndpoint is an external signal responding with its status, which
+// counts the requests it receives
+type signalEndpoint struct {
+	status   atomic.Int32
+	requests atomic.Int32
+}
+
+func (s *signalEndpoint) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	s.requests.Add(1)
+	writer.WriteHeader(int(s.status.Load()))
+}
+
+func TestSignalCheckerHoldUp(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name   string
+		status int
+		holdUp bool
+	}{
+		{"green", http.StatusOK, true},
+		{"red", http.StatusServiceUnavailable, false},
+		{"no content", http.StatusNoContent, false},
+		{"not found", http.StatusNotFound, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			endpoint := &signalEndpoint{}
+			endpoint.status.Store(int32(test.status))
+			server := httptest.NewServer(endpoint)
+			defer server.Close()
+			s := newSignalChecker(server.Client())
+
+			if holdUp := s.HoldUp(context.Background(), server.URL); holdUp != test.holdUp {
+				t.Errorf("expected hold up %t, got %t", test.holdUp, holdUp)
+			}
+			// The result is cached, even if the signal changes
+			endpoint.status.Store(http.StatusTeapot)
+			if holdUp := s.HoldUp(context.Background(), server.URL); holdUp != test.holdUp {
+				t.Errorf("expected the cached hold up %t, got %t", test.holdUp, holdUp)
+			}
+			if requests := endpoint.requests.Load(); requests != 1 {
+				t.Errorf("expected a single request, got %d", requests)
+			}
+			// Forgetting the signal queries it again
+			s.Forget(server.URL)
+			if holdUp := s.HoldUp(context.Background(), server.URL); holdUp {
+				t.Errorf("expected no hold up after the signal changed")
+			}
+			if requests := endpoint.requests.Load(); requests != 2 {
+				t.Errorf("expected 2 requests, got %d", requests)
+			}
+		})
+	}
+}
+
+func TestSignalCheckerUnreachable(t *testing.T) {
+	discardLogs(t)
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	s := newSignalChecker(server.Client())
+	if s.HoldUp(context.Background(), server.URL) {
+		t.Errorf("expected an unreachable signal to allow the scale down")
+	}
+}
+
+func TestReconcileRequiresSignal(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name     string
+		status   int
+		override string
+		replicas int32
+		requests int32
+	}{
+		{"signal green", http.StatusOK, "", 2, 1},
+		{"signal red", http.StatusServiceUnavailable, "", 0, 1},
+		{"signal failing", http.StatusInternalServerError, "", 0, 1},
+		{"override down", http.StatusOK, "down", 0, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			endpoint := &signalEndpoint{}
+			endpoint.status.Store(int32(test.status))
+			server := httptest.NewServer(endpoint)
+			defer server.Close()
+			annotations := map[string]string{
+				"scheduler.enabled":        "true",
+				"scheduler.off-schedule":   "20:00-08:00",
+				"scheduler.require-signal": server.URL,
+			}
+			if test.override != "" {
+				annotations["scheduler.override"] = test.override
+			}
+			c, clientset := newTestController(t, NewDefaultControllerConfig(), newTestDeployment("foo", 2, annotations))
+			c.SetClock(&fakeClock{now: time.Date(2024, time.June, 3, 22, 0, 0, 0, time.UTC)})
+
+			if err := c.reconcile(context.Background(), "default/foo"); err != nil {
+				t.Fatal(err)
+			}
+			current, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *current.Spec.Replicas != test.replicas {
+				t.Errorf("expected %d replicas, got %d", test.replicas, *current.Spec.Replicas)
+			}
+			if requests := endpoint.requests.Load(); requests != test.requests {
+				t.Errorf("expected %d signal requests, got %d", test.requests, requests)
+			}
+		})
+	}
+}
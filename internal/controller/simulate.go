@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	apps_v1 "k8s.io/api/apps/v1"
+)
+
+// Transition is a change of the state of a deployment at a point in time
+type Transition struct {
+	At    time.Time
+	State DeploymentState
+}
+
+// Timeline returns the transitions of the schedule during the calendar
+// date of date, in the schedule's location. The first one is the state of
+// the schedule at the start of the day, followed by the transitions in
+// order. It follows NextTransition from midnight, so windows, days, off
+// days, exceptions and DST are all accounted for.
+func (s Schedule) Timeline(date time.Time) []Transition {
+	location := s.Location
+	if location == nil {
+		location = time.UTC
+	}
+	start := zonedClock(date.Year(), date.Month(), date.Day(), 0, location)
+	next := start.AddDate(0, 0, 1)
+	end := zonedClock(next.Year(), next.Month(), next.Day(), 0, location)
+
+	transitions := []Transition{{At: start, State: ENABLED}}
+	if s.InRange(start) {
+		transitions[0].State = DISABLED
+	}
+	for at := start; ; {
+		var state DeploymentState
+		at, state = s.NextTransition(at)
+		if at.IsZero() || !at.Before(end) {
+			return transitions
+		}
+		transitions = append(transitions, Transition{At: at, State: state})
+	}
+}
+
+// ResolveStandaloneSchedule resolves the schedule of the deployment out of
+// its own annotations and the config, the same way the controller does, so
+// it can be evaluated outside of the cluster. Schedules referenced through
+// a ConfigMap and the default schedules of namespaces can not be resolved.
+func ResolveStandaloneSchedule(config ControllerConfig, deployment *apps_v1.Deployment) (Schedule, error) {
+	annotations := deployment.GetAnnotations()
+	if _, exists := annotations[config.Annotation(SCHEDULE_REF_ANNOTATION)]; exists {
+		return Schedule{}, fmt.Errorf("the %s annotation can not be resolved outside of the cluster", config.Annotation(SCHEDULE_REF_ANNOTATION))
+	}
+
+	var schedule Schedule
+	var err error
+	if _, exists := annotations[config.Annotation(SCHEDULE_ANNOTATION)]; !exists && config.DefaultSchedule != "" {
+		schedule.Range, err = ParseSchedule(config.DefaultSchedule)
+	} else {
+		schedule, err = ParseScheduleAnnotation(config, annotations, deployment.GetLabels())
+	}
+	if err != nil {
+		return Schedule{}, err
+	}
+	return completeSchedule(config, schedule, deployment)
+}
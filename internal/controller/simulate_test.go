@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	apps_v1 "k8s.io/api/apps/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestScheduleTimeline(t *testing.T) {
+	tests := []struct {
+		name        string
+		schedule    string
+		date        time.Time
+		transitions []string
+	}{
+		{"overnight window", "20:00-08:00", time.Date(2024, time.June, 3, 0, 0, 0, 0, time.UTC), []string{"00:00 down", "08:00 up", "20:00 down"}},
+		{"window within the day", "12:00-13:00", time.Date(2024, time.June, 3, 0, 0, 0, 0, time.UTC), []string{"00:00 up", "12:00 down", "13:00 up"}},
+		{"window ending at midnight", "18:00-00:00", time.Date(2024, time.June, 3, 0, 0, 0, 0, time.UTC), []string{"00:00 up", "18:00 down"}},
+		{"multiple windows", `{"windows":["00:00-06:00","12:00-13:00"]}`, time.Date(2024, time.June, 3, 0, 0, 0, 0, time.UTC), []string{"00:00 down", "06:00 up", "12:00 down", "13:00 up"}},
+		{"weekday window on a Saturday", "weekdays 20:00-08:00", time.Date(2024, time.June, 8, 0, 0, 0, 0, time.UTC), []string{"00:00 down", "08:00 up"}},
+		{"weekday window on a Sunday", "weekdays 20:00-08:00", time.Date(2024, time.June, 9, 0, 0, 0, 0, time.UTC), []string{"00:00 up"}},
+		{"time zone", `{"windows":["20:00-08:00"],"timezone":"Europe/Athens"}`, time.Date(2024, time.June, 3, 0, 0, 0, 0, time.UTC), []string{"00:00 down", "08:00 up", "20:00 down"}},
+		{"exception date", `{"windows":["12:00-13:00"],"exceptions":["2024-06-03"]}`, time.Date(2024, time.June, 3, 0, 0, 0, 0, time.UTC), []string{"00:00 up"}},
+		{"day after an exception", `{"windows":["12:00-13:00"],"exceptions":["2024-06-03"]}`, time.Date(2024, time.June, 4, 0, 0, 0, 0, time.UTC), []string{"00:00 up", "12:00 down", "13:00 up"}},
+		{"DST start", `{"windows":["01:00-05:00"],"timezone":"Europe/Athens"}`, time.Date(2024, time.March, 31, 0, 0, 0, 0, time.UTC), []string{"00:00 up", "01:00 down", "05:00 up"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schedule, err := ParseScheduleSpec(test.schedule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			transitions := []string{}
+			for _, transition := range schedule.Timeline(test.date) {
+				if transition.At.Location().String() != schedule.Location.String() {
+					t.Errorf("expected the transitions in %s, got %s", schedule.Location, transition.At.Location())
+				}
+				transitions = append(transitions, transition.At.Format(CLOCK_LAYOUT_MINUTES)+" "+transition.State.String())
+			}
+			if strings.Join(transitions, ", ") != strings.Join(test.transitions, ", ") {
+				t.Errorf("expected the transitions %v, got %v", test.transitions, transitions)
+			}
+		})
+	}
+}
+
+func TestScheduleTimelineOffDays(t *testing.T) {
+	schedule, err := ParseScheduleSpec("12:00-13:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule.OffDays = NewWeekdays(time.Saturday)
+	tests := []struct {
+		name        string
+		date        time.Time
+		transitions []string
+	}{
+		{"day before", time.Date(2024, time.June, 7, 0, 0, 0, 0, time.UTC), []string{"00:00 up", "12:00 down", "13:00 up"}},
+		{"off day", time.Date(2024, time.June, 8, 0, 0, 0, 0, time.UTC), []string{"00:00 down"}},
+		{"day after", time.Date(2024, time.June, 9, 0, 0, 0, 0, time.UTC), []string{"00:00 up", "12:00 down", "13:00 up"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			transitions := []string{}
+			for _, transition := range schedule.Timeline(test.date) {
+				transitions = append(transitions, transition.At.Format(CLOCK_LAYOUT_MINUTES)+" "+transition.State.String())
+			}
+			if strings.Join(transitions, ", ") != strings.Join(test.transitions, ", ") {
+				t.Errorf("expected the transitions %v, got %v", test.transitions, transitions)
+			}
+		})
+	}
+}
+
+func TestResolveStandaloneSchedule(t *testing.T) {
+	tests := []struct {
+		name            string
+		defaultSchedule string
+		annotations     map[string]string
+		schedule        string
+		err             string
+	}{
+		{"annotation", "", map[string]string{"scheduler.off-schedule": "20:00-08:00"}, "20:00-08:00", ""},
+		{"default schedule", "19:00-07:00", nil, "19:00-07:00", ""},
+		{"annotation over the default schedule", "19:00-07:00", map[string]string{"scheduler.off-schedule": "20:00-08:00"}, "20:00-08:00", ""},
+		{"schedule reference", "", map[string]string{"scheduler.schedule-ref": "schedules/office-hours"}, "", "can not be resolved outside of the cluster"},
+		{"no schedule", "", nil, "", "scheduler.off-schedule"},
+		{"invalid time zone", "", map[string]string{"scheduler.off-schedule": "20:00-08:00", "scheduler.timezone": "Europe/Nowhere"}, "", "Europe/Nowhere"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := NewDefaultControllerConfig()
+			config.DefaultSchedule = test.defaultSchedule
+			deployment := &apps_v1.Deployment{ObjectMeta: meta_v1.ObjectMeta{Annotations: test.annotations}}
+			schedule, err := ResolveStandaloneSchedule(config, deployment)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("expected the error '%s', got '%v'", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if schedule.Range.String() != test.schedule {
+				t.Errorf("expected the schedule '%s', got '%s'", test.schedule, schedule.Range)
+			}
+		})
+	}
+}
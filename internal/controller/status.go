@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	apps_v1 "k8s.io/api/apps/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// DeploymentStatus describes the scheduling state of a deployment
+type DeploymentStatus struct {
+	Namespace string
+	Name      string
+	Replicas  int32
+	// State is the state the deployment is currently in, DISABLED if it was
+	// scaled down by the controller or has no replicas
+	State DeploymentState
+	// DesiredState is the state the deployment must be in according to
+	// Decide. Signals, pauses, delays and the scale down limit can keep the
+	// deployment in another State.
+	DesiredState DeploymentState
+	// Error is the reason the deployment can not be scheduled, if any
+	Error string
+	// NextTransition is the next time the schedule changes the state of
+	// the deployment to NextState. It is zero if the state never changes.
+	NextTransition time.Time
+	NextState      DeploymentState
+}
+
+// StatusFilter narrows down the deployments returned by Status. The zero
+// value matches all the deployments.
+type StatusFilter struct {
+	// Namespace of the deployments, empty means all the namespaces
+	Namespace string
+	// State the deployments are currently in, nil means any state
+	State *DeploymentState
+}
+
+// Status returns the status of the deployments enabled for scheduling that
+// match the filter, sorted by namespace and name.
+func (c *Controller) Status(ctx context.Context, filter StatusFilter) []DeploymentStatus {
+	now := c.clock.Now()
+	statuses := []DeploymentStatus{}
+	for _, obj := range c.listDeployments(filter.Namespace) {
+		deployment, ok := obj.(*apps_v1.Deployment)
+		if !ok || !c.isScheduled(deployment) {
+			continue
+		}
+		deployment = c.withRememberedReplicas(ctx, deployment)
+		if filter.Namespace != "" && deployment.Namespace != filter.Namespace {
+			continue
+		}
+
+		status := DeploymentStatus{
+			Namespace: deployment.Namespace,
+			Name:      deployment.Name,
+		}
+		if deployment.Spec.Replicas != nil {
+			status.Replicas = *deployment.Spec.Replicas
+		}
+		status.State = ENABLED
+		if _, scaledDown := scaledDownMemory(c.config, deployment.GetAnnotations()); scaledDown || status.Replicas == 0 {
+			status.State = DISABLED
+		}
+		if c.isIdleMode(deployment) {
+			// The desired state of deployments in idle mode depends on their
+			// traffic, which is not queried for the status
+			status.DesiredState = status.State
+		} else {
+			state, err := c.Decide(deployment, now)
+			status.DesiredState = state
+			if err != nil {
+				status.Error = err.Error()
+			} else if schedule, err := c.resolveSchedule(deployment); err == nil {
+				status.NextTransition, status.NextState = schedule.NextTransition(now)
+			}
+		}
+		if filter.State != nil && status.State != *filter.State {
+			continue
+		}
+		statuses = append(statuses, status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Namespace != statuses[j].Namespace {
+			return statuses[i].Namespace < statuses[j].Namespace
+		}
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// listDeployments lists the deployments of the informer cache in a
+// namespace, or in all the namespaces if it is empty. The namespace index
+// avoids going through every deployment of the cluster.
+func (c *Controller) listDeployments(namespace string) []interface{} {
+	indexer := c.deploymentInformer.GetIndexer()
+	if namespace != "" {
+		if objs, err := indexer.ByIndex(cache.NamespaceIndex, namespace); err == nil {
+			return objs
+		}
+	}
+	return indexer.List()
+}
+
+// recordNextTransition updates the next transition metric of a deployment
+func (c *Controller) recordNextTransition(deployment *apps_v1.Deployment) {
+	schedule, err := c.resolveSchedule(deployment)
+	if err != nil {
+		c.deleteDeploymentMetrics(deployment.Namespace, deployment.Name)
+		return
+	}
+	now := c.clock.Now()
+	next, _ := schedule.NextTransition(now)
+	if next.IsZero() {
+		c.deleteDeploymentMetrics(deployment.Namespace, deployment.Name)
+		return
+	}
+	c.setNextTransition(deployment.Namespace, deployment.Name, next.Sub(now).Seconds())
+}
@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// locationCache holds the already loaded time zones so the controller's
+// loop does not read the zoneinfo database on every iteration.
+var locationCache sync.Map
+
+// timezoneAliases maps common time zone abbreviations, in lower case, to the
+// IANA time zone observing them. The IANA time zone follows the daylight
+// saving time of the region, e.g. 'EST' stands for New York time all year
+// round and not for a fixed offset.
+var timezoneAliases = map[string]string{
+	"z":    "UTC",
+	"gmt":  "UTC",
+	"est":  "America/New_York",
+	"edt":  "America/New_York",
+	"cst":  "America/Chicago",
+	"cdt":  "America/Chicago",
+	"mst":  "America/Denver",
+	"mdt":  "America/Denver",
+	"pst":  "America/Los_Angeles",
+	"pdt":  "America/Los_Angeles",
+	"bst":  "Europe/London",
+	"wet":  "Europe/Lisbon",
+	"west": "Europe/Lisbon",
+	"cet":  "Europe/Berlin",
+	"cest": "Europe/Berlin",
+	"eet":  "Europe/Athens",
+	"eest": "Europe/Athens",
+	"msk":  "Europe/Moscow",
+	"ist":  "Asia/Kolkata",
+	"sgt":  "Asia/Singapore",
+	"jst":  "Asia/Tokyo",
+	"kst":  "Asia/Seoul",
+	"aest": "Australia/Sydney",
+	"aedt": "Australia/Sydney",
+	"nzst": "Pacific/Auckland",
+	"nzdt": "Pacific/Auckland",
+}
+
+// timezoneRegions are the areas of the IANA time zones searched for city
+// names given without their area (e.g. 'Athens' for 'Europe/Athens')
+var timezoneRegions = []string{"Europe", "America", "Asia", "Africa", "Australia", "Pacific", "Atlantic", "Indian"}
+
+// timezoneOffsetPattern matches fixed offsets like 'UTC+2', 'GMT-03:30' or
+// '+0530'
+var timezoneOffsetPattern = regexp.MustCompile(`^(?i:utc|gmt)?([+-])(\d{1,2})(?::?(\d{2}))?$`)
+
+// LoadLocation works like time.LoadLocation but caches the loaded time zones.
+// An empty name stands for UTC. Names are normalized by NormalizeTimezone
+// first, and fixed offsets like 'UTC+2' are accepted as well.
+func LoadLocation(name string) (*time.Location, error) {
+	if cached, exists := locationCache.Load(name); exists {
+		return cached.(*time.Location), nil
+	}
+
+	location, err := loadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locationCache.Store(name, location)
+	return location, nil
+}
+
+// loadLocation resolves a time zone name, trying in order the aliases,
+// fixed offsets, IANA names and finally city names without their area.
+func loadLocation(name string) (*time.Location, error) {
+	normalized := NormalizeTimezone(name)
+	if match := timezoneOffsetPattern.FindStringSubmatch(normalized); match != nil {
+		return fixedOffsetLocation(match)
+	}
+	if location, err := time.LoadLocation(normalized); err == nil {
+		return location, nil
+	}
+	if !strings.Contains(normalized, "/") {
+		city := titleTimezoneWords(normalized)
+		for _, region := range timezoneRegions {
+			if location, err := time.LoadLocation(region + "/" + city); err == nil {
+				return location, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unknown time zone '%s', expected an IANA name (e.g. Europe/Athens, America/New_York), an abbreviation (e.g. EST, CET) or an offset (e.g. UTC+2)", name)
+}
+
+// NormalizeTimezone maps the aliases of timezoneAliases to their IANA name,
+// ignoring case and surrounding whitespace. Any other name is only trimmed.
+func NormalizeTimezone(name string) string {
+	name = strings.TrimSpace(name)
+	if alias, exists := timezoneAliases[strings.ToLower(name)]; exists {
+		return alias
+	}
+	return name
+}
+
+// fixedOffsetLocation returns a time zone with the fixed offset matched by
+// timezoneOffsetPattern. Offsets beyond the ones in use (-12 to +14 hours)
+// are rejected.
+func fixedOffsetLocation(match []string) (*time.Location, error) {
+	hours, _ := strconv.Atoi(match[2])
+	minutes := 0
+	if match[3] != "" {
+		minutes, _ = strconv.Atoi(match[3])
+	}
+	offset := time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute
+	if match[1] == "-" {
+		offset = -offset
+	}
+	if minutes >= 60 || offset < -12*time.Hour || offset > 14*time.Hour {
+		return nil, fmt.Errorf("invalid time zone offset '%s', expected an offset between UTC-12 and UTC+14", match[0])
+	}
+	return time.FixedZone(fmt.Sprintf("UTC%s%02d:%02d", match[1], hours, minutes), int(offset/time.Second)), nil
+}
+
+// titleTimezoneWords spells a city name the way IANA names do, e.g. 'new
+// york' as 'New_York'
+func titleTimezoneWords(city string) string {
+	words := strings.FieldsFunc(city, func(r rune) bool { return r == ' ' || r == '_' })
+	for i, word := range words {
+		words[i] = strings.ToUpper(word[:1]) + strings.ToLower(word[1:])
+	}
+	return strings.Join(words, "_")
+}
@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveLocation(t *testing.T) {
+	tests := []struct {
+		name            string
+		defaultTimezone string
+		annotations     map[string]string
+		location        string
+		err             bool
+	}{
+		{"UTC", "", nil, "UTC", false},
+		{"cluster default", "Europe/Athens", nil, "Europe/Athens", false},
+		{"annotation", "", map[string]string{"scheduler.timezone": "America/New_York"}, "America/New_York", false},
+		{"annotation over cluster default", "Europe/Athens", map[string]string{"scheduler.timezone": "America/New_York"}, "America/New_York", false},
+		{"empty annotation over cluster default", "Europe/Athens", map[string]string{"scheduler.timezone": ""}, "UTC", false},
+		{"alias annotation", "", map[string]string{"scheduler.timezone": "EST"}, "America/New_York", false},
+		{"alias cluster default", "CET", nil, "Europe/Berlin", false},
+		{"invalid annotation", "Europe/Athens", map[string]string{"scheduler.timezone": "Europe/Nowhere"}, "", true},
+		{"invalid cluster default", "Europe/Nowhere", nil, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := NewDefaultControllerConfig()
+			config.DefaultTimezone = test.defaultTimezone
+			location, err := resolveLocation(config, newTestDeployment("foo", 1, test.annotations))
+			if test.err {
+				if err == nil {
+					t.Errorf("expected an error, got the time zone %s", location)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if location.String() != test.location {
+				t.Errorf("expected the time zone %s, got %s", test.location, location)
+			}
+		})
+	}
+}
+
+func TestLoadLocationIsCached(t *testing.T) {
+	for _, name := range []string{"", "Europe/Athens", "EST", "UTC+2"} {
+		first, err := LoadLocation(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := LoadLocation(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first != second {
+			t.Errorf("expected the time zone '%s' to be loaded once", name)
+		}
+	}
+}
+
+func TestLoadLocation(t *testing.T) {
+	tests := []struct {
+		name     string
+		location string
+		offset   time.Duration
+		err      string
+	}{
+		{"", "UTC", 0, ""},
+		{"UTC", "UTC", 0, ""},
+		{"Europe/Athens", "Europe/Athens", 3 * time.Hour, ""},
+		{"America/New_York", "America/New_York", -4 * time.Hour, ""},
+		{"EST", "America/New_York", -4 * time.Hour, ""},
+		{"cest", "Europe/Berlin", 2 * time.Hour, ""},
+		{" JST ", "Asia/Tokyo", 9 * time.Hour, ""},
+		{"GMT", "UTC", 0, ""},
+		{"Z", "UTC", 0, ""},
+		{"GMT+2", "UTC+02:00", 2 * time.Hour, ""},
+		{"UTC-03:30", "UTC-03:30", -3*time.Hour - 30*time.Minute, ""},
+		{"+0530", "UTC+05:30", 5*time.Hour + 30*time.Minute, ""},
+		{"utc+14", "UTC+14:00", 14 * time.Hour, ""},
+		{"UTC-12", "UTC-12:00", -12 * time.Hour, ""},
+		{"Athens", "Europe/Athens", 3 * time.Hour, ""},
+		{"new york", "America/New_York", -4 * time.Hour, ""},
+		{"los_angeles", "America/Los_Angeles", -7 * time.Hour, ""},
+		{"Nowhere", "", 0, "unknown time zone 'Nowhere'"},
+		{"Europe/Nowhere", "", 0, "unknown time zone 'Europe/Nowhere'"},
+		{"GMT+", "", 0, "unknown time zone 'GMT+'"},
+		{"UTC+15", "", 0, "invalid time zone offset 'UTC+15'"},
+		{"UTC-13", "", 0, "invalid time zone offset 'UTC-13'"},
+		{"+05:60", "", 0, "invalid time zone offset '+05:60'"},
+	}
+
+	// Offsets on 2024-06-03, during the daylight saving time of the
+	// northern hemisphere
+	at := time.Date(2024, time.June, 3, 12, 0, 0, 0, time.UTC)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			location, err := LoadLocation(test.name)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("expected the error '%s', got '%v'", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if location.String() != test.location {
+				t.Errorf("expected the time zone %s, got %s", test.location, location)
+			}
+			if _, offset := at.In(location).Zone(); time.Duration(offset)*time.Second != test.offset {
+				t.Errorf("expected the offset %s, got %s", test.offset, time.Duration(offset)*time.Second)
+			}
+		})
+	}
+}
+
+func TestLoadLocationErrorListsExamples(t *testing.T) {
+	_, err := LoadLocation("Mars/Olympus_Mons")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, example := range []string{"Europe/Athens", "EST", "UTC+2"} {
+		if !strings.Contains(err.Error(), example) {
+			t.Errorf("expected the example %s in the error, got '%s'", example, err)
+		}
+	}
+}
+
+func TestNormalizeTimezone(t *testing.T) {
+	tests := []struct {
+		name       string
+		normalized string
+	}{
+		{"EST", "America/New_York"},
+		{"est", "America/New_York"},
+		{" CET ", "Europe/Berlin"},
+		{"Europe/Athens", "Europe/Athens"},
+		{" Europe/Athens ", "Europe/Athens"},
+		{"UTC+2", "UTC+2"},
+		{"Athens", "Athens"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if normalized := NormalizeTimezone(test.name); normalized != test.normalized {
+				t.Errorf("expected '%s', got '%s'", test.normalized, normalized)
+			}
+		})
+	}
+}
@@ -2,110 +2,163 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/dimitris4000/concept02/internal/logging"
 	api_v1 "k8s.io/api/apps/v1"
-	metav1 "k8s.io/apimachinery/internal/apis/meta/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/util/wait"
+	appsv1_apply "k8s.io/client-go/applyconfigurations/apps/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/homedir"
 	"k8s.io/client-go/util/retry"
 )
 
-var kubeconfig *string
+// kubeconfig holds the value of the 'kubeconfig' flag
+var kubeconfig = kubeconfigFlag()
+
+// maxStartupBackoff caps the backoff between the startup connection attempts
+const maxStartupBackoff = 30 * time.Second
+
+// Limits above these values are likely to overload the API server
+const (
+	maxReasonableKubeQPS   = 200
+	maxReasonableKubeBurst = 400
+)
+
+// kubeconfigFlag registers the "kubeconfig" argument so it can be parsed
+// together with the rest of the flags of the application. A "kubeconfig"
+// flag registered already by a library (e.g. controller-runtime, which the
+// tests use) is shared instead of being redefined.
+func kubeconfigFlag() flag.Value {
+	if existing := flag.Lookup("kubeconfig"); existing != nil {
+		return existing.Value
+	}
+	if home := homedir.HomeDir(); home != "" {
+		flag.String("kubeconfig", filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
+	} else {
+		flag.String("kubeconfig", "", "absolute path to the kubeconfig file")
+	}
+	return flag.Lookup("kubeconfig").Value
+}
 
 // LoadK8SClientConfigFile configures and initializes the k8s API clientset object.
 // If run inside the cluster is uses the pods service account to access the API.
 // Otherwise it uses either the configuration of ~/.kube/config or the config
-// provided by the 'kubeconfig' flag.
-func LoadK8SClientConfigFile() (*kubernetes.Clientset, error) {
-	if kubeconfig == nil {
-		// Parse "kubeconfig" argument if provided
-		if home := homedir.HomeDir(); home != "" {
-			kubeconfig = flag.String("kubeconfig", filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
-		} else {
-			kubeconfig = flag.String("kubeconfig", "", "absolute path to the kubeconfig file")
-		}
-		flag.Parse()
+// provided by the 'kubeconfig' flag. The client side rate limits of the
+// clientset are the KubeQPS and KubeBurst of the controller configuration.
+func LoadK8SClientConfigFile(controllerConfig ControllerConfig) (*kubernetes.Clientset, error) {
+	config, err := loadK8SRestConfig(controllerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create API client
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
 	}
 
+	return clientset, err
+}
+
+// loadK8SRestConfig builds the configuration of the k8s API clients, see
+// LoadK8SClientConfigFile for the sources of the configuration. The
+// 'kubeconfig' flag is expected to be parsed already.
+func loadK8SRestConfig(controllerConfig ControllerConfig) (*rest.Config, error) {
 	// Check & Load config file
 	var conf string
-	if s, err := os.Stat(*kubeconfig); err == nil && !s.IsDir() {
-		slog.Info(fmt.Sprintf("Using %s file to configure k8s API connection", *kubeconfig))
-		conf = *kubeconfig
+	if s, err := os.Stat(kubeconfig.String()); err == nil && !s.IsDir() {
+		slog.Info(fmt.Sprintf("Using %s file to configure k8s API connection", kubeconfig))
+		conf = kubeconfig.String()
 	} else {
-		slog.Info(fmt.Sprintf("%s file not found", *kubeconfig))
+		slog.Info(fmt.Sprintf("%s file not found", kubeconfig))
 		conf = ""
 	}
 	config, err := clientcmd.BuildConfigFromFlags("", conf)
 	if err != nil {
 		return nil, err
 	}
+	if controllerConfig.KubeQPS > maxReasonableKubeQPS || controllerConfig.KubeBurst > maxReasonableKubeBurst {
+		slog.Warn(fmt.Sprintf("k8s API client limits (QPS: %g, burst: %d) are unreasonably high and may overload the API server", controllerConfig.KubeQPS, controllerConfig.KubeBurst))
+	}
+	config.QPS = float32(controllerConfig.KubeQPS)
+	config.Burst = controllerConfig.KubeBurst
 
-	// Create API client
-	clientset, err := kubernetes.NewForConfig(config)
+	return config, nil
+}
+
+// connectK8S creates the k8s API clientset and makes sure the API server is
+// reachable. The API server may be briefly unavailable while the cluster
+// boots, so failed attempts are retried with exponential backoff instead of
+// failing right away.
+func connectK8S(config ControllerConfig) (*kubernetes.Clientset, error) {
+	var clientset *kubernetes.Clientset
+	var lastErr error
+	attempt := 0
+	backoff := wait.Backoff{Duration: config.StartupBackoff, Factor: 2, Steps: config.StartupAttempts, Cap: maxStartupBackoff}
+	err := wait.ExponentialBackoff(backoff, func() (bool, error) {
+		attempt++
+		clientset, lastErr = LoadK8SClientConfigFile(config)
+		if lastErr == nil {
+			_, lastErr = clientset.Discovery().ServerVersion()
+		}
+		if lastErr != nil {
+			slog.Warn(fmt.Sprintf("Failed to connect to the k8s API (attempt %d/%d): %s", attempt, config.StartupAttempts, lastErr))
+			return false, nil
+		}
+		return true, nil
+	})
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("could not connect to the k8s API after %d attempts: %s", attempt, lastErr)
 	}
-
-	return clientset, err
+	return clientset, nil
 }
 
 // ToggleDeployment "disables" or "enables" a deployment by changing
 // the configured replicas number. The function will retry the change if
-// the initial resource update fails.
-func ToggleDeployment(clientset kubernetes.Interface, namespace, deployment string, targetState DeploymentState) error {
+// the initial resource update fails. The replicas of scaled down deployments
+// are kept in the ReplicaStore of the configuration.
+func ToggleDeployment(ctx context.Context, clientset kubernetes.Interface, config ControllerConfig, namespace, deployment string, targetState DeploymentState) error {
+	_, err := toggleDeployment(ctx, clientset, config, newReplicaStore(clientset, config), namespace, deployment, targetState)
+	return err
+}
+
+// toggleDeployment is the same as ToggleDeployment but also reports whether
+// the replicas of the deployment were actually changed.
+func toggleDeployment(ctx context.Context, clientset kubernetes.Interface, config ControllerConfig, store ReplicaStore, namespace, deployment string, targetState DeploymentState) (bool, error) {
+	scaled := false
 	deploymentsClient := clientset.AppsV1().Deployments(namespace)
 	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
 		// Retrieve the latest version of Deployment before attempting update
 		// RetryOnConflict uses exponential backoff to avoid exhausting the apiserver
-		deploymentObj, getErr := deploymentsClient.Get(context.Background(), deployment, metav1.GetOptions{})
+		deploymentObj, getErr := deploymentsClient.Get(ctx, deployment, metav1.GetOptions{})
 		if getErr != nil {
-			return fmt.Errorf("Failed to get latest version of Deployment: %v", getErr)
-		}
-
-		// Memorize current replicas number
-		if *deploymentObj.Spec.Replicas != 0 {
-			deploymentObj.ObjectMeta.Annotations[REPLICAS_MEMORY_ANNOTATION] = strconv.Itoa(int(*deploymentObj.Spec.Replicas))
-		}
-
-		// Set the new replicas number
-		if targetState == DISABLED {
-			if *deploymentObj.Spec.Replicas == 0 {
-				return nil
-			}
-			slog.Info(fmt.Sprintf("Scaling down deployment '%s.%s'\n", namespace, deployment))
-			deploymentObj.Spec.Replicas = int32Ptr(0)
-		} else {
-			if *deploymentObj.Spec.Replicas != 0 {
-				return nil
-			}
-			slog.Info(fmt.Sprintf("Scaling up deployment '%s.%s'\n", namespace, deployment))
-			if value, exists := deploymentObj.ObjectMeta.Annotations[REPLICAS_MEMORY_ANNOTATION]; exists {
-				i, err := strconv.Atoi(value)
-				if err != nil {
-					return err
-				}
-				deploymentObj.Spec.Replicas = int32Ptr(int32(i))
-				delete(deploymentObj.ObjectMeta.Annotations, REPLICAS_MEMORY_ANNOTATION)
-			}
+			return fmt.Errorf("Failed to get latest version of Deployment: %w", getErr)
 		}
 
-		// Make the update call to k8s API
-		_, updateErr := deploymentsClient.Update(context.Background(), deploymentObj, metav1.UpdateOptions{})
-		return updateErr
+		var err error
+		scaled, err = toggleTarget(ctx, clientset, config, store, deploymentObj, targetState)
+		return err
 	})
 	if retryErr != nil {
-		return fmt.Errorf("Update failed: %v", retryErr)
+		return false, fmt.Errorf("Update failed: %w", retryErr)
 	}
 
-	return nil
+	return scaled, nil
 }
 
 // AttemptToggleDeployment "disables" or "enables" a deployment by changing
@@ -113,40 +166,310 @@ func ToggleDeployment(clientset kubernetes.Interface, namespace, deployment stri
 // case of a failure during the initial resource update. This function is meant
 // to be a bit more efficient than ToggleDeployment but in endge cases it
 // might fail to apply the change.
-func AttemptToggleDeployment(clientset kubernetes.Interface, deployment *api_v1.Deployment, targetState DeploymentState) error {
+func AttemptToggleDeployment(ctx context.Context, clientset kubernetes.Interface, config ControllerConfig, deployment *api_v1.Deployment, targetState DeploymentState) error {
+	_, err := toggleTarget(ctx, clientset, config, newReplicaStore(clientset, config), deployment, targetState)
+	return err
+}
+
+// toggleTarget scales the deployment either through its replicas or through
+// its HorizontalPodAutoscaler, according to its target annotation. It
+// reports whether the deployment was actually scaled.
+func toggleTarget(ctx context.Context, clientset kubernetes.Interface, config ControllerConfig, store ReplicaStore, deployment *api_v1.Deployment, targetState DeploymentState) (bool, error) {
+	hpa, err := isHPATarget(config, deployment)
+	if err != nil {
+		return false, err
+	}
+	if hpa {
+		return toggleHPA(ctx, clientset, config, store, deployment, targetState)
+	}
+
+	// The object is modified in place, so changed replicas mean that the
+	// change was sent to the k8s API
+	replicas := *deployment.Spec.Replicas
+	err = toggleDeploymentObject(ctx, clientset, config, store, deployment, targetState)
+	return err == nil && *deployment.Spec.Replicas != replicas, err
+}
+
+// toggleDeploymentObject holds the logic shared by ToggleDeployment and
+// AttemptToggleDeployment. It modifies the provided deployment object and
+// sends it back to the k8s API. The replicas of the deployment are
+// remembered in the store before it is scaled down, and forgotten once it
+// was scaled back up, so they are never lost.
+func toggleDeploymentObject(ctx context.Context, clientset kubernetes.Interface, config ControllerConfig, store ReplicaStore, deployment *api_v1.Deployment, targetState DeploymentState) error {
 	namespace := deployment.Namespace
 	deploymentName := deployment.Name
-
-	// Memorize current replicas number
-	if *deployment.Spec.Replicas != 0 {
-		deployment.ObjectMeta.Annotations[REPLICAS_MEMORY_ANNOTATION] = strconv.Itoa(int(*deployment.Spec.Replicas))
+	original := deployment.DeepCopy()
+	graceful := isGracefulScaleDown(config, deployment)
+	pause, err := isPauseScaleDown(config, deployment)
+	if err != nil {
+		return err
+	}
+	offReplicas, err := parseOffReplicas(config, deployment)
+	if err != nil {
+		return err
+	}
+	minReplicas, err := parseMinReplicas(config, deployment)
+	if err != nil {
+		return err
+	}
+	onReplicas, err := parseOnReplicas(config, deployment, minReplicas)
+	if err != nil {
+		return err
+	}
+	partial := offReplicas != nil || minReplicas > 0
+	stored, remembered, err := store.Recall(ctx, deployment)
+	if err != nil {
+		return fmt.Errorf("could not recall the replicas of deployment '%s.%s': %w", namespace, deploymentName, err)
+	}
+	scaledDown := remembered && !isRestored(config, deployment.GetAnnotations())
+	if deployment.ObjectMeta.Annotations == nil {
+		deployment.ObjectMeta.Annotations = map[string]string{}
 	}
 
 	// Set the new replicas number
 	if targetState == DISABLED {
-		if *deployment.Spec.Replicas == 0 {
-			return nil
+		if pause {
+			deployment.Spec.Paused = true
 		}
-		slog.Info(fmt.Sprintf("Scaling down deployment '%s.%s'\n", namespace, deploymentName))
-		deployment.Spec.Replicas = int32Ptr(0)
-	} else {
-		if *deployment.Spec.Replicas != 0 {
-			return nil
+		// Memorize current replicas number. During a graceful or partial
+		// scale down the deployment goes through replica numbers other than
+		// zero which must not replace the original one.
+		remembered := *deployment.Spec.Replicas
+		if scaledDown && (graceful || partial) {
+			remembered = stored
 		}
-		slog.Info(fmt.Sprintf("Scaling up deployment '%s.%s'\n", namespace, deploymentName))
-		if value, exists := deployment.ObjectMeta.Annotations[REPLICAS_MEMORY_ANNOTATION]; exists {
-			i, err := strconv.Atoi(value)
+		// A deployment still at its on-replicas, or within a replica
+		// window, remembers its resting count
+		restingAnnotation := config.Annotation(RESTING_REPLICAS_ANNOTATION)
+		if value, exists := deployment.ObjectMeta.Annotations[restingAnnotation]; exists {
+			if !scaledDown && (onReplicas > 0 && *deployment.Spec.Replicas == onReplicas || hasReplicaWindows(config, deployment)) {
+				remembered = rememberedReplicas(config, value, fmt.Sprintf("deployment '%s.%s'", namespace, deploymentName))
+			}
+		}
+		if minReplicas > remembered {
+			return fmt.Errorf("invalid %s annotation %d, the deployment only has %d replicas", config.Annotation(MIN_REPLICAS_ANNOTATION), minReplicas, remembered)
+		}
+		target := max(offReplicas.target(remembered), minReplicas)
+		if *deployment.Spec.Replicas <= target {
+			return updateDeploymentIfChanged(ctx, clientset, config, original, deployment)
+		}
+		// Replicas above the target of a scaled down deployment were set by
+		// hand, e.g. by applying its manifest again, and become the
+		// remembered ones. Only replicas above the remembered ones can tell
+		// such an edit apart from a graceful scale down in progress.
+		if scaledDown && (!graceful || *deployment.Spec.Replicas > remembered) {
+			logging.FromContext(ctx).Warn(fmt.Sprintf("Replicas of deployment '%s.%s' changed to %d while it was scaled down, remembering them instead of %d", namespace, deploymentName, *deployment.Spec.Replicas, stored))
+			replicaDrift.Inc()
+			remembered = *deployment.Spec.Replicas
+			if minReplicas > remembered {
+				return fmt.Errorf("invalid %s annotation %d, the deployment only has %d replicas", config.Annotation(MIN_REPLICAS_ANNOTATION), minReplicas, remembered)
+			}
+			target = max(offReplicas.target(remembered), minReplicas)
+		}
+		if !scaledDown || remembered != stored {
+			if err := store.Remember(ctx, deployment, remembered); err != nil {
+				return err
+			}
+		}
+		delete(deployment.ObjectMeta.Annotations, restingAnnotation)
+		delete(deployment.ObjectMeta.Annotations, config.Annotation(LAST_RESTORED_ANNOTATION))
+		if graceful {
+			gracefulTarget, err := gracefulScaleDownTarget(ctx, clientset, deployment)
 			if err != nil {
 				return err
 			}
-			deployment.Spec.Replicas = int32Ptr(int32(i))
-			delete(deployment.ObjectMeta.Annotations, REPLICAS_MEMORY_ANNOTATION)
+			target = max(target, gracefulTarget)
+			if target == *deployment.Spec.Replicas {
+				logging.FromContext(ctx).Info(fmt.Sprintf("Waiting for disruption budget to scale down deployment '%s.%s'\n", namespace, deploymentName))
+				return nil
+			}
+		}
+		logging.FromContext(ctx).Info(fmt.Sprintf("Scaling down deployment '%s.%s'\n", namespace, deploymentName))
+		deployment.Spec.Replicas = int32Ptr(target)
+	} else {
+		if pause {
+			deployment.Spec.Paused = false
+		}
+		// An interrupted graceful scale down, or a partial one, leaves the
+		// deployment with some replicas and the replicas memory in place.
+		if *deployment.Spec.Replicas != 0 && !((graceful || partial) && scaledDown) {
+			return updateDeploymentIfChanged(ctx, clientset, config, original, deployment)
+		}
+		logging.FromContext(ctx).Info(fmt.Sprintf("Scaling up deployment '%s.%s'\n", namespace, deploymentName))
+		if !scaledDown {
+			return updateDeploymentIfChanged(ctx, clientset, config, original, deployment)
+		}
+		deployment.Spec.Replicas = int32Ptr(stored)
+		// The replicas are kept remembered, marked as restored, with
+		// KeepReplicasMemory
+		keep := config.KeepReplicasMemory
+		if keep {
+			if err := store.Remember(ctx, deployment, stored); err != nil {
+				return err
+			}
+			deployment.ObjectMeta.Annotations[config.Annotation(LAST_RESTORED_ANNOTATION)] = lastRestoredReplicas(stored, config.now())
+		}
+		// The remembered replicas are kept aside so the deployment can be
+		// restored to its resting count by hand
+		if onReplicas > 0 {
+			deployment.ObjectMeta.Annotations[config.Annotation(RESTING_REPLICAS_ANNOTATION)] = strconv.Itoa(int(*deployment.Spec.Replicas))
+			deployment.Spec.Replicas = int32Ptr(onReplicas)
+		}
+		if err := updateDeploymentIfChanged(ctx, clientset, config, original, deployment); err != nil || keep {
+			return err
+		}
+		return store.Forget(ctx, deployment)
+	}
+
+	return updateDeploymentIfChanged(ctx, clientset, config, original, deployment)
+}
+
+// updateDeploymentIfChanged calls updateDeployment only if the replicas,
+// the paused flag or the annotations of the deployment actually changed,
+// otherwise the update just bumps the resourceVersion and wakes up every
+// watcher. Changes of the replicas are recorded in the audit log.
+func updateDeploymentIfChanged(ctx context.Context, clientset kubernetes.Interface, config ControllerConfig, original, modified *api_v1.Deployment) error {
+	if *original.Spec.Replicas == *modified.Spec.Replicas && original.Spec.Paused == modified.Spec.Paused && maps.Equal(original.Annotations, modified.Annotations) {
+		return nil
+	}
+	if err := updateDeployment(ctx, clientset, config, original, modified); err != nil {
+		return err
+	}
+	if *original.Spec.Replicas != *modified.Spec.Replicas {
+		audit(ctx, config, "deployments", modified.Namespace, modified.Name, *original.Spec.Replicas, *modified.Spec.Replicas)
+	}
+	return nil
+}
+
+// isPauseScaleDown checks if the deployment uses the pause scale down mode,
+// where the deployment's rollouts are paused while it is scaled down.
+func isPauseScaleDown(config ControllerConfig, deployment *api_v1.Deployment) (bool, error) {
+	modeAnnotation := config.Annotation(SCALE_DOWN_MODE_ANNOTATION)
+	switch mode := deployment.GetAnnotations()[modeAnnotation]; strings.ToLower(strings.TrimSpace(mode)) {
+	case "", SCALE_DOWN_MODE_REPLICAS:
+		return false, nil
+	case SCALE_DOWN_MODE_PAUSE:
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid %s annotation '%s', expected one of %s, %s", modeAnnotation, mode, SCALE_DOWN_MODE_REPLICAS, SCALE_DOWN_MODE_PAUSE)
+	}
+}
+
+// updateDeployment sends the changes between the original and the modified
+// deployment to the k8s API using the configured update strategy. The patch
+// and apply strategies only send the changed fields, which avoids most of the
+// conflicts a full object update runs into.
+func updateDeployment(ctx context.Context, clientset kubernetes.Interface, config ControllerConfig, original, modified *api_v1.Deployment) error {
+	deploymentsClient := clientset.AppsV1().Deployments(modified.Namespace)
+
+	switch config.UpdateStrategy {
+	case UPDATE_STRATEGY_PATCH:
+		originalJSON, err := json.Marshal(original)
+		if err != nil {
+			return err
+		}
+		modifiedJSON, err := json.Marshal(modified)
+		if err != nil {
+			return err
+		}
+		patch, err := strategicpatch.CreateTwoWayMergePatch(originalJSON, modifiedJSON, api_v1.Deployment{})
+		if err != nil {
+			return err
+		}
+		_, err = deploymentsClient.Patch(ctx, modified.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+		return err
+
+	case UPDATE_STRATEGY_APPLY:
+		// Start from the fields the scheduler already owns, so fields it
+		// stops setting are released, and add the changed ones on top.
+		apply, err := appsv1_apply.ExtractDeployment(original, FIELD_MANAGER)
+		if err != nil {
+			return err
+		}
+		annotations := map[string]string{}
+		for key, value := range apply.Annotations {
+			annotations[key] = value
+		}
+		for key, value := range modified.Annotations {
+			if originalValue, exists := original.Annotations[key]; !exists || originalValue != value {
+				annotations[key] = value
+			}
+		}
+		for key := range original.Annotations {
+			if _, exists := modified.Annotations[key]; !exists {
+				delete(annotations, key)
+			}
+		}
+		apply.Annotations = nil
+		apply.WithAnnotations(annotations)
+		if apply.Spec == nil {
+			apply.WithSpec(appsv1_apply.DeploymentSpec())
 		}
+		apply.Spec.WithReplicas(*modified.Spec.Replicas)
+		if original.Spec.Paused != modified.Spec.Paused {
+			apply.Spec.WithPaused(modified.Spec.Paused)
+		}
+		_, err = deploymentsClient.Apply(ctx, apply, metav1.ApplyOptions{FieldManager: FIELD_MANAGER, Force: true})
+		return err
+
+	default:
+		_, err := deploymentsClient.Update(ctx, modified, metav1.UpdateOptions{})
+		return err
 	}
+}
+
+// PatchDeploymentAnnotations sets the provided annotations on a deployment
+// using a merge patch, so the rest of the object is left untouched. A nil
+// value removes the annotation.
+func PatchDeploymentAnnotations(ctx context.Context, clientset kubernetes.Interface, namespace, deployment string, annotations map[string]*string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = clientset.AppsV1().Deployments(namespace).Patch(ctx, deployment, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
 
-	// Make the update call to k8s API
-	_, updateErr := clientset.AppsV1().Deployments(namespace).Update(context.Background(), deployment, metav1.UpdateOptions{})
-	return updateErr
+// rememberedReplicas parses the value of the replicas memory annotation. A
+// corrupt value (e.g. edited by hand) must not leave the object stuck at
+// zero replicas, so it is replaced by the configured fallback replicas.
+func rememberedReplicas(config ControllerConfig, value, object string) int32 {
+	i, err := strconv.ParseInt(strings.TrimSpace(value), 10, 32)
+	if err != nil || i < 0 {
+		slog.Warn(fmt.Sprintf("Invalid %s annotation '%s' on %s, restoring %d replicas", config.Annotation(REPLICAS_MEMORY_ANNOTATION), value, object, config.FallbackReplicas))
+		return int32(config.FallbackReplicas)
+	}
+	return int32(i)
+}
+
+// lastRestoredReplicas returns the value of the last-restored-replicas
+// annotation of an object restored to replicas at restored, in the
+// '<replicas>@<RFC3339 time>' form (e.g. '3@2024-06-01T08:00:00Z')
+func lastRestoredReplicas(replicas int32, restored time.Time) string {
+	return fmt.Sprintf("%d@%s", replicas, restored.UTC().Format(time.RFC3339))
+}
+
+// isRestored checks if the annotations mark an object as restored, i.e. its
+// replicas memory annotation, kept by KeepReplicasMemory, holds the replicas
+// it was scaled back up to instead of the ones of a scaled down object
+func isRestored(config ControllerConfig, annotations map[string]string) bool {
+	_, restored := annotations[config.Annotation(LAST_RESTORED_ANNOTATION)]
+	return restored
+}
+
+// scaledDownMemory returns the replicas memory annotation of a scaled down
+// object, false if the object is not scaled down
+func scaledDownMemory(config ControllerConfig, annotations map[string]string) (string, bool) {
+	value, remembered := annotations[config.Annotation(REPLICAS_MEMORY_ANNOTATION)]
+	if !remembered || isRestored(config, annotations) {
+		return "", false
+	}
+	return value, true
 }
 
 func int32Ptr(i int32) *int32 { return &i }
@@ -0,0 +1,585 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	apps_v1 "k8s.io/api/apps/v1"
+	autoscaling_v2 "k8s.io/api/autoscaling/v2"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var updateStrategies = []string{UPDATE_STRATEGY_UPDATE, UPDATE_STRATEGY_PATCH}
+
+func TestToggleDeploymentUpdateStrategies(t *testing.T) {
+	for _, strategy := range updateStrategies {
+		t.Run(strategy, func(t *testing.T) {
+			discardLogs(t)
+			config := NewDefaultControllerConfig()
+			config.UpdateStrategy = strategy
+			_, clientset := newTestController(t, config, newTestDeployment("foo", 3, map[string]string{"scheduler.enabled": "true"}))
+			store := newReplicaStore(clientset, config)
+			ctx := context.Background()
+
+			if _, err := toggleDeployment(ctx, clientset, config, store, "default", "foo", DISABLED); err != nil {
+				t.Fatal(err)
+			}
+			deployment, err := clientset.AppsV1().Deployments("default").Get(ctx, "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *deployment.Spec.Replicas != 0 {
+				t.Errorf("expected 0 replicas after the scale down, got %d", *deployment.Spec.Replicas)
+			}
+			if memory := deployment.Annotations["scheduler.replicas-memory"]; memory != "3" {
+				t.Errorf("expected 3 replicas to be remembered, got '%s'", memory)
+			}
+
+			if _, err := toggleDeployment(ctx, clientset, config, store, "default", "foo", ENABLED); err != nil {
+				t.Fatal(err)
+			}
+			deployment, err = clientset.AppsV1().Deployments("default").Get(ctx, "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *deployment.Spec.Replicas != 3 {
+				t.Errorf("expected 3 replicas after the restore, got %d", *deployment.Spec.Replicas)
+			}
+			if memory, exists := deployment.Annotations["scheduler.replicas-memory"]; exists {
+				t.Errorf("expected the remembered replicas to be dropped, got '%s'", memory)
+			}
+			if deployment.Annotations["scheduler.enabled"] != "true" {
+				t.Errorf("expected the other annotations to be kept, got %v", deployment.Annotations)
+			}
+		})
+	}
+}
+
+func TestUpdateDeploymentIfChanged(t *testing.T) {
+	enabled := map[string]string{"scheduler.enabled": "true"}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		modify      func(*apps_v1.Deployment)
+		changed     bool
+	}{
+		{"nothing", enabled, func(d *apps_v1.Deployment) {}, false},
+		{"initialized annotations", nil, func(d *apps_v1.Deployment) { d.Annotations = map[string]string{} }, false},
+		{"same annotation value", enabled, func(d *apps_v1.Deployment) { d.Annotations = map[string]string{"scheduler.enabled": "true"} }, false},
+		{"replicas", enabled, func(d *apps_v1.Deployment) { d.Spec.Replicas = int32Ptr(0) }, true},
+		{"paused", enabled, func(d *apps_v1.Deployment) { d.Spec.Paused = true }, true},
+		{"added annotation", enabled, func(d *apps_v1.Deployment) { d.Annotations["scheduler.replicas-memory"] = "3" }, true},
+		{"changed annotation", enabled, func(d *apps_v1.Deployment) { d.Annotations["scheduler.enabled"] = "false" }, true},
+		{"removed annotation", enabled, func(d *apps_v1.Deployment) { delete(d.Annotations, "scheduler.enabled") }, true},
+	}
+
+	for _, strategy := range updateStrategies {
+		for _, test := range tests {
+			t.Run(strategy+"/"+test.name, func(t *testing.T) {
+				config := NewDefaultControllerConfig()
+				config.UpdateStrategy = strategy
+				_, clientset := newTestController(t, config, newTestDeployment("foo", 3, test.annotations))
+				ctx := context.Background()
+				original, err := clientset.AppsV1().Deployments("default").Get(ctx, "foo", meta_v1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				modified := original.DeepCopy()
+				test.modify(modified)
+				clientset.ClearActions()
+
+				if err := updateDeploymentIfChanged(ctx, clientset, config, original, modified); err != nil {
+					t.Fatal(err)
+				}
+				if writes := deploymentWrites(clientset); writes != 0 != test.changed {
+					t.Errorf("expected changed %t, got the actions %v", test.changed, clientset.Actions())
+				}
+			})
+		}
+	}
+}
+
+func TestToggleDeploymentSkipsNeedlessWrites(t *testing.T) {
+	tests := []struct {
+		name        string
+		replicas    int32
+		annotations map[string]string
+		state       DeploymentState
+	}{
+		{"already up", 3, map[string]string{"scheduler.enabled": "true"}, ENABLED},
+		{"already down", 0, map[string]string{"scheduler.enabled": "true", "scheduler.replicas-memory": "3"}, DISABLED},
+		{"down by hand", 0, map[string]string{"scheduler.enabled": "true"}, DISABLED},
+	}
+
+	for _, strategy := range updateStrategies {
+		for _, test := range tests {
+			t.Run(strategy+"/"+test.name, func(t *testing.T) {
+				discardLogs(t)
+				config := NewDefaultControllerConfig()
+				config.UpdateStrategy = strategy
+				_, clientset := newTestController(t, config, newTestDeployment("foo", test.replicas, test.annotations))
+				store := newReplicaStore(clientset, config)
+
+				scaled, err := toggleDeployment(context.Background(), clientset, config, store, "default", "foo", test.state)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if scaled {
+					t.Errorf("expected the deployment not to be scaled")
+				}
+				if writes := deploymentWrites(clientset); writes != 0 {
+					t.Errorf("expected no writes, got the actions %v", clientset.Actions())
+				}
+			})
+		}
+	}
+}
+
+func TestRememberedReplicas(t *testing.T) {
+	tests := []struct {
+		value    string
+		replicas int32
+	}{
+		{"3", 3},
+		{" 3 ", 3},
+		{"0", 0},
+		{"", 2},
+		{"garbage", 2},
+		{"-1", 2},
+		{"3.5", 2},
+		{"0x10", 2},
+		{"99999999999", 2},
+	}
+
+	discardLogs(t)
+	config := NewDefaultControllerConfig()
+	config.FallbackReplicas = 2
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			if replicas := rememberedReplicas(config, test.value, "deployment 'default.foo'"); replicas != test.replicas {
+				t.Errorf("expected %d replicas, got %d", test.replicas, replicas)
+			}
+		})
+	}
+}
+
+func TestToggleDeploymentRestoresFallbackReplicas(t *testing.T) {
+	tests := []struct {
+		name      string
+		configMap bool
+		value     string
+		replicas  int32
+	}{
+		{"valid annotation", false, "3", 3},
+		{"garbage annotation", false, "garbage", 2},
+		{"negative annotation", false, "-3", 2},
+		{"valid ConfigMap entry", true, "3", 3},
+		{"garbage ConfigMap entry", true, "garbage", 2},
+		{"negative ConfigMap entry", true, "-3", 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			discardLogs(t)
+			config := NewDefaultControllerConfig()
+			config.FallbackReplicas = 2
+			annotations := map[string]string{"scheduler.replicas-memory": test.value}
+			if test.configMap {
+				config.ReplicasConfigMap = "scheduler/replicas"
+				annotations = nil
+			}
+			_, clientset := newTestController(t, config, newTestDeployment("foo", 0, annotations))
+			if test.configMap {
+				configMap := &core_v1.ConfigMap{
+					ObjectMeta: meta_v1.ObjectMeta{Namespace: "scheduler", Name: "replicas"},
+					Data:       map[string]string{"default.foo": test.value},
+				}
+				if _, err := clientset.CoreV1().ConfigMaps("scheduler").Create(context.Background(), configMap, meta_v1.CreateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			store := newReplicaStore(clientset, config)
+			if _, err := toggleDeployment(context.Background(), clientset, config, store, "default", "foo", ENABLED); err != nil {
+				t.Fatal(err)
+			}
+			deployment, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *deployment.Spec.Replicas != test.replicas {
+				t.Errorf("expected %d replicas, got %d", test.replicas, *deployment.Spec.Replicas)
+			}
+			if _, remembered := deployment.Annotations["scheduler.replicas-memory"]; remembered {
+				t.Errorf("expected the corrupt replicas memory to be dropped, got %v", deployment.Annotations)
+			}
+		})
+	}
+}
+
+func TestToggleDeploymentReplicaDrift(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		replicas    int32
+		scaled      int32
+		remembered  string
+		drift       float64
+	}{
+		{"not edited", nil, 0, 0, "3", 0},
+		{"edited up", nil, 5, 0, "5", 1},
+		{"edited down", nil, 2, 0, "2", 1},
+		{"off-replicas not edited", map[string]string{"scheduler.off-replicas": "1"}, 1, 1, "3", 0},
+		{"off-replicas edited", map[string]string{"scheduler.off-replicas": "1"}, 4, 1, "4", 1},
+		{"graceful scale down in progress", map[string]string{"scheduler.graceful-scale-down": "true"}, 2, 0, "3", 0},
+		{"edited during a graceful scale down", map[string]string{"scheduler.graceful-scale-down": "true"}, 5, 0, "5", 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			config := NewDefaultControllerConfig()
+			annotations := map[string]string{"scheduler.enabled": "true", "scheduler.replicas-memory": "3"}
+			for key, value := range test.annotations {
+				annotations[key] = value
+			}
+			_, clientset := newTestController(t, config, newTestDeployment("foo", test.replicas, annotations))
+			store := newReplicaStore(clientset, config)
+			before := scrapeMetric(t, "scheduler_replica_drift_total")
+
+			// The deployment is scaled down again, remembering the edited
+			// replicas, and later restored to them
+			if _, err := toggleDeployment(ctx, clientset, config, store, "default", "foo", DISABLED); err != nil {
+				t.Fatal(err)
+			}
+			deployment, err := clientset.AppsV1().Deployments("default").Get(ctx, "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *deployment.Spec.Replicas != test.scaled {
+				t.Errorf("expected %d replicas after the scale down, got %d", test.scaled, *deployment.Spec.Replicas)
+			}
+			if remembered := deployment.Annotations["scheduler.replicas-memory"]; remembered != test.remembered {
+				t.Errorf("expected the remembered replicas %s, got '%s'", test.remembered, remembered)
+			}
+			if drift := scrapeMetric(t, "scheduler_replica_drift_total") - before; drift != test.drift {
+				t.Errorf("expected a drift of %v, got %v", test.drift, drift)
+			}
+
+			if _, err := toggleDeployment(ctx, clientset, config, store, "default", "foo", ENABLED); err != nil {
+				t.Fatal(err)
+			}
+			deployment, err = clientset.AppsV1().Deployments("default").Get(ctx, "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if restored := fmt.Sprint(*deployment.Spec.Replicas); restored != test.remembered {
+				t.Errorf("expected the deployment restored to %s replicas, got %s", test.remembered, restored)
+			}
+		})
+	}
+}
+
+// BenchmarkToggleDeployment scales a deployment down and back up with each
+// update strategy, including the read of the deployment before every write
+func BenchmarkToggleDeployment(b *testing.B) {
+	for _, strategy := range updateStrategies {
+		b.Run(strategy, func(b *testing.B) {
+			discardLogs(b)
+			config := NewDefaultControllerConfig()
+			config.UpdateStrategy = strategy
+			_, clientset := newTestController(b, config, newTestDeployment("foo", 3, map[string]string{"scheduler.enabled": "true"}))
+			store := newReplicaStore(clientset, config)
+			ctx := context.Background()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				state := DISABLED
+				if i%2 == 1 {
+					state = ENABLED
+				}
+				if _, err := toggleDeployment(ctx, clientset, config, store, "default", "foo", state); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkUpdateDeployment compares the writes of the update strategies
+// alone, a full object update against a patch of the changed fields. The
+// fake clientset only measures the client side, i.e. building the patch, and
+// not the transfer of the full object or the conflicts of a real API server.
+func BenchmarkUpdateDeployment(b *testing.B) {
+	for _, strategy := range updateStrategies {
+		b.Run(strategy, func(b *testing.B) {
+			config := NewDefaultControllerConfig()
+			config.UpdateStrategy = strategy
+			_, clientset := newTestController(b, config, newTestDeployment("foo", 3, nil))
+			ctx := context.Background()
+			up, err := clientset.AppsV1().Deployments("default").Get(ctx, "foo", meta_v1.GetOptions{})
+			if err != nil {
+				b.Fatal(err)
+			}
+			down := up.DeepCopy()
+			down.Spec.Replicas = new(int32)
+			down.Annotations = map[string]string{config.Annotation(REPLICAS_MEMORY_ANNOTATION): "3"}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				original, modified := up, down
+				if i%2 == 1 {
+					original, modified = down, up
+				}
+				if err := updateDeployment(ctx, clientset, config, original, modified); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestToggleDeploymentKeepsReplicasMemory(t *testing.T) {
+	discardLogs(t)
+	now := time.Date(2024, time.June, 4, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		target   string
+		keep     bool
+		memory   string
+		restored string
+	}{
+		{"replicas forgotten", TARGET_REPLICAS, false, "", ""},
+		{"replicas kept", TARGET_REPLICAS, true, "3", "3@2024-06-04T08:00:00Z"},
+		{"minReplicas forgotten", TARGET_HPA, false, "", ""},
+		{"minReplicas kept", TARGET_HPA, true, "3", "3@2024-06-04T08:00:00Z"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := NewDefaultControllerConfig()
+			config.KeepReplicasMemory = test.keep
+			config.Clock = &fakeClock{now: now}
+			annotations := map[string]string{"scheduler.replicas-memory": "3", "scheduler.target": test.target}
+			_, clientset := newTestController(t, config, newTestDeployment("foo", 0, annotations))
+			hpa := &autoscaling_v2.HorizontalPodAutoscaler{
+				ObjectMeta: meta_v1.ObjectMeta{Namespace: "default", Name: "foo"},
+				Spec: autoscaling_v2.HorizontalPodAutoscalerSpec{
+					ScaleTargetRef: autoscaling_v2.CrossVersionObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "foo"},
+					MinReplicas:    int32Ptr(1),
+					MaxReplicas:    10,
+				},
+			}
+			if _, err := clientset.AutoscalingV2().HorizontalPodAutoscalers("default").Create(context.Background(), hpa, meta_v1.CreateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+
+			store := newReplicaStore(clientset, config)
+			if _, err := toggleDeployment(context.Background(), clientset, config, store, "default", "foo", ENABLED); err != nil {
+				t.Fatal(err)
+			}
+			deployment, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if memory := deployment.Annotations["scheduler.replicas-memory"]; memory != test.memory {
+				t.Errorf("expected the replicas memory '%s', got '%s'", test.memory, memory)
+			}
+			if restored := deployment.Annotations["scheduler.last-restored-replicas"]; restored != test.restored {
+				t.Errorf("expected the last restored replicas '%s', got '%s'", test.restored, restored)
+			}
+
+			// A kept memory is replaced by the replicas of the next scale
+			// down, which drops the restore mark
+			if _, err := toggleDeployment(context.Background(), clientset, config, store, "default", "foo", DISABLED); err != nil {
+				t.Fatal(err)
+			}
+			deployment, err = clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if memory := deployment.Annotations["scheduler.replicas-memory"]; memory != "3" {
+				t.Errorf("expected 3 replicas to be remembered on scale down, got '%s'", memory)
+			}
+			if restored, exists := deployment.Annotations["scheduler.last-restored-replicas"]; exists {
+				t.Errorf("expected the restore mark to be dropped on scale down, got '%s'", restored)
+			}
+		})
+	}
+}
+
+func TestToggleDeploymentScaleDownModes(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name     string
+		mode     string
+		replicas int32
+		paused   bool
+		state    DeploymentState
+		expected int32
+		pause    bool
+		err      bool
+	}{
+		{"default mode down", "", 3, false, DISABLED, 0, false, false},
+		{"replicas mode down", "replicas", 3, false, DISABLED, 0, false, false},
+		{"replicas mode up", "replicas", 0, false, ENABLED, 3, false, false},
+		{"replicas mode keeps a manual pause", "replicas", 0, true, ENABLED, 3, true, false},
+		{"pause mode down", "pause", 3, false, DISABLED, 0, true, false},
+		{"pause mode up", "pause", 0, true, ENABLED, 3, false, false},
+		{"pause mode already up", "pause", 3, true, ENABLED, 3, false, false},
+		{"pause mode case", "Pause", 3, false, DISABLED, 0, true, false},
+		{"invalid mode", "delete", 3, false, DISABLED, 3, false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := NewDefaultControllerConfig()
+			annotations := map[string]string{}
+			if test.mode != "" {
+				annotations["scheduler.scale-down-mode"] = test.mode
+			}
+			if test.replicas == 0 {
+				annotations["scheduler.replicas-memory"] = "3"
+			}
+			deployment := newTestDeployment("foo", test.replicas, annotations)
+			deployment.Spec.Paused = test.paused
+			_, clientset := newTestController(t, config, deployment)
+
+			_, err := toggleDeployment(context.Background(), clientset, config, newReplicaStore(clientset, config), "default", "foo", test.state)
+			if test.err != (err != nil) {
+				t.Fatalf("expected error %t, got '%v'", test.err, err)
+			}
+			current, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *current.Spec.Replicas != test.expected || current.Spec.Paused != test.pause {
+				t.Errorf("expected %d replicas (paused %t), got %d (paused %t)", test.expected, test.pause, *current.Spec.Replicas, current.Spec.Paused)
+			}
+		})
+	}
+}
+
+// useTestKubeconfig points the kubeconfig flag to a kubeconfig of the API
+// server for the duration of the test
+func useTestKubeconfig(t *testing.T, server string) {
+	t.Helper()
+	content := `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: ` + server + `
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+current-context: test
+users:
+- name: test
+  user:
+    token: test
+`
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	previous := kubeconfig.String()
+	if err := kubeconfig.Set(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { kubeconfig.Set(previous) })
+}
+
+func TestLoadK8SRestConfigLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		qps     float64
+		burst   int
+		warning bool
+	}{
+		{"defaults", 20, 30, false},
+		{"reasonable limits", 200, 400, false},
+		{"unreasonable QPS", 500, 400, true},
+		{"unreasonable burst", 200, 1000, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			useTestKubeconfig(t, "https://127.0.0.1:6443")
+			config := NewDefaultControllerConfig()
+			config.KubeQPS = test.qps
+			config.KubeBurst = test.burst
+
+			restConfig, err := loadK8SRestConfig(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if restConfig.QPS != float32(test.qps) || restConfig.Burst != test.burst {
+				t.Errorf("expected the limits %g/%d, got %g/%d", test.qps, test.burst, restConfig.QPS, restConfig.Burst)
+			}
+			if warning := strings.Contains(logs.String(), "unreasonably high"); warning != test.warning {
+				t.Errorf("expected warning %t, got the logs %s", test.warning, logs)
+			}
+		})
+	}
+}
+
+func TestConnectK8SRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int32
+		attempts int
+		requests int32
+		err      string
+	}{
+		{"reachable", 0, 3, 1, ""},
+		{"transient failures", 2, 3, 3, ""},
+		{"unreachable", 5, 3, 3, "could not connect to the k8s API after 3 attempts"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if requests.Add(1) <= test.failures {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"major":"1","minor":"29","gitVersion":"v1.29.2"}`))
+			}))
+			defer server.Close()
+			useTestKubeconfig(t, server.URL)
+			config := NewDefaultControllerConfig()
+			config.StartupAttempts = test.attempts
+			config.StartupBackoff = time.Millisecond
+
+			clientset, err := connectK8S(config)
+			if test.err == "" && (err != nil || clientset == nil) {
+				t.Errorf("expected a clientset, got the error '%v'", err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("expected the error '%s', got '%v'", test.err, err)
+			}
+			if requests.Load() != test.requests {
+				t.Errorf("expected %d requests, got %d", test.requests, requests.Load())
+			}
+			// Every failed attempt is logged
+			if failed := strings.Count(logs.String(), "Failed to connect to the k8s API"); failed != int(min(test.failures, test.requests)) {
+				t.Errorf("expected %d failed attempts logged, got the logs %s", min(test.failures, test.requests), logs)
+			}
+		})
+	}
+}
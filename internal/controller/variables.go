@@ -0,0 +1,30 @@
+package controller
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// variablePattern matches the '${name}' variables of a schedule
+var variablePattern = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// ExpandVariables replaces the '${name}' variables of a schedule (or schedule
+// reference) with the value of the deployment label of the same name. This
+// allows the same manifest to carry different schedules per environment,
+// e.g. with 'scheduler.schedule-ref: "schedules/${env}"'. Variables without
+// a matching label are an error.
+func ExpandVariables(text string, labels map[string]string) (string, error) {
+	var err error
+	expanded := variablePattern.ReplaceAllStringFunc(text, func(variable string) string {
+		name := variablePattern.FindStringSubmatch(variable)[1]
+		value, exists := labels[name]
+		if !exists && err == nil {
+			err = fmt.Errorf("unresolved variable '%s', the deployment has no '%s' label", variable, name)
+		}
+		return value
+	})
+	if err != nil {
+		return "", err
+	}
+	return expanded, nil
+}
@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestExpandVariables(t *testing.T) {
+	labels := map[string]string{"env": "prod", "env-schedule": "20:00-08:00", "empty": ""}
+	tests := []struct {
+		text     string
+		expected string
+		err      string
+	}{
+		{"20:00-08:00", "20:00-08:00", ""},
+		{"${env-schedule}", "20:00-08:00", ""},
+		{"schedules/${env}", "schedules/prod", ""},
+		{"${env}-${env}", "prod-prod", ""},
+		{"schedules/${empty}nightly", "schedules/nightly", ""},
+		{"$env", "$env", ""},
+		{"${missing}", "", "unresolved variable '${missing}', the deployment has no 'missing' label"},
+		{"${env}/${missing}/${other}", "", "unresolved variable '${missing}'"},
+		{"${}", "", "unresolved variable '${}'"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			expanded, err := ExpandVariables(test.text, labels)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("expected the error '%s', got '%v'", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expanded != test.expected {
+				t.Errorf("expected '%s', got '%s'", test.expected, expanded)
+			}
+		})
+	}
+}
+
+func TestDecideExpandsVariables(t *testing.T) {
+	night := time.Date(2024, time.June, 3, 22, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		labels      map[string]string
+		state       DeploymentState
+		err         string
+	}{
+		{"schedule from a label", map[string]string{"scheduler.off-schedule": "${env-schedule}"}, map[string]string{"env-schedule": "20:00-08:00"}, DISABLED, ""},
+		{"other schedule from a label", map[string]string{"scheduler.off-schedule": "${env-schedule}"}, map[string]string{"env-schedule": "23:00-06:00"}, ENABLED, ""},
+		{"reference from a label", map[string]string{"scheduler.schedule-ref": "schedules/${env}"}, map[string]string{"env": "dev"}, DISABLED, ""},
+		{"other reference from a label", map[string]string{"scheduler.schedule-ref": "schedules/${env}"}, map[string]string{"env": "prod"}, ENABLED, ""},
+		{"unresolved schedule", map[string]string{"scheduler.off-schedule": "${env-schedule}"}, nil, ENABLED, "unresolved variable '${env-schedule}'"},
+		{"unresolved reference", map[string]string{"scheduler.schedule-ref": "schedules/${env}"}, map[string]string{"stage": "dev"}, ENABLED, "unresolved variable '${env}'"},
+	}
+
+	c, _ := newTestController(t, NewDefaultControllerConfig())
+	configMap := &core_v1.ConfigMap{
+		ObjectMeta: meta_v1.ObjectMeta{Namespace: "default", Name: "schedules"},
+		Data:       map[string]string{"dev": "20:00-08:00", "prod": "23:00-06:00"},
+	}
+	if err := c.configMapInformer.GetIndexer().Add(configMap); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			deployment := newTestDeployment("foo", 1, test.annotations)
+			deployment.Labels = test.labels
+			state, err := c.Decide(deployment, night)
+			if test.err == "" && err != nil {
+				t.Errorf("expected no error, got '%s'", err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("expected the error '%s', got '%v'", test.err, err)
+			}
+			if state != test.state {
+				t.Errorf("expected state %s, got %s", test.state, state)
+			}
+		})
+	}
+}
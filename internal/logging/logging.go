@@ -0,0 +1,47 @@
+// logging package carries the slog.Logger of an operation through its
+// context, so all the log lines of a single reconcile or HTTP request can be
+// correlated by their ID.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+)
+
+// CORRELATION_ID_KEY is the log attribute holding the correlation ID
+const CORRELATION_ID_KEY = "correlation_id"
+
+type loggerKey struct{}
+
+// WithLogger returns a copy of ctx carrying the logger
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// WithCorrelationID returns a copy of ctx carrying a logger that tags every
+// log line with the correlation ID, on top of the attributes of the logger
+// already carried by ctx.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return WithLogger(ctx, FromContext(ctx).With(CORRELATION_ID_KEY, id))
+}
+
+// FromContext returns the logger carried by ctx, or the default logger if
+// there is none
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// NewCorrelationID generates a random ID, short enough to keep the log lines
+// readable
+func NewCorrelationID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,57 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestWithCorrelationID(t *testing.T) {
+	tests := []struct {
+		name     string
+		ctx      func(logger *slog.Logger) context.Context
+		expected string
+	}{
+		{"logger in context", func(logger *slog.Logger) context.Context {
+			return WithLogger(context.Background(), logger.With("deployment", "default/foo"))
+		}, "msg=test deployment=default/foo correlation_id=abc"},
+		{"nested IDs", func(logger *slog.Logger) context.Context {
+			return WithCorrelationID(WithLogger(context.Background(), logger), "outer")
+		}, "msg=test correlation_id=outer correlation_id=abc"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logs := &bytes.Buffer{}
+			logger := slog.New(slog.NewTextHandler(logs, nil))
+			ctx := WithCorrelationID(test.ctx(logger), "abc")
+
+			FromContext(ctx).Info("test")
+			if !strings.Contains(logs.String(), test.expected) {
+				t.Errorf("expected the log line '%s', got '%s'", test.expected, logs)
+			}
+		})
+	}
+}
+
+func TestFromContextDefaultsToTheDefaultLogger(t *testing.T) {
+	if logger := FromContext(context.Background()); logger != slog.Default() {
+		t.Errorf("expected the default logger without a logger in the context")
+	}
+}
+
+func TestNewCorrelationID(t *testing.T) {
+	ids := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		id := NewCorrelationID()
+		if len(id) != 16 {
+			t.Errorf("expected an ID of 16 hex digits, got '%s'", id)
+		}
+		ids[id] = true
+	}
+	if len(ids) != 100 {
+		t.Errorf("expected unique IDs, got %d out of 100", len(ids))
+	}
+}
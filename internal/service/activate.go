@@ -0,0 +1,121 @@
+// activate.go holds the activator of the service, which wakes up scaled
+// down deployments on demand, e.g. on the first request after they went
+// idle.
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dimitris4000/concept02/internal/controller"
+	"github.com/dimitris4000/concept02/internal/logging"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Bounds of the wait of an activation for a ready pod
+const (
+	defaultActivateTimeout = time.Minute
+	activatePollInterval   = time.Second
+)
+
+// activateHandler scales a deployment back up to its remembered replicas.
+// With the 'wait=true' query parameter it only responds once the deployment
+// has a ready pod, or with 504 if that takes longer than the 'timeout'
+// query parameter (one minute by default).
+func (h *SchedulerService) activateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotSupported(w, r)
+		return
+	}
+
+	var d JsonResourceSpecifier
+	if r.Body == nil {
+		writeError(w, http.StatusBadRequest, "Please send a request body")
+		return
+	}
+	err := json.NewDecoder(r.Body).Decode(&d)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if d.Resource != "" || d.Selector != "" {
+		writeError(w, http.StatusBadRequest, "Only single deployments can be activated")
+		return
+	}
+
+	query := r.URL.Query()
+	waitReady := false
+	if text := query.Get("wait"); text != "" {
+		waitReady, err = strconv.ParseBool(text)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid wait parameter '%s', expected true or false", text))
+			return
+		}
+	}
+	timeout := defaultActivateTimeout
+	if text := query.Get("timeout"); text != "" {
+		timeout, err = time.ParseDuration(text)
+		if err != nil || timeout <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid timeout parameter '%s', expected a positive duration like '30s'", text))
+			return
+		}
+	}
+
+	k8s, err := h.k8sClient()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		logging.FromContext(r.Context()).Warn(fmt.Sprintf("%s", err))
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), h.Config.Controller.APITimeout)
+	defer cancel()
+	err = controller.ToggleDeployment(ctx, k8s, h.Config.Controller, d.Namespace, d.Name, controller.ENABLED)
+	if apierrors.IsNotFound(err) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		logging.FromContext(r.Context()).Warn(fmt.Sprintf("%s", err))
+		return
+	}
+
+	if waitReady {
+		err = waitForReadyPod(r.Context(), k8s, d.Namespace, d.Name, timeout)
+		if errors.Is(err, context.DeadlineExceeded) {
+			writeError(w, http.StatusGatewayTimeout, fmt.Sprintf("deployment '%s.%s' has no ready pod after %s", d.Namespace, d.Name, timeout))
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			logging.FromContext(r.Context()).Warn(fmt.Sprintf("%s", err))
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, JsonResponse{Status: STATUS_OK, Message: "Deployment activated", Data: d})
+}
+
+// waitForReadyPod polls the status of the deployment until it has at least
+// one ready replica. It fails with context.DeadlineExceeded once the timeout
+// expires.
+func waitForReadyPod(ctx context.Context, clientset kubernetes.Interface, namespace, name string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return wait.PollUntilContextCancel(ctx, activatePollInterval, true, func(ctx context.Context) (bool, error) {
+		deployment, err := clientset.AppsV1().Deployments(namespace).Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return deployment.Status.ReadyReplicas > 0, nil
+	})
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestActivateHandler(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		ready    bool
+		readyIn  time.Duration
+		status   int
+		replicas int32
+	}{
+		{"without waiting", http.MethodPost, "/activate", `{"namespace":"default","name":"foo"}`, false, 0, http.StatusOK, 3},
+		{"already ready", http.MethodPost, "/activate?wait=true", `{"namespace":"default","name":"foo"}`, true, 0, http.StatusOK, 3},
+		{"pods becoming ready", http.MethodPost, "/activate?wait=true&timeout=10s", `{"namespace":"default","name":"foo"}`, false, 200 * time.Millisecond, http.StatusOK, 3},
+		{"pods not ready in time", http.MethodPost, "/activate?wait=true&timeout=1500ms", `{"namespace":"default","name":"foo"}`, false, 0, http.StatusGatewayTimeout, 3},
+		{"not waiting for pods", http.MethodPost, "/activate?wait=false&timeout=1ms", `{"namespace":"default","name":"foo"}`, false, 0, http.StatusOK, 3},
+		{"missing deployment", http.MethodPost, "/activate", `{"namespace":"default","name":"bar"}`, false, 0, http.StatusNotFound, 0},
+		{"invalid wait", http.MethodPost, "/activate?wait=maybe", `{"namespace":"default","name":"foo"}`, false, 0, http.StatusBadRequest, 0},
+		{"invalid timeout", http.MethodPost, "/activate?wait=true&timeout=-1s", `{"namespace":"default","name":"foo"}`, false, 0, http.StatusBadRequest, 0},
+		{"selector", http.MethodPost, "/activate", `{"namespace":"default","selector":"app=foo"}`, false, 0, http.StatusBadRequest, 0},
+		{"invalid body", http.MethodPost, "/activate", `{`, false, 0, http.StatusBadRequest, 0},
+		{"unsupported method", http.MethodGet, "/activate", "", false, 0, http.StatusNotImplemented, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			deployment := newTestDeployment("foo", 0, map[string]string{"scheduler.replicas-memory": "3"})
+			if test.ready {
+				deployment.Status.ReadyReplicas = 1
+			}
+			h, clientset := newTestService(deployment)
+			if test.readyIn > 0 {
+				go func() {
+					time.Sleep(test.readyIn)
+					current, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+					if err != nil {
+						return
+					}
+					current.Status.ReadyReplicas = 1
+					clientset.AppsV1().Deployments("default").UpdateStatus(context.Background(), current, meta_v1.UpdateOptions{})
+				}()
+			}
+
+			recorder := serve(h, test.method, test.target, test.body)
+			if recorder.Code != test.status {
+				t.Fatalf("expected status %d, got %d: %s", test.status, recorder.Code, recorder.Body)
+			}
+			if test.replicas == 0 {
+				return
+			}
+			current, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *current.Spec.Replicas != test.replicas {
+				t.Errorf("expected %d replicas, got %d", test.replicas, *current.Spec.Replicas)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+// admission.go holds the validating admission webhook of the service which
+// can be registered in k8s to reject deployments with a bad schedule.
+
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/dimitris4000/concept02/internal/controller"
+	"github.com/dimitris4000/concept02/internal/logging"
+	admission_v1 "k8s.io/api/admission/v1"
+	apps_v1 "k8s.io/api/apps/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// validateHandler handles the AdmissionReview requests sent by the k8s API
+// on Deployment create/update. Deployments that carry a schedule annotation
+// which can not be parsed by the controller are rejected.
+func (h *SchedulerService) validateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotSupported(w, r)
+		return
+	}
+
+	var review admission_v1.AdmissionReview
+	if r.Body == nil {
+		writeError(w, http.StatusBadRequest, "Please send a request body")
+		return
+	}
+	err := json.NewDecoder(r.Body).Decode(&review)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if review.Request == nil {
+		writeError(w, http.StatusBadRequest, "AdmissionReview does not contain a request")
+		return
+	}
+
+	review.Response = validateDeployment(h.Config.Controller, review.Request)
+	review.Response.UID = review.Request.UID
+	review.Request = nil
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(review)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn(fmt.Sprintf("%s", err))
+	}
+}
+
+// validateDeployment checks the deployment of an admission request and
+// returns the verdict of the webhook.
+func validateDeployment(config controller.ControllerConfig, request *admission_v1.AdmissionRequest) *admission_v1.AdmissionResponse {
+	var deployment apps_v1.Deployment
+	err := json.Unmarshal(request.Object.Raw, &deployment)
+	if err != nil {
+		return &admission_v1.AdmissionResponse{
+			Allowed: false,
+			Result: &meta_v1.Status{
+				Message: fmt.Sprintf("could not decode deployment: %s", err),
+				Code:    http.StatusBadRequest,
+			},
+		}
+	}
+
+	// Only deployments that actually carry a schedule need to be checked
+	annotations := deployment.GetAnnotations()
+	if _, exists := annotations[config.Annotation(controller.SCHEDULE_ANNOTATION)]; !exists {
+		return &admission_v1.AdmissionResponse{Allowed: true}
+	}
+
+	schedule, err := controller.ParseScheduleAnnotation(config, annotations, deployment.GetLabels())
+	if err != nil {
+		return &admission_v1.AdmissionResponse{
+			Allowed: false,
+			Result: &meta_v1.Status{
+				Message: err.Error(),
+				Code:    http.StatusUnprocessableEntity,
+			},
+		}
+	}
+
+	// Suspiciously long windows are allowed, but kubectl shows the warning
+	response := &admission_v1.AdmissionResponse{Allowed: true}
+	if err := schedule.CheckWindowLength(config.MaxWindowLength); err != nil {
+		response.Warnings = []string{err.Error()}
+	}
+	return response
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	admission_v1 "k8s.io/api/admission/v1"
+	apps_v1 "k8s.io/api/apps/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// admissionReview builds the AdmissionReview the k8s API sends for a
+// deployment with the provided annotations
+func admissionReview(t *testing.T, annotations map[string]string) []byte {
+	t.Helper()
+	deployment := apps_v1.Deployment{
+		ObjectMeta: meta_v1.ObjectMeta{Namespace: "x", Name: "foo", Annotations: annotations},
+	}
+	raw, err := json.Marshal(deployment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	review := admission_v1.AdmissionReview{
+		Request: &admission_v1.AdmissionRequest{
+			UID:    types.UID("1234"),
+			Object: runtime.RawExtension{Raw: raw},
+		},
+	}
+	body, err := json.Marshal(review)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestValidateHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		allowed     bool
+		code        int32
+	}{
+		{"no annotations", nil, true, 0},
+		{"no schedule", map[string]string{"scheduler.enabled": "true"}, true, 0},
+		{"valid schedule", map[string]string{"scheduler.off-schedule": "20:00-08:00"}, true, 0},
+		{"valid schedule with days", map[string]string{"scheduler.off-schedule": "weekdays 19:00-07:00"}, true, 0},
+		{"valid JSON schedule", map[string]string{"scheduler.off-schedule": `{"windows":["20:00-08:00","12:00-13:00"],"timezone":"Europe/Athens"}`}, true, 0},
+		{"valid schedule with timezone", map[string]string{"scheduler.off-schedule": "20:00-08:00", "scheduler.timezone": "Europe/Athens"}, true, 0},
+		{"invalid schedule", map[string]string{"scheduler.off-schedule": "20:00"}, false, http.StatusUnprocessableEntity},
+		{"invalid hour", map[string]string{"scheduler.off-schedule": "25:00-08:00"}, false, http.StatusUnprocessableEntity},
+		{"empty schedule", map[string]string{"scheduler.off-schedule": ""}, false, http.StatusUnprocessableEntity},
+		{"invalid JSON schedule", map[string]string{"scheduler.off-schedule": `{"windows":[]}`}, false, http.StatusUnprocessableEntity},
+	}
+
+	h := &SchedulerService{Config: NewDefaultSchedulerServiceConfig()}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(admissionReview(t, test.annotations)))
+			h.validateHandler(recorder, request)
+
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusOK, recorder.Code, recorder.Body)
+			}
+			var review admission_v1.AdmissionReview
+			if err := json.NewDecoder(recorder.Body).Decode(&review); err != nil {
+				t.Fatal(err)
+			}
+			if review.Response == nil {
+				t.Fatal("expected a response")
+			}
+			if review.Response.UID != "1234" {
+				t.Errorf("expected the UID of the request, got '%s'", review.Response.UID)
+			}
+			if review.Response.Allowed != test.allowed {
+				t.Errorf("expected allowed %t, got %t", test.allowed, review.Response.Allowed)
+			}
+			if !test.allowed && (review.Response.Result == nil || review.Response.Result.Code != test.code) {
+				t.Errorf("expected a result with code %d, got %+v", test.code, review.Response.Result)
+			}
+		})
+	}
+}
+
+func TestValidateHandlerWarnsAboutLongWindows(t *testing.T) {
+	config := NewDefaultSchedulerServiceConfig()
+	config.Controller.MaxWindowLength = 16 * time.Hour
+	h := &SchedulerService{Config: config}
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(admissionReview(t, map[string]string{"scheduler.off-schedule": "08:00-07:00"})))
+	h.validateHandler(recorder, request)
+
+	var review admission_v1.AdmissionReview
+	if err := json.NewDecoder(recorder.Body).Decode(&review); err != nil {
+		t.Fatal(err)
+	}
+	if !review.Response.Allowed {
+		t.Errorf("expected long windows to be allowed")
+	}
+	if len(review.Response.Warnings) != 1 {
+		t.Errorf("expected a warning, got %v", review.Response.Warnings)
+	}
+}
+
+func TestValidateHandlerRejectsBadRequests(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+	}{
+		{"wrong method", http.MethodGet, "", http.StatusNotImplemented},
+		{"invalid JSON", http.MethodPost, "{", http.StatusBadRequest},
+		{"no request", http.MethodPost, "{}", http.StatusBadRequest},
+	}
+
+	h := &SchedulerService{Config: NewDefaultSchedulerServiceConfig()}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(test.method, "/validate", bytes.NewReader([]byte(test.body)))
+			h.validateHandler(recorder, request)
+
+			if recorder.Code != test.status {
+				t.Errorf("expected status %d, got %d", test.status, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestValidateDeploymentRejectsUndecodableObjects(t *testing.T) {
+	request := &admission_v1.AdmissionRequest{Object: runtime.RawExtension{Raw: []byte("not a deployment")}}
+	response := validateDeployment(NewDefaultSchedulerServiceConfig().Controller, request)
+	if response.Allowed {
+		t.Errorf("expected the object to be rejected")
+	}
+	if response.Result == nil || response.Result.Code != http.StatusBadRequest {
+		t.Errorf("expected a result with code %d, got %+v", http.StatusBadRequest, response.Result)
+	}
+}
@@ -0,0 +1,23 @@
+package service
+
+import "runtime"
+
+// BuildInfo describes the build of the running binary. The version, commit
+// and date are set at build time through ldflags, see the Dockerfile.
+type BuildInfo struct {
+	Version   string
+	GitCommit string
+	BuildDate string
+	GoVersion string
+}
+
+// NewBuildInfo creates a BuildInfo for a binary built by the running Go
+// version
+func NewBuildInfo(version, gitCommit, buildDate string) BuildInfo {
+	return BuildInfo{
+		Version:   version,
+		GitCommit: gitCommit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+	}
+}
@@ -6,4 +6,14 @@ package service
 type JsonResourceSpecifier struct {
 	Namespace string `json:"namespace"`
 	Name      string `json:"name"`
+	// Resource optionally selects a resource other than deployments, in the
+	// '<resource>.<group>' format (e.g. 'statefulsets.apps')
+	Resource string `json:"resource,omitempty"`
+	// Selector optionally selects all the deployments of the namespace
+	// matching a label selector (e.g. 'app=foo') instead of Name
+	Selector string `json:"selector,omitempty"`
+}
+
+type JsonReadiness struct {
+	Ready bool `json:"ready"`
 }
@@ -0,0 +1,109 @@
+// jsonresponses.go holds all the JSON schemas related to http responses
+// concept02 service is sending back to its clients
+
+package service
+
+import "time"
+
+// JsonResponse is the envelope of all the JSON responses of the service
+type JsonResponse struct {
+	Status  string      `json:"status"`
+	Message string      `json:"message,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+type JsonVersion struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"gitCommit"`
+	BuildDate string `json:"buildDate"`
+	GoVersion string `json:"goVersion"`
+}
+
+type JsonScheduleRange struct {
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Timezone string `json:"timezone"`
+	Days     string `json:"days,omitempty"`
+}
+
+type JsonScheduleCheck struct {
+	InRange bool                `json:"inRange"`
+	State   string              `json:"state"`
+	At      time.Time           `json:"at"`
+	Parsed  JsonScheduleRange   `json:"parsed"`
+	Windows []JsonScheduleRange `json:"windows,omitempty"`
+	Window  *JsonScheduleRange  `json:"window,omitempty"`
+}
+
+type JsonEffectiveSchedule struct {
+	Namespace      string              `json:"namespace"`
+	Name           string              `json:"name"`
+	Schedule       JsonScheduleRange   `json:"schedule"`
+	Windows        []JsonScheduleRange `json:"windows,omitempty"`
+	Exceptions     []string            `json:"exceptions,omitempty"`
+	OffDays        string              `json:"offDays,omitempty"`
+	InRange        bool                `json:"inRange"`
+	NextTransition *time.Time          `json:"nextTransition,omitempty"`
+	NextState      string              `json:"nextState,omitempty"`
+}
+
+type JsonScheduleSimulation struct {
+	Date        string           `json:"date"`
+	Schedule    string           `json:"schedule"`
+	Timezone    string           `json:"timezone"`
+	Transitions []JsonTransition `json:"transitions"`
+}
+
+type JsonTransition struct {
+	At     time.Time          `json:"at"`
+	State  string             `json:"state"`
+	Window *JsonScheduleRange `json:"window,omitempty"`
+	OffDay bool               `json:"offDay,omitempty"`
+}
+
+type JsonStatus struct {
+	ReconcileTotal int64                  `json:"reconcileTotal"`
+	LastReconcile  *time.Time             `json:"lastReconcile,omitempty"`
+	Counts         JsonStatusCounts       `json:"counts"`
+	Deployments    []JsonDeploymentStatus `json:"deployments"`
+}
+
+type JsonStatusCounts struct {
+	Total int `json:"total"`
+	Up    int `json:"up"`
+	Down  int `json:"down"`
+}
+
+type JsonDeploymentStatus struct {
+	Namespace      string     `json:"namespace"`
+	Name           string     `json:"name"`
+	Replicas       int32      `json:"replicas"`
+	State          string     `json:"state"`
+	DesiredState   string     `json:"desiredState"`
+	Error          string     `json:"error,omitempty"`
+	NextTransition *time.Time `json:"nextTransition,omitempty"`
+	NextState      string     `json:"nextState,omitempty"`
+}
+
+type JsonScaleResult struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+type JsonManagementState struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Enabled   bool   `json:"enabled"`
+	Value     string `json:"value"`
+}
+
+type JsonDeploymentEvent struct {
+	Namespace        string    `json:"namespace"`
+	Name             string    `json:"name"`
+	State            string    `json:"state"`
+	Replicas         int32     `json:"replicas"`
+	PreviousReplicas int32     `json:"previousReplicas"`
+	Time             time.Time `json:"time"`
+}
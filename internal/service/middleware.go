@@ -0,0 +1,85 @@
+// middleware.go holds the http middlewares wrapping the handlers of the service
+
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dimitris4000/concept02/internal/controller"
+	"github.com/dimitris4000/concept02/internal/logging"
+)
+
+// CORRELATION_ID_HEADER carries the correlation ID of a request. An ID sent
+// by the client is kept so the logs can be matched with the client's ones.
+const CORRELATION_ID_HEADER = "X-Request-Id"
+
+// statusRecorder wraps a http.ResponseWriter to capture the status code
+// written by the handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap gives http.ResponseController access to the wrapped writer, e.g.
+// to flush streamed responses
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// loggingMiddleware logs the method, path, status code and duration of every
+// request. The probe endpoints are skipped unless logProbes is set, to avoid
+// flooding the logs.
+func loggingMiddleware(next http.Handler, logProbes bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !logProbes && isProbePath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		logging.FromContext(r.Context()).Info(fmt.Sprintf("%s %s", r.Method, r.URL.Path),
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"duration", time.Since(start),
+		)
+	})
+}
+
+// auditMiddleware attributes the scale actions of every request to the HTTP
+// actor in the audit log, with the request and its client as the reason
+func auditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reason := fmt.Sprintf("%s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		next.ServeHTTP(w, r.WithContext(controller.WithAuditSource(r.Context(), controller.AUDIT_ACTOR_HTTP, reason)))
+	})
+}
+
+// correlationMiddleware tags the logs of every request with a correlation
+// ID, which is also sent back to the client in the X-Request-Id header.
+func correlationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(CORRELATION_ID_HEADER)
+		if id == "" || len(id) > 64 {
+			id = logging.NewCorrelationID()
+		}
+		w.Header().Set(CORRELATION_ID_HEADER, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithCorrelationID(r.Context(), id)))
+	})
+}
+
+// isProbePath checks if the path belongs to the health/readiness probes
+func isProbePath(path string) bool {
+	return path == "/liveness" || path == "/healthz" || path == "/readiness" || strings.HasPrefix(path, "/readiness/")
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/dimitris4000/concept02/internal/controller"
+)
+
+// captureLogs sends the default logger to the returned buffer for the
+// duration of the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	logger := slog.Default()
+	logs := &bytes.Buffer{}
+	slog.SetDefault(slog.New(slog.NewTextHandler(logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(logger) })
+	return logs
+}
+
+func TestLoggingMiddleware(t *testing.T) {
+	tests := []struct {
+		name      string
+		logProbes bool
+		method    string
+		path      string
+		body      string
+		logged    string
+	}{
+		{"scale request", false, http.MethodPost, "/scaleDown", `{"namespace":"default","name":"foo"}`, "method=POST path=/scaleDown status=200 duration="},
+		{"failed scale request", false, http.MethodPost, "/scaleDown", `{`, "method=POST path=/scaleDown status=400 duration="},
+		{"unsupported method", false, http.MethodGet, "/scaleUp", "", "method=GET path=/scaleUp status=501 duration="},
+		{"liveness probe", false, http.MethodGet, "/liveness", "", ""},
+		{"readiness probe", false, http.MethodGet, "/readiness", "", ""},
+		{"readiness toggle", false, http.MethodPost, "/readiness/ready", "", ""},
+		{"logged liveness probe", true, http.MethodGet, "/liveness", "", "method=GET path=/liveness status=200 duration="},
+		{"logged readiness probe", true, http.MethodGet, "/readiness", "", "method=GET path=/readiness status=200 duration="},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			config := NewDefaultSchedulerServiceConfig()
+			config.LogProbes = test.logProbes
+			h := NewSchedulerService(config, nil)
+			_, clientset := newTestService(newTestDeployment("foo", 3, nil))
+			h.clientset = clientset
+
+			serve(h, test.method, test.path, test.body)
+			if test.logged == "" {
+				if strings.Contains(logs.String(), "path="+test.path) {
+					t.Errorf("expected the request not to be logged, got the logs %s", logs)
+				}
+				return
+			}
+			if !strings.Contains(logs.String(), test.logged) {
+				t.Errorf("expected the log line '%s', got the logs %s", test.logged, logs)
+			}
+			if !strings.Contains(logs.String(), "correlation_id=") {
+				t.Errorf("expected the request log to carry a correlation ID, got the logs %s", logs)
+			}
+		})
+	}
+}
+
+func TestCorrelationMiddleware(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    string
+		generated bool
+	}{
+		{"generated ID", "", true},
+		{"client ID", "deploy-1234", false},
+		{"client ID too long", strings.Repeat("a", 65), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			h, _ := newTestService(newTestDeployment("foo", 3, nil))
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/scaleDown", strings.NewReader(`{"namespace":"default","name":"foo"}`))
+			if test.header != "" {
+				request.Header.Set(CORRELATION_ID_HEADER, test.header)
+			}
+
+			h.Http.Handler.ServeHTTP(recorder, request)
+			id := recorder.Header().Get(CORRELATION_ID_HEADER)
+			if test.generated && (len(id) != 16 || id == test.header) {
+				t.Errorf("expected a generated ID, got '%s'", id)
+			}
+			if !test.generated && id != test.header {
+				t.Errorf("expected the ID '%s', got '%s'", test.header, id)
+			}
+			// The ID reaches the logs of the scale action
+			for _, line := range strings.Split(logs.String(), "\n") {
+				if strings.Contains(line, "Scaling down deployment") && !strings.Contains(line, "correlation_id="+id) {
+					t.Errorf("expected the scale log to carry the ID %s, got '%s'", id, line)
+				}
+			}
+			if !strings.Contains(logs.String(), "Scaling down deployment") {
+				t.Errorf("expected the scale action to be logged, got the logs %s", logs)
+			}
+		})
+	}
+}
+
+func TestAuditMiddleware(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name    string
+		target  string
+		body    string
+		entries []string
+	}{
+		{"scale down", "/scaleDown", `{"namespace":"default","name":"foo"}`, []string{"default/foo 3 -> 0 by POST /scaleDown from 192.0.2.1:1234"}},
+		{"scale up", "/scaleUp", `{"namespace":"default","name":"bar"}`, []string{"default/bar 0 -> 2 by POST /scaleUp from 192.0.2.1:1234"}},
+		{"selector", "/scaleUp", `{"namespace":"default","selector":"app=shop"}`, []string{"default/bar 0 -> 2 by POST /scaleUp from 192.0.2.1:1234"}},
+		{"no change", "/scaleUp", `{"namespace":"default","name":"foo"}`, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			foo := newTestLabeledDeployment("default", "foo", 3, map[string]string{"app": "shop"})
+			bar := newTestLabeledDeployment("default", "bar", 0, map[string]string{"app": "shop"})
+			bar.Annotations = map[string]string{"scheduler.replicas-memory": "2"}
+			h, _ := newTestService(foo, bar)
+			h.Config.Controller.AuditLog = filepath.Join(t.TempDir(), "audit.log")
+
+			recorder := serve(h, http.MethodPost, test.target, test.body)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusOK, recorder.Code, recorder.Body)
+			}
+			entries := []string{}
+			if file, err := os.Open(h.Config.Controller.AuditLog); err == nil {
+				defer file.Close()
+				scanner := bufio.NewScanner(file)
+				for scanner.Scan() {
+					var entry controller.AuditEntry
+					if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+						t.Fatal(err)
+					}
+					if entry.Actor != controller.AUDIT_ACTOR_HTTP {
+						t.Errorf("expected the actor %s, got %s", controller.AUDIT_ACTOR_HTTP, entry.Actor)
+					}
+					entries = append(entries, fmt.Sprintf("%s/%s %d -> %d by %s", entry.Namespace, entry.Name, entry.FromReplicas, entry.ToReplicas, entry.Reason))
+				}
+			}
+			sort.Strings(entries)
+			if strings.Join(entries, ", ") != strings.Join(test.entries, ", ") {
+				t.Errorf("expected the audit entries %v, got %v", test.entries, entries)
+			}
+		})
+	}
+}
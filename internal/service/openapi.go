@@ -0,0 +1,22 @@
+package service
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPIDocument is the OpenAPI 3 description of the HTTP API. It is
+// maintained by hand next to the handlers of configureHandlers.
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// openAPIHandler serves the OpenAPI document of the HTTP API
+func (h *SchedulerService) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotSupported(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDocument)
+}
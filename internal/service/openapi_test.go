@@ -0,0 +1,137 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// openAPI is the part of the OpenAPI document checked by the tests
+type openAPI struct {
+	OpenAPI    string                                `json:"openapi"`
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+func TestOpenAPIHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		status int
+	}{
+		{"get", http.MethodGet, http.StatusOK},
+		{"post", http.MethodPost, http.StatusNotImplemented},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h, _ := newTestService()
+			recorder := serve(h, test.method, "/openapi.json", "")
+			if recorder.Code != test.status {
+				t.Fatalf("expected status %d, got %d", test.status, recorder.Code)
+			}
+			if test.status != http.StatusOK {
+				return
+			}
+			if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+				t.Errorf("expected the content type application/json, got %s", contentType)
+			}
+			var document openAPI
+			if err := json.NewDecoder(recorder.Body).Decode(&document); err != nil {
+				t.Fatalf("expected a valid JSON document, got '%s'", err)
+			}
+			if !strings.HasPrefix(document.OpenAPI, "3.") {
+				t.Errorf("expected an OpenAPI 3 document, got version '%s'", document.OpenAPI)
+			}
+		})
+	}
+}
+
+func TestOpenAPIDocumentPaths(t *testing.T) {
+	var document openAPI
+	if err := json.Unmarshal(openAPIDocument, &document); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path    string
+		methods []string
+	}{
+		{"/version", []string{"get"}},
+		{"/liveness", []string{"get"}},
+		{"/healthz", []string{"get"}},
+		{"/readiness", []string{"get", "post"}},
+		{"/readiness/ready", []string{"post"}},
+		{"/readiness/notready", []string{"post"}},
+		{"/scaleDown", []string{"post"}},
+		{"/scaleUp", []string{"post"}},
+		{"/enable", []string{"post"}},
+		{"/disable", []string{"post"}},
+		{"/activate", []string{"post"}},
+		{"/schedule/check", []string{"get"}},
+		{"/schedule/simulate", []string{"get"}},
+		{"/schedule", []string{"get"}},
+		{"/status", []string{"get"}},
+		{"/reload", []string{"post"}},
+		{"/watch", []string{"get"}},
+		{"/metrics", []string{"get"}},
+		{"/validate", []string{"post"}},
+		{"/openapi.json", []string{"get"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			operations, exists := document.Paths[test.path]
+			if !exists {
+				t.Fatalf("expected the path %s in the document", test.path)
+			}
+			for _, method := range test.methods {
+				if _, exists := operations[method]; !exists {
+					t.Errorf("expected the %s operation of %s", method, test.path)
+				}
+			}
+		})
+	}
+	if len(document.Paths) != len(tests) {
+		t.Errorf("expected %d documented paths, got %d", len(tests), len(document.Paths))
+	}
+}
+
+func TestOpenAPIDocumentMatchesHandlers(t *testing.T) {
+	var document openAPI
+	if err := json.Unmarshal(openAPIDocument, &document); err != nil {
+		t.Fatal(err)
+	}
+	h, _ := newTestService()
+	mux := http.NewServeMux()
+	h.configureHandlers(mux)
+	for path := range document.Paths {
+		// Paths under /readiness/ are served by the handler of the subtree
+		expected := path
+		if strings.HasPrefix(path, "/readiness/") {
+			expected = "/readiness/"
+		}
+		if _, pattern := mux.Handler(httptest.NewRequest(http.MethodGet, path, nil)); pattern != expected {
+			t.Errorf("expected the documented path %s to be served by %s, got '%s'", path, expected, pattern)
+		}
+	}
+
+	// The request schema lists every field of JsonResourceSpecifier
+	properties := document.Components.Schemas["JsonResourceSpecifier"].Properties
+	specifier := reflect.TypeOf(JsonResourceSpecifier{})
+	for i := 0; i < specifier.NumField(); i++ {
+		name, _, _ := strings.Cut(specifier.Field(i).Tag.Get("json"), ",")
+		if _, exists := properties[name]; !exists {
+			t.Errorf("expected the property '%s' in the JsonResourceSpecifier schema", name)
+		}
+	}
+	if len(properties) != specifier.NumField() {
+		t.Errorf("expected %d JsonResourceSpecifier properties, got %d", specifier.NumField(), len(properties))
+	}
+}
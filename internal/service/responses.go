@@ -0,0 +1,65 @@
+// responses.go holds the helpers used by the handlers to write responses
+
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+const (
+	STATUS_OK    = "ok"
+	STATUS_ERROR = "error"
+)
+
+// writeJSON writes the payload as the JSON body of the response
+func writeJSON(w http.ResponseWriter, status int, payload JsonResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(payload)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to write response: %s", err))
+	}
+}
+
+// writeData writes a successful response carrying the provided data
+func writeData(w http.ResponseWriter, data interface{}) {
+	writeJSON(w, http.StatusOK, JsonResponse{Status: STATUS_OK, Data: data})
+}
+
+// writeError writes an error response with the provided message
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, JsonResponse{Status: STATUS_ERROR, Message: message})
+}
+
+// writeMethodNotSupported writes the response of requests with a method the
+// handler does not support
+func writeMethodNotSupported(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotImplemented, fmt.Sprintf("method %s is not supported", r.Method))
+}
+
+// wantsJSON checks if the client asked for a JSON response, either through
+// the Accept header or the 'format=json' query parameter. It is used by the
+// endpoints that answer in plain text by default (e.g. probes).
+func wantsJSON(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// writeText writes a plain text response, or the equivalent JSON envelope if
+// the client asked for JSON.
+func writeText(w http.ResponseWriter, r *http.Request, status int, text string) {
+	if wantsJSON(r) {
+		responseStatus := STATUS_OK
+		if status >= http.StatusBadRequest {
+			responseStatus = STATUS_ERROR
+		}
+		writeJSON(w, status, JsonResponse{Status: responseStatus, Message: text})
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintln(w, text)
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestResponseFormats(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		accept      string
+		body        string
+		status      int
+		contentType string
+		envelope    string
+		text        string
+	}{
+		{"plain text liveness", http.MethodGet, "/liveness", "", "", http.StatusOK, "text/plain; charset=utf-8", "", "OK\n"},
+		{"JSON liveness by query", http.MethodGet, "/liveness?format=json", "", "", http.StatusOK, "application/json", STATUS_OK, ""},
+		{"JSON liveness by Accept header", http.MethodGet, "/liveness", "application/json", "", http.StatusOK, "application/json", STATUS_OK, ""},
+		{"plain text readiness", http.MethodGet, "/readiness", "text/plain", "", http.StatusOK, "text/plain; charset=utf-8", "", "OK\n"},
+		{"JSON error", http.MethodGet, "/schedule/check?expr=20:00", "", "", http.StatusBadRequest, "application/json", STATUS_ERROR, ""},
+		{"JSON error of a plain text endpoint", http.MethodPost, "/readiness?format=json", "", "{", http.StatusBadRequest, "application/json", STATUS_ERROR, ""},
+		{"unsupported method", http.MethodGet, "/enable", "", "", http.StatusNotImplemented, "application/json", STATUS_ERROR, ""},
+		{"missing body", http.MethodPost, "/enable", "", "", http.StatusBadRequest, "application/json", STATUS_ERROR, ""},
+		{"JSON data", http.MethodGet, "/schedule/check?expr=20:00-08:00", "", "", http.StatusOK, "application/json", STATUS_OK, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h, _ := newTestService()
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(test.method, test.target, strings.NewReader(test.body))
+			if test.accept != "" {
+				request.Header.Set("Accept", test.accept)
+			}
+			h.Http.Handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != test.status {
+				t.Errorf("expected status %d, got %d", test.status, recorder.Code)
+			}
+			if contentType := recorder.Header().Get("Content-Type"); contentType != test.contentType {
+				t.Errorf("expected the content type '%s', got '%s'", test.contentType, contentType)
+			}
+			if test.envelope == "" {
+				if recorder.Body.String() != test.text {
+					t.Errorf("expected the body '%s', got '%s'", test.text, recorder.Body)
+				}
+				return
+			}
+			var response JsonResponse
+			if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Status != test.envelope {
+				t.Errorf("expected the status '%s', got '%s'", test.envelope, response.Status)
+			}
+			if test.envelope == STATUS_ERROR && response.Message == "" {
+				t.Errorf("expected an error message")
+			}
+			if test.envelope == STATUS_OK && response.Message == "" && response.Data == nil {
+				t.Errorf("expected a message or data, got %+v", response)
+			}
+		})
+	}
+}
+
+func TestVersionHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      string
+		accept      string
+		contentType string
+		text        string
+	}{
+		{"plain text", "/version", "", "text/plain; charset=utf-8", "1.2.3\n"},
+		{"plain text by Accept header", "/version", "text/plain", "text/plain; charset=utf-8", "1.2.3\n"},
+		{"JSON by Accept header", "/version", "application/json", "application/json", ""},
+		{"JSON among other types", "/version", "text/html, application/json;q=0.9", "application/json", ""},
+		{"JSON by query", "/version?format=json", "", "application/json", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h, _ := newTestService()
+			h.Config.Build = BuildInfo{Version: "1.2.3", GitCommit: "abc1234", BuildDate: "2024-06-03T12:00:00Z", GoVersion: "go1.22.0"}
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, test.target, nil)
+			if test.accept != "" {
+				request.Header.Set("Accept", test.accept)
+			}
+			h.Http.Handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d", http.StatusOK, recorder.Code)
+			}
+			if contentType := recorder.Header().Get("Content-Type"); contentType != test.contentType {
+				t.Errorf("expected the content type '%s', got '%s'", test.contentType, contentType)
+			}
+			if test.text != "" {
+				if recorder.Body.String() != test.text {
+					t.Errorf("expected the body '%s', got '%s'", test.text, recorder.Body)
+				}
+				return
+			}
+			var version JsonVersion
+			response := JsonResponse{Data: &version}
+			if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			expected := JsonVersion{Version: "1.2.3", GitCommit: "abc1234", BuildDate: "2024-06-03T12:00:00Z", GoVersion: "go1.22.0"}
+			if response.Status != STATUS_OK || version != expected {
+				t.Errorf("expected the version %+v, got %+v (status %s)", expected, version, response.Status)
+			}
+		})
+	}
+}
+
+func TestNewBuildInfo(t *testing.T) {
+	build := NewBuildInfo("1.2.3", "abc1234", "2024-06-03T12:00:00Z")
+	if build.Version != "1.2.3" || build.GitCommit != "abc1234" || build.BuildDate != "2024-06-03T12:00:00Z" {
+		t.Errorf("expected the provided build metadata, got %+v", build)
+	}
+	if build.GoVersion != runtime.Version() {
+		t.Errorf("expected the Go version %s, got %s", runtime.Version(), build.GoVersion)
+	}
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/dimitris4000/concept02/internal/controller"
+	"github.com/dimitris4000/concept02/internal/logging"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// toggleSelected scales all the deployments of a namespace matching the
+// label selector of the request, e.g. the deployments of an app composed of
+// several ones, and responds with the result of every deployment. A
+// deployment failing to scale does not stop the rest.
+func (h *SchedulerService) toggleSelected(ctx context.Context, w http.ResponseWriter, r *http.Request, d JsonResourceSpecifier, targetState controller.DeploymentState) {
+	if d.Name != "" || d.Resource != "" {
+		writeError(w, http.StatusBadRequest, "The selector can not be combined with a name or a resource")
+		return
+	}
+	if d.Namespace == "" {
+		writeError(w, http.StatusBadRequest, "Please provide the namespace of the selected deployments")
+		return
+	}
+	selector, err := labels.Parse(d.Selector)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid selector '%s': %s", d.Selector, err))
+		return
+	}
+
+	k8s, err := h.k8sClient()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		logging.FromContext(r.Context()).Warn(fmt.Sprintf("%s", err))
+		return
+	}
+	deployments, err := k8s.AppsV1().Deployments(d.Namespace).List(ctx, meta_v1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		logging.FromContext(r.Context()).Warn(fmt.Sprintf("%s", err))
+		return
+	}
+
+	results := []JsonScaleResult{}
+	failed := 0
+	for _, deployment := range deployments.Items {
+		result := JsonScaleResult{Namespace: deployment.Namespace, Name: deployment.Name, Status: STATUS_OK}
+		err := controller.ToggleDeployment(ctx, k8s, h.Config.Controller, deployment.Namespace, deployment.Name, targetState)
+		if err != nil {
+			logging.FromContext(r.Context()).Warn(fmt.Sprintf("%s", err))
+			result.Status = STATUS_ERROR
+			result.Error = err.Error()
+			failed++
+		}
+		results = append(results, result)
+	}
+
+	if failed > 0 {
+		writeJSON(w, http.StatusInternalServerError, JsonResponse{Status: STATUS_ERROR, Message: fmt.Sprintf("%d of %d deployments failed to scale", failed, len(results)), Data: results})
+		return
+	}
+	writeJSON(w, http.StatusOK, JsonResponse{Status: STATUS_OK, Message: fmt.Sprintf("Request received for %d deployments", len(results)), Data: results})
+}
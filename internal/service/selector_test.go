@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	apps_v1 "k8s.io/api/apps/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8s_testing "k8s.io/client-go/testing"
+)
+
+// newTestLabeledDeployment creates a deployment with the labels
+func newTestLabeledDeployment(namespace, name string, replicas int32, labels map[string]string) *apps_v1.Deployment {
+	deployment := newTestDeployment(name, replicas, nil)
+	deployment.Namespace = namespace
+	deployment.Labels = labels
+	return deployment
+}
+
+func TestScaleSelected(t *testing.T) {
+	discardLogs(t)
+	objects := []runtime.Object{
+		newTestLabeledDeployment("default", "frontend", 2, map[string]string{"app": "shop", "tier": "web"}),
+		newTestLabeledDeployment("default", "backend", 3, map[string]string{"app": "shop", "tier": "api"}),
+		newTestLabeledDeployment("default", "blog", 1, map[string]string{"app": "blog"}),
+		newTestLabeledDeployment("other", "frontend", 2, map[string]string{"app": "shop", "tier": "web"}),
+	}
+	tests := []struct {
+		name     string
+		target   string
+		body     string
+		failing  string
+		status   int
+		results  []string
+		replicas map[string]int32
+	}{
+		{"no matches", "/scaleDown", `{"namespace":"default","selector":"app=wiki"}`, "", http.StatusOK, []string{}, map[string]int32{"default/frontend": 2, "default/backend": 3, "default/blog": 1, "other/frontend": 2}},
+		{"single match", "/scaleDown", `{"namespace":"default","selector":"app=blog"}`, "", http.StatusOK, []string{"default/blog ok"}, map[string]int32{"default/frontend": 2, "default/backend": 3, "default/blog": 0, "other/frontend": 2}},
+		{"several matches", "/scaleDown", `{"namespace":"default","selector":"app=shop"}`, "", http.StatusOK, []string{"default/backend ok", "default/frontend ok"}, map[string]int32{"default/frontend": 0, "default/backend": 0, "default/blog": 1, "other/frontend": 2}},
+		{"set based selector", "/scaleDown", `{"namespace":"default","selector":"tier in (web,api),app!=blog"}`, "", http.StatusOK, []string{"default/backend ok", "default/frontend ok"}, map[string]int32{"default/frontend": 0, "default/backend": 0, "default/blog": 1, "other/frontend": 2}},
+		{"scale up", "/scaleUp", `{"namespace":"default","selector":"app=shop"}`, "", http.StatusOK, []string{"default/backend ok", "default/frontend ok"}, map[string]int32{"default/frontend": 2, "default/backend": 3, "default/blog": 1, "other/frontend": 2}},
+		{"failed deployment", "/scaleDown", `{"namespace":"default","selector":"app=shop"}`, "backend", http.StatusInternalServerError, []string{"default/backend error", "default/frontend ok"}, map[string]int32{"default/frontend": 0, "default/backend": 3, "default/blog": 1, "other/frontend": 2}},
+		{"invalid selector", "/scaleDown", `{"namespace":"default","selector":"app in shop"}`, "", http.StatusBadRequest, nil, nil},
+		{"without a namespace", "/scaleDown", `{"selector":"app=shop"}`, "", http.StatusBadRequest, nil, nil},
+		{"with a name", "/scaleDown", `{"namespace":"default","name":"frontend","selector":"app=shop"}`, "", http.StatusBadRequest, nil, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h, clientset := newTestService(objects...)
+			if test.failing != "" {
+				clientset.PrependReactor("update", "deployments", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+					if action.(k8s_testing.UpdateAction).GetObject().(*apps_v1.Deployment).Name == test.failing {
+						return true, nil, errors.New("injected failure")
+					}
+					return false, nil, nil
+				})
+			}
+
+			recorder := serve(h, http.MethodPost, test.target, test.body)
+			if recorder.Code != test.status {
+				t.Fatalf("expected status %d, got %d: %s", test.status, recorder.Code, recorder.Body)
+			}
+			if test.results == nil {
+				return
+			}
+			var results []JsonScaleResult
+			decodeData(t, recorder, &results)
+			got := []string{}
+			for _, result := range results {
+				got = append(got, result.Namespace+"/"+result.Name+" "+result.Status)
+			}
+			sort.Strings(got)
+			if strings.Join(got, ", ") != strings.Join(test.results, ", ") {
+				t.Errorf("expected the results %v, got %v", test.results, got)
+			}
+			for key, replicas := range test.replicas {
+				namespace, name, _ := strings.Cut(key, "/")
+				deployment, err := clientset.AppsV1().Deployments(namespace).Get(context.Background(), name, meta_v1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if *deployment.Spec.Replicas != replicas {
+					t.Errorf("expected %d replicas of %s, got %d", replicas, key, *deployment.Spec.Replicas)
+				}
+			}
+		})
+	}
+}
@@ -5,178 +5,649 @@ package service
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/dimitris4000/concept02/internal/controller"
+	"github.com/dimitris4000/concept02/internal/logging"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	apps_v1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
 )
 
+// minShutdownTimeout is the least time in-flight requests get to complete
+// on shutdown
+const minShutdownTimeout = time.Second
+
 // SchedulerServiceConfig is holding all the configuration
 // of the http service of the scheduler
 type SchedulerServiceConfig struct {
-	Version              string
+	Build                BuildInfo
 	ShutdownWaitDuration time.Duration
+	Controller           controller.ControllerConfig
+	// TLSCertFile and TLSKeyFile enable HTTPS when both are set
+	TLSCertFile string
+	TLSKeyFile  string
+	// LogProbes enables the request logging of the probe endpoints
+	LogProbes bool
+	// HealthzStaleIntervals is how many controller resync intervals may pass
+	// without a reconcile before /healthz reports unhealthy
+	HealthzStaleIntervals int
 }
 
 // NewDefaultSchedulerServiceConfig is used to create an initial
 // SchedulerServiceConfig instance with sane defaults
 func NewDefaultSchedulerServiceConfig() SchedulerServiceConfig {
 	return SchedulerServiceConfig{
-		Version:              "0.0.0",
-		ShutdownWaitDuration: 15 * time.Second,
+		Build:                 NewBuildInfo("0.0.0", "unknown", "unknown"),
+		ShutdownWaitDuration:  5 * time.Second,
+		Controller:            controller.NewDefaultControllerConfig(),
+		HealthzStaleIntervals: 6,
 	}
 }
 
 // SchedulerService is the core struct of the http service
 // portion of the scheduler service
 type SchedulerService struct {
-	Http               *http.Server
-	Config             SchedulerServiceConfig
-	serverReady        bool
+	Http       *http.Server
+	Config     SchedulerServiceConfig
+	controller *controller.Controller
+	// clientset is the k8s API client of the handlers, loaded on demand
+	// from the controller configuration when not set
+	clientset          kubernetes.Interface
+	serverReady        atomic.Bool
 	terminationChannel chan os.Signal
 }
 
-// NewSchedulerService initializes the http server of the scheduler service
-func NewSchedulerService(config SchedulerServiceConfig) *SchedulerService {
+// NewSchedulerService initializes the http server of the scheduler service.
+// The controller is optional and is used to report its state through the
+// service's endpoints.
+func NewSchedulerService(config SchedulerServiceConfig, schedulerController *controller.Controller) *SchedulerService {
 	mux := http.NewServeMux()
 	newService := &SchedulerService{
 		Http: &http.Server{
 			Addr:    ":8081", // This can be remapped in k8s resources
-			Handler: mux,
+			Handler: correlationMiddleware(loggingMiddleware(auditMiddleware(mux), config.LogProbes)),
 		},
 		Config:             config,
-		serverReady:        true,
+		controller:         schedulerController,
 		terminationChannel: make(chan os.Signal, 1),
 	}
-	newService.configureHandlers()
+	newService.serverReady.Store(true)
+	newService.configureHandlers(mux)
 
 	return newService
 }
 
 // configureHandlers functions is meant to contain all the configuration of
 // the URL paths of the Scheduler service
-func (h *SchedulerService) configureHandlers() {
-	mux := h.Http.Handler.(*http.ServeMux)
+func (h *SchedulerService) configureHandlers(mux *http.ServeMux) {
 	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintln(w, h.Config.Version)
+		if wantsJSON(r) {
+			writeData(w, JsonVersion{
+				Version:   h.Config.Build.Version,
+				GitCommit: h.Config.Build.GitCommit,
+				BuildDate: h.Config.Build.BuildDate,
+				GoVersion: h.Config.Build.GoVersion,
+			})
+			return
+		}
+		fmt.Fprintln(w, h.Config.Build.Version)
 	})
 
 	mux.HandleFunc("/liveness", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprintln(w, "OK")
+		writeText(w, r, http.StatusOK, "OK")
+	})
+
+	// Deeper health check of the controller than the liveness probe
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if h.controller != nil {
+			ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+			defer cancel()
+			err := h.controller.CheckHealth(ctx, h.Config.HealthzStaleIntervals)
+			if err != nil {
+				writeText(w, r, http.StatusServiceUnavailable, fmt.Sprintf("NOT OK (%s)", err))
+				return
+			}
+		}
+		writeText(w, r, http.StatusOK, "OK")
 	})
 
 	readinessHandler := func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodPost {
 			if r.URL.Path == "/readiness/ready" {
-				h.serverReady = true
+				h.serverReady.Store(true)
 			} else if r.URL.Path == "/readiness/notready" {
-				h.serverReady = false
+				h.serverReady.Store(false)
+			} else if r.Body != nil && r.ContentLength != 0 {
+				var d JsonReadiness
+				err := json.NewDecoder(r.Body).Decode(&d)
+				if err != nil {
+					writeError(w, http.StatusBadRequest, err.Error())
+					return
+				}
+				h.serverReady.Store(d.Ready)
 			}
 		}
 
-		if h.serverReady {
-			w.WriteHeader(http.StatusOK)
-			fmt.Fprintln(w, "OK")
+		// The pod is not ready before the controller's cache is warm
+		if h.controller != nil && !h.controller.HasSynced() {
+			writeText(w, r, http.StatusServiceUnavailable, "NOT OK (controller not synced)")
+		} else if h.serverReady.Load() {
+			writeText(w, r, http.StatusOK, "OK")
 		} else {
-			w.WriteHeader(http.StatusServiceUnavailable)
-			fmt.Fprintln(w, "NOT OK")
+			writeText(w, r, http.StatusServiceUnavailable, "NOT OK")
 		}
 	}
 	mux.HandleFunc("/readiness", readinessHandler)
 	mux.HandleFunc("/readiness/", readinessHandler)
 
-	mux.HandleFunc("/scaleDown", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			w.WriteHeader(http.StatusNotImplemented)
+	// Scale a single resource, or all the deployments matching a selector
+	scaleHandler := func(targetState controller.DeploymentState) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				writeMethodNotSupported(w, r)
+				return
+			}
+
+			var d JsonResourceSpecifier
+			if r.Body == nil {
+				writeError(w, http.StatusBadRequest, "Please send a request body")
+				return
+			}
+			err := json.NewDecoder(r.Body).Decode(&d)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), h.Config.Controller.APITimeout)
+			defer cancel()
+			if d.Selector != "" {
+				h.toggleSelected(ctx, w, r, d, targetState)
+				return
+			}
+			err = h.toggleResource(ctx, d, targetState)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				logging.FromContext(r.Context()).Warn(fmt.Sprintf("%s", err))
+				return
+			}
+
+			writeJSON(w, http.StatusOK, JsonResponse{Status: STATUS_OK, Message: "Request received", Data: d})
 		}
+	}
+	mux.HandleFunc("/scaleDown", scaleHandler(controller.DISABLED))
+	mux.HandleFunc("/scaleUp", scaleHandler(controller.ENABLED))
 
-		var d JsonResourceSpecifier
-		if r.Body == nil {
-			http.Error(w, "Please send a request body", http.StatusBadRequest)
+	// Turn scheduling on or off for a deployment through its enabled annotation
+	managementHandler := func(enable bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				writeMethodNotSupported(w, r)
+				return
+			}
+
+			var d JsonResourceSpecifier
+			if r.Body == nil {
+				writeError(w, http.StatusBadRequest, "Please send a request body")
+				return
+			}
+			err := json.NewDecoder(r.Body).Decode(&d)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+
+			k8s, err := h.k8sClient()
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				logging.FromContext(r.Context()).Warn(fmt.Sprintf("%s", err))
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), h.Config.Controller.APITimeout)
+			defer cancel()
+			_, err = k8s.AppsV1().Deployments(d.Namespace).Get(ctx, d.Name, meta_v1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				writeError(w, http.StatusNotFound, err.Error())
+				return
+			}
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				logging.FromContext(r.Context()).Warn(fmt.Sprintf("%s", err))
+				return
+			}
+
+			// Disabling sets the annotation to "false" instead of removing
+			// it, so the controller restores a scaled down deployment
+			value := strconv.FormatBool(enable)
+			enabledAnnotation := h.Config.Controller.Annotation(controller.ENABLED_ANNOTATION)
+			err = controller.PatchDeploymentAnnotations(ctx, k8s, d.Namespace, d.Name, map[string]*string{enabledAnnotation: &value})
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				logging.FromContext(r.Context()).Warn(fmt.Sprintf("%s", err))
+				return
+			}
+
+			writeData(w, JsonManagementState{
+				Namespace: d.Namespace,
+				Name:      d.Name,
+				Enabled:   enable,
+				Value:     value,
+			})
+		}
+	}
+	mux.HandleFunc("/enable", managementHandler(true))
+	mux.HandleFunc("/disable", managementHandler(false))
+
+	mux.HandleFunc("/schedule/check", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeMethodNotSupported(w, r)
 			return
 		}
-		err := json.NewDecoder(r.Body).Decode(&d)
+
+		// The schedule is parsed like the controller does, so JSON
+		// schedules are accepted too
+		query := r.URL.Query()
+		schedule, err := controller.ParseScheduleSpec(query.Get("expr"))
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if schedule.Location != nil && query.Has("tz") {
+			writeError(w, http.StatusBadRequest, "invalid tz parameter: the time zone is already set by the schedule")
+			return
+		}
+		if schedule.Location == nil {
+			schedule.Location, err = controller.LoadLocation(query.Get("tz"))
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		exceptions, err := controller.ParseExceptions(query.Get("exceptions"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		schedule.Exceptions = append(schedule.Exceptions, exceptions...)
+		// The schedule can be checked at any time, e.g. to verify the days
+		// and exceptions ahead of time
+		at := time.Now()
+		if text := query.Get("at"); text != "" {
+			at, err = time.Parse(time.RFC3339, text)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid at parameter '%s', expected an RFC3339 time (e.g. 2024-06-01T14:30:00Z)", text))
+				return
+			}
+		}
+
+		location := schedule.Location
+		state := controller.ENABLED
+		response := JsonScheduleCheck{
+			At:     at.In(location),
+			Parsed: newJsonScheduleRange(schedule.Range, location),
+		}
+		for _, window := range schedule.Windows {
+			response.Windows = append(response.Windows, newJsonScheduleRange(window, location))
+		}
+		if window, inRange := schedule.Match(at); inRange {
+			state = controller.DISABLED
+			matched := newJsonScheduleRange(window, location)
+			response.Window = &matched
+		}
+		response.InRange = state == controller.DISABLED
+		response.State = state.String()
+		writeData(w, response)
+	})
+
+	mux.HandleFunc("/schedule/simulate", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeMethodNotSupported(w, r)
 			return
 		}
 
-		k8s, err := controller.LoadK8SClientConfigFile()
+		// Every parameter but the date is an annotation of the simulated
+		// deployment, named without the annotation prefix
+		query := r.URL.Query()
+		date, err := time.Parse("2006-01-02", query.Get("date"))
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			slog.Warn(fmt.Sprintf("%s", err))
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid date parameter '%s', expected the YYYY-MM-DD format", query.Get("date")))
 			return
 		}
-		err = controller.ToggleDeployment(k8s, d.Namespace, d.Name, controller.DISABLED)
+		deployment := &apps_v1.Deployment{ObjectMeta: meta_v1.ObjectMeta{Annotations: map[string]string{}}}
+		for name := range query {
+			if name != "date" {
+				deployment.Annotations[h.Config.Controller.Annotation(controller.DEFAULT_ANNOTATION_PREFIX+name)] = query.Get(name)
+			}
+		}
+		schedule, err := controller.ResolveStandaloneSchedule(h.Config.Controller, deployment)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			slog.Warn(fmt.Sprintf("%s", err))
+			writeError(w, http.StatusBadRequest, err.Error())
 			return
 		}
 
-		fmt.Fprintln(w, "Request received")
+		writeData(w, newJsonScheduleSimulation(date, schedule))
 	})
 
-	mux.HandleFunc("/scaleUp", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			w.WriteHeader(http.StatusNotImplemented)
+	mux.HandleFunc("/schedule", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeMethodNotSupported(w, r)
+			return
 		}
 
-		var d JsonResourceSpecifier
-		if r.Body == nil {
-			http.Error(w, "Please send a request body", http.StatusBadRequest)
+		if h.controller == nil {
+			writeError(w, http.StatusNotImplemented, "The controller is disabled")
+			return
+		}
+
+		query := r.URL.Query()
+		namespace, name := query.Get("namespace"), query.Get("name")
+		if namespace == "" || name == "" {
+			writeError(w, http.StatusBadRequest, "Please provide the namespace and name query parameters")
+			return
+		}
+		schedule, exists, err := h.controller.EffectiveSchedule(namespace, name)
+		if !exists && err == nil {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("deployment '%s.%s' not found", namespace, name))
 			return
 		}
-		err := json.NewDecoder(r.Body).Decode(&d)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
 			return
 		}
 
-		k8s, err := controller.LoadK8SClientConfigFile()
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			slog.Warn(fmt.Sprintf("%s", err))
+		writeData(w, newJsonEffectiveSchedule(namespace, name, schedule, h.controller.Now()))
+	})
+
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeMethodNotSupported(w, r)
 			return
 		}
-		err = controller.ToggleDeployment(k8s, d.Namespace, d.Name, controller.ENABLED)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			slog.Warn(fmt.Sprintf("%s", err))
+
+		if h.controller == nil {
+			writeError(w, http.StatusNotImplemented, "The controller is disabled")
+			return
+		}
+
+		query := r.URL.Query()
+		filter := controller.StatusFilter{Namespace: query.Get("namespace")}
+		if text := query.Get("state"); text != "" {
+			state, err := controller.ParseDeploymentState(text)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			filter.State = &state
+		}
+
+		statuses := []JsonDeploymentStatus{}
+		counts := JsonStatusCounts{}
+		for _, status := range h.controller.Status(r.Context(), filter) {
+			jsonStatus := JsonDeploymentStatus{
+				Namespace:    status.Namespace,
+				Name:         status.Name,
+				Replicas:     status.Replicas,
+				State:        status.State.String(),
+				DesiredState: status.DesiredState.String(),
+				Error:        status.Error,
+			}
+			if !status.NextTransition.IsZero() {
+				next := status.NextTransition
+				jsonStatus.NextTransition = &next
+				jsonStatus.NextState = status.NextState.String()
+			}
+			statuses = append(statuses, jsonStatus)
+			if status.State == controller.ENABLED {
+				counts.Up++
+			} else {
+				counts.Down++
+			}
+		}
+		counts.Total = len(statuses)
+		response := JsonStatus{
+			ReconcileTotal: h.controller.ReconcileLoops(),
+			Counts:         counts,
+			Deployments:    statuses,
+		}
+		if last := h.controller.LastReconcileTime(); !last.IsZero() {
+			response.LastReconcile = &last
+		}
+		writeData(w, response)
+	})
+
+	mux.HandleFunc("/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeMethodNotSupported(w, r)
+			return
+		}
+		if h.controller == nil {
+			writeError(w, http.StatusNotImplemented, "The controller is disabled")
 			return
 		}
 
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprintln(w, "Request received")
+		h.controller.Reload()
+		writeJSON(w, http.StatusAccepted, JsonResponse{Status: STATUS_OK, Message: "Reload requested"})
 	})
 
+	// Stream the replica changes of the scheduled deployments as
+	// Server-Sent Events
+	mux.HandleFunc("/watch", h.watchHandler)
+
+	// Wake up a scaled down deployment on demand
+	mux.HandleFunc("/activate", h.activateHandler)
+
+	// Prometheus metrics of the scheduler
+	mux.Handle("/metrics", promhttp.Handler())
+
+	// Validating admission webhook for Deployment create/update
+	mux.HandleFunc("/validate", h.validateHandler)
+
+	// OpenAPI description of the endpoints above
+	mux.HandleFunc("/openapi.json", h.openAPIHandler)
+}
+
+// newJsonScheduleRange describes a time range of a schedule in its location
+func newJsonScheduleRange(timeRange controller.TimeRange, location *time.Location) JsonScheduleRange {
+	return JsonScheduleRange{
+		Start:    timeRange.Start.Format(timeRange.Layout()),
+		End:      timeRange.End.Format(timeRange.Layout()),
+		Timezone: location.String(),
+		Days:     timeRange.Days.String(),
+	}
+}
+
+// newJsonEffectiveSchedule describes the schedule of a deployment at now
+func newJsonEffectiveSchedule(namespace, name string, schedule controller.Schedule, now time.Time) JsonEffectiveSchedule {
+	response := JsonEffectiveSchedule{
+		Namespace: namespace,
+		Name:      name,
+		Schedule:  newJsonScheduleRange(schedule.Range, schedule.Location),
+		InRange:   schedule.InRange(now),
+	}
+	for _, window := range schedule.Windows {
+		response.Windows = append(response.Windows, newJsonScheduleRange(window, schedule.Location))
+	}
+	for _, exception := range schedule.Exceptions {
+		response.Exceptions = append(response.Exceptions, exception.String())
+	}
+	if schedule.OffDays != 0 {
+		// Every day is the empty string in the day letters form
+		response.OffDays = schedule.OffDays.String()
+		if response.OffDays == "" {
+			response.OffDays = controller.DAYS_KEYWORD_DAILY
+		}
+	}
+	if next, state := schedule.NextTransition(now); !next.IsZero() {
+		response.NextTransition = &next
+		response.NextState = state.String()
+	}
+	return response
+}
+
+// newJsonScheduleSimulation describes the timeline of a schedule on date
+func newJsonScheduleSimulation(date time.Time, schedule controller.Schedule) JsonScheduleSimulation {
+	response := JsonScheduleSimulation{
+		Date:        date.Format("2006-01-02"),
+		Schedule:    schedule.String(),
+		Timezone:    schedule.Location.String(),
+		Transitions: []JsonTransition{},
+	}
+	for _, transition := range schedule.Timeline(date) {
+		entry := JsonTransition{
+			At:    transition.At,
+			State: transition.State.String(),
+		}
+		// Off days match the zero time range
+		if window, inRange := schedule.Match(transition.At); inRange && window == (controller.TimeRange{}) {
+			entry.OffDay = true
+		} else if inRange {
+			matched := newJsonScheduleRange(window, schedule.Location)
+			entry.Window = &matched
+		}
+		response.Transitions = append(response.Transitions, entry)
+	}
+	return response
+}
+
+// waitForShutdown gives the load balancers ShutdownWaitDuration to stop
+// sending requests to the service, since it is no longer ready. A second
+// termination signal cuts the wait short.
+func (h *SchedulerService) waitForShutdown() {
+	timer := time.NewTimer(h.Config.ShutdownWaitDuration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-h.terminationChannel:
+		slog.Info("Received a second termination signal, shutting down immediately")
+	}
+}
+
+// toggleResource scales the resource specified in a request. Deployments
+// go through controller.ToggleDeployment while any other resource is scaled
+// through its scale subresource.
+func (h *SchedulerService) toggleResource(ctx context.Context, d JsonResourceSpecifier, targetState controller.DeploymentState) error {
+	resource := schema.ParseGroupResource(d.Resource)
+	if d.Resource == "" || resource == (schema.GroupResource{Group: "apps", Resource: "deployments"}) {
+		k8s, err := h.k8sClient()
+		if err != nil {
+			return err
+		}
+		return controller.ToggleDeployment(ctx, k8s, h.Config.Controller, d.Namespace, d.Name, targetState)
+	}
+
+	clients, err := controller.LoadK8SScaleClients(h.Config.Controller)
+	if err != nil {
+		return err
+	}
+	return controller.ToggleScalable(ctx, clients, h.Config.Controller, resource, d.Namespace, d.Name, targetState)
+}
+
+// k8sClient returns the clientset of the service, or loads one from the
+// controller configuration if none was set
+func (h *SchedulerService) k8sClient() (kubernetes.Interface, error) {
+	if h.clientset != nil {
+		return h.clientset, nil
+	}
+	clientset, err := controller.LoadK8SClientConfigFile(h.Config.Controller)
+	if err != nil {
+		return nil, err
+	}
+	return clientset, nil
 }
 
 // RunForever blocking function that is starting the http server and the listening
 // process. It is meant to be run only in the main function of the scheduler, for
 // other cases feel free to copy the code and adapt to your needs (i.e. Not efficient
-// to run as gofunc).
-func (h *SchedulerService) RunForever() {
-	slog.Info(fmt.Sprintf("SchedulerService is listening on '%s'", h.Http.Addr))
+// to run as gofunc). An error is returned if the server can not start or stops
+// serving unexpectedly.
+func (h *SchedulerService) RunForever() error {
+	useTLS, err := h.configureTLS()
+	if err != nil {
+		return err
+	}
+
+	// Bind the port before serving so failures (e.g. port already in use)
+	// are detected right away instead of leaving a service that serves nothing.
+	listener, err := net.Listen("tcp", h.Http.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on '%s': %s", h.Http.Addr, err)
+	}
+
+	slog.Info(fmt.Sprintf("SchedulerService is listening on '%s' (TLS: %t)", h.Http.Addr, useTLS))
+	return h.serve(listener, useTLS)
+}
+
+// configureTLS sets up the server to serve the configured certificate, if
+// any, reloading it when the certificate files are rotated. It reports
+// whether TLS is enabled.
+func (h *SchedulerService) configureTLS() (bool, error) {
+	if h.Config.TLSCertFile == "" || h.Config.TLSKeyFile == "" {
+		return false, nil
+	}
+	reloader, err := newCertReloader(h.Config.TLSCertFile, h.Config.TLSKeyFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to load TLS certificate: %s", err)
+	}
+	h.Http.TLSConfig = &tls.Config{GetCertificate: reloader.GetCertificate}
+	return true, nil
+}
+
+// serve serves the requests of the listener until a termination signal is
+// received, and then shuts the server down gracefully
+func (h *SchedulerService) serve(listener net.Listener, useTLS bool) error {
+	serveErrCh := make(chan error, 1)
 	go func() {
-		h.Http.ListenAndServe()
+		if useTLS {
+			serveErrCh <- h.Http.ServeTLS(listener, "", "")
+		} else {
+			serveErrCh <- h.Http.Serve(listener)
+		}
 	}()
 
-	//Block until an unterrupt signal is received.
+	//Block until an unterrupt signal is received or the server fails.
 	signal.Notify(h.terminationChannel, syscall.SIGTERM, syscall.SIGINT)
-	<-h.terminationChannel
+	defer signal.Stop(h.terminationChannel)
+	select {
+	case <-h.terminationChannel:
+	case err := <-serveErrCh:
+		h.serverReady.Store(false)
+		if !errors.Is(err, http.ErrServerClosed) {
+			slog.Error(fmt.Sprintf("SchedulerService stopped serving: %s", err))
+			return err
+		}
+		return nil
+	}
 
-	slog.Info(fmt.Sprintf("Server will shut down in %d seconds...", h.Config.ShutdownWaitDuration/time.Second))
-	h.serverReady = false
-	time.Sleep(h.Config.ShutdownWaitDuration)
+	slog.Info(fmt.Sprintf("Server will shut down in %d seconds, interrupt again to shut down immediately...", h.Config.ShutdownWaitDuration/time.Second))
+	h.serverReady.Store(false)
+	h.waitForShutdown()
 
-	h.Http.Shutdown(context.Background())
+	// In-flight requests get a window to complete, the connections of
+	// handlers that hang past it are closed. The window is never shorter
+	// than minShutdownTimeout, so requests are not cut off right away when
+	// the shutdown wait is disabled.
+	ctx, cancel := context.WithTimeout(context.Background(), max(h.Config.ShutdownWaitDuration, minShutdownTimeout))
+	defer cancel()
+	if err := h.Http.Shutdown(ctx); err != nil {
+		slog.Warn(fmt.Sprintf("Timed out waiting for in-flight requests to complete, closing their connections: %s", err))
+		h.Http.Close()
+	}
 	slog.Info("BYE")
+	return nil
 }
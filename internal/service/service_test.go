@@ -0,0 +1,911 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/dimitris4000/concept02/internal/controller"
+	apps_v1 "k8s.io/api/apps/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// discardLogs silences the default logger for the duration of the test
+func discardLogs(tb testing.TB) {
+	tb.Helper()
+	logger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	tb.Cleanup(func() { slog.SetDefault(logger) })
+}
+
+// newTestService creates a service without a controller, whose handlers
+// use a fake clientset holding the objects
+func newTestService(objects ...runtime.Object) (*SchedulerService, *fake.Clientset) {
+	clientset := fake.NewSimpleClientset(objects...)
+	h := NewSchedulerService(NewDefaultSchedulerServiceConfig(), nil)
+	h.clientset = clientset
+	return h, clientset
+}
+
+// newTestDeployment creates a deployment in the default namespace
+func newTestDeployment(name string, replicas int32, annotations map[string]string) *apps_v1.Deployment {
+	return &apps_v1.Deployment{
+		ObjectMeta: meta_v1.ObjectMeta{Namespace: "default", Name: name, Annotations: annotations},
+		Spec:       apps_v1.DeploymentSpec{Replicas: &replicas},
+	}
+}
+
+// serve sends a request through all the handlers and middlewares of the
+// service
+func serve(h *SchedulerService, method, target, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	h.Http.Handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// decodeData decodes the data of a JSON response into data
+func decodeData(t *testing.T, recorder *httptest.ResponseRecorder, data interface{}) {
+	t.Helper()
+	response := JsonResponse{Data: data}
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManagementHandler(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name        string
+		path        string
+		annotations map[string]string
+		value       string
+	}{
+		{"enable", "/enable", nil, "true"},
+		{"enable a disabled deployment", "/enable", map[string]string{"scheduler.enabled": "false"}, "true"},
+		{"disable", "/disable", map[string]string{"scheduler.enabled": "true"}, "false"},
+		{"disable an unmanaged deployment", "/disable", nil, "false"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h, clientset := newTestService(newTestDeployment("foo", 3, test.annotations))
+			recorder := serve(h, http.MethodPost, test.path, `{"namespace":"default","name":"foo"}`)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusOK, recorder.Code, recorder.Body)
+			}
+			var state JsonManagementState
+			decodeData(t, recorder, &state)
+			if state.Value != test.value || state.Enabled != (test.value == "true") {
+				t.Errorf("expected the enabled annotation '%s', got %+v", test.value, state)
+			}
+
+			deployment, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value, exists := deployment.Annotations["scheduler.enabled"]; !exists || value != test.value {
+				t.Errorf("expected the enabled annotation '%s', got '%s' (exists %t)", test.value, value, exists)
+			}
+		})
+	}
+}
+
+func TestDisabledDeploymentIsRestored(t *testing.T) {
+	discardLogs(t)
+	// A deployment scaled down by the controller whose schedule was removed
+	// is not touched until it is disabled
+	deployment := newTestDeployment("foo", 0, map[string]string{"scheduler.replicas-memory": "3"})
+	h, clientset := newTestService(deployment)
+
+	config := controller.NewDefaultControllerConfig()
+	stopCh := make(chan struct{})
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	c := controller.NewResourceController(clientset,
+		factory.Apps().V1().Deployments().Informer(),
+		factory.Core().V1().ConfigMaps().Informer(),
+		factory.Core().V1().Namespaces().Informer(),
+		config)
+	go c.Run(stopCh)
+	t.Cleanup(func() {
+		close(stopCh)
+		<-c.Done()
+	})
+	h.controller = c
+
+	recorder := serve(h, http.MethodPost, "/disable", `{"namespace":"default","name":"foo"}`)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, recorder.Code, recorder.Body)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := wait.PollUntilContextCancel(ctx, 10*time.Millisecond, true, func(ctx context.Context) (bool, error) {
+		deployment, err := clientset.AppsV1().Deployments("default").Get(ctx, "foo", meta_v1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		_, remembered := deployment.Annotations["scheduler.replicas-memory"]
+		return *deployment.Spec.Replicas == 3 && !remembered, nil
+	})
+	if err != nil {
+		deployment, _ := clientset.AppsV1().Deployments("default").Get(context.Background(), "foo", meta_v1.GetOptions{})
+		t.Fatalf("expected the disabled deployment to be restored to 3 replicas, got %d replicas and annotations %v", *deployment.Spec.Replicas, deployment.Annotations)
+	}
+}
+
+// fixedClock is a controller clock stuck at a fixed time
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+func TestScheduleHandler(t *testing.T) {
+	discardLogs(t)
+	monday := func(hour int) time.Time { return time.Date(2024, time.June, 3, hour, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name        string
+		target      string
+		annotations map[string]string
+		now         time.Time
+		status      int
+		inRange     bool
+		next        time.Time
+		nextState   string
+	}{
+		{"in range", "/schedule?namespace=default&name=foo", map[string]string{"scheduler.off-schedule": "20:00-08:00"}, monday(22), http.StatusOK, true, monday(32), "up"},
+		{"out of range", "/schedule?namespace=default&name=foo", map[string]string{"scheduler.off-schedule": "20:00-08:00"}, monday(12), http.StatusOK, false, monday(20), "down"},
+		{"time zone", "/schedule?namespace=default&name=foo", map[string]string{"scheduler.off-schedule": "20:00-08:00", "scheduler.timezone": "Europe/Athens"}, monday(12), http.StatusOK, false, monday(17), "down"},
+		{"missing deployment", "/schedule?namespace=default&name=bar", nil, monday(12), http.StatusNotFound, false, time.Time{}, ""},
+		{"missing name", "/schedule?namespace=default", nil, monday(12), http.StatusBadRequest, false, time.Time{}, ""},
+		{"invalid schedule", "/schedule?namespace=default&name=foo", map[string]string{"scheduler.off-schedule": "20:00"}, monday(12), http.StatusUnprocessableEntity, false, time.Time{}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h, clientset := newTestService(newTestDeployment("foo", 3, test.annotations))
+			config := controller.NewDefaultControllerConfig()
+			config.Clock = fixedClock(test.now)
+			factory := informers.NewSharedInformerFactory(clientset, 0)
+			deploymentInformer := factory.Apps().V1().Deployments().Informer()
+			h.controller = controller.NewResourceController(clientset,
+				deploymentInformer,
+				factory.Core().V1().ConfigMaps().Informer(),
+				factory.Core().V1().Namespaces().Informer(),
+				config)
+			stopCh := make(chan struct{})
+			defer close(stopCh)
+			factory.Start(stopCh)
+			factory.WaitForCacheSync(stopCh)
+
+			recorder := serve(h, http.MethodGet, test.target, "")
+			if recorder.Code != test.status {
+				t.Fatalf("expected status %d, got %d: %s", test.status, recorder.Code, recorder.Body)
+			}
+			if test.status != http.StatusOK {
+				return
+			}
+			var schedule JsonEffectiveSchedule
+			decodeData(t, recorder, &schedule)
+			if schedule.InRange != test.inRange {
+				t.Errorf("expected in range %t, got %t", test.inRange, schedule.InRange)
+			}
+			if schedule.NextTransition == nil || !schedule.NextTransition.Equal(test.next) || schedule.NextState != test.nextState {
+				t.Errorf("expected the transition to %s at %s, got %s at %v", test.nextState, test.next, schedule.NextState, schedule.NextTransition)
+			}
+		})
+	}
+}
+
+func TestManagementHandlerErrors(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"enable a missing deployment", http.MethodPost, "/enable", `{"namespace":"default","name":"missing"}`, http.StatusNotFound},
+		{"disable a missing deployment", http.MethodPost, "/disable", `{"namespace":"default","name":"missing"}`, http.StatusNotFound},
+		{"missing namespace", http.MethodPost, "/disable", `{"namespace":"other","name":"foo"}`, http.StatusNotFound},
+		{"invalid body", http.MethodPost, "/disable", `{`, http.StatusBadRequest},
+		{"wrong method", http.MethodGet, "/disable", "", http.StatusNotImplemented},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h, clientset := newTestService(newTestDeployment("foo", 3, nil))
+			recorder := serve(h, test.method, test.path, test.body)
+			if recorder.Code != test.status {
+				t.Errorf("expected status %d, got %d: %s", test.status, recorder.Code, recorder.Body)
+			}
+			var response JsonResponse
+			if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Status != STATUS_ERROR {
+				t.Errorf("expected an error response, got %+v", response)
+			}
+			for _, action := range clientset.Actions() {
+				if action.GetVerb() == "patch" {
+					t.Errorf("expected no patch, got %v", action)
+				}
+			}
+		})
+	}
+}
+
+// startServing serves the requests of the service on a local port until it
+// shuts down, whose outcome is sent to the returned channel
+func startServing(t *testing.T, h *SchedulerService) (string, <-chan error) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errCh := make(chan error, 1)
+	go func() { errCh <- h.serve(listener, false) }()
+	return "http://" + listener.Addr().String(), errCh
+}
+
+func TestServeShutsDownSlowRequests(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name     string
+		wait     time.Duration
+		delay    time.Duration
+		complete bool
+	}{
+		{"request within the wait", 200 * time.Millisecond, 100 * time.Millisecond, true},
+		{"request within the minimum timeout", 0, 100 * time.Millisecond, true},
+		{"hanging request", 200 * time.Millisecond, time.Hour, false},
+		{"hanging request without a wait", 0, time.Hour, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h, _ := newTestService()
+			h.Config.ShutdownWaitDuration = test.wait
+			started := make(chan struct{})
+			release := make(chan struct{})
+			defer close(release)
+			h.Http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				select {
+				case <-time.After(test.delay):
+				case <-release:
+				}
+				w.WriteHeader(http.StatusOK)
+			})
+			url, errCh := startServing(t, h)
+
+			requestErrCh := make(chan error, 1)
+			go func() {
+				response, err := http.Get(url)
+				if err == nil {
+					response.Body.Close()
+				}
+				requestErrCh <- err
+			}()
+			<-started
+
+			start := time.Now()
+			h.terminationChannel <- syscall.SIGTERM
+			deadline := test.wait + max(test.wait, minShutdownTimeout)
+			select {
+			case err := <-errCh:
+				if err != nil {
+					t.Errorf("expected no error, got '%s'", err)
+				}
+			case <-time.After(deadline + 2*time.Second):
+				t.Fatalf("expected the shutdown to return within %s", deadline)
+			}
+			if elapsed := time.Since(start); elapsed < test.wait {
+				t.Errorf("expected the shutdown to wait %s, returned after %s", test.wait, elapsed)
+			}
+
+			err := <-requestErrCh
+			if test.complete && err != nil {
+				t.Errorf("expected the request to complete, got '%s'", err)
+			}
+			if !test.complete && err == nil {
+				t.Errorf("expected the connection of the hanging request to be closed")
+			}
+		})
+	}
+}
+
+func TestServeShutdownWait(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name    string
+		wait    time.Duration
+		signals int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{"single signal", 300 * time.Millisecond, 1, 300 * time.Millisecond, 5 * time.Second},
+		{"without a wait", 0, 1, 0, 5 * time.Second},
+		{"second signal", time.Hour, 2, 0, 5 * time.Second},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h, _ := newTestService()
+			h.Config.ShutdownWaitDuration = test.wait
+			h.serverReady.Store(true)
+			_, errCh := startServing(t, h)
+
+			start := time.Now()
+			h.terminationChannel <- syscall.SIGTERM
+			// The service reports not ready as soon as the wait starts
+			if err := wait.PollUntilContextTimeout(context.Background(), 5*time.Millisecond, time.Second, true, func(context.Context) (bool, error) {
+				return !h.serverReady.Load(), nil
+			}); err != nil {
+				t.Errorf("expected the service not ready during the shutdown wait")
+			}
+			for i := 1; i < test.signals; i++ {
+				h.terminationChannel <- syscall.SIGTERM
+			}
+			select {
+			case err := <-errCh:
+				if err != nil {
+					t.Errorf("expected no error, got '%s'", err)
+				}
+			case <-time.After(test.max):
+				t.Fatalf("expected the shutdown to return within %s", test.max)
+			}
+			if elapsed := time.Since(start); elapsed < test.min {
+				t.Errorf("expected the shutdown to wait %s, returned after %s", test.min, elapsed)
+			}
+		})
+	}
+}
+
+func TestRunForeverReturnsStartupErrors(t *testing.T) {
+	discardLogs(t)
+	bound, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bound.Close()
+	tests := []struct {
+		name     string
+		addr     string
+		certFile string
+		keyFile  string
+	}{
+		{"port already bound", bound.Addr().String(), "", ""},
+		{"invalid address", "127.0.0.1:http-alt-nope", "", ""},
+		{"missing certificate", "127.0.0.1:0", "missing-cert.pem", "missing-key.pem"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h, _ := newTestService()
+			h.Http.Addr = test.addr
+			h.Config.TLSCertFile = test.certFile
+			h.Config.TLSKeyFile = test.keyFile
+
+			errCh := make(chan error, 1)
+			go func() { errCh <- h.RunForever() }()
+			select {
+			case err := <-errCh:
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+			case <-time.After(5 * time.Second):
+				h.terminationChannel <- syscall.SIGTERM
+				t.Fatal("expected RunForever to return an error instead of serving")
+			}
+		})
+	}
+}
+
+func TestServeReturnsServingErrors(t *testing.T) {
+	discardLogs(t)
+	h, _ := newTestService()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A closed listener fails the server right away
+	listener.Close()
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- h.serve(listener, false) }()
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Errorf("expected an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected serve to return the error of the server")
+	}
+	if h.serverReady.Load() {
+		t.Errorf("expected the service to be not ready once it stopped serving")
+	}
+}
+
+func TestReadinessHandler(t *testing.T) {
+	discardLogs(t)
+	// The steps run in order on the same service
+	steps := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"ready by default", http.MethodGet, "/readiness", "", http.StatusOK},
+		{"not ready", http.MethodPost, "/readiness/notready", "", http.StatusServiceUnavailable},
+		{"still not ready", http.MethodGet, "/readiness", "", http.StatusServiceUnavailable},
+		{"ready", http.MethodPost, "/readiness/ready", "", http.StatusOK},
+		{"not ready through the body", http.MethodPost, "/readiness", `{"ready":false}`, http.StatusServiceUnavailable},
+		{"ready through the body", http.MethodPost, "/readiness", `{"ready":true}`, http.StatusOK},
+		{"invalid body", http.MethodPost, "/readiness", `{`, http.StatusBadRequest},
+		{"unchanged by the invalid body", http.MethodGet, "/readiness", "", http.StatusOK},
+	}
+
+	h, _ := newTestService()
+	for _, step := range steps {
+		recorder := serve(h, step.method, step.path, step.body)
+		if recorder.Code != step.status {
+			t.Errorf("%s: expected status %d, got %d", step.name, step.status, recorder.Code)
+		}
+	}
+}
+
+// TestReadinessConcurrentToggles is meant to be run with -race, to check
+// that the readiness is safe to toggle and read from concurrent requests
+func TestReadinessConcurrentToggles(t *testing.T) {
+	discardLogs(t)
+	h, _ := newTestService()
+	paths := []string{"/readiness/ready", "/readiness/notready"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				serve(h, http.MethodPost, paths[(i+j)%2], "")
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				recorder := serve(h, http.MethodGet, "/readiness", "")
+				if recorder.Code != http.StatusOK && recorder.Code != http.StatusServiceUnavailable {
+					t.Errorf("expected the readiness status, got %d", recorder.Code)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The service is left in the state of the last toggle
+	serve(h, http.MethodPost, "/readiness/ready", "")
+	if recorder := serve(h, http.MethodGet, "/readiness", ""); recorder.Code != http.StatusOK {
+		t.Errorf("expected the service to be ready, got %d", recorder.Code)
+	}
+}
+
+func TestReadinessWaitsForControllerSync(t *testing.T) {
+	discardLogs(t)
+	h, clientset := newTestService()
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	h.controller = controller.NewResourceController(clientset,
+		factory.Apps().V1().Deployments().Informer(),
+		factory.Core().V1().ConfigMaps().Informer(),
+		factory.Core().V1().Namespaces().Informer(),
+		controller.NewDefaultControllerConfig())
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	// The steps run in order on the same service
+	steps := []struct {
+		name   string
+		before func()
+		method string
+		path   string
+		status int
+		body   string
+	}{
+		{"unsynced", func() {}, http.MethodGet, "/readiness", http.StatusServiceUnavailable, "NOT OK (controller not synced)\n"},
+		{"unsynced though marked ready", func() {}, http.MethodPost, "/readiness/ready", http.StatusServiceUnavailable, "NOT OK (controller not synced)\n"},
+		{"synced", func() {
+			factory.Start(stopCh)
+			factory.WaitForCacheSync(stopCh)
+		}, http.MethodGet, "/readiness", http.StatusOK, "OK\n"},
+		{"synced but not ready", func() {}, http.MethodPost, "/readiness/notready", http.StatusServiceUnavailable, "NOT OK\n"},
+	}
+	for _, step := range steps {
+		step.before()
+		recorder := serve(h, step.method, step.path, "")
+		if recorder.Code != step.status || recorder.Body.String() != step.body {
+			t.Errorf("%s: expected status %d and the body '%s', got %d and '%s'", step.name, step.status, step.body, recorder.Code, recorder.Body)
+		}
+	}
+}
+
+func TestHealthzHandler(t *testing.T) {
+	discardLogs(t)
+	h, clientset := newTestService()
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	c := controller.NewResourceController(clientset,
+		factory.Apps().V1().Deployments().Informer(),
+		factory.Core().V1().ConfigMaps().Informer(),
+		factory.Core().V1().Namespaces().Informer(),
+		controller.NewDefaultControllerConfig())
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	// The steps run in order on the same service
+	steps := []struct {
+		name           string
+		before         func()
+		staleIntervals int
+		status         int
+		body           string
+	}{
+		{"without a controller", func() {}, 6, http.StatusOK, "OK\n"},
+		{"not reconciled yet", func() { h.controller = c }, 6, http.StatusServiceUnavailable, "NOT OK (controller has not reconciled yet)\n"},
+		{"reconciled", func() {
+			go c.Run(stopCh)
+			if err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+				return !c.LastReconcileTime().IsZero(), nil
+			}); err != nil {
+				t.Fatal("the controller did not reconcile")
+			}
+		}, 6, http.StatusOK, "OK\n"},
+		{"stale", func() {}, 0, http.StatusServiceUnavailable, "NOT OK (last reconcile was "},
+	}
+	for _, step := range steps {
+		step.before()
+		h.Config.HealthzStaleIntervals = step.staleIntervals
+		recorder := serve(h, http.MethodGet, "/healthz", "")
+		if recorder.Code != step.status || !strings.HasPrefix(recorder.Body.String(), step.body) {
+			t.Errorf("%s: expected status %d and the body '%s', got %d and '%s'", step.name, step.status, step.body, recorder.Code, recorder.Body)
+		}
+	}
+}
+
+func TestReloadHandler(t *testing.T) {
+	discardLogs(t)
+	h, clientset := newTestService(newTestDeployment("foo", 1, map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00"}))
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	c := controller.NewResourceController(clientset,
+		factory.Apps().V1().Deployments().Informer(),
+		factory.Core().V1().ConfigMaps().Informer(),
+		factory.Core().V1().Namespaces().Informer(),
+		controller.NewDefaultControllerConfig())
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	loops := func() int64 { return c.ReconcileLoops() }
+
+	// The steps run in order on the same service
+	steps := []struct {
+		name   string
+		before func()
+		method string
+		status int
+		loops  int64
+	}{
+		{"without a controller", func() {}, http.MethodPost, http.StatusNotImplemented, 0},
+		{"wrong method", func() {
+			h.controller = c
+			go c.Run(stopCh)
+			// The controller resyncs once as it starts
+			if err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+				return loops() == 1, nil
+			}); err != nil {
+				t.Fatal("the controller did not start")
+			}
+		}, http.MethodGet, http.StatusNotImplemented, 1},
+		{"reload", func() {}, http.MethodPost, http.StatusAccepted, 2},
+	}
+	for _, step := range steps {
+		step.before()
+		recorder := serve(h, step.method, "/reload", "")
+		if recorder.Code != step.status {
+			t.Errorf("%s: expected status %d, got %d: %s", step.name, step.status, recorder.Code, recorder.Body)
+		}
+		// The reload runs well before the next resync interval
+		wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, time.Second, true, func(context.Context) (bool, error) {
+			return loops() >= step.loops, nil
+		})
+		if loops() != step.loops {
+			t.Errorf("%s: expected %d resyncs, got %d", step.name, step.loops, loops())
+		}
+	}
+}
+
+func TestScheduleCheckHandler(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		status   int
+		inRange  bool
+		state    string
+		timezone string
+		window   string
+	}{
+		{"in range", http.MethodGet, "/schedule/check?expr=20:00-08:00&at=2024-06-03T22:00:00Z", http.StatusOK, true, "down", "UTC", "20:00-08:00"},
+		{"out of range", http.MethodGet, "/schedule/check?expr=20:00-08:00&at=2024-06-03T12:00:00Z", http.StatusOK, false, "up", "UTC", ""},
+		{"time zone", http.MethodGet, "/schedule/check?expr=20:00-08:00&tz=Europe/Athens&at=2024-06-03T18:00:00Z", http.StatusOK, true, "down", "Europe/Athens", "20:00-08:00"},
+		{"JSON schedule", http.MethodGet, `/schedule/check?expr={"windows":["00:00-06:00","12:00-13:00"]}&at=2024-06-03T12:30:00Z`, http.StatusOK, true, "down", "UTC", "12:00-13:00"},
+		{"exception date", http.MethodGet, "/schedule/check?expr=20:00-08:00&exceptions=2024-06-03&at=2024-06-03T22:00:00Z", http.StatusOK, false, "up", "UTC", ""},
+		{"malformed expression", http.MethodGet, "/schedule/check?expr=20:00", http.StatusBadRequest, false, "", "", ""},
+		{"missing expression", http.MethodGet, "/schedule/check", http.StatusBadRequest, false, "", "", ""},
+		{"invalid time zone", http.MethodGet, "/schedule/check?expr=20:00-08:00&tz=Mars/Olympus", http.StatusBadRequest, false, "", "", ""},
+		{"time zone set twice", http.MethodGet, `/schedule/check?expr={"windows":["20:00-08:00"],"timezone":"UTC"}&tz=Europe/Athens`, http.StatusBadRequest, false, "", "", ""},
+		{"invalid exceptions", http.MethodGet, "/schedule/check?expr=20:00-08:00&exceptions=tomorrow", http.StatusBadRequest, false, "", "", ""},
+		{"invalid time", http.MethodGet, "/schedule/check?expr=20:00-08:00&at=22:00", http.StatusBadRequest, false, "", "", ""},
+		{"unsupported method", http.MethodPost, "/schedule/check?expr=20:00-08:00", http.StatusNotImplemented, false, "", "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h, _ := newTestService()
+			target := strings.NewReplacer(`"`, "%22", "{", "%7B", "}", "%7D", "[", "%5B", "]", "%5D").Replace(test.target)
+			recorder := serve(h, test.method, target, "")
+			if recorder.Code != test.status {
+				t.Fatalf("expected status %d, got %d: %s", test.status, recorder.Code, recorder.Body)
+			}
+			if test.status != http.StatusOK {
+				return
+			}
+			var check JsonScheduleCheck
+			decodeData(t, recorder, &check)
+			if check.InRange != test.inRange || check.State != test.state {
+				t.Errorf("expected in range %t and state %s, got %t and %s", test.inRange, test.state, check.InRange, check.State)
+			}
+			if check.Parsed.Timezone != test.timezone {
+				t.Errorf("expected the time zone %s, got %s", test.timezone, check.Parsed.Timezone)
+			}
+			window := ""
+			if check.Window != nil {
+				window = check.Window.Start + "-" + check.Window.End
+			}
+			if window != test.window {
+				t.Errorf("expected the matched window '%s', got '%s'", test.window, window)
+			}
+		})
+	}
+}
+
+func TestScheduleCheckHandlerAt(t *testing.T) {
+	discardLogs(t)
+	tests := []struct {
+		name  string
+		query string
+		at    time.Time
+		state string
+	}{
+		{"weekday", "expr=weekdays 20:00-08:00&at=2024-06-07T22:00:00Z", time.Date(2024, time.June, 7, 22, 0, 0, 0, time.UTC), "down"},
+		{"window of a weekday ending on a weekend", "expr=weekdays 20:00-08:00&at=2024-06-08T03:00:00Z", time.Date(2024, time.June, 8, 3, 0, 0, 0, time.UTC), "down"},
+		{"weekend", "expr=weekdays 20:00-08:00&at=2024-06-08T22:00:00Z", time.Date(2024, time.June, 8, 22, 0, 0, 0, time.UTC), "up"},
+		{"exception range", "expr=20:00-08:00&exceptions=2024-06-01/2024-06-05&at=2024-06-04T22:00:00Z", time.Date(2024, time.June, 4, 22, 0, 0, 0, time.UTC), "up"},
+		{"after the exception range", "expr=20:00-08:00&exceptions=2024-06-01/2024-06-05&at=2024-06-06T22:00:00Z", time.Date(2024, time.June, 6, 22, 0, 0, 0, time.UTC), "down"},
+		{"exception date in the time zone", "expr=20:00-08:00&tz=Europe/Athens&exceptions=2024-06-04&at=2024-06-03T22:30:00Z", time.Date(2024, time.June, 4, 1, 30, 0, 0, time.FixedZone("EEST", 3*60*60)), "up"},
+		{"day before the exception in the time zone", "expr=20:00-08:00&tz=Europe/Athens&exceptions=2024-06-04&at=2024-06-03T20:00:00Z", time.Date(2024, time.June, 3, 23, 0, 0, 0, time.FixedZone("EEST", 3*60*60)), "down"},
+		{"time with an offset", "expr=20:00-08:00&at=2024-06-03T23:00:00%2B02:00", time.Date(2024, time.June, 3, 21, 0, 0, 0, time.UTC), "down"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h, _ := newTestService()
+			recorder := serve(h, http.MethodGet, "/schedule/check?"+strings.ReplaceAll(test.query, " ", "%20"), "")
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusOK, recorder.Code, recorder.Body)
+			}
+			var check JsonScheduleCheck
+			decodeData(t, recorder, &check)
+			// The time is reported in the time zone of the schedule
+			if at := check.At.Format(time.RFC3339); at != test.at.Format(time.RFC3339) {
+				t.Errorf("expected the time %s, got %s", test.at.Format(time.RFC3339), at)
+			}
+			if check.State != test.state {
+				t.Errorf("expected the state %s, got %s", test.state, check.State)
+			}
+		})
+	}
+}
+
+func TestStatusHandlerReportsReconcileLoops(t *testing.T) {
+	discardLogs(t)
+	now := time.Date(2024, time.June, 3, 12, 0, 0, 0, time.UTC)
+	h, clientset := newTestService(newTestDeployment("foo", 1, map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00"}))
+	config := controller.NewDefaultControllerConfig()
+	config.Clock = fixedClock(now)
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	c := controller.NewResourceController(clientset,
+		factory.Apps().V1().Deployments().Informer(),
+		factory.Core().V1().ConfigMaps().Informer(),
+		factory.Core().V1().Namespaces().Informer(),
+		config)
+	h.controller = c
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	// The steps run in order on the same controller
+	steps := []struct {
+		name   string
+		before func()
+		loops  int64
+	}{
+		{"not started", func() {}, 0},
+		{"started", func() { go c.Run(stopCh) }, 1},
+		{"reloaded", c.Reload, 2},
+		{"reloaded again", c.Reload, 3},
+	}
+	for _, step := range steps {
+		step.before()
+		wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, time.Second, true, func(context.Context) (bool, error) {
+			return c.ReconcileLoops() >= step.loops, nil
+		})
+		recorder := serve(h, http.MethodGet, "/status", "")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d: %s", step.name, http.StatusOK, recorder.Code, recorder.Body)
+		}
+		var status JsonStatus
+		decodeData(t, recorder, &status)
+		if status.ReconcileTotal != step.loops {
+			t.Errorf("%s: expected %d loops, got %d", step.name, step.loops, status.ReconcileTotal)
+		}
+		switch {
+		case step.loops == 0 && status.LastReconcile != nil:
+			t.Errorf("%s: expected no last loop, got %s", step.name, status.LastReconcile)
+		case step.loops > 0 && (status.LastReconcile == nil || !status.LastReconcile.Equal(now)):
+			t.Errorf("%s: expected the last loop at the time of the controller's clock %s, got %v", step.name, now, status.LastReconcile)
+		}
+	}
+}
+
+func TestStatusHandlerFilters(t *testing.T) {
+	discardLogs(t)
+	scheduled := func(namespace, name string, replicas int32, remembered string) *apps_v1.Deployment {
+		annotations := map[string]string{"scheduler.enabled": "true", "scheduler.off-schedule": "20:00-08:00"}
+		if remembered != "" {
+			annotations["scheduler.replicas-memory"] = remembered
+		}
+		deployment := newTestDeployment(name, replicas, annotations)
+		deployment.Namespace = namespace
+		return deployment
+	}
+	h, clientset := newTestService()
+	config := controller.NewDefaultControllerConfig()
+	config.Clock = fixedClock(time.Date(2024, time.June, 3, 12, 0, 0, 0, time.UTC))
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	deploymentInformer := factory.Apps().V1().Deployments().Informer()
+	for _, deployment := range []*apps_v1.Deployment{
+		scheduled("default", "foo", 2, ""),
+		scheduled("default", "bar", 0, "2"),
+		scheduled("other", "baz", 1, ""),
+		scheduled("other", "qux", 0, "3"),
+		newTestDeployment("unscheduled", 0, nil),
+	} {
+		if err := deploymentInformer.GetIndexer().Add(deployment); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h.controller = controller.NewResourceController(clientset,
+		deploymentInformer,
+		factory.Core().V1().ConfigMaps().Informer(),
+		factory.Core().V1().Namespaces().Informer(),
+		config)
+
+	tests := []struct {
+		name        string
+		query       string
+		status      int
+		deployments []string
+		counts      JsonStatusCounts
+	}{
+		{"no filters", "", http.StatusOK, []string{"default/bar", "default/foo", "other/baz", "other/qux"}, JsonStatusCounts{Total: 4, Up: 2, Down: 2}},
+		{"scaled down", "?state=down", http.StatusOK, []string{"default/bar", "other/qux"}, JsonStatusCounts{Total: 2, Down: 2}},
+		{"scaled up", "?state=up", http.StatusOK, []string{"default/foo", "other/baz"}, JsonStatusCounts{Total: 2, Up: 2}},
+		{"state in upper case", "?state=DOWN", http.StatusOK, []string{"default/bar", "other/qux"}, JsonStatusCounts{Total: 2, Down: 2}},
+		{"namespace", "?namespace=default", http.StatusOK, []string{"default/bar", "default/foo"}, JsonStatusCounts{Total: 2, Up: 1, Down: 1}},
+		{"namespace and state", "?namespace=other&state=down", http.StatusOK, []string{"other/qux"}, JsonStatusCounts{Total: 1, Down: 1}},
+		{"namespace and other state", "?namespace=default&state=up", http.StatusOK, []string{"default/foo"}, JsonStatusCounts{Total: 1, Up: 1}},
+		{"empty namespace", "?namespace=empty", http.StatusOK, []string{}, JsonStatusCounts{}},
+		{"invalid state", "?state=sideways", http.StatusBadRequest, nil, JsonStatusCounts{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := serve(h, http.MethodGet, "/status"+test.query, "")
+			if recorder.Code != test.status {
+				t.Fatalf("expected status %d, got %d: %s", test.status, recorder.Code, recorder.Body)
+			}
+			if test.status != http.StatusOK {
+				return
+			}
+			var status JsonStatus
+			decodeData(t, recorder, &status)
+			deployments := []string{}
+			for _, deployment := range status.Deployments {
+				deployments = append(deployments, deployment.Namespace+"/"+deployment.Name)
+			}
+			if strings.Join(deployments, ", ") != strings.Join(test.deployments, ", ") {
+				t.Errorf("expected the deployments %v, got %v", test.deployments, deployments)
+			}
+			if status.Counts != test.counts {
+				t.Errorf("expected the counts %+v, got %+v", test.counts, status.Counts)
+			}
+		})
+	}
+}
+
+func TestScheduleSimulateHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		status      int
+		timezone    string
+		transitions []string
+	}{
+		{"overnight window", "?date=2024-06-03&off-schedule=20:00-08:00", http.StatusOK, "UTC", []string{"00:00 down 20:00-08:00", "08:00 up", "20:00 down 20:00-08:00"}},
+		{"time zone", "?date=2024-06-03&off-schedule=12:00-13:00&timezone=Europe/Athens", http.StatusOK, "Europe/Athens", []string{"00:00 up", "12:00 down 12:00-13:00", "13:00 up"}},
+		{"weekday window on a weekend", "?date=2024-06-09&off-schedule=weekdays%2020:00-08:00", http.StatusOK, "UTC", []string{"00:00 up"}},
+		{"off day", "?date=2024-06-08&off-schedule=12:00-13:00&off-days=sat,sun", http.StatusOK, "UTC", []string{"00:00 down off day"}},
+		{"invalid date", "?date=tomorrow&off-schedule=20:00-08:00", http.StatusBadRequest, "", nil},
+		{"no schedule", "?date=2024-06-03", http.StatusBadRequest, "", nil},
+		{"invalid schedule", "?date=2024-06-03&off-schedule=20:00", http.StatusBadRequest, "", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h, _ := newTestService()
+			recorder := serve(h, http.MethodGet, "/schedule/simulate"+test.query, "")
+			if recorder.Code != test.status {
+				t.Fatalf("expected status %d, got %d: %s", test.status, recorder.Code, recorder.Body)
+			}
+			if test.status != http.StatusOK {
+				return
+			}
+			var simulation JsonScheduleSimulation
+			decodeData(t, recorder, &simulation)
+			if simulation.Timezone != test.timezone {
+				t.Errorf("expected the time zone %s, got %s", test.timezone, simulation.Timezone)
+			}
+			location, err := time.LoadLocation(test.timezone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			transitions := []string{}
+			for _, transition := range simulation.Transitions {
+				description := transition.At.In(location).Format("15:04") + " " + transition.State
+				if transition.Window != nil {
+					description += " " + transition.Window.Start + "-" + transition.Window.End
+				}
+				if transition.OffDay {
+					description += " off day"
+				}
+				transitions = append(transitions, description)
+			}
+			if strings.Join(transitions, ", ") != strings.Join(test.transitions, ", ") {
+				t.Errorf("expected the transitions %v, got %v", test.transitions, transitions)
+			}
+		})
+	}
+}
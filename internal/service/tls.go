@@ -0,0 +1,90 @@
+// tls.go holds the TLS related mechanics of the http service
+
+package service
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloader keeps the certificate of the http server in sync with the
+// certificate files, so rotated certificates are picked up without a restart.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	mutex    sync.Mutex
+	cert     *tls.Certificate
+	modTime  time.Time
+}
+
+// newCertReloader creates a certReloader and loads the initial certificate
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	reloader := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	modTime, err := reloader.lastModified()
+	if err != nil {
+		return nil, err
+	}
+	err = reloader.load(modTime)
+	if err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+// lastModified returns the most recent modification time of the cert files
+func (c *certReloader) lastModified() (time.Time, error) {
+	certStat, err := os.Stat(c.certFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	keyStat, err := os.Stat(c.keyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if keyStat.ModTime().After(certStat.ModTime()) {
+		return keyStat.ModTime(), nil
+	}
+	return certStat.ModTime(), nil
+}
+
+// load reads the key pair from the cert files. It must be called with the
+// mutex held, or before the reloader is shared.
+func (c *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert = &cert
+	c.modTime = modTime
+	return nil
+}
+
+// GetCertificate is meant to be used as the tls.Config callback. It reloads
+// the certificate when the files have changed since the last load. If the
+// reload fails the previous certificate keeps being served.
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	modTime, err := c.lastModified()
+	if err == nil && modTime.After(c.modTime) {
+		err = c.load(modTime)
+		if err == nil {
+			slog.Info(fmt.Sprintf("Reloaded TLS certificate from '%s'", c.certFile))
+		}
+	}
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to reload TLS certificate: %s", err))
+	}
+
+	return c.cert, nil
+}
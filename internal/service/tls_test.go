@@ -0,0 +1,217 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1 with
+// the common name, and its key, to the cert.pem and key.pem files of dir.
+// The files are stamped with modified, so rotations within the same second
+// are still detected. The certificate is returned for the clients to trust.
+func writeTestCertificate(t *testing.T, dir, commonName string, modified time.Time) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{
+		"cert.pem": pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		"key.pem":  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, content, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cert
+}
+
+func TestCertReloaderReloadsRotatedCertificates(t *testing.T) {
+	discardLogs(t)
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Hour)
+	writeTestCertificate(t, dir, "first", start)
+	reloader, err := newCertReloader(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name       string
+		rotate     func()
+		commonName string
+	}{
+		{"initial certificate", func() {}, "first"},
+		{"unchanged files", func() {}, "first"},
+		{"rotated certificate", func() { writeTestCertificate(t, dir, "second", start.Add(time.Minute)) }, "second"},
+		{"corrupt rotation", func() {
+			path := filepath.Join(dir, "cert.pem")
+			if err := os.WriteFile(path, []byte("not a certificate"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(path, start.Add(2*time.Minute), start.Add(2*time.Minute)); err != nil {
+				t.Fatal(err)
+			}
+		}, "second"},
+		{"fixed rotation", func() { writeTestCertificate(t, dir, "third", start.Add(3*time.Minute)) }, "third"},
+	}
+	for _, step := range steps {
+		step.rotate()
+		cert, err := reloader.GetCertificate(nil)
+		if err != nil {
+			t.Fatalf("%s: %s", step.name, err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if leaf.Subject.CommonName != step.commonName {
+			t.Errorf("%s: expected the certificate '%s', got '%s'", step.name, step.commonName, leaf.Subject.CommonName)
+		}
+	}
+}
+
+func TestNewCertReloaderRejectsInvalidFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTestCertificate(t, dir, "valid", time.Now())
+	if err := os.WriteFile(filepath.Join(dir, "invalid.pem"), []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		certFile string
+		keyFile  string
+	}{
+		{"missing certificate", "missing.pem", "key.pem"},
+		{"missing key", "cert.pem", "missing.pem"},
+		{"invalid key", "cert.pem", "invalid.pem"},
+		{"key as certificate", "key.pem", "key.pem"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := newCertReloader(filepath.Join(dir, test.certFile), filepath.Join(dir, test.keyFile))
+			if err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestServeTLS(t *testing.T) {
+	discardLogs(t)
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Hour)
+	first := writeTestCertificate(t, dir, "first", start)
+	h, _ := newTestService()
+	h.Config.ShutdownWaitDuration = 0
+	h.Config.TLSCertFile = filepath.Join(dir, "cert.pem")
+	h.Config.TLSKeyFile = filepath.Join(dir, "key.pem")
+	useTLS, err := h.configureTLS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !useTLS {
+		t.Fatal("expected TLS to be enabled")
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errCh := make(chan error, 1)
+	go func() { errCh <- h.serve(listener, true) }()
+	defer func() {
+		h.terminationChannel <- syscall.SIGTERM
+		if err := <-errCh; err != nil {
+			t.Error(err)
+		}
+	}()
+	url := "https://" + listener.Addr().String() + "/liveness"
+
+	// get requests the liveness endpoint over a new connection trusting
+	// the certificate
+	get := func(trusted *x509.Certificate) (*http.Response, error) {
+		roots := x509.NewCertPool()
+		roots.AddCert(trusted)
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots},
+			DisableKeepAlives: true,
+		}}
+		return client.Get(url)
+	}
+
+	response, err := get(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, response.StatusCode)
+	}
+	if commonName := response.TLS.PeerCertificates[0].Subject.CommonName; commonName != "first" {
+		t.Errorf("expected the certificate 'first', got '%s'", commonName)
+	}
+
+	// Plain HTTP requests are not served
+	plain, err := http.Get("http://" + listener.Addr().String() + "/liveness")
+	if err == nil {
+		plain.Body.Close()
+		if plain.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected plain HTTP requests to be rejected, got status %d", plain.StatusCode)
+		}
+	}
+
+	// The rotated certificate is served without a restart
+	second := writeTestCertificate(t, dir, "second", start.Add(time.Minute))
+	if _, err := get(first); err == nil {
+		t.Errorf("expected the handshake with the rotated certificate to fail for clients trusting the previous one")
+	}
+	response, err = get(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if commonName := response.TLS.PeerCertificates[0].Subject.CommonName; commonName != "second" {
+		t.Errorf("expected the certificate 'second', got '%s'", commonName)
+	}
+}
@@ -0,0 +1,80 @@
+// watch.go holds the Server-Sent Events stream of the service, which pushes
+// the replica changes of the scheduled deployments to dashboards.
+
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dimitris4000/concept02/internal/logging"
+)
+
+// watchKeepAlive is how often a comment is sent on idle streams, so proxies
+// do not close them
+const watchKeepAlive = 15 * time.Second
+
+// watchHandler streams a "scale" event with a JsonDeploymentEvent payload
+// for every replica change of a scheduled deployment. The stream ends when
+// the client disconnects, or when the client falls too far behind, in which
+// case it is expected to reconnect.
+func (h *SchedulerService) watchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotSupported(w, r)
+		return
+	}
+	if h.controller == nil {
+		writeError(w, http.StatusNotImplemented, "The controller is disabled")
+		return
+	}
+
+	flusher := http.NewResponseController(w)
+	events, unsubscribe := h.controller.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := flusher.Flush(); err != nil {
+		logging.FromContext(r.Context()).Warn(fmt.Sprintf("Failed to start event stream: %s", err))
+		return
+	}
+
+	keepAlive := time.NewTicker(watchKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case event, open := <-events:
+			if !open {
+				logging.FromContext(r.Context()).Warn("Closing event stream of a client that fell behind")
+				return
+			}
+			var payload []byte
+			payload, err = json.Marshal(JsonDeploymentEvent{
+				Namespace:        event.Namespace,
+				Name:             event.Name,
+				State:            event.State,
+				Replicas:         event.Replicas,
+				PreviousReplicas: event.PreviousReplicas,
+				Time:             event.Time,
+			})
+			if err == nil {
+				_, err = fmt.Fprintf(w, "event: scale\ndata: %s\n\n", payload)
+			}
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		if err == nil {
+			err = flusher.Flush()
+		}
+		if err != nil {
+			logging.FromContext(r.Context()).Warn(fmt.Sprintf("Failed to write event stream: %s", err))
+			return
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dimitris4000/concept02/internal/controller"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+)
+
+func TestWatchHandler(t *testing.T) {
+	discardLogs(t)
+	h, clientset := newTestService(
+		newTestDeployment("foo", 3, map[string]string{"scheduler.enabled": "true"}),
+		newTestDeployment("bar", 3, nil),
+	)
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	h.controller = controller.NewResourceController(clientset,
+		factory.Apps().V1().Deployments().Informer(),
+		factory.Core().V1().ConfigMaps().Informer(),
+		factory.Core().V1().Namespaces().Informer(),
+		controller.NewDefaultControllerConfig())
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	factory.Start(stopCh)
+	factory.WaitForCacheSync(stopCh)
+	server := httptest.NewServer(h.Http.Handler)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/watch", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := server.Client().Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream, got status %d and the content type '%s'", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+
+	// Only the scheduled deployment produces events
+	steps := []struct {
+		name     string
+		replicas int32
+		state    string
+		previous int32
+	}{
+		{"bar", 0, "", 0},
+		{"foo", 0, "down", 3},
+		{"foo", 2, "up", 0},
+	}
+	for _, step := range steps {
+		deployment, err := clientset.AppsV1().Deployments("default").Get(ctx, step.name, meta_v1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		deployment.Spec.Replicas = &step.replicas
+		if _, err := clientset.AppsV1().Deployments("default").Update(ctx, deployment, meta_v1.UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reader := bufio.NewReader(response.Body)
+	var events []JsonDeploymentEvent
+	for len(events) < 2 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("expected 2 events, got %+v: %s", events, err)
+		}
+		data, found := strings.CutPrefix(strings.TrimSpace(line), "data: ")
+		if !found {
+			continue
+		}
+		var event JsonDeploymentEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	for i, step := range steps[1:] {
+		event := events[i]
+		if event.Namespace != "default" || event.Name != step.name || event.State != step.state || event.Replicas != step.replicas || event.PreviousReplicas != step.previous {
+			t.Errorf("expected %s to be scaled %s from %d to %d replicas, got %+v", step.name, step.state, step.previous, step.replicas, event)
+		}
+	}
+}
+
+func TestWatchHandlerErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		status int
+	}{
+		{"without a controller", http.MethodGet, http.StatusNotImplemented},
+		{"wrong method", http.MethodPost, http.StatusNotImplemented},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h, _ := newTestService()
+			if recorder := serve(h, test.method, "/watch", ""); recorder.Code != test.status {
+				t.Errorf("expected status %d, got %d", test.status, recorder.Code)
+			}
+		})
+	}
+}
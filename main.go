@@ -1,32 +1,228 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/dimitris4000/concept02/internal/cli"
 	"github.com/dimitris4000/concept02/internal/controller"
 	"github.com/dimitris4000/concept02/internal/service"
 )
 
+// Build metadata, GitCommit and BuildDate are set through ldflags (e.g.
+// -X main.GitCommit=$(git rev-parse HEAD))
 var (
-	Version = "0.1.0"
+	Version   = "0.1.0"
+	GitCommit = "unknown"
+	BuildDate = "unknown"
+)
+
+// Subcommands of the application. The first argument selects the command,
+// serve is used when no command is provided.
+const (
+	COMMAND_SERVE    = "serve"
+	COMMAND_LIST     = "list"
+	COMMAND_VALIDATE = "validate"
+	COMMAND_ONESHOT  = "oneshot"
+	COMMAND_SIMULATE = "simulate"
 )
 
 func main() {
+	controllerConfig := controller.NewDefaultControllerConfig()
+	schedulerConfig := service.NewDefaultSchedulerServiceConfig()
+	flag.StringVar(&controllerConfig.AnnotationPrefix, "annotation-prefix", controllerConfig.AnnotationPrefix, "prefix of the annotations managed by the scheduler (e.g. mycompany.io/scheduler.)")
+	flag.StringVar(&controllerConfig.DefaultSchedule, "default-schedule", controllerConfig.DefaultSchedule, "off-schedule (e.g. 20:00-08:00) of enabled deployments without their own schedule annotation")
+	flag.StringVar(&controllerConfig.DefaultTimezone, "default-timezone", controllerConfig.DefaultTimezone, "time zone (e.g. Europe/Athens) of deployments without a timezone annotation, defaults to UTC")
+	flag.DurationVar(&controllerConfig.APITimeout, "api-timeout", controllerConfig.APITimeout, "timeout of the k8s API calls")
+	flag.Float64Var(&controllerConfig.KubeQPS, "kube-qps", controllerConfig.KubeQPS, "maximum queries per second of the k8s API client")
+	flag.IntVar(&controllerConfig.KubeBurst, "kube-burst", controllerConfig.KubeBurst, "maximum burst of queries of the k8s API client")
+	flag.Float64Var(&controllerConfig.ReconcileQPS, "reconcile-qps", controllerConfig.ReconcileQPS, "maximum number of deployment reconciles per second")
+	flag.IntVar(&controllerConfig.ReconcileBurst, "reconcile-burst", controllerConfig.ReconcileBurst, "maximum burst of deployment reconciles")
+	flag.IntVar(&controllerConfig.ToggleConcurrency, "toggle-concurrency", controllerConfig.ToggleConcurrency, "maximum number of deployments reconciled in parallel")
+	flag.DurationVar(&controllerConfig.RetryBaseDelay, "retry-base-delay", controllerConfig.RetryBaseDelay, "initial backoff delay of deployments that failed to reconcile")
+	flag.DurationVar(&controllerConfig.RetryMaxDelay, "retry-max-delay", controllerConfig.RetryMaxDelay, "maximum backoff delay of deployments that failed to reconcile")
+	flag.StringVar(&controllerConfig.UpdateStrategy, "update-strategy", controllerConfig.UpdateStrategy, "how replica changes are written to the k8s API: update, patch or apply")
+	flag.IntVar(&controllerConfig.FallbackReplicas, "fallback-replicas", controllerConfig.FallbackReplicas, "replicas restored on scale up when the replicas memory annotation is corrupt")
+	flag.IntVar(&controllerConfig.MaxScaleDownPerLoop, "max-scale-down-per-loop", controllerConfig.MaxScaleDownPerLoop, "maximum number of deployments scaled down in a single resync interval, 0 means unlimited")
+	flag.StringVar(&controllerConfig.NotifyURL, "notify-url", controllerConfig.NotifyURL, "webhook notified with a JSON payload when a deployment is scaled")
+	flag.DurationVar(&controllerConfig.NotifyTimeout, "notify-timeout", controllerConfig.NotifyTimeout, "timeout of the notification webhook requests")
+	flag.IntVar(&controllerConfig.NotifyRetries, "notify-retries", controllerConfig.NotifyRetries, "number of retries of failed notification webhook requests")
+	flag.DurationVar(&controllerConfig.NotifyBackoff, "notify-backoff", controllerConfig.NotifyBackoff, "initial backoff between notification webhook retries, doubled on every retry")
+	flag.IntVar(&controllerConfig.StartupAttempts, "startup-attempts", controllerConfig.StartupAttempts, "number of attempts to connect to the k8s API on startup")
+	flag.DurationVar(&controllerConfig.StartupBackoff, "startup-backoff", controllerConfig.StartupBackoff, "initial backoff between the k8s API connection attempts on startup, doubled on every attempt")
+	flag.BoolVar(&controllerConfig.SkipRBACCheck, "skip-rbac-check", controllerConfig.SkipRBACCheck, "skip the check of the controller's permissions on startup")
+	flag.BoolVar(&controllerConfig.RestoreOnShutdown, "restore-on-shutdown", controllerConfig.RestoreOnShutdown, "scale the deployments the controller has scaled down back up when it stops")
+	flag.DurationVar(&controllerConfig.RestoreTimeout, "restore-timeout", controllerConfig.RestoreTimeout, "maximum duration of the restore on shutdown")
+	flag.StringVar(&controllerConfig.ScheduleConfigMap, "schedule-configmap", controllerConfig.ScheduleConfigMap, "'<namespace>/<name>' of a ConfigMap mapping '<namespace>.<deployment>' keys to the schedules of the deployments")
+	flag.StringVar(&controllerConfig.ReplicasConfigMap, "replicas-configmap", controllerConfig.ReplicasConfigMap, "'<namespace>/<name>' of a ConfigMap keeping the remembered replicas of scaled down deployments instead of their replicas-memory annotation")
+	flag.BoolVar(&controllerConfig.PerDeploymentMetrics, "per-deployment-metrics", controllerConfig.PerDeploymentMetrics, "export metrics labeled per deployment, false exports only aggregate metrics")
+	flag.IntVar(&controllerConfig.MaxDeploymentSeries, "max-deployment-series", controllerConfig.MaxDeploymentSeries, "maximum number of deployments with per-deployment metrics, 0 means unlimited")
+	flag.StringVar(&controllerConfig.PrometheusURL, "prometheus-url", controllerConfig.PrometheusURL, "Prometheus server (e.g. http://prometheus:9090) queried for the request rate of deployments with the idle-timeout annotation")
+	flag.StringVar(&controllerConfig.IdleQuery, "idle-query", controllerConfig.IdleQuery, "Prometheus query returning the request rate of a deployment in idle mode, ${namespace} and ${name} are replaced by the deployment's")
+	flag.DurationVar(&controllerConfig.MaxWindowLength, "max-window-length", controllerConfig.MaxWindowLength, "length above which the windows of schedules are reported as suspicious (e.g. 16h), 0 disables the warnings")
+	flag.DurationVar(&controllerConfig.MaxClockSkew, "max-clock-skew", controllerConfig.MaxClockSkew, "difference between the clocks of the controller and the k8s API server above which errors are logged, 0 disables the errors")
+	flag.BoolVar(&controllerConfig.KeepReplicasMemory, "keep-replicas-memory", controllerConfig.KeepReplicasMemory, "keep the replicas memory of restored objects, updated to the replicas they were scaled back up to, and record when in their last-restored-replicas annotation")
+	flag.StringVar(&controllerConfig.IgnoreLabels, "ignore-labels", controllerConfig.IgnoreLabels, "comma separated 'key=value' (or 'key' for any value) labels of deployments that are never scheduled (e.g. app.kubernetes.io/managed-by=Helm)")
+	flag.StringVar(&controllerConfig.AuditLog, "audit-log", controllerConfig.AuditLog, "file every scale action is appended to as a JSON line, '-' for stdout, empty disables the audit log")
+	flag.StringVar(&schedulerConfig.TLSCertFile, "tls-cert-file", schedulerConfig.TLSCertFile, "certificate file of the HTTPS server (requires --tls-key-file)")
+	flag.StringVar(&schedulerConfig.TLSKeyFile, "tls-key-file", schedulerConfig.TLSKeyFile, "key file of the HTTPS server (requires --tls-cert-file)")
+	flag.BoolVar(&schedulerConfig.LogProbes, "log-probes", schedulerConfig.LogProbes, "log the requests of the liveness/readiness probes")
+	flag.DurationVar(&schedulerConfig.ShutdownWaitDuration, "shutdown-wait-duration", schedulerConfig.ShutdownWaitDuration, "time the HTTP service keeps serving after a termination signal, also the time in-flight requests get to complete (at least 1s)")
+	disableHTTP := flag.Bool("disable-http", false, "run only the controller without the HTTP service")
+	disableController := flag.Bool("disable-controller", false, "run only the HTTP service without the controller")
+	configFile := flag.String("config", "", "YAML file with option values keyed by flag name, flags set on the command line take precedence")
+
+	command, args := COMMAND_SERVE, os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	flag.CommandLine.Parse(args)
+	if *configFile != "" {
+		exitOnError(loadConfigFile(*configFile, flag.CommandLine))
+	}
+
+	switch command {
+	case COMMAND_SERVE:
+		schedulerConfig.Build = service.NewBuildInfo(Version, GitCommit, BuildDate)
+		schedulerConfig.Controller = controllerConfig
+		serve(schedulerConfig, *disableHTTP, *disableController)
+	case COMMAND_LIST:
+		exitOnError(list(controllerConfig))
+	case COMMAND_VALIDATE:
+		exitOnError(validate(controllerConfig, flag.Args()))
+	case COMMAND_ONESHOT:
+		exitOnError(oneshot(controllerConfig))
+	case COMMAND_SIMULATE:
+		exitOnError(simulate(controllerConfig, flag.Args()))
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command '%s', expected one of %s, %s, %s, %s, %s\n", command, COMMAND_SERVE, COMMAND_LIST, COMMAND_VALIDATE, COMMAND_ONESHOT, COMMAND_SIMULATE)
+		os.Exit(2)
+	}
+}
+
+// serve runs the controller and the HTTP service of the scheduler until the
+// application is terminated. Either of them can be disabled, in which case
+// only the other one runs.
+func serve(schedulerConfig service.SchedulerServiceConfig, disableHTTP, disableController bool) {
 	fmt.Printf("Version: %s\n", Version)
 	fmt.Printf("Current Time: %s\n", time.Now())
+	if disableHTTP && disableController {
+		exitOnError(fmt.Errorf("the HTTP service and the controller can not both be disabled"))
+	}
 
 	// Start the K8S controller of the scheduler
-	controllerCh, err := controller.Start()
+	var schedulerController *controller.Controller
+	if !disableController {
+		var controllerCh chan struct{}
+		var err error
+		schedulerController, controllerCh, err = controller.Start(schedulerConfig.Controller)
+		if err != nil {
+			panic(err)
+		}
+		// Wait for the controller to stop, which includes the restore of
+		// the deployments on shutdown
+		defer func() {
+			close(controllerCh)
+			<-schedulerController.Done()
+		}()
+	}
+
+	if disableHTTP {
+		waitForTermination()
+		return
+	}
+
+	// Start the HTTP service of the scheduler
+	scheduler := service.NewSchedulerService(schedulerConfig, schedulerController)
+	err := scheduler.RunForever()
 	if err != nil {
 		panic(err)
 	}
-	defer close(controllerCh)
+}
 
-	// Start the HTTP service of the scheduler
-	schedulerConfig := service.NewDefaultSchedulerServiceConfig()
-	schedulerConfig.Version = Version
-	schedulerConfig.ShutdownWaitDuration = 5 * time.Second
-	scheduler := service.NewSchedulerService(schedulerConfig)
-	scheduler.RunForever()
+// waitForTermination blocks until the application receives SIGTERM or SIGINT
+func waitForTermination() {
+	terminationChannel := make(chan os.Signal, 1)
+	signal.Notify(terminationChannel, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-terminationChannel
+	slog.Info(fmt.Sprintf("Received %s, stopping the controller", sig))
+}
+
+// list prints the enabled deployments and their schedules
+func list(controllerConfig controller.ControllerConfig) error {
+	clientset, err := controller.LoadK8SClientConfigFile(controllerConfig)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), controllerConfig.APITimeout)
+	defer cancel()
+	return cli.List(ctx, clientset, controllerConfig, os.Stdout)
+}
+
+// validate checks the schedules of the deployments in the provided manifest
+// files, or in the cluster if no files are provided. It fails if any of the
+// schedules is invalid.
+func validate(controllerConfig controller.ControllerConfig, files []string) error {
+	var invalid int
+	var err error
+	if len(files) > 0 {
+		invalid, err = cli.ValidateFiles(files, controllerConfig, os.Stdout)
+	} else {
+		clientset, loadErr := controller.LoadK8SClientConfigFile(controllerConfig)
+		if loadErr != nil {
+			return loadErr
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), controllerConfig.APITimeout)
+		defer cancel()
+		invalid, err = cli.Validate(ctx, clientset, controllerConfig, os.Stdout)
+	}
+	if err != nil {
+		return err
+	}
+	if invalid > 0 {
+		return fmt.Errorf("found %d deployments with an invalid schedule", invalid)
+	}
+	return nil
+}
+
+// oneshot scales the deployment configured through the environment once,
+// according to its schedule.
+func oneshot(controllerConfig controller.ControllerConfig) error {
+	target, err := cli.OneshotTargetFromEnv(controllerConfig)
+	if err != nil {
+		return err
+	}
+	clientset, err := controller.LoadK8SClientConfigFile(controllerConfig)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), controllerConfig.APITimeout)
+	defer cancel()
+	return cli.Oneshot(ctx, clientset, controllerConfig, target, time.Now(), os.Stdout)
+}
+
+// simulate prints the timeline of the schedule described by the annotations
+// in args over a whole day, without access to the cluster.
+func simulate(controllerConfig controller.ControllerConfig, args []string) error {
+	target, err := cli.ParseSimulateArgs(controllerConfig, args)
+	if err != nil {
+		return err
+	}
+	return cli.Simulate(target, os.Stdout)
+}
+
+// exitOnError terminates the application with a non-zero exit code if err
+// is not nil.
+func exitOnError(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }